@@ -1,11 +1,18 @@
 package main
 
 import (
+	"authentication/data"
+	"authentication/metrics"
+	"authentication/throttle"
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 type authenticationRequestPayload struct {
@@ -19,6 +26,16 @@ type authenticationResponsePayload struct {
 	Data    any    `json:"data"`
 }
 
+type authenticationResult struct {
+	User *data.User `json:"user"`
+	*tokenPair
+}
+
+var (
+	errInvalidCredentials = errors.New("invalid credentials")
+	errAccountDeactivated = errors.New("user account is deactivated")
+)
+
 func (app *Config) Authenticate(w http.ResponseWriter, r *http.Request) {
 	var requestPayload authenticationRequestPayload
 	if err := app.readJSON(w, r, &requestPayload); err != nil {
@@ -26,42 +43,133 @@ func (app *Config) Authenticate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Validate the user against the database
-	user, err := app.Repo.GetByEmail(requestPayload.Email)
+	user, retryAfter, err := app.checkPassword(r, requestPayload.Email, requestPayload.Password)
+	if retryAfter > 0 {
+		app.tooManyAttempts(w, retryAfter)
+		return
+	}
 	if err != nil {
-		app.errorJSON(w, errors.New("user does not exist"), http.StatusUnauthorized)
+		if errors.Is(err, errInvalidCredentials) {
+			app.errorJSON(w, err, http.StatusUnauthorized)
+		} else {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+		}
 		return
 	}
 
-	if valid, err := app.Repo.PasswordMatches(requestPayload.Password, *user); err != nil || !valid {
-		app.errorJSON(w, errors.New("invalid credentials"), http.StatusUnauthorized)
+	if user.Active == 0 {
+		app.errorJSON(w, errAccountDeactivated, http.StatusForbidden)
+		return
+	}
+
+	// Users with two-factor authentication get a challenge instead of tokens, which they
+	// complete at /authenticate/totp
+	if user.TOTPEnabled {
+		app.secondFactorRequired(w, *user)
 		return
 	}
 
 	// Log authentication
-	if err := app.logRequest("authentication", fmt.Sprintf("%s logged in", user.Email)); err != nil {
+	if err := app.logRequest(r.Context(), "authentication", fmt.Sprintf("%s logged in", user.Email), user.ID); err != nil {
 		app.errorJSON(w, err)
 		return
 	}
 
+	tokens, err := app.issueTokens(*user, "")
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
 	responsePayload := authenticationResponsePayload{
 		Error:   false,
 		Message: fmt.Sprintf("Logged in user %s", user.Email),
-		Data:    user,
+		Data: authenticationResult{
+			User:      user,
+			tokenPair: tokens,
+		},
 	}
 
 	app.writeJSON(w, http.StatusAccepted, responsePayload)
 }
 
+// checkPassword validates an email and password against the database, counting failures
+// towards the lockout of the account and the client IP. It returns errInvalidCredentials for
+// unknown emails and wrong passwords alike, or how long the caller has to wait while locked
+// out. Outdated password hashes are upgraded on success.
+func (app *Config) checkPassword(r *http.Request, email, password string) (*data.User, time.Duration, error) {
+	keys := []throttle.Key{accountKey(email), ipKey(r)}
+
+	retryAfter, err := app.Limiter.Check(keys...)
+	if err != nil {
+		return nil, 0, err
+	}
+	if retryAfter > 0 {
+		metrics.AuthAttempts.WithLabelValues("password", metrics.LockedOut).Inc()
+		return nil, retryAfter, nil
+	}
+
+	// Unknown emails and wrong passwords get the same answer after the same amount of work, so
+	// responses don't reveal which accounts exist.
+	user, err := app.Repo.GetByEmail(email)
+	if err != nil {
+		_, _ = app.Repo.PasswordMatches(password, dummyUser)
+	}
+
+	if err != nil || !app.passwordMatches(password, *user) {
+		if err := app.Limiter.Fail(keys...); err != nil {
+			log.Println("Failed to record failed login:", err)
+		}
+
+		metrics.AuthAttempts.WithLabelValues("password", metrics.Failure).Inc()
+		return nil, 0, errInvalidCredentials
+	}
+
+	metrics.AuthAttempts.WithLabelValues("password", metrics.Success).Inc()
+
+	if err := app.Limiter.Reset(accountKey(email)); err != nil {
+		log.Println("Failed to reset failed logins:", err)
+	}
+
+	app.rehashPassword(password, *user)
+
+	return user, 0, nil
+}
+
+func (app *Config) passwordMatches(plainText string, user data.User) bool {
+	valid, err := app.Repo.PasswordMatches(plainText, user)
+	return err == nil && valid
+}
+
+// rehashPassword upgrades the stored hash of a user who just proved their password when it was
+// produced by an outdated algorithm or cost. Failing to do so doesn't fail the login.
+func (app *Config) rehashPassword(plainText string, user data.User) {
+	if !app.Repo.PasswordNeedsRehash(user) {
+		return
+	}
+
+	if err := app.Repo.ResetPassword(plainText, user); err != nil {
+		log.Printf("Failed to rehash the password of user %d: %v", user.ID, err)
+	}
+}
+
 type logEntry struct {
-	Name string `json:"name"`
-	Data string `json:"data"`
+	Name     string `json:"name"`
+	Data     string `json:"data"`
+	Severity string `json:"severity"`
+	Service  string `json:"service"`
+	UserID   string `json:"user_id"`
 }
 
-func (app *Config) logRequest(name, data string) error {
+// logRequest logs what a user did with the logger service. The logger takes the trace ID from
+// the trace context of the request.
+func (app *Config) logRequest(ctx context.Context, name, data string, userID int) error {
 	entry := logEntry{
-		Name: name,
-		Data: data,
+		Name:     name,
+		Data:     data,
+		Severity: "info",
+		Service:  "authentication-service",
+		UserID:   strconv.Itoa(userID),
 	}
 
 	jsonData, err := json.MarshalIndent(entry, "", "\t")
@@ -69,8 +177,7 @@ func (app *Config) logRequest(name, data string) error {
 		return err
 	}
 
-	logServiceURL := "http://logger-service/log"
-	request, err := http.NewRequest(http.MethodPost, logServiceURL, bytes.NewBuffer(jsonData))
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, app.LogServiceURL, bytes.NewBuffer(jsonData))
 	if err != nil {
 		return err
 	}
@@ -1,12 +1,16 @@
 package main
 
 import (
+	"authentication/metrics"
+	"authentication/throttle"
 	"bytes"
 	"encoding/json"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
 )
 
 type RoundTripFunc func(req *http.Request) *http.Response
@@ -37,6 +41,71 @@ func Test_Authenticate(t *testing.T) {
 	}
 }
 
+func Test_Authenticate_lockout(t *testing.T) {
+	prepareTestApp()
+
+	app := testApp
+	app.Limiter = throttle.NewLimiter(throttle.NewMemoryStore())
+
+	attempts := func(result string) float64 {
+		return testutil.ToFloat64(metrics.AuthAttempts.WithLabelValues("password", result))
+	}
+	failures, lockouts, successes := attempts(metrics.Failure), attempts(metrics.LockedOut), attempts(metrics.Success)
+
+	attempt := func(email string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]string{"email": email, "password": "wrong"})
+
+		req, _ := http.NewRequest(http.MethodPost, "/authenticate", bytes.NewReader(body))
+		req.RemoteAddr = "10.0.0.1:1234"
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(app.Authenticate)
+		handler.ServeHTTP(rr, req)
+
+		return rr
+	}
+
+	for i := 0; i < accountPolicy.MaxFailures; i++ {
+		rr := attempt("nobody@here.com")
+		if rr.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: expected http.StatusUnauthorized but got %d", i+1, rr.Code)
+		}
+
+		var response jsonResponse
+		_ = json.NewDecoder(rr.Body).Decode(&response)
+		if response.Message != errInvalidCredentials.Error() {
+			t.Errorf("expected a uniform error message but got %q", response.Message)
+		}
+	}
+
+	// the next failure goes over the limit and locks the account
+	attempt("nobody@here.com")
+
+	rr := attempt("nobody@here.com")
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected http.StatusTooManyRequests but got %d", rr.Code)
+	}
+
+	if rr.Header().Get("Retry-After") == "" {
+		t.Error("expected a Retry-After header")
+	}
+
+	// other accounts from the same address are still allowed
+	if rr := attempt("me@here.com"); rr.Code != http.StatusAccepted {
+		t.Errorf("expected http.StatusAccepted for another account but got %d", rr.Code)
+	}
+
+	if got := attempts(metrics.Failure) - failures; got != float64(accountPolicy.MaxFailures+1) {
+		t.Errorf("expected %d failed attempts to be counted but got %v", accountPolicy.MaxFailures+1, got)
+	}
+	if got := attempts(metrics.LockedOut) - lockouts; got != 1 {
+		t.Errorf("expected one locked out attempt to be counted but got %v", got)
+	}
+	if got := attempts(metrics.Success) - successes; got != 1 {
+		t.Errorf("expected one successful attempt to be counted but got %v", got)
+	}
+}
+
 func prepareTestApp() {
 	jsonToReturn := prepareMockResponse()
 
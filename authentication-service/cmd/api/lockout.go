@@ -0,0 +1,95 @@
+package main
+
+import (
+	"authentication/data"
+	"authentication/throttle"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	accountPolicy = throttle.Policy{
+		MaxFailures: 5,
+		BaseLockout: 30 * time.Second,
+		MaxLockout:  time.Hour,
+		Window:      time.Hour,
+	}
+
+	ipPolicy = throttle.Policy{
+		MaxFailures: 20,
+		BaseLockout: time.Minute,
+		MaxLockout:  time.Hour,
+		Window:      time.Hour,
+	}
+)
+
+// dummyUser has a password hash of the same algorithm and cost as real ones; main replaces this
+// bcrypt hash with one of the configured algorithm. Comparing against it when an email is
+// unknown makes failed logins take the same time whether or not the account exists.
+var dummyUser = data.User{
+	Password: "$2a$12$ELrzRDdY87V/y3hlqlFF4OaNEgSBh2l6fdJ92DSwicNJYIO7R5Qhq",
+}
+
+func accountKey(email string) throttle.Key {
+	return throttle.Key{
+		Name:   "account:" + strings.ToLower(strings.TrimSpace(email)),
+		Policy: accountPolicy,
+	}
+}
+
+// totpKey counts wrong second factor codes of a user separately from wrong passwords.
+func totpKey(userID int) throttle.Key {
+	return throttle.Key{
+		Name:   "totp:" + strconv.Itoa(userID),
+		Policy: accountPolicy,
+	}
+}
+
+func ipKey(r *http.Request) throttle.Key {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	return throttle.Key{
+		Name:   "ip:" + ip,
+		Policy: ipPolicy,
+	}
+}
+
+// tooManyAttempts tells the client to back off for retryAfter.
+func (app *Config) tooManyAttempts(w http.ResponseWriter, retryAfter time.Duration) {
+	headers := make(http.Header)
+	headers.Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+
+	app.writeJSON(w, http.StatusTooManyRequests, jsonResponse{
+		Error:   true,
+		Message: "too many failed login attempts, try again later",
+	}, headers)
+}
+
+// UnlockUser clears the failed login and second factor counters of a user, lifting a lockout
+// before it expires.
+func (app *Config) UnlockUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	for _, key := range []throttle.Key{accountKey(user.Email), totpKey(user.ID)} {
+		if err := app.Limiter.Reset(key); err != nil {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	app.writeJSON(w, http.StatusOK, jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Unlocked user %d", user.ID),
+	})
+}
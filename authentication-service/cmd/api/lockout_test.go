@@ -0,0 +1,44 @@
+package main
+
+import (
+	"authentication/throttle"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_UnlockUser(t *testing.T) {
+	app := testApp
+	app.Limiter = throttle.NewLimiter(throttle.NewMemoryStore())
+
+	accessToken, _, _ := app.Tokens.IssueAccessToken(1, "me@here.com", []string{adminRole}, []string{amrPassword, amrOTP})
+
+	// lock both the password and the second factor of user 2
+	keys := []throttle.Key{accountKey("totp@here.com"), totpKey(2)}
+	for i := 0; i <= accountPolicy.MaxFailures; i++ {
+		if err := app.Limiter.Fail(keys...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, key := range keys {
+		if locked, _ := app.Limiter.Check(key); locked == 0 {
+			t.Fatalf("expected %s to be locked", key.Name)
+		}
+	}
+
+	req, _ := http.NewRequest(http.MethodPost, "/users/2/unlock", nil)
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	rr := httptest.NewRecorder()
+
+	app.routes().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected http.StatusOK but got %d (%s)", rr.Code, rr.Body.String())
+	}
+
+	for _, key := range keys {
+		if locked, _ := app.Limiter.Check(key); locked != 0 {
+			t.Errorf("expected %s to be unlocked but it is locked for %s", key.Name, locked)
+		}
+	}
+}
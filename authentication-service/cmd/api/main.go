@@ -1,49 +1,158 @@
 package main
 
 import (
+	"authentication/config"
 	"authentication/data"
+	"authentication/health"
+	"authentication/migrations"
+	"authentication/password"
+	"authentication/throttle"
+	"authentication/token"
+	"authentication/tracing"
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	_ "github.com/jackc/pgconn"
 	_ "github.com/jackc/pgx/v4"
 	_ "github.com/jackc/pgx/v4/stdlib"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
-const webPort = "80"
+const (
+	// shutdownTimeout bounds how long in-flight requests may take to finish after SIGTERM
+	shutdownTimeout = 20 * time.Second
+	// readinessTimeout bounds the checks behind /readyz
+	readinessTimeout = 2 * time.Second
+)
 
 type Config struct {
-	Client *http.Client
-	Repo   data.Repository
+	Settings
+	Client  *http.Client
+	Repo    data.Repository
+	Tokens  *token.Manager
+	Limiter *throttle.Limiter
+	Health  *health.Checker
 }
 
 func main() {
+	// load the settings from flags, environment variables and an optional YAML file
+	var settings Settings
+	args := config.Parse(&settings)
+
+	// export traces to the exporter named in OTEL_TRACES_EXPORTER
+	shutdownTracing, err := tracing.Setup("authentication-service")
+	if err != nil {
+		log.Panic(err)
+	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			log.Printf("Error flushing traces: %v", err)
+		}
+	}()
 
 	// connect to DB
-	conn := connectToDB()
+	conn := connectToDB(settings.DSN)
 	if conn == nil {
 		log.Panic("Can't connect to Postgres!")
 	}
 
+	embedded, err := migrations.Embedded()
+	if err != nil {
+		log.Panicf("Can't load the migrations: %v", err)
+	}
+	migrator := migrations.New(conn, embedded)
+
+	// "authApp migrate ..." manages the schema and exits instead of serving requests
+	if len(args) > 0 && args[0] == "migrate" {
+		if err := runMigrate(migrator, args[1:], os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// bring the schema up to date; the migrator serializes replicas starting at the same time
+	if _, err := migrator.Up(context.Background()); err != nil {
+		log.Panicf("Can't migrate the database: %v", err)
+	}
+
+	// load the key used to sign access tokens
+	if settings.SigningKeyFile == "" {
+		log.Println("JWT_SIGNING_KEY_FILE is not set, generating an ephemeral signing key")
+	}
+	signingKey, err := token.LoadKey(settings.SigningKeyFile)
+	if err != nil {
+		log.Panicf("Can't load the token signing key: %v", err)
+	}
+
+	// new passwords are hashed with the configured hasher; hashes of the other algorithm still
+	// verify and are upgraded on the next login
+	passwords, err := password.NewPolicy(settings.PasswordHasher)
+	if err != nil {
+		log.Panic(err)
+	}
+	dummyUser.Password, err = passwords.Hash("not a real password")
+	if err != nil {
+		log.Panic(err)
+	}
+
+	// failed login counters are kept in Postgres, so lockouts apply across all replicas,
+	// unless LOCKOUT_STORE=memory
+	var lockoutStore throttle.Store = throttle.NewPostgresStore(conn)
+	if settings.LockoutStore == "memory" {
+		lockoutStore = throttle.NewMemoryStore()
+	}
+
 	// set up config
 	app := Config{
-		Client: &http.Client{},
-		Repo:   data.NewPostgresRepository(conn),
+		Settings: settings,
+		Client:   &http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport)},
+		Repo:     data.NewPostgresRepository(conn, passwords),
+		Tokens:   token.NewManager(signingKey, settings.TokenIssuer, settings.AccessTokenTTL, settings.RefreshTokenTTL),
+		Limiter:  throttle.NewLimiter(lockoutStore),
+		Health:   health.New(readinessTimeout),
 	}
 
+	// the service is ready while it can reach Postgres
+	app.Health.Add("postgres", conn.PingContext)
+
 	srv := &http.Server{
-		Addr:    fmt.Sprintf(":%s", webPort),
+		Addr:    fmt.Sprintf(":%s", app.WebPort),
 		Handler: app.routes(),
 	}
 
-	log.Printf("Starting Auth service on port: %s", webPort)
-	err := srv.ListenAndServe()
-	if err != nil {
-		log.Panic(err)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		log.Printf("Starting Auth service on port: %s", app.WebPort)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Panic(err)
+		}
+	}()
+
+	<-ctx.Done()
+	log.Println("Shutting down Auth service...")
+
+	// stop taking new requests and let the ones in flight finish before closing the database
+	app.Health.Drain()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Error shutting down the HTTP server: %v", err)
+	}
+
+	if err := conn.Close(); err != nil {
+		log.Printf("Error closing the database: %v", err)
 	}
 }
 
@@ -61,8 +170,7 @@ func openDB(dsn string) (*sql.DB, error) {
 	return db, nil
 }
 
-func connectToDB() *sql.DB {
-	dsn := os.Getenv("DSN")
+func connectToDB(dsn string) *sql.DB {
 	counts := 0
 
 	for {
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"authentication/token"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+)
+
+type contextKey string
+
+const claimsContextKey contextKey = "claims"
+
+// requireAuth rejects requests that don't carry a valid access token issued to a user and stores
+// the token claims in the request context.
+func (app *Config) requireAuth(next http.Handler) http.Handler {
+	return app.requireToken(app.Tokens.Verify, next)
+}
+
+// requireClientAuth is requireAuth for the endpoints OAuth clients call with the access tokens
+// they were issued.
+func (app *Config) requireClientAuth(next http.Handler) http.Handler {
+	return app.requireToken(app.Tokens.VerifyClientToken, next)
+}
+
+func (app *Config) requireToken(verify func(string) (*token.Claims, error), next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scheme, tokenString, found := strings.Cut(r.Header.Get("Authorization"), " ")
+		if !found || !strings.EqualFold(scheme, "Bearer") || tokenString == "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			app.errorJSON(w, errors.New("authentication required"), http.StatusUnauthorized)
+			return
+		}
+
+		claims, err := verify(tokenString)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			app.errorJSON(w, err, http.StatusUnauthorized)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), claimsContextKey, claims)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// realIP replaces the remote address of requests that come from one of the trusted proxies with
+// the address of the client the proxy forwarded. The headers of other requests are ignored, so
+// clients can't choose the address their failed logins are counted against.
+func realIP(proxies []string) func(http.Handler) http.Handler {
+	// the settings were validated, so the proxies parse
+	trusted, _ := parseNetworks(proxies)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if ip := forwardedClient(r, trusted); ip != "" {
+				r.RemoteAddr = ip
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// forwardedClient returns the client address forwarded by a trusted proxy, or "" when r didn't
+// come from one. X-Forwarded-For is read from the right, skipping the trusted proxies, as the
+// addresses to their left could have been sent by the client.
+func forwardedClient(r *http.Request, trusted []*net.IPNet) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !isTrusted(net.ParseIP(host), trusted) {
+		return ""
+	}
+
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		hops := strings.Split(forwarded, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			ip := net.ParseIP(strings.TrimSpace(hops[i]))
+			if ip == nil {
+				return ""
+			}
+			if i == 0 || !isTrusted(ip, trusted) {
+				return ip.String()
+			}
+		}
+	}
+
+	if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+		return ip.String()
+	}
+
+	return ""
+}
+
+func isTrusted(ip net.IP, trusted []*net.IPNet) bool {
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// requireMFA only lets through requests whose access token was obtained with a second factor.
+// It has to run after requireAuth.
+func (app *Config) requireMFA(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := claimsFromContext(r.Context())
+		if !ok || !claims.HasMethod(amrOTP) {
+			app.errorJSON(w, errors.New("two-factor authentication is required for this action"), http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireRole only lets through requests whose access token grants at least one of roles.
+// It has to run after requireAuth.
+func (app *Config) requireRole(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := claimsFromContext(r.Context())
+			if !ok || !claims.HasRole(roles...) {
+				app.errorJSON(w, errors.New("not allowed to perform this action"), http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func claimsFromContext(ctx context.Context) (*token.Claims, bool) {
+	claims, ok := ctx.Value(claimsContextKey).(*token.Claims)
+	return claims, ok
+}
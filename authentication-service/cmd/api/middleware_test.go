@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_realIP(t *testing.T) {
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		realIP     string
+		expected   string
+	}{
+		{"direct client", "203.0.113.7:4000", "", "", "203.0.113.7:4000"},
+		{"spoofed by a client", "203.0.113.7:4000", "198.51.100.1", "198.51.100.2", "203.0.113.7:4000"},
+		{"forwarded by the broker", "172.28.0.10:4000", "198.51.100.1", "", "198.51.100.1"},
+		{"chain forwarded by the broker", "172.28.0.10:4000", "198.51.100.9, 198.51.100.1", "", "198.51.100.1"},
+		{"chain through trusted proxies", "172.28.0.10:4000", "198.51.100.1, 10.1.2.3", "", "198.51.100.1"},
+		{"real ip from the broker", "172.28.0.10:4000", "", "198.51.100.2", "198.51.100.2"},
+		{"garbage from the broker", "172.28.0.10:4000", "not-an-ip", "", "172.28.0.10:4000"},
+		{"ipv6 proxy", "[fd00::1]:4000", "2001:db8::5", "", "2001:db8::5"},
+	}
+
+	handler := realIP([]string{"172.28.0.10", "10.0.0.0/8", "fd00::/8"})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.RemoteAddr))
+	}))
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = tt.remoteAddr
+		if tt.forwarded != "" {
+			req.Header.Set("X-Forwarded-For", tt.forwarded)
+		}
+		if tt.realIP != "" {
+			req.Header.Set("X-Real-IP", tt.realIP)
+		}
+		rr := httptest.NewRecorder()
+
+		handler.ServeHTTP(rr, req)
+
+		if got := rr.Body.String(); got != tt.expected {
+			t.Errorf("%s: expected remote address %s but got %s", tt.name, tt.expected, got)
+		}
+	}
+}
+
+func Test_parseNetworks(t *testing.T) {
+	networks, err := parseNetworks([]string{"172.28.0.10", "10.0.0.0/8", "::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(networks) != 3 || networks[0].String() != "172.28.0.10/32" || networks[2].String() != "::1/128" {
+		t.Errorf("unexpected networks %v", networks)
+	}
+
+	if _, err := parseNetworks([]string{"broker-service"}); err == nil {
+		t.Error("expected an error for a host name")
+	}
+}
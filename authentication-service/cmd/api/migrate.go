@@ -0,0 +1,107 @@
+package main
+
+import (
+	"authentication/migrations"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+const migrateUsage = "usage: migrate status | up | down [steps] | to <version>"
+
+var errMigrateUsage = errors.New(migrateUsage)
+
+// runMigrate implements the migrate subcommand, which manages the schema without starting the
+// server:
+//
+//	migrate status          lists the applied and pending migrations
+//	migrate up              applies all pending migrations
+//	migrate down [steps]    reverts the last migration, or the last steps migrations
+//	migrate to <version>    applies or reverts migrations up to version; 0 reverts all
+func runMigrate(migrator *migrations.Migrator, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errMigrateUsage
+	}
+
+	ctx := context.Background()
+
+	var ran []migrations.Migration
+	var err error
+
+	switch args[0] {
+	case "status":
+		if len(args) != 1 {
+			return errMigrateUsage
+		}
+		return printMigrationStatus(ctx, migrator, out)
+
+	case "up":
+		if len(args) != 1 {
+			return errMigrateUsage
+		}
+		ran, err = migrator.Up(ctx)
+
+	case "down":
+		steps := 1
+		if len(args) == 2 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return errMigrateUsage
+			}
+		} else if len(args) > 2 {
+			return errMigrateUsage
+		}
+		ran, err = migrator.Down(ctx, steps)
+
+	case "to":
+		if len(args) != 2 {
+			return errMigrateUsage
+		}
+		version, parseErr := strconv.ParseInt(args[1], 10, 64)
+		if parseErr != nil || version < 0 {
+			return errMigrateUsage
+		}
+		ran, err = migrator.To(ctx, version)
+
+	default:
+		return errMigrateUsage
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if len(ran) == 0 {
+		fmt.Fprintln(out, "Nothing to migrate")
+	}
+
+	return nil
+}
+
+func printMigrationStatus(ctx context.Context, migrator *migrations.Migrator, out io.Writer) error {
+	statuses, err := migrator.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+
+	for _, s := range statuses {
+		appliedAt := "pending"
+		if s.AppliedAt != nil {
+			appliedAt = s.AppliedAt.Format(time.RFC3339)
+		}
+		if s.Missing {
+			appliedAt += " (unknown to this build)"
+		}
+
+		fmt.Fprintf(w, "%d\t%s\t%s\n", s.Version, s.Name, appliedAt)
+	}
+
+	return w.Flush()
+}
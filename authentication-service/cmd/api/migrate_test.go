@@ -0,0 +1,26 @@
+package main
+
+import (
+	"io"
+	"testing"
+)
+
+func Test_runMigrate_usage(t *testing.T) {
+	invalid := [][]string{
+		{},
+		{"sideways"},
+		{"status", "now"},
+		{"up", "3"},
+		{"down", "zero"},
+		{"down", "0"},
+		{"down", "1", "2"},
+		{"to"},
+		{"to", "-1"},
+	}
+
+	for _, args := range invalid {
+		if err := runMigrate(nil, args, io.Discard); err != errMigrateUsage {
+			t.Errorf("%v: expected the usage error but got %v", args, err)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"authentication/data"
+	"authentication/token"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+var errClientNotFound = errors.New("client not found")
+
+type oauthClientPayload struct {
+	Name         string   `json:"name"`
+	RedirectURIs []string `json:"redirect_uris"`
+	FirstParty   bool     `json:"first_party"`
+	// Confidential clients get a secret; public clients, such as single page apps, rely on PKCE.
+	Confidential bool `json:"confidential"`
+}
+
+type registeredClient struct {
+	*data.OAuthClient
+	ClientSecret string `json:"client_secret,omitempty"`
+}
+
+// ListOAuthClients returns all registered OpenID Connect clients.
+func (app *Config) ListOAuthClients(w http.ResponseWriter, r *http.Request) {
+	clients, err := app.Repo.GetAllOAuthClients()
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Found %d clients", len(clients)),
+		Data:    clients,
+	})
+}
+
+// CreateOAuthClient registers a new OpenID Connect client. The secret of a confidential client
+// is only returned in this response.
+func (app *Config) CreateOAuthClient(w http.ResponseWriter, r *http.Request) {
+	var requestPayload oauthClientPayload
+	if err := app.readJSON(w, r, &requestPayload); err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	client := data.OAuthClient{
+		Name:         strings.TrimSpace(requestPayload.Name),
+		RedirectURIs: requestPayload.RedirectURIs,
+		FirstParty:   requestPayload.FirstParty,
+	}
+
+	v := newValidator()
+	v.Check(client.Name != "", "name", "must be provided")
+	validateName(v, "name", client.Name)
+	v.Check(len(client.RedirectURIs) > 0, "redirect_uris", "must contain at least one URI")
+	for _, uri := range client.RedirectURIs {
+		v.Check(validRedirectURI(uri), "redirect_uris", "must be absolute http or https URIs without a fragment")
+	}
+
+	if !v.Valid() {
+		app.failedValidationJSON(w, v.Errors)
+		return
+	}
+
+	id, err := newClientID()
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+	client.ID = id
+
+	var secret string
+	if requestPayload.Confidential {
+		secret, client.SecretHash, err = token.NewOpaqueToken()
+		if err != nil {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := app.Repo.InsertOAuthClient(client); err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/oauth/clients/%s", client.ID))
+
+	app.writeJSON(w, http.StatusCreated, jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Registered client %s", client.Name),
+		Data: registeredClient{
+			OAuthClient:  &client,
+			ClientSecret: secret,
+		},
+	}, headers)
+}
+
+// DeleteOAuthClient unregisters a client. Tokens it already obtained stay valid until they expire.
+func (app *Config) DeleteOAuthClient(w http.ResponseWriter, r *http.Request) {
+	client, err := app.Repo.GetOAuthClient(chi.URLParam(r, "clientID"))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errClientNotFound, http.StatusNotFound)
+		} else {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if err := app.Repo.DeleteOAuthClient(client.ID); err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Deleted client %s", client.Name),
+	})
+}
+
+// validRedirectURI accepts the absolute URIs the authorization endpoint can redirect to. They
+// are stored one per line, so they must not contain whitespace.
+func validRedirectURI(uri string) bool {
+	if strings.ContainsAny(uri, " \t\r\n") {
+		return false
+	}
+
+	u, err := url.Parse(uri)
+	if err != nil {
+		return false
+	}
+
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" && u.Fragment == ""
+}
+
+func newClientID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,641 @@
+package main
+
+import (
+	"authentication/data"
+	"authentication/token"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"embed"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	scopeOpenID  = "openid"
+	scopeProfile = "profile"
+	scopeEmail   = "email"
+
+	pkceMethodS256       = "S256"
+	authorizationCodeTTL = 2 * time.Minute
+)
+
+// scopeDescriptions lists the supported scopes in the order they are shown on the consent page.
+var scopeDescriptions = []struct {
+	Scope       string
+	Description string
+}{
+	{scopeOpenID, "Know who you are"},
+	{scopeProfile, "See your name"},
+	{scopeEmail, "See your email address"},
+}
+
+var (
+	errUnknownClient        = errors.New("unknown client")
+	errUnregisteredRedirect = errors.New("the redirect URI is not registered for this client")
+)
+
+// pkceRX matches PKCE code verifiers and S256 challenges (RFC 7636, section 4.1 and 4.2).
+var pkceRX = regexp.MustCompile(`^[A-Za-z0-9\-._~]{43,128}$`)
+
+//go:embed templates
+var templateFS embed.FS
+
+var authorizeTemplate = template.Must(template.ParseFS(templateFS, "templates/authorize.page.gohtml"))
+
+// oauthError is an error response as defined by RFC 6749. The token endpoint returns it as
+// JSON; the authorization endpoint appends it to the redirect URI of the client.
+type oauthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func (e *oauthError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Description)
+}
+
+// authorizationRequest holds the parameters of a request to /oauth/authorize. The login form
+// posts them back unchanged.
+type authorizationRequest struct {
+	ResponseType        string
+	ClientID            string
+	RedirectURI         string
+	Scope               string
+	State               string
+	Nonce               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+
+	client *data.OAuthClient
+}
+
+// authorizePage is the data of the login and consent page.
+type authorizePage struct {
+	Request    *authorizationRequest
+	ClientName string
+	FirstParty bool
+	Scopes     []string
+	Email      string
+	Error      string
+}
+
+type openIDConfiguration struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserinfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+
+type oauthTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+	Scope       string `json:"scope"`
+	IDToken     string `json:"id_token"`
+}
+
+// userInfo holds the claims about a user the granted scopes allow a client to see.
+type userInfo struct {
+	Subject    string `json:"sub"`
+	Email      string `json:"email,omitempty"`
+	Name       string `json:"name,omitempty"`
+	GivenName  string `json:"given_name,omitempty"`
+	FamilyName string `json:"family_name,omitempty"`
+}
+
+// OpenIDConfiguration publishes the discovery document of the OpenID Connect provider. All
+// endpoints are relative to the token issuer, which therefore has to be the public URL of the
+// service.
+func (app *Config) OpenIDConfiguration(w http.ResponseWriter, r *http.Request) {
+	issuer := strings.TrimSuffix(app.Tokens.Issuer, "/")
+
+	scopes := make([]string, 0, len(scopeDescriptions))
+	for _, s := range scopeDescriptions {
+		scopes = append(scopes, s.Scope)
+	}
+
+	app.writeJSON(w, http.StatusOK, openIDConfiguration{
+		Issuer:                            app.Tokens.Issuer,
+		AuthorizationEndpoint:             issuer + "/oauth/authorize",
+		TokenEndpoint:                     issuer + "/oauth/token",
+		UserinfoEndpoint:                  issuer + "/oauth/userinfo",
+		JWKSURI:                           issuer + "/.well-known/jwks.json",
+		ScopesSupported:                   scopes,
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{"authorization_code"},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{"RS256"},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{pkceMethodS256},
+		ClaimsSupported:                   []string{"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce", "amr", "email", "name", "given_name", "family_name"},
+	})
+}
+
+// Authorize starts the authorization code flow by showing the login page. Third-party clients
+// also list the scopes they asked for, which the user allows by logging in.
+func (app *Config) Authorize(w http.ResponseWriter, r *http.Request) {
+	req, err := app.parseAuthorizationRequest(r)
+	if err != nil {
+		app.authorizationError(w, r, req, err)
+		return
+	}
+
+	app.renderAuthorizePage(w, http.StatusOK, req, "", "")
+}
+
+// AuthorizeLogin checks the credentials posted from the login page and redirects back to the
+// client with an authorization code. Users with two-factor authentication enter a TOTP or
+// recovery code on the same page.
+func (app *Config) AuthorizeLogin(w http.ResponseWriter, r *http.Request) {
+	req, err := app.parseAuthorizationRequest(r)
+	if err != nil {
+		app.authorizationError(w, r, req, err)
+		return
+	}
+
+	if r.PostForm.Get("action") == "deny" {
+		app.authorizationError(w, r, req, &oauthError{Code: "access_denied", Description: "the user denied the request"})
+		return
+	}
+
+	email := strings.TrimSpace(r.PostForm.Get("email"))
+
+	user, retryAfter, err := app.checkPassword(r, email, r.PostForm.Get("password"))
+	if retryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+		app.renderAuthorizePage(w, http.StatusTooManyRequests, req, email, "Too many failed attempts, try again later.")
+		return
+	}
+	if err != nil {
+		if !errors.Is(err, errInvalidCredentials) {
+			log.Println("Failed to check credentials:", err)
+			app.renderAuthorizePage(w, http.StatusInternalServerError, req, email, "Something went wrong, please try again.")
+			return
+		}
+
+		app.renderAuthorizePage(w, http.StatusUnauthorized, req, email, "Invalid email or password.")
+		return
+	}
+
+	if user.Active == 0 {
+		app.renderAuthorizePage(w, http.StatusForbidden, req, email, "This account is deactivated.")
+		return
+	}
+
+	if user.TOTPEnabled {
+		code := strings.TrimSpace(r.PostForm.Get("code"))
+
+		// authenticator codes are digits only, recovery codes contain letters
+		payload := totpCodePayload{Code: code}
+		if strings.Trim(strings.ReplaceAll(code, " ", ""), "0123456789") != "" {
+			payload = totpCodePayload{RecoveryCode: code}
+		}
+
+		valid, retryAfter, err := app.checkSecondFactor(r, user, payload)
+		if retryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+			app.renderAuthorizePage(w, http.StatusTooManyRequests, req, email, "Too many failed attempts, try again later.")
+			return
+		}
+		if err != nil {
+			log.Println("Failed to check second factor:", err)
+			app.renderAuthorizePage(w, http.StatusInternalServerError, req, email, "Something went wrong, please try again.")
+			return
+		}
+
+		if !valid {
+			app.renderAuthorizePage(w, http.StatusUnauthorized, req, email, "Enter a valid authentication code.")
+			return
+		}
+	}
+
+	code, hash, err := token.NewOpaqueToken()
+	if err != nil {
+		app.authorizationError(w, r, req, err)
+		return
+	}
+
+	_, err = app.Repo.InsertAuthorizationCode(data.AuthorizationCode{
+		CodeHash:      hash,
+		ClientID:      req.ClientID,
+		UserID:        user.ID,
+		RedirectURI:   req.RedirectURI,
+		Scope:         req.Scope,
+		Nonce:         req.Nonce,
+		CodeChallenge: req.CodeChallenge,
+		AuthMethods:   authMethods(*user),
+		AuthTime:      time.Now(),
+		ExpiresAt:     time.Now().Add(authorizationCodeTTL),
+	})
+	if err != nil {
+		app.authorizationError(w, r, req, err)
+		return
+	}
+
+	if err := app.logRequest(r.Context(), "authentication", fmt.Sprintf("%s logged in to %s", user.Email, req.client.Name), user.ID); err != nil {
+		log.Println("Failed to log authorization:", err)
+	}
+
+	app.redirectToClient(w, r, req, url.Values{"code": {code}})
+}
+
+// OAuthToken exchanges an authorization code for an access token and an ID token. Confidential
+// clients authenticate with their secret; every client proves with its PKCE code verifier that
+// it started the flow.
+func (app *Config) OAuthToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		app.oauthErrorJSON(w, &oauthError{Code: "invalid_request", Description: err.Error()}, http.StatusBadRequest)
+		return
+	}
+
+	client, err := app.authenticateClient(r)
+	if err != nil {
+		if _, _, basic := r.BasicAuth(); basic {
+			w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+		}
+		app.oauthErrorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	if grantType := r.PostForm.Get("grant_type"); grantType != "authorization_code" {
+		app.oauthErrorJSON(w, &oauthError{Code: "unsupported_grant_type", Description: fmt.Sprintf("grant type %q is not supported", grantType)}, http.StatusBadRequest)
+		return
+	}
+
+	invalidGrant := &oauthError{Code: "invalid_grant", Description: "invalid or expired authorization code"}
+
+	code, err := app.Repo.GetAuthorizationCodeByHash(token.Hash(r.PostForm.Get("code")))
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			app.oauthErrorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		app.oauthErrorJSON(w, invalidGrant, http.StatusBadRequest)
+		return
+	}
+
+	if code.ClientID != client.ID || code.UsedAt != nil || time.Now().After(code.ExpiresAt) ||
+		code.RedirectURI != r.PostForm.Get("redirect_uri") {
+		app.oauthErrorJSON(w, invalidGrant, http.StatusBadRequest)
+		return
+	}
+
+	if !verifyCodeChallenge(r.PostForm.Get("code_verifier"), code.CodeChallenge) {
+		app.oauthErrorJSON(w, &oauthError{Code: "invalid_grant", Description: "code verifier does not match the code challenge"}, http.StatusBadRequest)
+		return
+	}
+
+	if err := app.Repo.MarkAuthorizationCodeUsed(code.ID); err != nil {
+		if !errors.Is(err, data.ErrAuthorizationCodeUsed) {
+			app.oauthErrorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		app.oauthErrorJSON(w, invalidGrant, http.StatusBadRequest)
+		return
+	}
+
+	user, err := app.Repo.GetOne(code.UserID)
+	if err != nil || user.Active == 0 {
+		app.oauthErrorJSON(w, invalidGrant, http.StatusBadRequest)
+		return
+	}
+
+	subject := strconv.Itoa(user.ID)
+
+	// The token gets the audience of client tokens, so it is only accepted by the userinfo
+	// endpoint and never by the APIs of this project. Roles only go to first-party clients, which
+	// may base their own authorization on them.
+	claims := token.Claims{
+		Email:    user.Email,
+		AMR:      code.AuthMethods,
+		Scope:    code.Scope,
+		ClientID: client.ID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject: subject,
+		},
+	}
+	if client.FirstParty {
+		claims.Roles = user.Roles
+	}
+
+	accessToken, expiresAt, err := app.Tokens.IssueAccessTokenClaims(claims)
+	if err != nil {
+		app.oauthErrorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	info := newUserInfo(*user, strings.Fields(code.Scope))
+
+	idToken, err := app.Tokens.IssueIDToken(token.IDClaims{
+		Nonce:      code.Nonce,
+		AuthTime:   jwt.NewNumericDate(code.AuthTime),
+		AMR:        code.AuthMethods,
+		Email:      info.Email,
+		Name:       info.Name,
+		GivenName:  info.GivenName,
+		FamilyName: info.FamilyName,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject: subject,
+		},
+	}, client.ID)
+	if err != nil {
+		app.oauthErrorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, oauthTokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(time.Until(expiresAt).Seconds()),
+		Scope:       code.Scope,
+		IDToken:     idToken,
+	}, noStoreHeaders())
+}
+
+// UserInfo returns the claims about the calling user that the scopes of the access token allow.
+// Only tokens issued through OpenID Connect are accepted.
+func (app *Config) UserInfo(w http.ResponseWriter, r *http.Request) {
+	claims, ok := claimsFromContext(r.Context())
+	if !ok || !claims.HasScope(scopeOpenID) {
+		w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="openid"`)
+		app.oauthErrorJSON(w, &oauthError{Code: "insufficient_scope", Description: "the access token was not issued for OpenID Connect"}, http.StatusForbidden)
+		return
+	}
+
+	user, ok := app.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, newUserInfo(*user, strings.Fields(claims.Scope)), noStoreHeaders())
+}
+
+// parseAuthorizationRequest validates the parameters of an authorization request. Errors about
+// the client or its redirect URI leave the returned request nil, as the user must not be sent
+// to an unverified redirect URI; all other errors are *oauthError values to be reported to
+// the client.
+func (app *Config) parseAuthorizationRequest(r *http.Request) (*authorizationRequest, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, err
+	}
+
+	req := &authorizationRequest{
+		ResponseType:        r.Form.Get("response_type"),
+		ClientID:            r.Form.Get("client_id"),
+		RedirectURI:         r.Form.Get("redirect_uri"),
+		Scope:               r.Form.Get("scope"),
+		State:               r.Form.Get("state"),
+		Nonce:               r.Form.Get("nonce"),
+		CodeChallenge:       r.Form.Get("code_challenge"),
+		CodeChallengeMethod: r.Form.Get("code_challenge_method"),
+	}
+
+	client, err := app.Repo.GetOAuthClient(req.ClientID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errUnknownClient
+		}
+		return nil, err
+	}
+
+	if !client.AllowsRedirect(req.RedirectURI) {
+		return nil, errUnregisteredRedirect
+	}
+
+	req.client = client
+
+	if req.ResponseType != "code" {
+		return req, &oauthError{Code: "unsupported_response_type", Description: "only the code response type is supported"}
+	}
+
+	scopes := strings.Fields(req.Scope)
+	if !containsString(scopes, scopeOpenID) {
+		return req, &oauthError{Code: "invalid_scope", Description: "the openid scope is required"}
+	}
+
+	for _, scope := range scopes {
+		if !supportedScope(scope) {
+			return req, &oauthError{Code: "invalid_scope", Description: fmt.Sprintf("scope %q is not supported", scope)}
+		}
+	}
+
+	if req.CodeChallengeMethod != pkceMethodS256 || !pkceRX.MatchString(req.CodeChallenge) {
+		return req, &oauthError{Code: "invalid_request", Description: "a PKCE code challenge with the S256 method is required"}
+	}
+
+	return req, nil
+}
+
+// authorizationError reports a failed authorization request. Errors that can be trusted to reach
+// the client go back to its redirect URI, anything else is shown to the user.
+func (app *Config) authorizationError(w http.ResponseWriter, r *http.Request, req *authorizationRequest, err error) {
+	var oauthErr *oauthError
+
+	switch {
+	case req != nil && errors.As(err, &oauthErr):
+		params := url.Values{"error": {oauthErr.Code}}
+		if oauthErr.Description != "" {
+			params.Set("error_description", oauthErr.Description)
+		}
+		app.redirectToClient(w, r, req, params)
+
+	case req != nil:
+		log.Println("Authorization request failed:", err)
+		app.redirectToClient(w, r, req, url.Values{"error": {"server_error"}})
+
+	case errors.Is(err, errUnknownClient), errors.Is(err, errUnregisteredRedirect):
+		app.renderAuthorizePage(w, http.StatusBadRequest, nil, "", err.Error())
+
+	default:
+		log.Println("Authorization request failed:", err)
+		app.renderAuthorizePage(w, http.StatusBadRequest, nil, "", "The authorization request is invalid.")
+	}
+}
+
+// redirectToClient sends the user back to the redirect URI of the client with params, the
+// state of the request and the issuer (RFC 9207) added to its query.
+func (app *Config) redirectToClient(w http.ResponseWriter, r *http.Request, req *authorizationRequest, params url.Values) {
+	target, err := url.Parse(req.RedirectURI)
+	if err != nil {
+		app.renderAuthorizePage(w, http.StatusBadRequest, nil, "", "the redirect URI is invalid")
+		return
+	}
+
+	query := target.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+	if req.State != "" {
+		query.Set("state", req.State)
+	}
+	query.Set("iss", app.Tokens.Issuer)
+	target.RawQuery = query.Encode()
+
+	// 303 turns the POST of the login form into a GET of the redirect URI
+	status := http.StatusFound
+	if r.Method == http.MethodPost {
+		status = http.StatusSeeOther
+	}
+
+	http.Redirect(w, r, target.String(), status)
+}
+
+func (app *Config) renderAuthorizePage(w http.ResponseWriter, status int, req *authorizationRequest, email, message string) {
+	page := authorizePage{
+		Request: req,
+		Email:   email,
+		Error:   message,
+	}
+
+	if req != nil {
+		page.ClientName = req.client.Name
+		page.FirstParty = req.client.FirstParty
+
+		for _, s := range scopeDescriptions {
+			if containsString(strings.Fields(req.Scope), s.Scope) {
+				page.Scopes = append(page.Scopes, s.Description)
+			}
+		}
+	}
+
+	// the page takes credentials, so it must not be cached or framed by another site
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "frame-ancestors 'none'")
+	w.WriteHeader(status)
+
+	if err := authorizeTemplate.Execute(w, page); err != nil {
+		log.Println("Failed to render the authorize page:", err)
+	}
+}
+
+// authenticateClient identifies the client calling the token endpoint, from HTTP basic
+// authentication or from the client_id and client_secret form parameters. Public clients
+// only send their client ID.
+func (app *Config) authenticateClient(r *http.Request) (*data.OAuthClient, error) {
+	invalidClient := &oauthError{Code: "invalid_client", Description: "client authentication failed"}
+
+	clientID, secret, basic := r.BasicAuth()
+	if basic {
+		// RFC 6749 has the client form-urlencode both values before basic authentication
+		var err error
+		if clientID, err = url.QueryUnescape(clientID); err != nil {
+			return nil, invalidClient
+		}
+		if secret, err = url.QueryUnescape(secret); err != nil {
+			return nil, invalidClient
+		}
+	} else {
+		clientID = r.PostForm.Get("client_id")
+		secret = r.PostForm.Get("client_secret")
+	}
+
+	client, err := app.Repo.GetOAuthClient(clientID)
+	if err != nil {
+		return nil, invalidClient
+	}
+
+	if !client.Confidential() {
+		if secret != "" {
+			return nil, invalidClient
+		}
+		return client, nil
+	}
+
+	if subtle.ConstantTimeCompare([]byte(token.Hash(secret)), []byte(client.SecretHash)) != 1 {
+		return nil, invalidClient
+	}
+
+	return client, nil
+}
+
+// oauthErrorJSON writes an error response of the token and userinfo endpoints. Errors other
+// than *oauthError are internal and reported as server_error.
+func (app *Config) oauthErrorJSON(w http.ResponseWriter, err error, status int) {
+	var oauthErr *oauthError
+	if !errors.As(err, &oauthErr) {
+		log.Println("OAuth request failed:", err)
+		oauthErr = &oauthError{Code: "server_error"}
+	}
+
+	app.writeJSON(w, status, oauthErr, noStoreHeaders())
+}
+
+// verifyCodeChallenge checks a PKCE code verifier against the S256 challenge of the code.
+func verifyCodeChallenge(verifier, challenge string) bool {
+	if !pkceRX.MatchString(verifier) {
+		return false
+	}
+
+	sum := sha256.Sum256([]byte(verifier))
+	computed := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) == 1
+}
+
+func newUserInfo(user data.User, scopes []string) userInfo {
+	info := userInfo{Subject: strconv.Itoa(user.ID)}
+
+	if containsString(scopes, scopeEmail) {
+		info.Email = user.Email
+	}
+
+	if containsString(scopes, scopeProfile) {
+		info.Name = strings.TrimSpace(user.FirstName + " " + user.LastName)
+		info.GivenName = user.FirstName
+		info.FamilyName = user.LastName
+	}
+
+	return info
+}
+
+func supportedScope(scope string) bool {
+	for _, s := range scopeDescriptions {
+		if s.Scope == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// noStoreHeaders keeps responses carrying tokens out of caches (RFC 6749, section 5.1).
+func noStoreHeaders() http.Header {
+	return http.Header{
+		"Cache-Control": {"no-store"},
+		"Pragma":        {"no-cache"},
+	}
+}
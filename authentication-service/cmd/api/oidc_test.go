@@ -0,0 +1,384 @@
+package main
+
+import (
+	"authentication/data"
+	"authentication/throttle"
+	"authentication/token"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/pquerna/otp/totp"
+)
+
+// Test_OpenIDConnect runs the authorization code flow end to end against an in-process server,
+// the way a client library would: discovery, login, code exchange, ID token verification
+// against the JWKS and userinfo.
+func Test_OpenIDConnect(t *testing.T) {
+	prepareTestApp()
+
+	srv := httptest.NewServer(nil)
+	defer srv.Close()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := testApp
+	app.Repo = data.NewPostgresTestRepository(nil)
+	app.Tokens = token.NewManager(key, srv.URL, time.Minute, time.Hour)
+	app.Limiter = throttle.NewLimiter(throttle.NewMemoryStore())
+	srv.Config.Handler = app.routes()
+
+	// the client stops at redirects to inspect them, like the browser handing over to the app
+	client := srv.Client()
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	var discovery openIDConfiguration
+	getJSON(t, client, srv.URL+"/.well-known/openid-configuration", &discovery)
+
+	if discovery.Issuer != srv.URL || discovery.TokenEndpoint != srv.URL+"/oauth/token" {
+		t.Fatalf("unexpected discovery document %+v", discovery)
+	}
+
+	verifier := strings.Repeat("verifier-", 6)
+	sum := sha256.Sum256([]byte(verifier))
+	challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	authorizeParams := func(clientID, redirectURI string) url.Values {
+		return url.Values{
+			"response_type":         {"code"},
+			"client_id":             {clientID},
+			"redirect_uri":          {redirectURI},
+			"scope":                 {"openid email profile"},
+			"state":                 {"xyz"},
+			"nonce":                 {"n-0S6_WzA2Mj"},
+			"code_challenge":        {challenge},
+			"code_challenge_method": {"S256"},
+		}
+	}
+
+	login := func(params url.Values, fields map[string]string) *http.Response {
+		form := url.Values{}
+		for k, v := range params {
+			form[k] = v
+		}
+		for k, v := range fields {
+			form.Set(k, v)
+		}
+
+		resp, err := client.PostForm(discovery.AuthorizationEndpoint, form)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		return resp
+	}
+
+	exchange := func(form url.Values, basicUser, basicPassword string) (*http.Response, map[string]any) {
+		req, _ := http.NewRequest(http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if basicUser != "" {
+			req.SetBasicAuth(basicUser, basicPassword)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		var body map[string]any
+		_ = json.NewDecoder(resp.Body).Decode(&body)
+
+		return resp, body
+	}
+
+	// first-party client: a login page without consent, then a code for the redirect URI
+	firstParty := authorizeParams("first-party-app", "http://app.example.com/callback")
+
+	resp, err := client.Get(discovery.AuthorizationEndpoint + "?" + firstParty.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(page), "Log in to First Party App") || strings.Contains(string(page), "will be able to") {
+		t.Fatalf("expected the login page without consent but got %d: %s", resp.StatusCode, page)
+	}
+
+	resp = login(firstParty, map[string]string{"email": "me@here.com", "password": "verysecret"})
+	code := redirectParams(t, resp, "http://app.example.com/callback", srv.URL).Get("code")
+
+	tokenForm := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {"http://app.example.com/callback"},
+		"client_id":     {"first-party-app"},
+		"code_verifier": {verifier},
+	}
+
+	wrongVerifier := url.Values{}
+	for k, v := range tokenForm {
+		wrongVerifier[k] = v
+	}
+	wrongVerifier.Set("code_verifier", strings.Repeat("x", 43))
+
+	if resp, body := exchange(wrongVerifier, "", ""); resp.StatusCode != http.StatusBadRequest || body["error"] != "invalid_grant" {
+		t.Errorf("expected invalid_grant for a wrong code verifier but got %d %v", resp.StatusCode, body)
+	}
+
+	resp, body := exchange(tokenForm, "", "")
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Cache-Control") != "no-store" {
+		t.Fatalf("expected tokens but got %d %v", resp.StatusCode, body)
+	}
+
+	idClaims := verifyIDToken(t, client, discovery, body["id_token"].(string), "first-party-app")
+	if idClaims.Subject != "1" || idClaims.Nonce != "n-0S6_WzA2Mj" || idClaims.Email != "me@here.com" || idClaims.GivenName != "First" {
+		t.Errorf("unexpected ID token claims %+v", idClaims)
+	}
+
+	firstPartyToken := body["access_token"].(string)
+
+	accessClaims, err := app.Tokens.VerifyClientToken(firstPartyToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !accessClaims.HasRole(adminRole) || accessClaims.ClientID != "first-party-app" || !accessClaims.HasScope(scopeOpenID) {
+		t.Errorf("expected a first-party access token with roles and scopes but got %+v", accessClaims)
+	}
+
+	if resp, body := exchange(tokenForm, "", ""); resp.StatusCode != http.StatusBadRequest || body["error"] != "invalid_grant" {
+		t.Errorf("expected invalid_grant for a reused code but got %d %v", resp.StatusCode, body)
+	}
+
+	var info userInfo
+	if status := getJSON(t, client, discovery.UserinfoEndpoint, &info, firstPartyToken); status != http.StatusOK {
+		t.Errorf("expected http.StatusOK from userinfo but got %d", status)
+	}
+
+	plainToken, _, _ := app.Tokens.IssueAccessToken(1, "me@here.com", nil, []string{amrPassword})
+	if status := getJSON(t, client, discovery.UserinfoEndpoint, &info, plainToken); status != http.StatusUnauthorized {
+		t.Errorf("expected http.StatusUnauthorized from userinfo for a token not issued to a client but got %d", status)
+	}
+
+	// third-party client: consent on the login page, a secret at the token endpoint and no roles
+	partner := authorizeParams("partner-app", "https://partner.example.com/callback")
+
+	resp, _ = client.Get(discovery.AuthorizationEndpoint + "?" + partner.Encode())
+	page, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if !strings.Contains(string(page), "Partner App will be able to") || !strings.Contains(string(page), "See your email address") {
+		t.Errorf("expected the consent page for a third-party client but got %s", page)
+	}
+
+	resp = login(partner, map[string]string{"action": "deny"})
+	if params := redirectParams(t, resp, "https://partner.example.com/callback", srv.URL); params.Get("error") != "access_denied" {
+		t.Errorf("expected access_denied but got %v", params)
+	}
+
+	resp = login(partner, map[string]string{"email": "me@here.com", "password": "verysecret", "action": "allow"})
+	partnerForm := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {redirectParams(t, resp, "https://partner.example.com/callback", srv.URL).Get("code")},
+		"redirect_uri":  {"https://partner.example.com/callback"},
+		"code_verifier": {verifier},
+	}
+
+	if resp, body := exchange(partnerForm, "partner-app", "wrong-secret"); resp.StatusCode != http.StatusUnauthorized || body["error"] != "invalid_client" {
+		t.Errorf("expected invalid_client for a wrong secret but got %d %v", resp.StatusCode, body)
+	}
+
+	resp, body = exchange(partnerForm, "partner-app", data.TestClientSecret)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected tokens for the partner but got %d %v", resp.StatusCode, body)
+	}
+
+	partnerToken := body["access_token"].(string)
+
+	accessClaims, _ = app.Tokens.VerifyClientToken(partnerToken)
+	if accessClaims == nil || len(accessClaims.Roles) != 0 {
+		t.Errorf("expected a third-party access token without roles but got %+v", accessClaims)
+	}
+
+	// client tokens only work at the userinfo endpoint, not as the user anywhere else
+	for _, clientToken := range []string{firstPartyToken, partnerToken} {
+		for _, route := range []struct{ method, path string }{
+			{http.MethodPost, "/users/me/totp"},
+			{http.MethodPost, "/users/me/totp/verify"},
+			{http.MethodGet, "/users"},
+			{http.MethodPost, "/users/2/unlock"},
+			{http.MethodGet, "/roles"},
+			{http.MethodGet, "/oauth/clients"},
+		} {
+			req, _ := http.NewRequest(route.method, srv.URL+route.path, strings.NewReader(`{"code": "123456"}`))
+			req.Header.Set("Authorization", "Bearer "+clientToken)
+
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != http.StatusUnauthorized {
+				t.Errorf("expected http.StatusUnauthorized for a client token at %s %s but got %d", route.method, route.path, resp.StatusCode)
+			}
+		}
+	}
+
+	// users with two-factor authentication enter a code on the login page
+	resp = login(firstParty, map[string]string{"email": "totp@here.com", "password": "verysecret", "code": "000000"})
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected http.StatusUnauthorized for a wrong TOTP code but got %d", resp.StatusCode)
+	}
+
+	totpCode, _ := totp.GenerateCode(data.TestTOTPSecret, time.Now())
+	resp = login(firstParty, map[string]string{"email": "totp@here.com", "password": "verysecret", "code": totpCode})
+	tokenForm.Set("code", redirectParams(t, resp, "http://app.example.com/callback", srv.URL).Get("code"))
+
+	_, body = exchange(tokenForm, "", "")
+	if idClaims := verifyIDToken(t, client, discovery, body["id_token"].(string), "first-party-app"); len(idClaims.AMR) != 2 {
+		t.Errorf("expected the ID token to record both factors but got %v", idClaims.AMR)
+	}
+}
+
+func Test_Authorize_invalidRequests(t *testing.T) {
+	routes := testApp.routes()
+
+	valid := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {"first-party-app"},
+		"redirect_uri":          {"http://app.example.com/callback"},
+		"scope":                 {"openid"},
+		"state":                 {"xyz"},
+		"code_challenge":        {strings.Repeat("a", 43)},
+		"code_challenge_method": {"S256"},
+	}
+
+	tests := []struct {
+		name          string
+		param         string
+		value         string
+		expectedCode  int
+		expectedError string
+	}{
+		{"valid", "", "", http.StatusOK, ""},
+		{"unknown client", "client_id", "nobody", http.StatusBadRequest, ""},
+		{"unregistered redirect", "redirect_uri", "http://evil.example.com/callback", http.StatusBadRequest, ""},
+		{"implicit flow", "response_type", "token", http.StatusFound, "unsupported_response_type"},
+		{"no openid scope", "scope", "email", http.StatusFound, "invalid_scope"},
+		{"unknown scope", "scope", "openid admin", http.StatusFound, "invalid_scope"},
+		{"plain PKCE", "code_challenge_method", "plain", http.StatusFound, "invalid_request"},
+		{"no PKCE", "code_challenge", "", http.StatusFound, "invalid_request"},
+	}
+
+	for _, tt := range tests {
+		params := url.Values{}
+		for k, v := range valid {
+			params[k] = v
+		}
+		if tt.param != "" {
+			params.Set(tt.param, tt.value)
+		}
+
+		req, _ := http.NewRequest(http.MethodGet, "/oauth/authorize?"+params.Encode(), nil)
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != tt.expectedCode {
+			t.Errorf("%s: expected status %d but got %d", tt.name, tt.expectedCode, rr.Code)
+			continue
+		}
+
+		if tt.expectedError == "" {
+			continue
+		}
+
+		location, _ := url.Parse(rr.Header().Get("Location"))
+		if !strings.HasPrefix(location.String(), "http://app.example.com/callback?") ||
+			location.Query().Get("error") != tt.expectedError || location.Query().Get("state") != "xyz" {
+			t.Errorf("%s: expected a redirect with error %s but got %s", tt.name, tt.expectedError, location)
+		}
+	}
+}
+
+// getJSON fetches url, optionally with a bearer token, and decodes the response into v.
+func getJSON(t *testing.T, client *http.Client, url string, v any, accessToken ...string) int {
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	if len(accessToken) > 0 {
+		req.Header.Set("Authorization", "Bearer "+accessToken[0])
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	_ = json.NewDecoder(resp.Body).Decode(v)
+
+	return resp.StatusCode
+}
+
+// redirectParams checks that resp redirects to redirectURI with the expected state and issuer
+// and returns the query parameters of the redirect.
+func redirectParams(t *testing.T, resp *http.Response, redirectURI, issuer string) url.Values {
+	t.Helper()
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if resp.StatusCode != http.StatusSeeOther || err != nil {
+		t.Fatalf("expected a redirect to the client but got %d %q", resp.StatusCode, resp.Header.Get("Location"))
+	}
+
+	params := location.Query()
+	location.RawQuery = ""
+
+	if location.String() != redirectURI || params.Get("state") != "xyz" || params.Get("iss") != issuer {
+		t.Fatalf("unexpected redirect %s", resp.Header.Get("Location"))
+	}
+
+	return params
+}
+
+// verifyIDToken checks an ID token the way a client does, with the public key from the JWKS.
+func verifyIDToken(t *testing.T, client *http.Client, discovery openIDConfiguration, idToken, clientID string) *token.IDClaims {
+	t.Helper()
+
+	var keys token.JWKS
+	getJSON(t, client, discovery.JWKSURI, &keys)
+
+	var claims token.IDClaims
+	_, err := jwt.ParseWithClaims(idToken, &claims, func(tok *jwt.Token) (any, error) {
+		for _, k := range keys.Keys {
+			if k.Kid == tok.Header["kid"] {
+				n, _ := base64.RawURLEncoding.DecodeString(k.N)
+				e, _ := base64.RawURLEncoding.DecodeString(k.E)
+
+				return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+			}
+		}
+		return nil, jwt.ErrTokenUnverifiable
+	}, jwt.WithValidMethods([]string{"RS256"}), jwt.WithIssuer(discovery.Issuer), jwt.WithAudience(clientID))
+	if err != nil {
+		t.Fatalf("invalid ID token: %v", err)
+	}
+
+	return &claims
+}
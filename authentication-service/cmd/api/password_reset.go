@@ -0,0 +1,205 @@
+package main
+
+import (
+	"authentication/data"
+	"authentication/token"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+const passwordResetTTL = 30 * time.Minute
+
+var errInvalidResetToken = errors.New("invalid or expired password reset token")
+
+type forgotPasswordPayload struct {
+	Email string `json:"email"`
+}
+
+type resetPasswordPayload struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+type mailMessage struct {
+	To       string         `json:"to"`
+	Subject  string         `json:"subject"`
+	Template string         `json:"template"`
+	Data     map[string]any `json:"data"`
+}
+
+// ForgotPassword emails a single-use password reset link to the owner of an account. The response
+// is the same whether or not the account exists, so it can't be used to discover accounts.
+func (app *Config) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var requestPayload forgotPasswordPayload
+	if err := app.readJSON(w, r, &requestPayload); err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	email := strings.TrimSpace(requestPayload.Email)
+
+	v := newValidator()
+	validateEmail(v, email)
+	if !v.Valid() {
+		app.failedValidationJSON(w, v.Errors)
+		return
+	}
+
+	responsePayload := jsonResponse{
+		Error:   false,
+		Message: "If an account exists for this email address, a password reset link has been sent to it",
+	}
+
+	user, err := app.Repo.GetByEmail(email)
+	if err != nil || user.Active == 0 {
+		app.writeJSON(w, http.StatusAccepted, responsePayload)
+		return
+	}
+
+	plainText, hash, err := token.NewOpaqueToken()
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	_, err = app.Repo.InsertPasswordReset(data.PasswordReset{
+		UserID:    user.ID,
+		TokenHash: hash,
+		ExpiresAt: time.Now().Add(passwordResetTTL),
+	})
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// Sending the email takes a while; doing it in the background keeps the response time
+	// independent of whether the account exists. It outlives the request, so it only keeps
+	// its trace.
+	ctx := trace.ContextWithSpanContext(context.Background(), trace.SpanContextFromContext(r.Context()))
+	go func() {
+		if err := app.sendPasswordResetMail(ctx, *user, plainText); err != nil {
+			log.Printf("Failed to send password reset email to %s: %v", user.Email, err)
+		}
+	}()
+
+	app.writeJSON(w, http.StatusAccepted, responsePayload)
+}
+
+// ResetPassword redeems a password reset token and sets the new password. All refresh tokens of
+// the user are revoked, so every existing session has to log in again.
+func (app *Config) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var requestPayload resetPasswordPayload
+	if err := app.readJSON(w, r, &requestPayload); err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	v := newValidator()
+	v.Check(requestPayload.Token != "", "token", "must be provided")
+	validatePassword(v, requestPayload.Password)
+	if !v.Valid() {
+		app.failedValidationJSON(w, v.Errors)
+		return
+	}
+
+	reset, err := app.Repo.GetPasswordResetByHash(token.Hash(requestPayload.Token))
+	if err != nil || reset.UsedAt != nil || time.Now().After(reset.ExpiresAt) {
+		app.errorJSON(w, errInvalidResetToken, http.StatusBadRequest)
+		return
+	}
+
+	if err := app.Repo.MarkPasswordResetUsed(reset.ID); err != nil {
+		if errors.Is(err, data.ErrPasswordResetUsed) {
+			app.errorJSON(w, errInvalidResetToken, http.StatusBadRequest)
+		} else {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	user, err := app.Repo.GetOne(reset.UserID)
+	if err != nil {
+		app.errorJSON(w, errInvalidResetToken, http.StatusBadRequest)
+		return
+	}
+
+	if err := app.Repo.ResetPassword(requestPayload.Password, *user); err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if err := app.Repo.RevokeUserRefreshTokens(user.ID); err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if err := app.logRequest(r.Context(), "authentication", fmt.Sprintf("%s reset their password", user.Email), user.ID); err != nil {
+		log.Println("Failed to log password reset:", err)
+	}
+
+	app.writeJSON(w, http.StatusAccepted, jsonResponse{
+		Error:   false,
+		Message: "Password has been reset",
+	})
+}
+
+// sendPasswordResetMail asks the mail service to send the templated reset email.
+func (app *Config) sendPasswordResetMail(ctx context.Context, user data.User, resetToken string) error {
+	resetURL, err := url.Parse(app.PasswordResetURL)
+	if err != nil {
+		return err
+	}
+
+	query := resetURL.Query()
+	query.Set("token", resetToken)
+	resetURL.RawQuery = query.Encode()
+
+	name := user.FirstName
+	if name == "" {
+		name = user.Email
+	}
+
+	msg := mailMessage{
+		To:       user.Email,
+		Subject:  "Reset your password",
+		Template: "password-reset",
+		Data: map[string]any{
+			"first_name": name,
+			"reset_url":  resetURL.String(),
+			"expires_in": fmt.Sprintf("%d minutes", int(passwordResetTTL.Minutes())),
+		},
+	}
+
+	jsonData, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, app.MailServiceURL, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := app.Client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("unexpected status code from mail service: %d", response.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_ForgotPassword(t *testing.T) {
+	sent := make(chan mailMessage, 1)
+
+	app := testApp
+	app.MailServiceURL = "http://mailer-service/send"
+	app.PasswordResetURL = "http://localhost/reset-password"
+	app.Client = NewTestClient(func(req *http.Request) *http.Response {
+		var msg mailMessage
+		_ = json.NewDecoder(req.Body).Decode(&msg)
+		sent <- msg
+
+		return &http.Response{
+			StatusCode: http.StatusAccepted,
+			Body:       io.NopCloser(bytes.NewBufferString(`{"error": false}`)),
+			Header:     make(http.Header),
+		}
+	})
+
+	for _, email := range []string{"me@here.com", "nobody@here.com"} {
+		body, _ := json.Marshal(forgotPasswordPayload{Email: email})
+
+		req, _ := http.NewRequest(http.MethodPost, "/password/forgot", bytes.NewReader(body))
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(app.ForgotPassword)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusAccepted {
+			t.Errorf("%s: expected http.StatusAccepted but got %d", email, rr.Code)
+		}
+	}
+
+	select {
+	case msg := <-sent:
+		if msg.To != "me@here.com" || msg.Template != "password-reset" {
+			t.Errorf("unexpected reset email: %+v", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected a password reset email to be sent")
+	}
+
+	select {
+	case msg := <-sent:
+		t.Errorf("expected no email for an unknown account but got %+v", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func Test_ResetPassword(t *testing.T) {
+	prepareTestApp()
+
+	tests := []struct {
+		name         string
+		payload      resetPasswordPayload
+		expectedCode int
+	}{
+		{"valid token", resetPasswordPayload{Token: "reset-token", Password: "anothersecret"}, http.StatusAccepted},
+		{"missing token", resetPasswordPayload{Password: "anothersecret"}, http.StatusUnprocessableEntity},
+		{"short password", resetPasswordPayload{Token: "reset-token", Password: "short"}, http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		body, _ := json.Marshal(tt.payload)
+
+		req, _ := http.NewRequest(http.MethodPost, "/password/reset", bytes.NewReader(body))
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(testApp.ResetPassword)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != tt.expectedCode {
+			t.Errorf("%s: expected status %d but got %d", tt.name, tt.expectedCode, rr.Code)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"authentication/data"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// adminRole grants access to the user and role management API. It can't be deleted.
+const adminRole = "admin"
+
+var (
+	errRoleNotFound  = errors.New("role not found")
+	errDuplicateRole = errors.New("a role with this name already exists")
+)
+
+// roleNameRX keeps role names usable in URLs and token claims.
+var roleNameRX = regexp.MustCompile(`^[a-z][a-z0-9-]*$`)
+
+type rolePayload struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// ListRoles returns all roles.
+func (app *Config) ListRoles(w http.ResponseWriter, r *http.Request) {
+	roles, err := app.Repo.GetAllRoles()
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Found %d roles", len(roles)),
+		Data:    roles,
+	})
+}
+
+// CreateRole validates the payload and inserts a new role.
+func (app *Config) CreateRole(w http.ResponseWriter, r *http.Request) {
+	var requestPayload rolePayload
+	if err := app.readJSON(w, r, &requestPayload); err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	role := data.Role{
+		Name:        strings.TrimSpace(requestPayload.Name),
+		Description: strings.TrimSpace(requestPayload.Description),
+	}
+
+	v := newValidator()
+	v.Check(role.Name != "", "name", "must be provided")
+	v.Check(len(role.Name) <= 64, "name", "must not be more than 64 characters long")
+	v.Check(role.Name == "" || roleNameRX.MatchString(role.Name), "name", "must only contain lowercase letters, digits and dashes")
+	v.Check(len(role.Description) <= 500, "description", "must not be more than 500 characters long")
+
+	if !v.Valid() {
+		app.failedValidationJSON(w, v.Errors)
+		return
+	}
+
+	if _, err := app.Repo.GetRoleByName(role.Name); err == nil {
+		app.errorJSON(w, errDuplicateRole, http.StatusConflict)
+		return
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	id, err := app.Repo.InsertRole(role)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+	role.ID = id
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/roles/%s", role.Name))
+
+	app.writeJSON(w, http.StatusCreated, jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Created role %s", role.Name),
+		Data:    role,
+	}, headers)
+}
+
+// DeleteRole removes a role and takes it away from every user who had it.
+func (app *Config) DeleteRole(w http.ResponseWriter, r *http.Request) {
+	role, ok := app.roleFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	if role.Name == adminRole {
+		app.errorJSON(w, errors.New("the admin role can't be deleted"), http.StatusConflict)
+		return
+	}
+
+	if err := app.Repo.DeleteRole(role.ID); err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Deleted role %s", role.Name),
+	})
+}
+
+// GetUserRoles returns the names of the roles of a user.
+func (app *Config) GetUserRoles(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Roles of user %d", user.ID),
+		Data:    user.Roles,
+	})
+}
+
+// AssignUserRole grants a role to a user. It takes effect when the user next logs in or
+// refreshes their access token.
+func (app *Config) AssignUserRole(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	role, ok := app.roleFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	if err := app.Repo.AssignRole(user.ID, role.ID); err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Assigned role %s to user %d", role.Name, user.ID),
+	})
+}
+
+// RevokeUserRole takes a role away from a user. Administrators can't revoke their own admin
+// role, so there is always someone left to manage roles.
+func (app *Config) RevokeUserRole(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	role, ok := app.roleFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	if claims, ok := claimsFromContext(r.Context()); ok && role.Name == adminRole && claims.Subject == strconv.Itoa(user.ID) {
+		app.errorJSON(w, errors.New("you can't revoke your own admin role"), http.StatusConflict)
+		return
+	}
+
+	if err := app.Repo.RevokeRole(user.ID, role.ID); err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Revoked role %s from user %d", role.Name, user.ID),
+	})
+}
+
+// roleFromURL loads the role named by the {role} URL parameter. When it returns false an
+// error response has already been sent.
+func (app *Config) roleFromURL(w http.ResponseWriter, r *http.Request) (*data.Role, bool) {
+	role, err := app.Repo.GetRoleByName(chi.URLParam(r, "role"))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errRoleNotFound, http.StatusNotFound)
+		} else {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+		}
+		return nil, false
+	}
+
+	return role, true
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_RolesAPI(t *testing.T) {
+	accessToken, _, _ := testApp.Tokens.IssueAccessToken(1, "me@here.com", []string{adminRole}, []string{amrPassword, amrOTP})
+	mailerToken, _, _ := testApp.Tokens.IssueAccessToken(2, "totp@here.com", []string{"mailer"}, []string{amrPassword, amrOTP})
+
+	tests := []struct {
+		name         string
+		method       string
+		url          string
+		body         string
+		token        string
+		expectedCode int
+	}{
+		{"no token", http.MethodGet, "/roles", "", "", http.StatusUnauthorized},
+		{"not an admin", http.MethodGet, "/roles", "", mailerToken, http.StatusForbidden},
+		{"list", http.MethodGet, "/roles", "", accessToken, http.StatusOK},
+		{"create", http.MethodPost, "/roles", `{"name": "log-reader", "description": "Reads log entries"}`, accessToken, http.StatusCreated},
+		{"create duplicate", http.MethodPost, "/roles", `{"name": "mailer"}`, accessToken, http.StatusConflict},
+		{"create invalid", http.MethodPost, "/roles", `{"name": "Log Reader"}`, accessToken, http.StatusUnprocessableEntity},
+		{"delete", http.MethodDelete, "/roles/mailer", "", accessToken, http.StatusOK},
+		{"delete admin", http.MethodDelete, "/roles/admin", "", accessToken, http.StatusConflict},
+		{"delete unknown", http.MethodDelete, "/roles/nobody", "", accessToken, http.StatusNotFound},
+		{"user roles", http.MethodGet, "/users/1/roles", "", accessToken, http.StatusOK},
+		{"assign", http.MethodPut, "/users/2/roles/mailer", "", accessToken, http.StatusOK},
+		{"assign unknown", http.MethodPut, "/users/2/roles/nobody", "", accessToken, http.StatusNotFound},
+		{"revoke", http.MethodDelete, "/users/2/roles/mailer", "", accessToken, http.StatusOK},
+		{"revoke own admin", http.MethodDelete, "/users/1/roles/admin", "", accessToken, http.StatusConflict},
+	}
+
+	routes := testApp.routes()
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest(tt.method, tt.url, bytes.NewBufferString(tt.body))
+		if tt.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		rr := httptest.NewRecorder()
+
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != tt.expectedCode {
+			t.Errorf("%s: expected status %d but got %d (%s)", tt.name, tt.expectedCode, rr.Code, rr.Body.String())
+		}
+	}
+}
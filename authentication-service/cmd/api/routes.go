@@ -1,6 +1,8 @@
 package main
 
 import (
+	"authentication/metrics"
+	"authentication/tracing"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
@@ -14,7 +16,7 @@ func (app *Config) routes() http.Handler {
 	// specify who is allowed to connect
 	mux.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{"https://*", "http://*"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
 		ExposedHeaders:   []string{"Link"},
 		AllowCredentials: true,
@@ -22,7 +24,78 @@ func (app *Config) routes() http.Handler {
 	}))
 
 	mux.Use(middleware.Heartbeat("/ping"))
+	mux.Use(realIP(app.TrustedProxies))
+	mux.Use(tracing.Middleware)
+	mux.Use(metrics.Middleware)
+
+	mux.Handle("/metrics", metrics.Handler())
+	mux.Get("/livez", app.Health.Livez)
+	mux.Get("/readyz", app.Health.Readyz)
 
 	mux.Post("/authenticate", app.Authenticate)
+	mux.Post("/authenticate/totp", app.AuthenticateTOTP)
+	mux.Post("/token/refresh", app.RefreshToken)
+	mux.Post("/token/revoke", app.RevokeToken)
+	mux.Get("/.well-known/jwks.json", app.JWKS)
+	mux.Get("/.well-known/openid-configuration", app.OpenIDConfiguration)
+	mux.Post("/password/forgot", app.ForgotPassword)
+	mux.Post("/password/reset", app.ResetPassword)
+
+	mux.Route("/users", func(mux chi.Router) {
+		mux.Use(app.requireAuth)
+
+		// two-factor enrollment of the calling user
+		mux.Post("/me/totp", app.BeginTOTPEnrollment)
+		mux.Post("/me/totp/verify", app.EnableTOTP)
+		mux.Post("/me/totp/disable", app.DisableTOTP)
+
+		// user management is for administrators, who must have logged in with two-factor authentication
+		mux.Group(func(mux chi.Router) {
+			mux.Use(app.requireRole(adminRole))
+			mux.Use(app.requireMFA)
+
+			mux.Get("/", app.ListUsers)
+			mux.Post("/", app.CreateUser)
+			mux.Get("/{id}", app.GetUser)
+			mux.Patch("/{id}", app.UpdateUser)
+			mux.Delete("/{id}", app.DeleteUser)
+			mux.Put("/{id}/password", app.ResetUserPassword)
+			mux.Post("/{id}/unlock", app.UnlockUser)
+			mux.Delete("/{id}/totp", app.ResetUserTOTP)
+			mux.Get("/{id}/roles", app.GetUserRoles)
+			mux.Put("/{id}/roles/{role}", app.AssignUserRole)
+			mux.Delete("/{id}/roles/{role}", app.RevokeUserRole)
+		})
+	})
+
+	mux.Route("/roles", func(mux chi.Router) {
+		mux.Use(app.requireAuth)
+		mux.Use(app.requireRole(adminRole))
+		mux.Use(app.requireMFA)
+
+		mux.Get("/", app.ListRoles)
+		mux.Post("/", app.CreateRole)
+		mux.Delete("/{role}", app.DeleteRole)
+	})
+
+	// OpenID Connect provider
+	mux.Route("/oauth", func(mux chi.Router) {
+		mux.Get("/authorize", app.Authorize)
+		mux.Post("/authorize", app.AuthorizeLogin)
+		mux.Post("/token", app.OAuthToken)
+
+		mux.With(app.requireClientAuth).Get("/userinfo", app.UserInfo)
+		mux.With(app.requireClientAuth).Post("/userinfo", app.UserInfo)
+
+		mux.Route("/clients", func(mux chi.Router) {
+			mux.Use(app.requireAuth)
+			mux.Use(app.requireRole(adminRole))
+			mux.Use(app.requireMFA)
+
+			mux.Get("/", app.ListOAuthClients)
+			mux.Post("/", app.CreateOAuthClient)
+			mux.Delete("/{clientID}", app.DeleteOAuthClient)
+		})
+	})
 	return mux
 }
@@ -16,7 +16,36 @@ func Test_routes_exit(t *testing.T) {
 		return
 	}
 
-	routes := []string{"/authenticate"}
+	routes := []string{
+		"/authenticate",
+		"/authenticate/totp",
+		"/token/refresh",
+		"/token/revoke",
+		"/.well-known/jwks.json",
+		"/.well-known/openid-configuration",
+		"/password/forgot",
+		"/password/reset",
+		"/users/",
+		"/users/{id}",
+		"/users/{id}/password",
+		"/users/{id}/unlock",
+		"/users/{id}/totp",
+		"/users/me/totp",
+		"/users/me/totp/verify",
+		"/users/me/totp/disable",
+		"/users/{id}/roles",
+		"/users/{id}/roles/{role}",
+		"/roles/",
+		"/roles/{role}",
+		"/oauth/authorize",
+		"/oauth/token",
+		"/oauth/userinfo",
+		"/oauth/clients/",
+		"/oauth/clients/{clientID}",
+		"/metrics",
+		"/livez",
+		"/readyz",
+	}
 
 	for _, route := range routes {
 		routeExists(t, testRouter, route)
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"authentication/password"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Settings configure the authentication service; see package config for where they are read
+// from.
+type Settings struct {
+	WebPort          string        `yaml:"web_port" env:"WEB_PORT" default:"80" usage:"port of the HTTP server"`
+	DSN              string        `yaml:"dsn" env:"DSN" required:"true" secret:"true" usage:"Postgres connection string"`
+	SigningKeyFile   string        `yaml:"jwt_signing_key_file" env:"JWT_SIGNING_KEY_FILE" usage:"PEM file with the key that signs access tokens; an ephemeral key is generated if empty"`
+	TokenIssuer      string        `yaml:"jwt_issuer" env:"JWT_ISSUER" default:"http://authentication-service" usage:"issuer of the access tokens"`
+	AccessTokenTTL   time.Duration `yaml:"access_token_ttl" env:"ACCESS_TOKEN_TTL" default:"15m" usage:"lifetime of access tokens"`
+	RefreshTokenTTL  time.Duration `yaml:"refresh_token_ttl" env:"REFRESH_TOKEN_TTL" default:"168h" usage:"lifetime of refresh tokens"`
+	PasswordHasher   string        `yaml:"password_hasher" env:"PASSWORD_HASHER" default:"argon2id" usage:"algorithm new passwords are hashed with: argon2id or bcrypt"`
+	LockoutStore     string        `yaml:"lockout_store" env:"LOCKOUT_STORE" default:"postgres" usage:"where failed logins are counted: postgres or memory"`
+	PasswordResetURL string        `yaml:"password_reset_url" env:"PASSWORD_RESET_URL" default:"http://localhost/reset-password" usage:"page users land on from the password reset email"`
+	TOTPIssuer       string        `yaml:"totp_issuer" env:"TOTP_ISSUER" default:"Microservices in Go" usage:"issuer authenticator apps show next to the codes"`
+	MailServiceURL   string        `yaml:"mail_service_url" env:"MAIL_SERVICE_URL" default:"http://mailer-service/send" usage:"URL password reset emails are sent to"`
+	LogServiceURL    string        `yaml:"log_service_url" env:"LOG_SERVICE_URL" default:"http://logger-service/log" usage:"URL authentications are logged to"`
+	TrustedProxies   []string      `yaml:"trusted_proxies" env:"TRUSTED_PROXIES" usage:"addresses or CIDR ranges of the proxies, such as the broker, whose X-Forwarded-For and X-Real-IP headers are trusted"`
+}
+
+func (s *Settings) Validate() error {
+	if n, err := strconv.Atoi(s.WebPort); err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("web_port: %q is not a port", s.WebPort)
+	}
+
+	if _, err := password.NewPolicy(s.PasswordHasher); err != nil {
+		return fmt.Errorf("password_hasher: %w", err)
+	}
+
+	if s.LockoutStore != "postgres" && s.LockoutStore != "memory" {
+		return fmt.Errorf("lockout_store: %q is neither postgres nor memory", s.LockoutStore)
+	}
+
+	if s.AccessTokenTTL <= 0 || s.RefreshTokenTTL <= 0 {
+		return errors.New("access_token_ttl and refresh_token_ttl must be positive")
+	}
+
+	if s.TokenIssuer == "" {
+		return errors.New("jwt_issuer must not be empty")
+	}
+
+	if _, err := parseNetworks(s.TrustedProxies); err != nil {
+		return fmt.Errorf("trusted_proxies: %w", err)
+	}
+
+	urls := []struct{ key, value string }{
+		{"password_reset_url", s.PasswordResetURL},
+		{"mail_service_url", s.MailServiceURL},
+		{"log_service_url", s.LogServiceURL},
+	}
+	for _, u := range urls {
+		if parsed, err := url.Parse(u.value); err != nil || parsed.Scheme == "" || parsed.Host == "" {
+			return fmt.Errorf("%s is not an absolute URL", u.key)
+		}
+	}
+
+	return nil
+}
+
+// parseNetworks parses a list of addresses and CIDR ranges; an address is a range of its own.
+func parseNetworks(values []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(values))
+	for _, value := range values {
+		if ip := net.ParseIP(value); ip != nil {
+			bits := 8 * net.IPv4len
+			if ip.To4() == nil {
+				bits = 8 * net.IPv6len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("%q is neither an address nor a CIDR range", value)
+		}
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
@@ -2,8 +2,13 @@ package main
 
 import (
 	"authentication/data"
+	"authentication/throttle"
+	"authentication/token"
+	"crypto/rand"
+	"crypto/rsa"
 	"os"
 	"testing"
+	"time"
 )
 
 var testApp Config
@@ -11,5 +16,15 @@ var testApp Config
 func TestMain(m *testing.M) {
 	repo := data.NewPostgresTestRepository(nil)
 	testApp.Repo = repo
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	testApp.Tokens = token.NewManager(key, "http://authentication-service", time.Minute, time.Hour)
+	testApp.Limiter = throttle.NewLimiter(throttle.NewMemoryStore())
+	testApp.TOTPIssuer = "Microservices in Go"
+	testApp.LogServiceURL = "http://logger-service/log"
+
 	os.Exit(m.Run())
 }
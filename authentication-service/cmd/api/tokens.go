@@ -0,0 +1,163 @@
+package main
+
+import (
+	"authentication/data"
+	"authentication/token"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+var errInvalidRefreshToken = errors.New("invalid refresh token")
+
+type tokenRequestPayload struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type tokenPair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+// issueTokens mints an access token and a refresh token for user. An empty familyID starts a
+// new refresh token family; rotations pass the family of the token being replaced.
+func (app *Config) issueTokens(user data.User, familyID string) (*tokenPair, error) {
+	accessToken, expiresAt, err := app.Tokens.IssueAccessToken(user.ID, user.Email, user.Roles, authMethods(user))
+	if err != nil {
+		return nil, err
+	}
+
+	if familyID == "" {
+		familyID, err = token.NewFamilyID()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	refreshToken, hash, err := token.NewOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = app.Repo.InsertRefreshToken(data.RefreshToken{
+		UserID:    user.ID,
+		FamilyID:  familyID,
+		TokenHash: hash,
+		ExpiresAt: time.Now().Add(app.Tokens.RefreshTTL),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &tokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(time.Until(expiresAt).Seconds()),
+	}, nil
+}
+
+// authMethods returns the "amr" claim of a user's tokens. Users with TOTP enabled can't obtain
+// tokens without their second factor, and enabling it revokes all earlier refresh tokens, so
+// every token family of such a user started with a TOTP code.
+func authMethods(user data.User) []string {
+	if user.TOTPEnabled {
+		return []string{amrPassword, amrOTP}
+	}
+
+	return []string{amrPassword}
+}
+
+// RefreshToken exchanges a valid refresh token for a new token pair. The presented refresh token
+// is rotated; presenting it again revokes every token of its family.
+func (app *Config) RefreshToken(w http.ResponseWriter, r *http.Request) {
+	var requestPayload tokenRequestPayload
+	if err := app.readJSON(w, r, &requestPayload); err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	stored, err := app.Repo.GetRefreshTokenByHash(token.Hash(requestPayload.RefreshToken))
+	if err != nil {
+		app.errorJSON(w, errInvalidRefreshToken, http.StatusUnauthorized)
+		return
+	}
+
+	if stored.RevokedAt != nil || time.Now().After(stored.ExpiresAt) {
+		app.errorJSON(w, errInvalidRefreshToken, http.StatusUnauthorized)
+		return
+	}
+
+	if stored.UsedAt != nil {
+		err = data.ErrRefreshTokenReused
+	} else {
+		err = app.Repo.MarkRefreshTokenUsed(stored.ID)
+	}
+	if err != nil {
+		if !errors.Is(err, data.ErrRefreshTokenReused) {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		// A rotated token came back: assume it leaked and cut off everyone holding the family.
+		log.Printf("Refresh token reuse detected for user %d, revoking family %s", stored.UserID, stored.FamilyID)
+		if err := app.Repo.RevokeRefreshTokenFamily(stored.FamilyID); err != nil {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		app.errorJSON(w, errInvalidRefreshToken, http.StatusUnauthorized)
+		return
+	}
+
+	user, err := app.Repo.GetOne(stored.UserID)
+	if err != nil || user.Active == 0 {
+		app.errorJSON(w, errInvalidRefreshToken, http.StatusUnauthorized)
+		return
+	}
+
+	tokens, err := app.issueTokens(*user, stored.FamilyID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	responsePayload := jsonResponse{
+		Error:   false,
+		Message: "Token refreshed",
+		Data:    tokens,
+	}
+
+	app.writeJSON(w, http.StatusAccepted, responsePayload)
+}
+
+// RevokeToken revokes the family of the presented refresh token. Unknown tokens are
+// accepted silently so the endpoint can't be used to probe for valid tokens.
+func (app *Config) RevokeToken(w http.ResponseWriter, r *http.Request) {
+	var requestPayload tokenRequestPayload
+	if err := app.readJSON(w, r, &requestPayload); err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	stored, err := app.Repo.GetRefreshTokenByHash(token.Hash(requestPayload.RefreshToken))
+	if err == nil {
+		if err := app.Repo.RevokeRefreshTokenFamily(stored.FamilyID); err != nil {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	app.writeJSON(w, http.StatusAccepted, jsonResponse{
+		Error:   false,
+		Message: "Token revoked",
+	})
+}
+
+// JWKS publishes the public keys used to sign access tokens.
+func (app *Config) JWKS(w http.ResponseWriter, r *http.Request) {
+	app.writeJSON(w, http.StatusOK, app.Tokens.JWKS())
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_RefreshToken(t *testing.T) {
+	body, _ := json.Marshal(tokenRequestPayload{RefreshToken: "some-refresh-token"})
+
+	req, _ := http.NewRequest(http.MethodPost, "/token/refresh", bytes.NewReader(body))
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(testApp.RefreshToken)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("expected http.StatusAccepted but got %d", rr.Code)
+	}
+
+	var response struct {
+		Data tokenPair `json:"data"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+
+	if response.Data.RefreshToken == "" || response.Data.RefreshToken == "some-refresh-token" {
+		t.Errorf("expected a rotated refresh token but got %q", response.Data.RefreshToken)
+	}
+
+	claims, err := testApp.Tokens.Verify(response.Data.AccessToken)
+	if err != nil {
+		t.Fatalf("expected a valid access token: %v", err)
+	}
+
+	if claims.Subject != "1" {
+		t.Errorf("expected subject 1 but got %s", claims.Subject)
+	}
+}
+
+func Test_RevokeToken(t *testing.T) {
+	body, _ := json.Marshal(tokenRequestPayload{RefreshToken: "some-refresh-token"})
+
+	req, _ := http.NewRequest(http.MethodPost, "/token/revoke", bytes.NewReader(body))
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(testApp.RevokeToken)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusAccepted {
+		t.Errorf("expected http.StatusAccepted but got %d", rr.Code)
+	}
+}
+
+func Test_JWKS(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil)
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(testApp.JWKS)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected http.StatusOK but got %d", rr.Code)
+	}
+
+	var keys struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&keys); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keys.Keys) != 1 || keys.Keys[0]["kty"] != "RSA" {
+		t.Errorf("expected a single RSA key but got %v", keys.Keys)
+	}
+}
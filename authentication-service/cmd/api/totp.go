@@ -0,0 +1,534 @@
+package main
+
+import (
+	"authentication/data"
+	"authentication/metrics"
+	"authentication/throttle"
+	"authentication/token"
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image/png"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+const (
+	// authentication methods recorded in the "amr" claim of access tokens
+	amrPassword = "pwd"
+	amrOTP      = "otp"
+
+	challengeTTL      = 5 * time.Minute
+	recoveryCodeCount = 10
+	qrCodeSize        = 256
+)
+
+var (
+	errInvalidChallenge = errors.New("invalid or expired second factor challenge")
+	errInvalidTOTPCode  = errors.New("invalid authentication code")
+	errTOTPEnabled      = errors.New("two-factor authentication is already enabled")
+	errTOTPNotEnrolled  = errors.New("two-factor authentication enrollment has not been started")
+	errTOTPNotEnabled   = errors.New("two-factor authentication is not enabled")
+)
+
+// totpOptions match what authenticator apps assume by default. A skew of one period accepts
+// the previous and the next code as well, to tolerate clock drift.
+var totpOptions = totp.ValidateOpts{
+	Period:    30,
+	Skew:      1,
+	Digits:    otp.DigitsSix,
+	Algorithm: otp.AlgorithmSHA1,
+}
+
+type secondFactorChallenge struct {
+	MFARequired    bool     `json:"mfa_required"`
+	ChallengeToken string   `json:"challenge_token"`
+	ExpiresIn      int      `json:"expires_in"`
+	Methods        []string `json:"methods"`
+}
+
+type totpAuthenticationPayload struct {
+	ChallengeToken string `json:"challenge_token"`
+	totpCodePayload
+}
+
+// totpCodePayload carries either a code from the authenticator app or a recovery code.
+type totpCodePayload struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+type totpEnrollment struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+	QRCode     string `json:"qr_code"`
+}
+
+type totpActivation struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+	*tokenPair
+}
+
+// secondFactorRequired answers a correct password of a user with TOTP enabled with a challenge
+// token instead of a token pair.
+func (app *Config) secondFactorRequired(w http.ResponseWriter, user data.User) {
+	challenge, expiresAt, err := app.Tokens.IssueChallengeToken(user.ID, challengeTTL)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeJSON(w, http.StatusAccepted, authenticationResponsePayload{
+		Error:   false,
+		Message: "Second factor required",
+		Data: secondFactorChallenge{
+			MFARequired:    true,
+			ChallengeToken: challenge,
+			ExpiresIn:      int(time.Until(expiresAt).Seconds()),
+			Methods:        []string{"totp", "recovery_code"},
+		},
+	})
+}
+
+// AuthenticateTOTP completes a login started at /authenticate by exchanging the challenge token
+// and a TOTP or recovery code for a token pair. A challenge is exchanged only once.
+func (app *Config) AuthenticateTOTP(w http.ResponseWriter, r *http.Request) {
+	var requestPayload totpAuthenticationPayload
+	if err := app.readJSON(w, r, &requestPayload); err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	challenge, err := app.Tokens.VerifyChallengeToken(requestPayload.ChallengeToken)
+	if err != nil {
+		app.errorJSON(w, errInvalidChallenge, http.StatusUnauthorized)
+		return
+	}
+
+	user, err := app.Repo.GetOne(challenge.UserID)
+	if err != nil || user.Active == 0 || !user.TOTPEnabled {
+		app.errorJSON(w, errInvalidChallenge, http.StatusUnauthorized)
+		return
+	}
+
+	valid, retryAfter, err := app.checkSecondFactor(r, user, requestPayload.totpCodePayload)
+	if retryAfter > 0 {
+		app.tooManyAttempts(w, retryAfter)
+		return
+	}
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if !valid {
+		app.errorJSON(w, errInvalidTOTPCode, http.StatusUnauthorized)
+		return
+	}
+
+	if err := app.Repo.MarkChallengeUsed(challenge.ID, challenge.ExpiresAt); err != nil {
+		if !errors.Is(err, data.ErrChallengeUsed) {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		app.errorJSON(w, errInvalidChallenge, http.StatusUnauthorized)
+		return
+	}
+
+	// Log authentication
+	if err := app.logRequest(r.Context(), "authentication", fmt.Sprintf("%s logged in", user.Email), user.ID); err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	tokens, err := app.issueTokens(*user, "")
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeJSON(w, http.StatusAccepted, authenticationResponsePayload{
+		Error:   false,
+		Message: fmt.Sprintf("Logged in user %s", user.Email),
+		Data: authenticationResult{
+			User:      user,
+			tokenPair: tokens,
+		},
+	})
+}
+
+// BeginTOTPEnrollment generates a new TOTP secret for the calling user. Two-factor
+// authentication is only enabled once a code from the authenticator app has been confirmed
+// at /users/me/totp/verify.
+func (app *Config) BeginTOTPEnrollment(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	if user.TOTPEnabled {
+		app.errorJSON(w, errTOTPEnabled, http.StatusConflict)
+		return
+	}
+
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      app.TOTPIssuer,
+		AccountName: user.Email,
+		Period:      totpOptions.Period,
+		Digits:      totpOptions.Digits,
+		Algorithm:   totpOptions.Algorithm,
+	})
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	qrCode, err := qrCodeDataURI(key)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	user.TOTPSecret = key.Secret()
+	user.RecoveryCodes = nil
+
+	if err := app.Repo.UpdateTOTP(*user); err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, jsonResponse{
+		Error:   false,
+		Message: "Scan the QR code with an authenticator app and confirm with a code from it",
+		Data: totpEnrollment{
+			Secret:     key.Secret(),
+			OTPAuthURI: key.URL(),
+			QRCode:     qrCode,
+		},
+	})
+}
+
+// EnableTOTP confirms an enrollment with a code from the authenticator app. The response holds
+// the recovery codes, which are shown only this once. Every other session of the user is
+// logged out and a new token pair is returned for the current one.
+func (app *Config) EnableTOTP(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	var requestPayload totpCodePayload
+	if err := app.readJSON(w, r, &requestPayload); err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if user.TOTPEnabled {
+		app.errorJSON(w, errTOTPEnabled, http.StatusConflict)
+		return
+	}
+
+	if user.TOTPSecret == "" {
+		app.errorJSON(w, errTOTPNotEnrolled, http.StatusConflict)
+		return
+	}
+
+	valid, err := app.useTOTPCode(user, requestPayload.Code)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if !valid {
+		app.errorJSON(w, errInvalidTOTPCode, http.StatusBadRequest)
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	user.TOTPEnabled = true
+	user.RecoveryCodes = hashes
+
+	if err := app.Repo.UpdateTOTP(*user); err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if err := app.Repo.RevokeUserRefreshTokens(user.ID); err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	tokens, err := app.issueTokens(*user, "")
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if err := app.logRequest(r.Context(), "authentication", fmt.Sprintf("%s enabled two-factor authentication", user.Email), user.ID); err != nil {
+		log.Println("Failed to log enabling two-factor authentication:", err)
+	}
+
+	app.writeJSON(w, http.StatusOK, jsonResponse{
+		Error:   false,
+		Message: "Two-factor authentication enabled, store the recovery codes in a safe place",
+		Data: totpActivation{
+			RecoveryCodes: codes,
+			tokenPair:     tokens,
+		},
+	})
+}
+
+// DisableTOTP turns two-factor authentication off for the calling user, who has to prove
+// possession of the second factor once more.
+func (app *Config) DisableTOTP(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	var requestPayload totpCodePayload
+	if err := app.readJSON(w, r, &requestPayload); err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if !user.TOTPEnabled {
+		app.errorJSON(w, errTOTPNotEnabled, http.StatusConflict)
+		return
+	}
+
+	retryAfter, err := app.Limiter.Check(totpKey(user.ID))
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+	if retryAfter > 0 {
+		app.tooManyAttempts(w, retryAfter)
+		return
+	}
+
+	valid, err := app.verifySecondFactor(user, requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if !valid {
+		if err := app.Limiter.Fail(totpKey(user.ID)); err != nil {
+			log.Println("Failed to record failed second factor:", err)
+		}
+
+		app.errorJSON(w, errInvalidTOTPCode, http.StatusBadRequest)
+		return
+	}
+
+	app.clearTOTP(w, r, *user, "Two-factor authentication disabled")
+}
+
+// ResetUserTOTP lets an administrator turn off two-factor authentication for a user who lost
+// both their authenticator and their recovery codes. The user is logged out everywhere.
+func (app *Config) ResetUserTOTP(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	if err := app.Repo.RevokeUserRefreshTokens(user.ID); err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.clearTOTP(w, r, *user, fmt.Sprintf("Two-factor authentication of user %d has been reset", user.ID))
+}
+
+func (app *Config) clearTOTP(w http.ResponseWriter, r *http.Request, user data.User, message string) {
+	user.TOTPEnabled = false
+	user.TOTPSecret = ""
+	user.RecoveryCodes = nil
+
+	if err := app.Repo.UpdateTOTP(user); err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if err := app.logRequest(r.Context(), "authentication", fmt.Sprintf("two-factor authentication disabled for %s", user.Email), user.ID); err != nil {
+		log.Println("Failed to log disabling two-factor authentication:", err)
+	}
+
+	app.writeJSON(w, http.StatusOK, jsonResponse{
+		Error:   false,
+		Message: message,
+	})
+}
+
+// checkSecondFactor verifies a TOTP or recovery code of user, counting failures towards the
+// lockout of the user's second factor and the client IP. It returns how long the caller has to
+// wait while locked out.
+func (app *Config) checkSecondFactor(r *http.Request, user *data.User, payload totpCodePayload) (bool, time.Duration, error) {
+	keys := []throttle.Key{totpKey(user.ID), ipKey(r)}
+
+	retryAfter, err := app.Limiter.Check(keys...)
+	if err != nil {
+		return false, 0, err
+	}
+	if retryAfter > 0 {
+		metrics.AuthAttempts.WithLabelValues("totp", metrics.LockedOut).Inc()
+		return false, retryAfter, nil
+	}
+
+	valid, err := app.verifySecondFactor(user, payload)
+	if err != nil {
+		return false, 0, err
+	}
+
+	if !valid {
+		if err := app.Limiter.Fail(keys...); err != nil {
+			log.Println("Failed to record failed second factor:", err)
+		}
+
+		metrics.AuthAttempts.WithLabelValues("totp", metrics.Failure).Inc()
+		return false, 0, nil
+	}
+
+	metrics.AuthAttempts.WithLabelValues("totp", metrics.Success).Inc()
+
+	if err := app.Limiter.Reset(totpKey(user.ID)); err != nil {
+		log.Println("Failed to reset failed second factors:", err)
+	}
+
+	return true, 0, nil
+}
+
+// verifySecondFactor checks a TOTP code or, when none is given, a recovery code. Both work only
+// once: a matching recovery code is removed from the user, and a TOTP code is only accepted
+// when it is newer than the last one.
+func (app *Config) verifySecondFactor(user *data.User, payload totpCodePayload) (bool, error) {
+	if payload.Code != "" {
+		return app.useTOTPCode(user, payload.Code)
+	}
+
+	if payload.RecoveryCode == "" {
+		return false, nil
+	}
+
+	hash := token.Hash(normalizeRecoveryCode(payload.RecoveryCode))
+
+	for i, stored := range user.RecoveryCodes {
+		if stored != hash {
+			continue
+		}
+
+		remaining := make([]string, 0, len(user.RecoveryCodes)-1)
+		remaining = append(remaining, user.RecoveryCodes[:i]...)
+		user.RecoveryCodes = append(remaining, user.RecoveryCodes[i+1:]...)
+
+		if err := app.Repo.UpdateTOTP(*user); err != nil {
+			return false, err
+		}
+
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// useTOTPCode checks a code from the authenticator app of user and records its time step, so
+// neither the code nor an earlier one is accepted again, even within the allowed skew.
+func (app *Config) useTOTPCode(user *data.User, code string) (bool, error) {
+	step, ok := matchTOTPCode(code, user.TOTPSecret, time.Now())
+	if !ok || step <= user.TOTPLastStep {
+		return false, nil
+	}
+
+	if err := app.Repo.UseTOTPStep(user.ID, step); err != nil {
+		if errors.Is(err, data.ErrTOTPStepUsed) {
+			return false, nil
+		}
+		return false, err
+	}
+	user.TOTPLastStep = step
+
+	return true, nil
+}
+
+// matchTOTPCode returns the time step code belongs to, looking as far around now as the skew of
+// totpOptions allows. It returns false when code doesn't match any of those steps.
+func matchTOTPCode(code, secret string, now time.Time) (int64, bool) {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if code == "" || secret == "" {
+		return 0, false
+	}
+
+	period := int64(totpOptions.Period)
+	current := now.Unix() / period
+
+	for skew := -int64(totpOptions.Skew); skew <= int64(totpOptions.Skew); skew++ {
+		step := current + skew
+
+		expected, err := totp.GenerateCodeCustom(secret, time.Unix(step*period, 0), totpOptions)
+		if err != nil {
+			return 0, false
+		}
+
+		if subtle.ConstantTimeCompare([]byte(code), []byte(expected)) == 1 {
+			return step, true
+		}
+	}
+
+	return 0, false
+}
+
+// newRecoveryCodes generates the single-use codes that stand in for the authenticator app,
+// formatted as "xxxxx-xxxxx", together with the hashes to store.
+func newRecoveryCodes() (codes []string, hashes []string, err error) {
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+
+		code := strings.ToLower(encoding.EncodeToString(b))[:10]
+
+		codes = append(codes, code[:5]+"-"+code[5:])
+		hashes = append(hashes, token.Hash(code))
+	}
+
+	return codes, hashes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// qrCodeDataURI renders the otpauth URI of key as a PNG data URI the front-end can show as is.
+func qrCodeDataURI(key *otp.Key) (string, error) {
+	img, err := key.Image(qrCodeSize, qrCodeSize)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return "", err
+	}
+
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"authentication/data"
+	"authentication/throttle"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pquerna/otp/totp"
+)
+
+func Test_AuthenticateTOTP(t *testing.T) {
+	prepareTestApp()
+
+	app := testApp
+	app.Repo = data.NewPostgresTestRepository(nil)
+	app.Limiter = throttle.NewLimiter(throttle.NewMemoryStore())
+	routes := app.routes()
+
+	post := func(url string, payload any) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(payload)
+
+		req, _ := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		return rr
+	}
+
+	login := func() string {
+		rr := post("/authenticate", map[string]string{"email": "totp@here.com", "password": "verysecret"})
+		if rr.Code != http.StatusAccepted {
+			t.Fatalf("expected http.StatusAccepted but got %d", rr.Code)
+		}
+
+		var challengeResponse struct {
+			Data struct {
+				secondFactorChallenge
+				AccessToken string `json:"access_token"`
+			} `json:"data"`
+		}
+		_ = json.NewDecoder(rr.Body).Decode(&challengeResponse)
+
+		challenge := challengeResponse.Data
+		if !challenge.MFARequired || challenge.ChallengeToken == "" || challenge.AccessToken != "" {
+			t.Fatalf("expected a second factor challenge instead of tokens but got %+v", challenge)
+		}
+
+		return challenge.ChallengeToken
+	}
+	challenge, secondChallenge, thirdChallenge := login(), login(), login()
+
+	code, _ := totp.GenerateCode(data.TestTOTPSecret, time.Now())
+	accessToken, _, _ := app.Tokens.IssueAccessToken(2, "totp@here.com", nil, []string{amrPassword})
+
+	tests := []struct {
+		name         string
+		payload      map[string]string
+		expectedCode int
+	}{
+		{"wrong code", map[string]string{"challenge_token": challenge, "code": "000000"}, http.StatusUnauthorized},
+		{"no code", map[string]string{"challenge_token": challenge}, http.StatusUnauthorized},
+		{"invalid challenge", map[string]string{"challenge_token": "not-a-token", "code": code}, http.StatusUnauthorized},
+		{"access token as challenge", map[string]string{"challenge_token": accessToken, "code": code}, http.StatusUnauthorized},
+		{"valid code", map[string]string{"challenge_token": challenge, "code": code}, http.StatusAccepted},
+		{"reused challenge", map[string]string{"challenge_token": challenge, "recovery_code": data.TestRecoveryCode}, http.StatusUnauthorized},
+		{"reused code", map[string]string{"challenge_token": secondChallenge, "code": code}, http.StatusUnauthorized},
+		{"recovery code", map[string]string{"challenge_token": thirdChallenge, "recovery_code": strings.ToUpper(data.TestRecoveryCode)}, http.StatusAccepted},
+	}
+
+	for _, tt := range tests {
+		rr := post("/authenticate/totp", tt.payload)
+
+		if rr.Code != tt.expectedCode {
+			t.Errorf("%s: expected status %d but got %d (%s)", tt.name, tt.expectedCode, rr.Code, rr.Body.String())
+			continue
+		}
+
+		if rr.Code != http.StatusAccepted {
+			continue
+		}
+
+		var response struct {
+			Data tokenPair `json:"data"`
+		}
+		_ = json.NewDecoder(rr.Body).Decode(&response)
+
+		claims, err := app.Tokens.Verify(response.Data.AccessToken)
+		if err != nil || !claims.HasMethod(amrOTP) {
+			t.Errorf("%s: expected an access token with the otp method but got %+v, %v", tt.name, claims, err)
+		}
+	}
+}
+
+func Test_TOTPEnrollment(t *testing.T) {
+	prepareTestApp()
+
+	app := testApp
+	app.Repo = data.NewPostgresTestRepository(nil)
+	app.Limiter = throttle.NewLimiter(throttle.NewMemoryStore())
+	routes := app.routes()
+
+	withoutTOTP, _, _ := app.Tokens.IssueAccessToken(1, "me@here.com", nil, []string{amrPassword})
+	withTOTP, _, _ := app.Tokens.IssueAccessToken(2, "totp@here.com", nil, []string{amrPassword, amrOTP})
+	code, _ := totp.GenerateCode(data.TestTOTPSecret, time.Now())
+
+	tests := []struct {
+		name         string
+		url          string
+		body         string
+		token        string
+		expectedCode int
+	}{
+		{"no token", "/users/me/totp", "", "", http.StatusUnauthorized},
+		{"begin", "/users/me/totp", "", withoutTOTP, http.StatusOK},
+		{"begin when enabled", "/users/me/totp", "", withTOTP, http.StatusConflict},
+		{"verify without enrollment", "/users/me/totp/verify", `{"code": "123456"}`, withoutTOTP, http.StatusConflict},
+		{"disable when not enabled", "/users/me/totp/disable", `{"code": "123456"}`, withoutTOTP, http.StatusConflict},
+		{"disable with wrong code", "/users/me/totp/disable", `{"code": "000000"}`, withTOTP, http.StatusBadRequest},
+		{"disable", "/users/me/totp/disable", `{"code": "` + code + `"}`, withTOTP, http.StatusOK},
+		{"disable with a used code", "/users/me/totp/disable", `{"code": "` + code + `"}`, withTOTP, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest(http.MethodPost, tt.url, bytes.NewBufferString(tt.body))
+		if tt.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		rr := httptest.NewRecorder()
+
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != tt.expectedCode {
+			t.Errorf("%s: expected status %d but got %d (%s)", tt.name, tt.expectedCode, rr.Code, rr.Body.String())
+			continue
+		}
+
+		if tt.name != "begin" {
+			continue
+		}
+
+		var response struct {
+			Data totpEnrollment `json:"data"`
+		}
+		_ = json.NewDecoder(rr.Body).Decode(&response)
+
+		if !strings.HasPrefix(response.Data.OTPAuthURI, "otpauth://totp/") || response.Data.Secret == "" {
+			t.Errorf("expected an otpauth URI but got %+v", response.Data)
+		}
+
+		if !strings.HasPrefix(response.Data.QRCode, "data:image/png;base64,") {
+			t.Errorf("expected a PNG data URI for the QR code")
+		}
+	}
+}
+
+func Test_matchTOTPCode(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	step := now.Unix() / int64(totpOptions.Period)
+
+	tests := []struct {
+		name     string
+		at       time.Time
+		matches  bool
+		expected int64
+	}{
+		{"current code", now, true, step},
+		{"previous code", now.Add(-30 * time.Second), true, step - 1},
+		{"next code", now.Add(30 * time.Second), true, step + 1},
+		{"too old", now.Add(-60 * time.Second), false, 0},
+	}
+
+	for _, tt := range tests {
+		code, _ := totp.GenerateCodeCustom(data.TestTOTPSecret, tt.at, totpOptions)
+
+		got, ok := matchTOTPCode(code[:3]+" "+code[3:], data.TestTOTPSecret, now)
+		if ok != tt.matches || got != tt.expected {
+			t.Errorf("%s: expected step %d (%v) but got %d (%v)", tt.name, tt.expected, tt.matches, got, ok)
+		}
+	}
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"authentication/data"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+var (
+	errUserNotFound   = errors.New("user not found")
+	errDuplicateEmail = errors.New("a user with this email address already exists")
+)
+
+type createUserPayload struct {
+	Email     string `json:"email"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Password  string `json:"password"`
+	Active    *int   `json:"active"`
+}
+
+// updateUserPayload only holds the fields present in a PATCH request; nil fields are left unchanged.
+type updateUserPayload struct {
+	Email     *string `json:"email"`
+	FirstName *string `json:"first_name"`
+	LastName  *string `json:"last_name"`
+	Active    *int    `json:"active"`
+}
+
+type passwordPayload struct {
+	Password string `json:"password"`
+}
+
+type paginationMetadata struct {
+	CurrentPage  int `json:"current_page"`
+	PageSize     int `json:"page_size"`
+	LastPage     int `json:"last_page"`
+	TotalRecords int `json:"total_records"`
+}
+
+type userList struct {
+	Users    []*data.User       `json:"users"`
+	Metadata paginationMetadata `json:"metadata"`
+}
+
+// ListUsers returns one page of users, optionally filtered by a search term.
+// Query parameters: search, page (starting at 1) and page_size.
+func (app *Config) ListUsers(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	v := newValidator()
+
+	filter := data.UserFilter{
+		Search:   strings.TrimSpace(query.Get("search")),
+		Page:     readInt(query.Get("page"), 1, "page", v),
+		PageSize: readInt(query.Get("page_size"), defaultPageSize, "page_size", v),
+	}
+
+	v.Check(filter.Page > 0, "page", "must be greater than zero")
+	v.Check(filter.PageSize > 0 && filter.PageSize <= maxPageSize, "page_size", fmt.Sprintf("must be between 1 and %d", maxPageSize))
+
+	if !v.Valid() {
+		app.failedValidationJSON(w, v.Errors)
+		return
+	}
+
+	users, total, err := app.Repo.Find(filter)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	metadata := paginationMetadata{
+		CurrentPage:  filter.Page,
+		PageSize:     filter.PageSize,
+		LastPage:     (total + filter.PageSize - 1) / filter.PageSize,
+		TotalRecords: total,
+	}
+
+	app.writeJSON(w, http.StatusOK, jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Found %d users", total),
+		Data:    userList{Users: users, Metadata: metadata},
+	})
+}
+
+// GetUser returns one user by id.
+func (app *Config) GetUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("User %d", user.ID),
+		Data:    user,
+	})
+}
+
+// CreateUser validates the payload and inserts a new user.
+func (app *Config) CreateUser(w http.ResponseWriter, r *http.Request) {
+	var requestPayload createUserPayload
+	if err := app.readJSON(w, r, &requestPayload); err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	user := data.User{
+		Email:     strings.TrimSpace(requestPayload.Email),
+		FirstName: strings.TrimSpace(requestPayload.FirstName),
+		LastName:  strings.TrimSpace(requestPayload.LastName),
+		Password:  requestPayload.Password,
+		Active:    1,
+	}
+	if requestPayload.Active != nil {
+		user.Active = *requestPayload.Active
+	}
+
+	v := newValidator()
+	validateEmail(v, user.Email)
+	validateName(v, "first_name", user.FirstName)
+	validateName(v, "last_name", user.LastName)
+	validatePassword(v, user.Password)
+	v.Check(user.Active == 0 || user.Active == 1, "active", "must be 0 or 1")
+
+	if !v.Valid() {
+		app.failedValidationJSON(w, v.Errors)
+		return
+	}
+
+	if taken, err := app.emailTaken(user.Email, 0); err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	} else if taken {
+		app.errorJSON(w, errDuplicateEmail, http.StatusConflict)
+		return
+	}
+
+	id, err := app.Repo.Insert(user)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	created, err := app.Repo.GetOne(id)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/users/%d", id))
+
+	app.writeJSON(w, http.StatusCreated, jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Created user %s", created.Email),
+		Data:    created,
+	}, headers)
+}
+
+// UpdateUser applies a partial update to a user. Setting "active" to 0 deactivates the account,
+// which prevents it from logging in or refreshing tokens.
+func (app *Config) UpdateUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	var requestPayload updateUserPayload
+	if err := app.readJSON(w, r, &requestPayload); err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	v := newValidator()
+
+	if requestPayload.Email != nil {
+		user.Email = strings.TrimSpace(*requestPayload.Email)
+		validateEmail(v, user.Email)
+	}
+	if requestPayload.FirstName != nil {
+		user.FirstName = strings.TrimSpace(*requestPayload.FirstName)
+		validateName(v, "first_name", user.FirstName)
+	}
+	if requestPayload.LastName != nil {
+		user.LastName = strings.TrimSpace(*requestPayload.LastName)
+		validateName(v, "last_name", user.LastName)
+	}
+	if requestPayload.Active != nil {
+		user.Active = *requestPayload.Active
+		v.Check(user.Active == 0 || user.Active == 1, "active", "must be 0 or 1")
+	}
+
+	if !v.Valid() {
+		app.failedValidationJSON(w, v.Errors)
+		return
+	}
+
+	if requestPayload.Email != nil {
+		if taken, err := app.emailTaken(user.Email, user.ID); err != nil {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		} else if taken {
+			app.errorJSON(w, errDuplicateEmail, http.StatusConflict)
+			return
+		}
+	}
+
+	if err := app.Repo.Update(*user); err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Updated user %d", user.ID),
+		Data:    user,
+	})
+}
+
+// DeleteUser removes a user permanently.
+func (app *Config) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	if err := app.Repo.DeleteByID(user.ID); err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Deleted user %d", user.ID),
+	})
+}
+
+// ResetUserPassword lets an administrator set a new password for a user.
+func (app *Config) ResetUserPassword(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	var requestPayload passwordPayload
+	if err := app.readJSON(w, r, &requestPayload); err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	v := newValidator()
+	validatePassword(v, requestPayload.Password)
+	if !v.Valid() {
+		app.failedValidationJSON(w, v.Errors)
+		return
+	}
+
+	if err := app.Repo.ResetPassword(requestPayload.Password, *user); err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Password of user %d has been reset", user.ID),
+	})
+}
+
+// userFromURL loads the user referenced by the {id} URL parameter. When it returns false an
+// error response has already been sent.
+func (app *Config) userFromURL(w http.ResponseWriter, r *http.Request) (*data.User, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || id < 1 {
+		app.errorJSON(w, errUserNotFound, http.StatusNotFound)
+		return nil, false
+	}
+
+	user, err := app.Repo.GetOne(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errUserNotFound, http.StatusNotFound)
+		} else {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+		}
+		return nil, false
+	}
+
+	return user, true
+}
+
+// currentUser loads the user the access token of the request was issued to. When it returns
+// false an error response has already been sent.
+func (app *Config) currentUser(w http.ResponseWriter, r *http.Request) (*data.User, bool) {
+	claims, ok := claimsFromContext(r.Context())
+	if !ok {
+		app.errorJSON(w, errors.New("authentication required"), http.StatusUnauthorized)
+		return nil, false
+	}
+
+	id, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		app.errorJSON(w, errUserNotFound, http.StatusNotFound)
+		return nil, false
+	}
+
+	user, err := app.Repo.GetOne(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errUserNotFound, http.StatusNotFound)
+		} else {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+		}
+		return nil, false
+	}
+
+	return user, true
+}
+
+// emailTaken reports whether email belongs to a user other than exceptID.
+func (app *Config) emailTaken(email string, exceptID int) (bool, error) {
+	existing, err := app.Repo.GetByEmail(email)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return existing.ID != exceptID, nil
+}
+
+// readInt parses a query string value, falling back to defaultValue when it is empty.
+func readInt(value string, defaultValue int, key string, v *validator) int {
+	if value == "" {
+		return defaultValue
+	}
+
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		v.Check(false, key, "must be an integer value")
+		return defaultValue
+	}
+
+	return i
+}
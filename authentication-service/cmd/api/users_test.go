@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_UsersAPI(t *testing.T) {
+	accessToken, _, _ := testApp.Tokens.IssueAccessToken(1, "me@here.com", []string{adminRole}, []string{amrPassword, amrOTP})
+	passwordOnlyToken, _, _ := testApp.Tokens.IssueAccessToken(1, "me@here.com", []string{adminRole}, []string{amrPassword})
+	nonAdminToken, _, _ := testApp.Tokens.IssueAccessToken(2, "totp@here.com", []string{"mailer"}, []string{amrPassword, amrOTP})
+
+	tests := []struct {
+		name         string
+		method       string
+		url          string
+		body         string
+		token        string
+		expectedCode int
+	}{
+		{"no token", http.MethodGet, "/users", "", "", http.StatusUnauthorized},
+		{"invalid token", http.MethodGet, "/users", "", "not-a-token", http.StatusUnauthorized},
+		{"not an admin", http.MethodGet, "/users", "", nonAdminToken, http.StatusForbidden},
+		{"without second factor", http.MethodGet, "/users", "", passwordOnlyToken, http.StatusForbidden},
+		{"list", http.MethodGet, "/users?search=me&page=1&page_size=10", "", accessToken, http.StatusOK},
+		{"list with bad page", http.MethodGet, "/users?page=0", "", accessToken, http.StatusUnprocessableEntity},
+		{"get", http.MethodGet, "/users/1", "", accessToken, http.StatusOK},
+		{"get with bad id", http.MethodGet, "/users/abc", "", accessToken, http.StatusNotFound},
+		{"create", http.MethodPost, "/users", `{"email": "you@there.com", "first_name": "You", "password": "verysecret"}`, accessToken, http.StatusCreated},
+		{"create duplicate", http.MethodPost, "/users", `{"email": "me@here.com", "password": "verysecret"}`, accessToken, http.StatusConflict},
+		{"create invalid", http.MethodPost, "/users", `{"email": "not-an-email", "password": "short", "active": 2}`, accessToken, http.StatusUnprocessableEntity},
+		{"deactivate", http.MethodPatch, "/users/1", `{"active": 0}`, accessToken, http.StatusOK},
+		{"patch invalid", http.MethodPatch, "/users/1", `{"email": ""}`, accessToken, http.StatusUnprocessableEntity},
+		{"delete", http.MethodDelete, "/users/1", "", accessToken, http.StatusOK},
+		{"reset password", http.MethodPut, "/users/1/password", `{"password": "anothersecret"}`, accessToken, http.StatusOK},
+		{"reset password too short", http.MethodPut, "/users/1/password", `{"password": "short"}`, accessToken, http.StatusUnprocessableEntity},
+		{"reset totp", http.MethodDelete, "/users/2/totp", "", accessToken, http.StatusOK},
+	}
+
+	routes := testApp.routes()
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest(tt.method, tt.url, bytes.NewBufferString(tt.body))
+		if tt.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		rr := httptest.NewRecorder()
+
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != tt.expectedCode {
+			t.Errorf("%s: expected status %d but got %d (%s)", tt.name, tt.expectedCode, rr.Code, rr.Body.String())
+		}
+	}
+}
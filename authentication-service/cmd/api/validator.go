@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"unicode/utf8"
+)
+
+var emailRX = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
+
+// validator collects validation errors keyed by the name of the offending field.
+type validator struct {
+	Errors map[string]string
+}
+
+func newValidator() *validator {
+	return &validator{Errors: make(map[string]string)}
+}
+
+func (v *validator) Valid() bool {
+	return len(v.Errors) == 0
+}
+
+// Check records message for key when ok is false, keeping the first error reported for each key.
+func (v *validator) Check(ok bool, key, message string) {
+	if ok {
+		return
+	}
+
+	if _, exists := v.Errors[key]; !exists {
+		v.Errors[key] = message
+	}
+}
+
+func validateEmail(v *validator, email string) {
+	v.Check(email != "", "email", "must be provided")
+	v.Check(utf8.RuneCountInString(email) <= 255, "email", "must not be more than 255 characters long")
+	v.Check(emailRX.MatchString(email), "email", "must be a valid email address")
+}
+
+func validatePassword(v *validator, password string) {
+	v.Check(password != "", "password", "must be provided")
+	v.Check(utf8.RuneCountInString(password) >= 8, "password", "must be at least 8 characters long")
+	// bcrypt only looks at the first 72 bytes
+	v.Check(len(password) <= 72, "password", "must not be more than 72 bytes long")
+}
+
+func validateName(v *validator, key, name string) {
+	v.Check(utf8.RuneCountInString(name) <= 255, key, "must not be more than 255 characters long")
+}
+
+// failedValidationJSON sends the collected validation errors with a 422 status code
+func (app *Config) failedValidationJSON(w http.ResponseWriter, errors map[string]string) error {
+	payload := jsonResponse{
+		Error:   true,
+		Message: "validation failed",
+		Data:    errors,
+	}
+
+	return app.writeJSON(w, http.StatusUnprocessableEntity, payload)
+}
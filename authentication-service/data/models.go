@@ -3,22 +3,24 @@ package data
 import (
 	"context"
 	"database/sql"
-	"errors"
 	"log"
+	"strings"
 	"time"
 
-	"golang.org/x/crypto/bcrypt"
+	"authentication/password"
 )
 
 const dbTimeout = time.Second * 3
 
 type PostgresRepository struct {
-	Conn *sql.DB
+	Conn      *sql.DB
+	Passwords *password.Policy
 }
 
-func NewPostgresRepository(db *sql.DB) *PostgresRepository {
+func NewPostgresRepository(db *sql.DB, passwords *password.Policy) *PostgresRepository {
 	return &PostgresRepository{
-		Conn: db,
+		Conn:      db,
+		Passwords: passwords,
 	}
 }
 
@@ -32,6 +34,66 @@ type User struct {
 	Active    int       `json:"active"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
+	// TOTPEnabled is set once the user confirmed their authenticator app, from then on
+	// logging in requires a code from it.
+	TOTPEnabled bool `json:"totp_enabled"`
+	// TOTPSecret is the base32 encoded shared secret; it is set as soon as enrollment starts.
+	TOTPSecret string `json:"-"`
+	// RecoveryCodes holds the hashes of the unused single-use recovery codes.
+	RecoveryCodes []string `json:"-"`
+	// TOTPLastStep is the time step of the last accepted TOTP code, which can't be used again.
+	TOTPLastStep int64 `json:"-"`
+	// Roles are the names of the roles assigned to the user, sorted by name.
+	Roles []string `json:"roles"`
+}
+
+// userColumns are the columns selected by every query returning users, in the order scanUser expects.
+// Role names can't contain commas, so the roles of a user are aggregated into one string.
+const userColumns = `id, email, first_name, last_name, password, user_active, created_at, updated_at,
+	totp_enabled, coalesce(totp_secret, ''), coalesce(totp_recovery_codes, ''), coalesce(totp_last_step, 0),
+	(select coalesce(string_agg(r.name, ',' order by r.name), '')
+		from user_roles ur join roles r on r.id = ur.role_id where ur.user_id = users.id)`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanUser reads one row selected with userColumns. Destinations for any columns selected
+// before userColumns are passed as extra.
+func scanUser(row rowScanner, extra ...any) (*User, error) {
+	var user User
+	var recoveryCodes, roles string
+
+	dest := append(extra,
+		&user.ID,
+		&user.Email,
+		&user.FirstName,
+		&user.LastName,
+		&user.Password,
+		&user.Active,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+		&user.TOTPEnabled,
+		&user.TOTPSecret,
+		&recoveryCodes,
+		&user.TOTPLastStep,
+		&roles,
+	)
+
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+
+	if recoveryCodes != "" {
+		user.RecoveryCodes = strings.Split(recoveryCodes, ",")
+	}
+
+	user.Roles = []string{}
+	if roles != "" {
+		user.Roles = strings.Split(roles, ",")
+	}
+
+	return &user, nil
 }
 
 // GetAll returns a slice of all users, sorted by last name
@@ -39,7 +101,7 @@ func (repo *PostgresRepository) GetAll() ([]*User, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	query := `select id, email, first_name, last_name, password, user_active, created_at, updated_at
+	query := `select ` + userColumns + `
 	from users order by last_name`
 
 	rows, err := repo.Conn.QueryContext(ctx, query)
@@ -51,54 +113,81 @@ func (repo *PostgresRepository) GetAll() ([]*User, error) {
 	var users []*User
 
 	for rows.Next() {
-		var user User
-		err := rows.Scan(
-			&user.ID,
-			&user.Email,
-			&user.FirstName,
-			&user.LastName,
-			&user.Password,
-			&user.Active,
-			&user.CreatedAt,
-			&user.UpdatedAt,
-		)
+		user, err := scanUser(rows)
 		if err != nil {
 			log.Println("Error scanning", err)
 			return nil, err
 		}
 
-		users = append(users, &user)
+		users = append(users, user)
 	}
 
 	return users, nil
 }
 
-// GetByEmail returns one user by email
-func (repo *PostgresRepository) GetByEmail(email string) (*User, error) {
+// UserFilter narrows down and paginates the users returned by Find. Search matches
+// case-insensitively against email, first name and last name.
+type UserFilter struct {
+	Search   string
+	Page     int
+	PageSize int
+}
+
+// Find returns one page of users matching filter, sorted by last name, along with the total
+// number of matching users
+func (repo *PostgresRepository) Find(filter UserFilter) ([]*User, int, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	query := `select id, email, first_name, last_name, password, user_active, created_at, updated_at from users where email = $1`
+	query := `select count(*) over(), ` + userColumns + `
+	from users
+	where $1 = ''
+		or position(lower($1) in lower(email)) > 0
+		or position(lower($1) in lower(first_name)) > 0
+		or position(lower($1) in lower(last_name)) > 0
+	order by last_name, id
+	limit $2 offset $3`
+
+	rows, err := repo.Conn.QueryContext(ctx, query,
+		filter.Search,
+		filter.PageSize,
+		(filter.Page-1)*filter.PageSize,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
 
-	var user User
-	row := repo.Conn.QueryRowContext(ctx, query, email)
+	total := 0
+	users := []*User{}
 
-	err := row.Scan(
-		&user.ID,
-		&user.Email,
-		&user.FirstName,
-		&user.LastName,
-		&user.Password,
-		&user.Active,
-		&user.CreatedAt,
-		&user.UpdatedAt,
-	)
+	for rows.Next() {
+		user, err := scanUser(rows, &total)
+		if err != nil {
+			log.Println("Error scanning", err)
+			return nil, 0, err
+		}
 
-	if err != nil {
-		return nil, err
+		users = append(users, user)
 	}
 
-	return &user, nil
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	return users, total, nil
+}
+
+// GetByEmail returns one user by email
+func (repo *PostgresRepository) GetByEmail(email string) (*User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select ` + userColumns + ` from users where email = $1`
+
+	row := repo.Conn.QueryRowContext(ctx, query, email)
+
+	return scanUser(row)
 }
 
 // GetOne returns one user by id
@@ -106,27 +195,11 @@ func (repo *PostgresRepository) GetOne(id int) (*User, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	query := `select id, email, first_name, last_name, password, user_active, created_at, updated_at from users where id = $1`
+	query := `select ` + userColumns + ` from users where id = $1`
 
-	var user User
 	row := repo.Conn.QueryRowContext(ctx, query, id)
 
-	err := row.Scan(
-		&user.ID,
-		&user.Email,
-		&user.FirstName,
-		&user.LastName,
-		&user.Password,
-		&user.Active,
-		&user.CreatedAt,
-		&user.UpdatedAt,
-	)
-
-	if err != nil {
-		return nil, err
-	}
-
-	return &user, nil
+	return scanUser(row)
 }
 
 // Update updates one user in the database, using the information
@@ -160,6 +233,35 @@ func (repo *PostgresRepository) Update(user User) error {
 	return nil
 }
 
+// UpdateTOTP stores the TOTP enrollment state of a user: the secret, the enabled flag and the
+// hashes of the remaining recovery codes. An empty secret clears the enrollment.
+func (repo *PostgresRepository) UpdateTOTP(user User) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update users set
+		totp_secret = nullif($1, ''),
+		totp_enabled = $2,
+		totp_recovery_codes = nullif($3, ''),
+		updated_at = $4
+		where id = $5
+	`
+
+	_, err := repo.Conn.ExecContext(ctx, stmt,
+		user.TOTPSecret,
+		user.TOTPEnabled,
+		strings.Join(user.RecoveryCodes, ","),
+		time.Now(),
+		user.ID,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
 // DeleteByID deletes one user from the database, by ID
 func (repo *PostgresRepository) DeleteByID(id int) error {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
@@ -180,7 +282,7 @@ func (repo *PostgresRepository) Insert(user User) (int, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), 12)
+	hashedPassword, err := repo.Passwords.Hash(user.Password)
 	if err != nil {
 		return 0, err
 	}
@@ -211,7 +313,7 @@ func (repo *PostgresRepository) ResetPassword(password string, user User) error
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+	hashedPassword, err := repo.Passwords.Hash(password)
 	if err != nil {
 		return err
 	}
@@ -225,20 +327,15 @@ func (repo *PostgresRepository) ResetPassword(password string, user User) error
 	return nil
 }
 
-// PasswordMatches uses Go's bcrypt package to compare a user supplied password
-// with the hash we have stored for a given user in the database. If the password
-// and hash match, we return true; otherwise, we return false.
+// PasswordMatches compares a user supplied password with the hash we have stored for a given
+// user in the database, using whichever algorithm produced that hash. If the password and hash
+// match, we return true; otherwise, we return false.
 func (repo *PostgresRepository) PasswordMatches(plainText string, user User) (bool, error) {
-	err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(plainText))
-	if err != nil {
-		switch {
-		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
-			// invalid password
-			return false, nil
-		default:
-			return false, err
-		}
-	}
+	return repo.Passwords.Verify(plainText, user.Password)
+}
 
-	return true, nil
+// PasswordNeedsRehash reports whether the stored hash of a user was produced by an outdated
+// algorithm or cost and should be replaced the next time the plain text password is known.
+func (repo *PostgresRepository) PasswordNeedsRehash(user User) bool {
+	return repo.Passwords.NeedsRehash(user.Password)
 }
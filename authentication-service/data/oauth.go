@@ -0,0 +1,252 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+)
+
+// ErrAuthorizationCodeUsed is returned when an authorization code is redeemed a second time.
+var ErrAuthorizationCodeUsed = errors.New("authorization code has already been used")
+
+// OAuthClient is an application registered to log users in with this service through OpenID
+// Connect. Public clients have no secret and rely on PKCE alone. First-party clients are
+// trusted applications whose users aren't asked for consent.
+type OAuthClient struct {
+	ID           string    `json:"client_id"`
+	Name         string    `json:"name"`
+	SecretHash   string    `json:"-"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	FirstParty   bool      `json:"first_party"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// Confidential reports whether the client has to authenticate with a secret at the token endpoint.
+func (c *OAuthClient) Confidential() bool {
+	return c.SecretHash != ""
+}
+
+// AllowsRedirect reports whether uri is one of the client's registered redirect URIs. URIs
+// are compared as exact strings.
+func (c *OAuthClient) AllowsRedirect(uri string) bool {
+	for _, allowed := range c.RedirectURIs {
+		if allowed == uri {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AuthorizationCode is the single-use code a client exchanges for tokens at the end of the
+// authorization code flow. It remembers everything the token endpoint has to check or put
+// into the ID token.
+type AuthorizationCode struct {
+	ID            int
+	CodeHash      string
+	ClientID      string
+	UserID        int
+	RedirectURI   string
+	Scope         string
+	Nonce         string
+	CodeChallenge string
+	AuthMethods   []string
+	AuthTime      time.Time
+	ExpiresAt     time.Time
+	UsedAt        *time.Time
+	CreatedAt     time.Time
+}
+
+const oauthClientColumns = `id, name, coalesce(secret_hash, ''), redirect_uris, first_party, created_at`
+
+func scanOAuthClient(row rowScanner) (*OAuthClient, error) {
+	var client OAuthClient
+	var redirectURIs string
+
+	err := row.Scan(
+		&client.ID,
+		&client.Name,
+		&client.SecretHash,
+		&redirectURIs,
+		&client.FirstParty,
+		&client.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	client.RedirectURIs = strings.Fields(redirectURIs)
+
+	return &client, nil
+}
+
+// GetAllOAuthClients returns all registered clients, sorted by name
+func (repo *PostgresRepository) GetAllOAuthClients() ([]*OAuthClient, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select ` + oauthClientColumns + ` from oauth_clients order by name`
+
+	rows, err := repo.Conn.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	clients := []*OAuthClient{}
+
+	for rows.Next() {
+		client, err := scanOAuthClient(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		clients = append(clients, client)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return clients, nil
+}
+
+// GetOAuthClient returns one client by its client ID
+func (repo *PostgresRepository) GetOAuthClient(id string) (*OAuthClient, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select ` + oauthClientColumns + ` from oauth_clients where id = $1`
+
+	return scanOAuthClient(repo.Conn.QueryRowContext(ctx, query, id))
+}
+
+// InsertOAuthClient registers a new client. Redirect URIs are stored one per line.
+func (repo *PostgresRepository) InsertOAuthClient(client OAuthClient) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `insert into oauth_clients (id, name, secret_hash, redirect_uris, first_party, created_at)
+		values ($1, $2, nullif($3, ''), $4, $5, $6)`
+
+	_, err := repo.Conn.ExecContext(ctx, stmt,
+		client.ID,
+		client.Name,
+		client.SecretHash,
+		strings.Join(client.RedirectURIs, "\n"),
+		client.FirstParty,
+		time.Now(),
+	)
+
+	return err
+}
+
+// DeleteOAuthClient deletes a client along with its outstanding authorization codes
+func (repo *PostgresRepository) DeleteOAuthClient(id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `delete from oauth_clients where id = $1`
+
+	_, err := repo.Conn.ExecContext(ctx, stmt, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// InsertAuthorizationCode stores a new authorization code and returns its ID
+func (repo *PostgresRepository) InsertAuthorizationCode(code AuthorizationCode) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	var newID int
+	stmt := `insert into oauth_authorization_codes (code_hash, client_id, user_id, redirect_uri, scope, nonce,
+		code_challenge, auth_methods, auth_time, expires_at, created_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) returning id`
+
+	err := repo.Conn.QueryRowContext(ctx, stmt,
+		code.CodeHash,
+		code.ClientID,
+		code.UserID,
+		code.RedirectURI,
+		code.Scope,
+		code.Nonce,
+		code.CodeChallenge,
+		strings.Join(code.AuthMethods, " "),
+		code.AuthTime,
+		code.ExpiresAt,
+		time.Now(),
+	).Scan(&newID)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// GetAuthorizationCodeByHash returns one authorization code by the hash of its value
+func (repo *PostgresRepository) GetAuthorizationCodeByHash(hash string) (*AuthorizationCode, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select id, code_hash, client_id, user_id, redirect_uri, scope, nonce, code_challenge,
+	auth_methods, auth_time, expires_at, used_at, created_at
+	from oauth_authorization_codes where code_hash = $1`
+
+	var code AuthorizationCode
+	var authMethods string
+	row := repo.Conn.QueryRowContext(ctx, query, hash)
+
+	err := row.Scan(
+		&code.ID,
+		&code.CodeHash,
+		&code.ClientID,
+		&code.UserID,
+		&code.RedirectURI,
+		&code.Scope,
+		&code.Nonce,
+		&code.CodeChallenge,
+		&authMethods,
+		&code.AuthTime,
+		&code.ExpiresAt,
+		&code.UsedAt,
+		&code.CreatedAt,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	code.AuthMethods = strings.Fields(authMethods)
+
+	return &code, nil
+}
+
+// MarkAuthorizationCodeUsed redeems an authorization code. It returns ErrAuthorizationCodeUsed
+// when the code had already been redeemed.
+func (repo *PostgresRepository) MarkAuthorizationCodeUsed(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update oauth_authorization_codes set used_at = $1 where id = $2 and used_at is null`
+
+	result, err := repo.Conn.ExecContext(ctx, stmt, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return ErrAuthorizationCodeUsed
+	}
+
+	return nil
+}
@@ -0,0 +1,111 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrPasswordResetUsed is returned when a password reset token is redeemed a second time.
+var ErrPasswordResetUsed = errors.New("password reset token has already been used")
+
+// PasswordReset is a single-use token that lets a user choose a new password.
+type PasswordReset struct {
+	ID        int
+	UserID    int
+	TokenHash string
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+// InsertPasswordReset stores a new password reset token and returns its ID. Tokens previously
+// issued to the same user stop working.
+func (repo *PostgresRepository) InsertPasswordReset(reset PasswordReset) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := repo.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	stmt := `update password_resets set used_at = $1 where user_id = $2 and used_at is null`
+	if _, err := tx.ExecContext(ctx, stmt, time.Now(), reset.UserID); err != nil {
+		return 0, err
+	}
+
+	var newID int
+	stmt = `insert into password_resets (user_id, token_hash, expires_at, created_at)
+		values ($1, $2, $3, $4) returning id`
+
+	err = tx.QueryRowContext(ctx, stmt,
+		reset.UserID,
+		reset.TokenHash,
+		reset.ExpiresAt,
+		time.Now(),
+	).Scan(&newID)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// GetPasswordResetByHash returns one password reset token by the hash of its value
+func (repo *PostgresRepository) GetPasswordResetByHash(hash string) (*PasswordReset, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select id, user_id, token_hash, expires_at, used_at, created_at
+	from password_resets where token_hash = $1`
+
+	var reset PasswordReset
+	row := repo.Conn.QueryRowContext(ctx, query, hash)
+
+	err := row.Scan(
+		&reset.ID,
+		&reset.UserID,
+		&reset.TokenHash,
+		&reset.ExpiresAt,
+		&reset.UsedAt,
+		&reset.CreatedAt,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &reset, nil
+}
+
+// MarkPasswordResetUsed redeems a password reset token. It returns ErrPasswordResetUsed when
+// the token had already been redeemed.
+func (repo *PostgresRepository) MarkPasswordResetUsed(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update password_resets set used_at = $1 where id = $2 and used_at is null`
+
+	result, err := repo.Conn.ExecContext(ctx, stmt, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return ErrPasswordResetUsed
+	}
+
+	return nil
+}
@@ -0,0 +1,130 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrRefreshTokenReused is returned when a refresh token that has already been rotated is presented again.
+var ErrRefreshTokenReused = errors.New("refresh token has already been used")
+
+// RefreshToken is one issued refresh token. Tokens created by rotating each other share a FamilyID.
+type RefreshToken struct {
+	ID        int
+	UserID    int
+	FamilyID  string
+	TokenHash string
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	RevokedAt *time.Time
+	CreatedAt time.Time
+}
+
+// InsertRefreshToken stores a new refresh token and returns its ID
+func (repo *PostgresRepository) InsertRefreshToken(token RefreshToken) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	var newID int
+	stmt := `insert into refresh_tokens (user_id, family_id, token_hash, expires_at, created_at)
+		values ($1, $2, $3, $4, $5) returning id`
+
+	err := repo.Conn.QueryRowContext(ctx, stmt,
+		token.UserID,
+		token.FamilyID,
+		token.TokenHash,
+		token.ExpiresAt,
+		time.Now(),
+	).Scan(&newID)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// GetRefreshTokenByHash returns one refresh token by the hash of its value
+func (repo *PostgresRepository) GetRefreshTokenByHash(hash string) (*RefreshToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select id, user_id, family_id, token_hash, expires_at, used_at, revoked_at, created_at
+	from refresh_tokens where token_hash = $1`
+
+	var token RefreshToken
+	row := repo.Conn.QueryRowContext(ctx, query, hash)
+
+	err := row.Scan(
+		&token.ID,
+		&token.UserID,
+		&token.FamilyID,
+		&token.TokenHash,
+		&token.ExpiresAt,
+		&token.UsedAt,
+		&token.RevokedAt,
+		&token.CreatedAt,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// MarkRefreshTokenUsed flags a refresh token as rotated. It returns ErrRefreshTokenReused
+// when the token had already been used, so concurrent refreshes can't both succeed.
+func (repo *PostgresRepository) MarkRefreshTokenUsed(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update refresh_tokens set used_at = $1 where id = $2 and used_at is null`
+
+	result, err := repo.Conn.ExecContext(ctx, stmt, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return ErrRefreshTokenReused
+	}
+
+	return nil
+}
+
+// RevokeRefreshTokenFamily revokes every refresh token descending from the same login
+func (repo *PostgresRepository) RevokeRefreshTokenFamily(familyID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update refresh_tokens set revoked_at = $1 where family_id = $2 and revoked_at is null`
+
+	_, err := repo.Conn.ExecContext(ctx, stmt, time.Now(), familyID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// RevokeUserRefreshTokens revokes every refresh token of a user, logging them out everywhere
+func (repo *PostgresRepository) RevokeUserRefreshTokens(userID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update refresh_tokens set revoked_at = $1 where user_id = $2 and revoked_at is null`
+
+	_, err := repo.Conn.ExecContext(ctx, stmt, time.Now(), userID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
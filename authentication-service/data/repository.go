@@ -1,12 +1,40 @@
 package data
 
+import "time"
+
 type Repository interface {
 	GetAll() ([]*User, error)
+	Find(filter UserFilter) ([]*User, int, error)
 	GetByEmail(email string) (*User, error)
 	GetOne(id int) (*User, error)
 	Update(user User) error
+	UpdateTOTP(user User) error
+	UseTOTPStep(userID int, step int64) error
+	MarkChallengeUsed(id string, expiresAt time.Time) error
 	DeleteByID(id int) error
 	Insert(user User) (int, error)
 	ResetPassword(password string, user User) error
 	PasswordMatches(plainText string, user User) (bool, error)
+	PasswordNeedsRehash(user User) bool
+	InsertRefreshToken(token RefreshToken) (int, error)
+	GetRefreshTokenByHash(hash string) (*RefreshToken, error)
+	MarkRefreshTokenUsed(id int) error
+	RevokeRefreshTokenFamily(familyID string) error
+	RevokeUserRefreshTokens(userID int) error
+	InsertPasswordReset(reset PasswordReset) (int, error)
+	GetPasswordResetByHash(hash string) (*PasswordReset, error)
+	MarkPasswordResetUsed(id int) error
+	GetAllRoles() ([]*Role, error)
+	GetRoleByName(name string) (*Role, error)
+	InsertRole(role Role) (int, error)
+	DeleteRole(id int) error
+	AssignRole(userID, roleID int) error
+	RevokeRole(userID, roleID int) error
+	GetAllOAuthClients() ([]*OAuthClient, error)
+	GetOAuthClient(id string) (*OAuthClient, error)
+	InsertOAuthClient(client OAuthClient) error
+	DeleteOAuthClient(id string) error
+	InsertAuthorizationCode(code AuthorizationCode) (int, error)
+	GetAuthorizationCodeByHash(hash string) (*AuthorizationCode, error)
+	MarkAuthorizationCodeUsed(id int) error
 }
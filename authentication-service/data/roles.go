@@ -0,0 +1,143 @@
+package data
+
+import (
+	"context"
+	"time"
+)
+
+// Role is a named set of permissions granted to users. The role names of a user end up in the
+// "roles" claim of their access tokens, where other services check them.
+type Role struct {
+	ID          int       `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// GetAllRoles returns all roles, sorted by name
+func (repo *PostgresRepository) GetAllRoles() ([]*Role, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select id, name, description, created_at from roles order by name`
+
+	rows, err := repo.Conn.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []*Role{}
+
+	for rows.Next() {
+		var role Role
+		err := rows.Scan(
+			&role.ID,
+			&role.Name,
+			&role.Description,
+			&role.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		roles = append(roles, &role)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return roles, nil
+}
+
+// GetRoleByName returns one role by name
+func (repo *PostgresRepository) GetRoleByName(name string) (*Role, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select id, name, description, created_at from roles where name = $1`
+
+	var role Role
+	row := repo.Conn.QueryRowContext(ctx, query, name)
+
+	err := row.Scan(
+		&role.ID,
+		&role.Name,
+		&role.Description,
+		&role.CreatedAt,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &role, nil
+}
+
+// InsertRole inserts a new role and returns its ID
+func (repo *PostgresRepository) InsertRole(role Role) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	var newID int
+	stmt := `insert into roles (name, description, created_at) values ($1, $2, $3) returning id`
+
+	err := repo.Conn.QueryRowContext(ctx, stmt,
+		role.Name,
+		role.Description,
+		time.Now(),
+	).Scan(&newID)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// DeleteRole deletes a role, taking it away from every user who had it
+func (repo *PostgresRepository) DeleteRole(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `delete from roles where id = $1`
+
+	_, err := repo.Conn.ExecContext(ctx, stmt, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// AssignRole grants a role to a user. Assigning a role the user already has is a no-op.
+func (repo *PostgresRepository) AssignRole(userID, roleID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `insert into user_roles (user_id, role_id, created_at) values ($1, $2, $3)
+		on conflict (user_id, role_id) do nothing`
+
+	_, err := repo.Conn.ExecContext(ctx, stmt, userID, roleID, time.Now())
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// RevokeRole takes a role away from a user
+func (repo *PostgresRepository) RevokeRole(userID, roleID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `delete from user_roles where user_id = $1 and role_id = $2`
+
+	_, err := repo.Conn.ExecContext(ctx, stmt, userID, roleID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
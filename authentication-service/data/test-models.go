@@ -1,12 +1,50 @@
 package data
 
 import (
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
+	"sync"
 	"time"
 )
 
+const (
+	// TestTOTPSecret is the TOTP secret of totp@here.com, the test user with two-factor enabled.
+	TestTOTPSecret = "JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP"
+	// TestRecoveryCode is the one unused recovery code of totp@here.com.
+	TestRecoveryCode = "abcde-fghij"
+	// TestClientSecret is the client secret of the confidential test client "partner-app".
+	TestClientSecret = "partner-secret"
+)
+
+// testTOTPUser returns user 2, who has completed TOTP enrollment.
+func testTOTPUser() *User {
+	sum := sha256.Sum256([]byte("abcdefghij"))
+
+	return &User{
+		ID:            2,
+		FirstName:     "Second",
+		LastName:      "Factor",
+		Email:         "totp@here.com",
+		Active:        1,
+		CreatedAt:     time.Now(),
+		UpdatedAt:     time.Now(),
+		TOTPEnabled:   true,
+		TOTPSecret:    TestTOTPSecret,
+		RecoveryCodes: []string{hex.EncodeToString(sum[:])},
+		Roles:         []string{},
+	}
+}
+
+// PostgresTestRepository stubs the database. Authorization codes, TOTP steps and redeemed
+// challenges are kept in memory so the OpenID Connect flow and replays can be tested end to end.
 type PostgresTestRepository struct {
 	Conn *sql.DB
+
+	mu         sync.Mutex
+	codes      []AuthorizationCode
+	totpSteps  map[int]int64
+	challenges map[string]bool
 }
 
 func NewPostgresTestRepository(db *sql.DB) *PostgresTestRepository {
@@ -22,8 +60,23 @@ func (u *PostgresTestRepository) GetAll() ([]*User, error) {
 	return users, nil
 }
 
-// GetByEmail returns one user by email
+// Find returns one page of users matching filter, along with the total number of matching users
+func (u *PostgresTestRepository) Find(filter UserFilter) ([]*User, int, error) {
+	users := []*User{}
+
+	return users, 0, nil
+}
+
+// GetByEmail returns one user by email. Only me@here.com and totp@here.com exist.
 func (u *PostgresTestRepository) GetByEmail(email string) (*User, error) {
+	if email == "totp@here.com" {
+		return testTOTPUser(), nil
+	}
+
+	if email != "me@here.com" {
+		return nil, sql.ErrNoRows
+	}
+
 	user := User{
 		ID:        1,
 		FirstName: "First",
@@ -33,6 +86,7 @@ func (u *PostgresTestRepository) GetByEmail(email string) (*User, error) {
 		Active:    1,
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
+		Roles:     []string{"admin"},
 	}
 
 	return &user, nil
@@ -40,6 +94,10 @@ func (u *PostgresTestRepository) GetByEmail(email string) (*User, error) {
 
 // GetOne returns one user by id
 func (u *PostgresTestRepository) GetOne(id int) (*User, error) {
+	if id == 2 {
+		return testTOTPUser(), nil
+	}
+
 	user := User{
 		ID:        1,
 		FirstName: "First",
@@ -49,6 +107,7 @@ func (u *PostgresTestRepository) GetOne(id int) (*User, error) {
 		Active:    1,
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
+		Roles:     []string{"admin"},
 	}
 
 	return &user, nil
@@ -60,6 +119,43 @@ func (u *PostgresTestRepository) Update(user User) error {
 	return nil
 }
 
+// UpdateTOTP stores the TOTP enrollment state of a user
+func (u *PostgresTestRepository) UpdateTOTP(user User) error {
+	return nil
+}
+
+// UseTOTPStep records the time step of the last accepted TOTP code of a user
+func (u *PostgresTestRepository) UseTOTPStep(userID int, step int64) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.totpSteps == nil {
+		u.totpSteps = make(map[int]int64)
+	}
+	if last, ok := u.totpSteps[userID]; ok && step <= last {
+		return ErrTOTPStepUsed
+	}
+	u.totpSteps[userID] = step
+
+	return nil
+}
+
+// MarkChallengeUsed redeems a second factor challenge
+func (u *PostgresTestRepository) MarkChallengeUsed(id string, expiresAt time.Time) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.challenges == nil {
+		u.challenges = make(map[string]bool)
+	}
+	if u.challenges[id] {
+		return ErrChallengeUsed
+	}
+	u.challenges[id] = true
+
+	return nil
+}
+
 // DeleteByID deletes one user from the database, by ID
 func (u *PostgresTestRepository) DeleteByID(id int) error {
 	return nil
@@ -75,9 +171,203 @@ func (u *PostgresTestRepository) ResetPassword(password string, user User) error
 	return nil
 }
 
-// PasswordMatches uses Go's bcrypt package to compare a user supplied password
-// with the hash we have stored for a given user in the database. If the password
-// and hash match, we return true; otherwise, we return false.
+// PasswordMatches compares a user supplied password with the stored hash
 func (u *PostgresTestRepository) PasswordMatches(plainText string, user User) (bool, error) {
 	return true, nil
 }
+
+// PasswordNeedsRehash reports whether the stored hash of a user is outdated
+func (u *PostgresTestRepository) PasswordNeedsRehash(user User) bool {
+	return false
+}
+
+// InsertRefreshToken stores a new refresh token and returns its ID
+func (u *PostgresTestRepository) InsertRefreshToken(token RefreshToken) (int, error) {
+	return 1, nil
+}
+
+// GetRefreshTokenByHash returns one refresh token by the hash of its value
+func (u *PostgresTestRepository) GetRefreshTokenByHash(hash string) (*RefreshToken, error) {
+	token := RefreshToken{
+		ID:        1,
+		UserID:    1,
+		FamilyID:  "family",
+		TokenHash: hash,
+		ExpiresAt: time.Now().Add(time.Hour),
+		CreatedAt: time.Now(),
+	}
+
+	return &token, nil
+}
+
+// MarkRefreshTokenUsed flags a refresh token as rotated
+func (u *PostgresTestRepository) MarkRefreshTokenUsed(id int) error {
+	return nil
+}
+
+// RevokeRefreshTokenFamily revokes every refresh token descending from the same login
+func (u *PostgresTestRepository) RevokeRefreshTokenFamily(familyID string) error {
+	return nil
+}
+
+// RevokeUserRefreshTokens revokes every refresh token of a user
+func (u *PostgresTestRepository) RevokeUserRefreshTokens(userID int) error {
+	return nil
+}
+
+// InsertPasswordReset stores a new password reset token and returns its ID
+func (u *PostgresTestRepository) InsertPasswordReset(reset PasswordReset) (int, error) {
+	return 1, nil
+}
+
+// GetPasswordResetByHash returns one password reset token by the hash of its value
+func (u *PostgresTestRepository) GetPasswordResetByHash(hash string) (*PasswordReset, error) {
+	reset := PasswordReset{
+		ID:        1,
+		UserID:    1,
+		TokenHash: hash,
+		ExpiresAt: time.Now().Add(time.Hour),
+		CreatedAt: time.Now(),
+	}
+
+	return &reset, nil
+}
+
+// MarkPasswordResetUsed redeems a password reset token
+func (u *PostgresTestRepository) MarkPasswordResetUsed(id int) error {
+	return nil
+}
+
+// testRoles are the roles that exist in the test repository
+var testRoles = []*Role{
+	{ID: 1, Name: "admin", Description: "Manages users and roles"},
+	{ID: 2, Name: "mailer", Description: "Sends mail through the broker"},
+}
+
+// GetAllRoles returns all roles, sorted by name
+func (u *PostgresTestRepository) GetAllRoles() ([]*Role, error) {
+	return testRoles, nil
+}
+
+// GetRoleByName returns one role by name. Only admin and mailer exist.
+func (u *PostgresTestRepository) GetRoleByName(name string) (*Role, error) {
+	for _, role := range testRoles {
+		if role.Name == name {
+			return role, nil
+		}
+	}
+
+	return nil, sql.ErrNoRows
+}
+
+// InsertRole inserts a new role and returns its ID
+func (u *PostgresTestRepository) InsertRole(role Role) (int, error) {
+	return 3, nil
+}
+
+// DeleteRole deletes a role
+func (u *PostgresTestRepository) DeleteRole(id int) error {
+	return nil
+}
+
+// AssignRole grants a role to a user
+func (u *PostgresTestRepository) AssignRole(userID, roleID int) error {
+	return nil
+}
+
+// RevokeRole takes a role away from a user
+func (u *PostgresTestRepository) RevokeRole(userID, roleID int) error {
+	return nil
+}
+
+// testOAuthClients returns the registered test clients: a public first-party client and a
+// confidential third-party one.
+func testOAuthClients() []*OAuthClient {
+	sum := sha256.Sum256([]byte(TestClientSecret))
+
+	return []*OAuthClient{
+		{
+			ID:           "first-party-app",
+			Name:         "First Party App",
+			RedirectURIs: []string{"http://app.example.com/callback"},
+			FirstParty:   true,
+		},
+		{
+			ID:           "partner-app",
+			Name:         "Partner App",
+			SecretHash:   hex.EncodeToString(sum[:]),
+			RedirectURIs: []string{"https://partner.example.com/callback"},
+		},
+	}
+}
+
+// GetAllOAuthClients returns all registered clients, sorted by name
+func (u *PostgresTestRepository) GetAllOAuthClients() ([]*OAuthClient, error) {
+	return testOAuthClients(), nil
+}
+
+// GetOAuthClient returns one client by its client ID. Only first-party-app and partner-app exist.
+func (u *PostgresTestRepository) GetOAuthClient(id string) (*OAuthClient, error) {
+	for _, client := range testOAuthClients() {
+		if client.ID == id {
+			return client, nil
+		}
+	}
+
+	return nil, sql.ErrNoRows
+}
+
+// InsertOAuthClient registers a new client
+func (u *PostgresTestRepository) InsertOAuthClient(client OAuthClient) error {
+	return nil
+}
+
+// DeleteOAuthClient deletes a client
+func (u *PostgresTestRepository) DeleteOAuthClient(id string) error {
+	return nil
+}
+
+// InsertAuthorizationCode stores a new authorization code and returns its ID
+func (u *PostgresTestRepository) InsertAuthorizationCode(code AuthorizationCode) (int, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	code.ID = len(u.codes) + 1
+	code.CreatedAt = time.Now()
+	u.codes = append(u.codes, code)
+
+	return code.ID, nil
+}
+
+// GetAuthorizationCodeByHash returns one authorization code by the hash of its value
+func (u *PostgresTestRepository) GetAuthorizationCodeByHash(hash string) (*AuthorizationCode, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	for _, code := range u.codes {
+		if code.CodeHash == hash {
+			return &code, nil
+		}
+	}
+
+	return nil, sql.ErrNoRows
+}
+
+// MarkAuthorizationCodeUsed redeems an authorization code
+func (u *PostgresTestRepository) MarkAuthorizationCodeUsed(id int) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	for i := range u.codes {
+		if u.codes[i].ID == id {
+			if u.codes[i].UsedAt != nil {
+				return ErrAuthorizationCodeUsed
+			}
+
+			now := time.Now()
+			u.codes[i].UsedAt = &now
+		}
+	}
+
+	return nil
+}
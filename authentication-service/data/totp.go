@@ -0,0 +1,71 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrTOTPStepUsed is returned when a TOTP code is not newer than the last accepted one.
+	ErrTOTPStepUsed = errors.New("authentication code has already been used")
+	// ErrChallengeUsed is returned when a second factor challenge is redeemed a second time.
+	ErrChallengeUsed = errors.New("second factor challenge has already been used")
+)
+
+// UseTOTPStep records step as the time step of the last accepted TOTP code of a user. It
+// returns ErrTOTPStepUsed unless step is later than the one recorded before, so each code is
+// accepted only once.
+func (repo *PostgresRepository) UseTOTPStep(userID int, step int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update users set totp_last_step = $1
+		where id = $2 and (totp_last_step is null or totp_last_step < $1)`
+
+	result, err := repo.Conn.ExecContext(ctx, stmt, step, userID)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return ErrTOTPStepUsed
+	}
+
+	return nil
+}
+
+// MarkChallengeUsed redeems the second factor challenge with the given token ID. It returns
+// ErrChallengeUsed when the challenge had already been redeemed. Challenges are remembered
+// until they expire; expired ones are forgotten along the way.
+func (repo *PostgresRepository) MarkChallengeUsed(id string, expiresAt time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	if _, err := repo.Conn.ExecContext(ctx, `delete from used_challenges where expires_at < $1`, time.Now()); err != nil {
+		return err
+	}
+
+	stmt := `insert into used_challenges (token_id, expires_at) values ($1, $2) on conflict do nothing`
+
+	result, err := repo.Conn.ExecContext(ctx, stmt, id, expiresAt)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return ErrChallengeUsed
+	}
+
+	return nil
+}
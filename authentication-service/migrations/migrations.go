@@ -0,0 +1,233 @@
+// Package migrations versions the database schema of the authentication service. The SQL files
+// are embedded in the binary, so every build carries the schema it expects.
+package migrations
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+//go:embed sql/*.sql
+var embedded embed.FS
+
+// fileNameRX matches migration files such as 0001_create_users.up.sql.
+var fileNameRX = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// ErrUnknownVersion is returned when asked to migrate to, or roll back, a version this build
+// doesn't have the SQL for.
+var ErrUnknownVersion = errors.New("unknown migration version")
+
+// Migration is one schema change with the SQL to apply and to revert it.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status describes one migration relative to a database.
+type Status struct {
+	Version int64
+	Name    string
+	// AppliedAt is nil while the migration is pending.
+	AppliedAt *time.Time
+	// Missing is set for migrations applied to the database that this build doesn't know,
+	// typically because a newer version of the service ran against it.
+	Missing bool
+}
+
+// Embedded returns the migrations compiled into the binary, ordered by version.
+func Embedded() ([]Migration, error) {
+	return Parse(embedded, "sql")
+}
+
+// Parse reads the migrations in dir of fsys. Every version needs both an up and a down file.
+func Parse(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		match := fileNameRX.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("invalid migration file name %q", entry.Name())
+		}
+
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil || version < 1 {
+			return nil, fmt.Errorf("invalid migration version in %q", entry.Name())
+		}
+
+		content, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has files with different names: %q and %q", version, m.Name, match[2])
+		}
+
+		if match[3] == "up" {
+			m.Up = string(content)
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs both an up and a down file", m.Version, m.Name)
+		}
+
+		migrations = append(migrations, *m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// step is a migration to run in one direction.
+type step struct {
+	Migration
+	up bool
+}
+
+// planUp returns the steps that apply all pending migrations. Applied versions this build
+// doesn't know are left alone, so an older build can still start against a newer schema.
+func planUp(migrations []Migration, applied map[int64]time.Time) []step {
+	var steps []step
+
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; !ok {
+			steps = append(steps, step{Migration: m, up: true})
+		}
+	}
+
+	return steps
+}
+
+// planTo returns the steps that bring a database with the applied versions to target: pending
+// migrations up to target are applied in order, applied ones above it are reverted newest first.
+func planTo(migrations []Migration, applied map[int64]time.Time, target int64) ([]step, error) {
+	if target != 0 && !known(migrations, target) {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownVersion, target)
+	}
+
+	for version := range applied {
+		if version > target && !known(migrations, version) {
+			return nil, fmt.Errorf("%w: %d is applied but can't be reverted by this build", ErrUnknownVersion, version)
+		}
+	}
+
+	var steps []step
+
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if _, ok := applied[m.Version]; ok && m.Version > target {
+			steps = append(steps, step{Migration: m, up: false})
+		}
+	}
+
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; !ok && m.Version <= target {
+			steps = append(steps, step{Migration: m, up: true})
+		}
+	}
+
+	return steps, nil
+}
+
+// planDown returns the steps that revert the n most recently applied migrations.
+func planDown(migrations []Migration, applied map[int64]time.Time, n int) ([]step, error) {
+	versions := make([]int64, 0, len(applied))
+	for version := range applied {
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] > versions[j] })
+
+	var steps []step
+
+	for _, version := range versions {
+		if len(steps) == n {
+			break
+		}
+
+		m, ok := find(migrations, version)
+		if !ok {
+			return nil, fmt.Errorf("%w: %d is applied but can't be reverted by this build", ErrUnknownVersion, version)
+		}
+
+		steps = append(steps, step{Migration: m, up: false})
+	}
+
+	return steps, nil
+}
+
+// statuses merges the known migrations with the versions applied to a database.
+func statuses(migrations []Migration, applied map[int64]time.Time, names map[int64]string) []Status {
+	var result []Status
+
+	for _, m := range migrations {
+		s := Status{Version: m.Version, Name: m.Name}
+		if at, ok := applied[m.Version]; ok {
+			at := at
+			s.AppliedAt = &at
+		}
+
+		result = append(result, s)
+	}
+
+	for version, at := range applied {
+		if known(migrations, version) {
+			continue
+		}
+
+		at := at
+		result = append(result, Status{Version: version, Name: names[version], AppliedAt: &at, Missing: true})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Version < result[j].Version
+	})
+
+	return result
+}
+
+func known(migrations []Migration, version int64) bool {
+	_, ok := find(migrations, version)
+	return ok
+}
+
+func find(migrations []Migration, version int64) (Migration, bool) {
+	for _, m := range migrations {
+		if m.Version == version {
+			return m, true
+		}
+	}
+
+	return Migration{}, false
+}
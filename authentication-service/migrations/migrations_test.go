@@ -0,0 +1,183 @@
+package migrations
+
+import (
+	"errors"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func testMigrations() []Migration {
+	return []Migration{
+		{Version: 1, Name: "one", Up: "up 1", Down: "down 1"},
+		{Version: 2, Name: "two", Up: "up 2", Down: "down 2"},
+		{Version: 3, Name: "three", Up: "up 3", Down: "down 3"},
+	}
+}
+
+func appliedSet(versions ...int64) map[int64]time.Time {
+	applied := make(map[int64]time.Time)
+	for _, v := range versions {
+		applied[v] = time.Now()
+	}
+
+	return applied
+}
+
+func describe(steps []step) []string {
+	var out []string
+	for _, s := range steps {
+		if s.up {
+			out = append(out, "up "+s.Name)
+		} else {
+			out = append(out, "down "+s.Name)
+		}
+	}
+
+	return out
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func Test_Embedded(t *testing.T) {
+	migrations, err := Embedded()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, m := range migrations {
+		if m.Version != int64(i+1) {
+			t.Errorf("expected consecutive versions but migration %d is %d_%s", i, m.Version, m.Name)
+		}
+	}
+}
+
+func Test_Parse(t *testing.T) {
+	valid := fstest.MapFS{
+		"sql/0002_add_column.up.sql":     {Data: []byte("alter table t add column c int;")},
+		"sql/0002_add_column.down.sql":   {Data: []byte("alter table t drop column c;")},
+		"sql/0001_create_table.up.sql":   {Data: []byte("create table t (id int);")},
+		"sql/0001_create_table.down.sql": {Data: []byte("drop table t;")},
+	}
+
+	migrations, err := Parse(valid, "sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(migrations) != 2 || migrations[0].Name != "create_table" || migrations[1].Down != "alter table t drop column c;" {
+		t.Errorf("unexpected migrations: %+v", migrations)
+	}
+
+	invalid := map[string]fstest.MapFS{
+		"missing down": {
+			"sql/0001_create_table.up.sql": {Data: []byte("create table t (id int);")},
+		},
+		"bad name": {
+			"sql/create_table.sql": {Data: []byte("create table t (id int);")},
+		},
+		"mismatched names": {
+			"sql/0001_create_table.up.sql": {Data: []byte("create table t (id int);")},
+			"sql/0001_drop_table.down.sql": {Data: []byte("drop table t;")},
+		},
+	}
+
+	for name, fsys := range invalid {
+		if _, err := Parse(fsys, "sql"); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func Test_planTo(t *testing.T) {
+	tests := []struct {
+		name     string
+		applied  map[int64]time.Time
+		target   int64
+		expected []string
+	}{
+		{"fresh database", appliedSet(), 3, []string{"up one", "up two", "up three"}},
+		{"up to date", appliedSet(1, 2, 3), 3, nil},
+		{"partial", appliedSet(1), 2, []string{"up two"}},
+		{"back to one", appliedSet(1, 2, 3), 1, []string{"down three", "down two"}},
+		{"everything down", appliedSet(1, 2), 0, []string{"down two", "down one"}},
+		{"fills gaps", appliedSet(1, 3), 3, []string{"up two"}},
+	}
+
+	for _, tt := range tests {
+		steps, err := planTo(testMigrations(), tt.applied, tt.target)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+			continue
+		}
+
+		if got := describe(steps); !equal(got, tt.expected) {
+			t.Errorf("%s: expected %v but got %v", tt.name, tt.expected, got)
+		}
+	}
+
+	if _, err := planTo(testMigrations(), appliedSet(), 7); !errors.Is(err, ErrUnknownVersion) {
+		t.Errorf("expected ErrUnknownVersion for an unknown target but got %v", err)
+	}
+
+	if _, err := planTo(testMigrations(), appliedSet(1, 2, 3, 4), 2); !errors.Is(err, ErrUnknownVersion) {
+		t.Errorf("expected ErrUnknownVersion when reverting an unknown version but got %v", err)
+	}
+
+}
+
+func Test_planUp(t *testing.T) {
+	if got := describe(planUp(testMigrations(), appliedSet(1, 3))); !equal(got, []string{"up two"}) {
+		t.Errorf("expected only the pending migration but got %v", got)
+	}
+
+	if steps := planUp(testMigrations(), appliedSet(1, 2, 3, 4)); len(steps) != 0 {
+		t.Errorf("expected newer unknown versions to be left alone but got %v", describe(steps))
+	}
+}
+
+func Test_planDown(t *testing.T) {
+	steps, err := planDown(testMigrations(), appliedSet(1, 2, 3), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := describe(steps); !equal(got, []string{"down three", "down two"}) {
+		t.Errorf("unexpected steps %v", got)
+	}
+
+	if steps, _ := planDown(testMigrations(), appliedSet(1), 5); len(steps) != 1 {
+		t.Errorf("expected only the applied migration to be reverted but got %v", describe(steps))
+	}
+
+	if _, err := planDown(testMigrations(), appliedSet(1, 4), 1); !errors.Is(err, ErrUnknownVersion) {
+		t.Errorf("expected ErrUnknownVersion but got %v", err)
+	}
+}
+
+func Test_statuses(t *testing.T) {
+	result := statuses(testMigrations(), appliedSet(1, 4), map[int64]string{1: "one", 4: "four"})
+
+	if len(result) != 4 {
+		t.Fatalf("expected 4 statuses but got %d", len(result))
+	}
+
+	if result[0].AppliedAt == nil || result[1].AppliedAt != nil {
+		t.Errorf("expected version 1 applied and version 2 pending")
+	}
+
+	if !result[3].Missing || result[3].Name != "four" {
+		t.Errorf("expected version 4 to be reported as missing but got %+v", result[3])
+	}
+}
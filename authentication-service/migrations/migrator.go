@@ -0,0 +1,200 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// lockID is the key of the Postgres advisory lock held while migrating, so replicas starting at
+// the same time don't apply the same migration twice.
+const lockID int64 = 0x61757468 // "auth"
+
+// Migrator applies migrations to a Postgres database and records them in schema_migrations.
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+}
+
+func New(db *sql.DB, migrations []Migration) *Migrator {
+	return &Migrator{
+		db:         db,
+		migrations: migrations,
+	}
+}
+
+// Latest returns the highest version known to the migrator, or 0 when there are none.
+func (m *Migrator) Latest() int64 {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Status lists every known migration and every applied one, ordered by version.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	var result []Status
+
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, names, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		result = statuses(m.migrations, applied, names)
+		return nil
+	})
+
+	return result, err
+}
+
+// Up applies all pending migrations.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	return m.run(ctx, func(applied map[int64]time.Time) ([]step, error) {
+		return planUp(m.migrations, applied), nil
+	})
+}
+
+// Down reverts the n most recently applied migrations.
+func (m *Migrator) Down(ctx context.Context, n int) ([]Migration, error) {
+	return m.run(ctx, func(applied map[int64]time.Time) ([]step, error) {
+		return planDown(m.migrations, applied, n)
+	})
+}
+
+// To applies or reverts migrations until exactly the versions up to and including version are
+// applied. Version 0 reverts everything.
+func (m *Migrator) To(ctx context.Context, version int64) ([]Migration, error) {
+	return m.run(ctx, func(applied map[int64]time.Time) ([]step, error) {
+		return planTo(m.migrations, applied, version)
+	})
+}
+
+// run plans and executes steps while holding the migration lock. It returns the migrations
+// that ran; each one runs in its own transaction.
+func (m *Migrator) run(ctx context.Context, plan func(applied map[int64]time.Time) ([]step, error)) ([]Migration, error) {
+	var done []Migration
+
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, _, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		steps, err := plan(applied)
+		if err != nil {
+			return err
+		}
+
+		for _, s := range steps {
+			if err := execute(ctx, conn, s); err != nil {
+				return err
+			}
+
+			done = append(done, s.Migration)
+		}
+
+		return nil
+	})
+
+	return done, err
+}
+
+// withLock runs fn on a single connection holding the advisory lock; session level advisory
+// locks belong to the connection that took them.
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `select pg_advisory_lock($1)`, lockID); err != nil {
+		return fmt.Errorf("acquiring migration lock: %w", err)
+	}
+	defer func() {
+		if _, err := conn.ExecContext(context.Background(), `select pg_advisory_unlock($1)`, lockID); err != nil {
+			log.Println("Failed to release migration lock:", err)
+		}
+	}()
+
+	stmt := `create table if not exists schema_migrations (
+		version bigint primary key,
+		name character varying(255) not null,
+		applied_at timestamp without time zone not null
+	)`
+	if _, err := conn.ExecContext(ctx, stmt); err != nil {
+		return err
+	}
+
+	return fn(conn)
+}
+
+func appliedVersions(ctx context.Context, conn *sql.Conn) (map[int64]time.Time, map[int64]string, error) {
+	rows, err := conn.QueryContext(ctx, `select version, name, applied_at from schema_migrations`)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]time.Time)
+	names := make(map[int64]string)
+
+	for rows.Next() {
+		var version int64
+		var name string
+		var appliedAt time.Time
+
+		if err := rows.Scan(&version, &name, &appliedAt); err != nil {
+			return nil, nil, err
+		}
+
+		applied[version] = appliedAt
+		names[version] = name
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	return applied, names, nil
+}
+
+// execute runs one step and records it in schema_migrations within the same transaction, so a
+// failing migration leaves neither the schema change nor the record behind.
+func execute(ctx context.Context, conn *sql.Conn, s step) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	script, direction := s.Down, "down"
+	if s.up {
+		script, direction = s.Up, "up"
+	}
+
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return fmt.Errorf("migration %d_%s %s: %w", s.Version, s.Name, direction, err)
+	}
+
+	if s.up {
+		_, err = tx.ExecContext(ctx, `insert into schema_migrations (version, name, applied_at) values ($1, $2, $3)`,
+			s.Version, s.Name, time.Now())
+	} else {
+		_, err = tx.ExecContext(ctx, `delete from schema_migrations where version = $1`, s.Version)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	log.Printf("Migrated %s: %d_%s", direction, s.Version, s.Name)
+	return nil
+}
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS public.users;
+DROP SEQUENCE IF EXISTS public.user_id_seq;
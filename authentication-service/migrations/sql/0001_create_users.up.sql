@@ -0,0 +1,24 @@
+-- IF NOT EXISTS lets databases that were created from the old init.sql script adopt the migrations.
+CREATE SEQUENCE IF NOT EXISTS public.user_id_seq
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+CREATE TABLE IF NOT EXISTS public.users (
+    id integer DEFAULT nextval('public.user_id_seq'::regclass) NOT NULL,
+    email character varying(255),
+    first_name character varying(255),
+    last_name character varying(255),
+    password character varying(60),
+    user_active integer DEFAULT 0,
+    created_at timestamp without time zone,
+    updated_at timestamp without time zone,
+    CONSTRAINT users_pkey PRIMARY KEY (id),
+    CONSTRAINT users_email_key UNIQUE (email)
+);
+
+INSERT INTO public.users (email, first_name, last_name, password, user_active, created_at, updated_at)
+SELECT 'admin@example.com', 'Admin', 'User', '$2a$12$1zGLuYDDNvATh4RA4avbKuheAMpb1svexSzrQm7up.bnpwQHs0jNe', 1, '2022-03-14 00:00:00', '2022-03-14 00:00:00'
+WHERE NOT EXISTS (SELECT 1 FROM public.users WHERE email = 'admin@example.com');
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS public.refresh_tokens (
+    id serial PRIMARY KEY,
+    user_id integer NOT NULL REFERENCES public.users (id) ON DELETE CASCADE,
+    family_id character varying(64) NOT NULL,
+    token_hash character varying(64) NOT NULL UNIQUE,
+    expires_at timestamp without time zone NOT NULL,
+    used_at timestamp without time zone,
+    revoked_at timestamp without time zone,
+    created_at timestamp without time zone NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS refresh_tokens_family_id_idx ON public.refresh_tokens (family_id);
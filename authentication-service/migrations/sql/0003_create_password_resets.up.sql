@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS public.password_resets (
+    id serial PRIMARY KEY,
+    user_id integer NOT NULL REFERENCES public.users (id) ON DELETE CASCADE,
+    token_hash character varying(64) NOT NULL UNIQUE,
+    expires_at timestamp without time zone NOT NULL,
+    used_at timestamp without time zone,
+    created_at timestamp without time zone NOT NULL
+);
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS public.login_attempts (
+    key character varying(320) PRIMARY KEY,
+    failures integer NOT NULL DEFAULT 0,
+    locked_until timestamp without time zone,
+    last_failure_at timestamp without time zone NOT NULL
+);
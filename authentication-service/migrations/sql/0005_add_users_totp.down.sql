@@ -0,0 +1,4 @@
+ALTER TABLE public.users
+    DROP COLUMN IF EXISTS totp_secret,
+    DROP COLUMN IF EXISTS totp_enabled,
+    DROP COLUMN IF EXISTS totp_recovery_codes;
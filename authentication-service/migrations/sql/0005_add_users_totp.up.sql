@@ -0,0 +1,4 @@
+ALTER TABLE public.users
+    ADD COLUMN IF NOT EXISTS totp_secret character varying(64),
+    ADD COLUMN IF NOT EXISTS totp_enabled boolean DEFAULT false NOT NULL,
+    ADD COLUMN IF NOT EXISTS totp_recovery_codes text;
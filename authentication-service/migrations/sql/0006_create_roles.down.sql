@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS public.user_roles;
+DROP TABLE IF EXISTS public.roles;
@@ -0,0 +1,26 @@
+CREATE TABLE IF NOT EXISTS public.roles (
+    id serial PRIMARY KEY,
+    name character varying(64) NOT NULL UNIQUE,
+    description text NOT NULL DEFAULT '',
+    created_at timestamp without time zone NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS public.user_roles (
+    user_id integer NOT NULL REFERENCES public.users (id) ON DELETE CASCADE,
+    role_id integer NOT NULL REFERENCES public.roles (id) ON DELETE CASCADE,
+    created_at timestamp without time zone NOT NULL,
+    PRIMARY KEY (user_id, role_id)
+);
+
+INSERT INTO public.roles (name, description, created_at)
+VALUES
+    ('admin', 'Manages users and roles', '2022-03-14 00:00:00'),
+    ('mailer', 'Sends mail through the broker', '2022-03-14 00:00:00'),
+    ('log-reader', 'Reads log entries', '2022-03-14 00:00:00')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO public.user_roles (user_id, role_id, created_at)
+SELECT u.id, r.id, '2022-03-14 00:00:00'
+FROM public.users u, public.roles r
+WHERE u.email = 'admin@example.com' AND r.name = 'admin'
+ON CONFLICT (user_id, role_id) DO NOTHING;
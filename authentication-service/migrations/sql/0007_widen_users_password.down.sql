@@ -0,0 +1,2 @@
+-- Fails while any password is stored as an argon2id hash; those users need a bcrypt hash first.
+ALTER TABLE public.users ALTER COLUMN password TYPE character varying(60);
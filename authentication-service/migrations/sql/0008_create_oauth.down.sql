@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS public.oauth_authorization_codes;
+DROP TABLE IF EXISTS public.oauth_clients;
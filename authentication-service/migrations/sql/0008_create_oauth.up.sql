@@ -0,0 +1,24 @@
+CREATE TABLE IF NOT EXISTS public.oauth_clients (
+    id character varying(64) PRIMARY KEY,
+    name character varying(255) NOT NULL,
+    secret_hash character varying(64),
+    redirect_uris text NOT NULL,
+    first_party boolean NOT NULL DEFAULT false,
+    created_at timestamp without time zone NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS public.oauth_authorization_codes (
+    id serial PRIMARY KEY,
+    code_hash character varying(64) NOT NULL UNIQUE,
+    client_id character varying(64) NOT NULL REFERENCES public.oauth_clients (id) ON DELETE CASCADE,
+    user_id integer NOT NULL REFERENCES public.users (id) ON DELETE CASCADE,
+    redirect_uri text NOT NULL,
+    scope text NOT NULL,
+    nonce text NOT NULL DEFAULT '',
+    code_challenge character varying(128) NOT NULL,
+    auth_methods character varying(64) NOT NULL,
+    auth_time timestamp without time zone NOT NULL,
+    expires_at timestamp without time zone NOT NULL,
+    used_at timestamp without time zone,
+    created_at timestamp without time zone NOT NULL
+);
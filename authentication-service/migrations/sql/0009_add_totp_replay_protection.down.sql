@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS public.used_challenges;
+
+ALTER TABLE public.users
+    DROP COLUMN IF EXISTS totp_last_step;
@@ -0,0 +1,9 @@
+-- The time step of the last accepted TOTP code; codes of that step or earlier are rejected.
+ALTER TABLE public.users
+    ADD COLUMN IF NOT EXISTS totp_last_step bigint;
+
+-- Redeemed second factor challenges, kept until they expire so each one is redeemed only once.
+CREATE TABLE IF NOT EXISTS public.used_challenges (
+    token_id character varying(64) PRIMARY KEY,
+    expires_at timestamp without time zone NOT NULL
+);
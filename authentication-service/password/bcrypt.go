@@ -0,0 +1,52 @@
+package password
+
+import (
+	"errors"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// BcryptID names the bcrypt algorithm in configuration.
+const BcryptID = "bcrypt"
+
+// DefaultBcrypt has the cost the service has always used.
+var DefaultBcrypt = Bcrypt{Cost: 12}
+
+// Bcrypt hashes passwords with bcrypt. Its modular crypt format ($2a$12$...) already names the
+// algorithm and the cost, so it is stored as is.
+type Bcrypt struct {
+	Cost int
+}
+
+func (b Bcrypt) Hash(plainText string) (string, error) {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(plainText), b.Cost)
+	if err != nil {
+		return "", err
+	}
+
+	return string(hashed), nil
+}
+
+func (b Bcrypt) Verify(plainText, encoded string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(plainText))
+	if err != nil {
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (b Bcrypt) Recognizes(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") ||
+		strings.HasPrefix(encoded, "$2b$") ||
+		strings.HasPrefix(encoded, "$2y$")
+}
+
+func (b Bcrypt) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != b.Cost
+}
@@ -0,0 +1,84 @@
+// Package password hashes and verifies user passwords. Encoded hashes name their algorithm and
+// parameters, so hashes created with different algorithms or costs can be stored side by side
+// and upgraded when users log in.
+package password
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrUnknownAlgorithm is returned for encoded hashes no configured hasher recognizes.
+var ErrUnknownAlgorithm = errors.New("unknown password hash algorithm")
+
+// Hasher is one password hashing algorithm with fixed parameters.
+type Hasher interface {
+	// Hash returns the self-describing encoding of the hash of plainText.
+	Hash(plainText string) (string, error)
+	// Verify reports whether plainText matches an encoded hash produced by this algorithm.
+	Verify(plainText, encoded string) (bool, error)
+	// Recognizes reports whether encoded was produced by this algorithm.
+	Recognizes(encoded string) bool
+	// NeedsRehash reports whether encoded was produced with parameters other than the hasher's.
+	NeedsRehash(encoded string) bool
+}
+
+// Policy hashes new passwords with its Current hasher and verifies existing hashes with
+// whichever hasher produced them.
+type Policy struct {
+	Current Hasher
+	// Legacy hashers are only used to verify passwords that haven't been rehashed yet.
+	Legacy []Hasher
+}
+
+// NewPolicy returns the policy for the named algorithm, "argon2id" or "bcrypt". An empty name
+// selects argon2id. Hashes of the other algorithm are still accepted.
+func NewPolicy(algorithm string) (*Policy, error) {
+	switch algorithm {
+	case "", Argon2idID:
+		return &Policy{Current: DefaultArgon2id, Legacy: []Hasher{DefaultBcrypt}}, nil
+	case BcryptID:
+		return &Policy{Current: DefaultBcrypt, Legacy: []Hasher{DefaultArgon2id}}, nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownAlgorithm, algorithm)
+	}
+}
+
+// Hash hashes plainText with the current hasher.
+func (p *Policy) Hash(plainText string) (string, error) {
+	return p.Current.Hash(plainText)
+}
+
+// Verify reports whether plainText matches encoded, whatever algorithm produced it.
+func (p *Policy) Verify(plainText, encoded string) (bool, error) {
+	hasher, ok := p.hasherFor(encoded)
+	if !ok {
+		return false, ErrUnknownAlgorithm
+	}
+
+	return hasher.Verify(plainText, encoded)
+}
+
+// NeedsRehash reports whether encoded should be replaced by a hash from the current hasher,
+// because it uses another algorithm or outdated parameters.
+func (p *Policy) NeedsRehash(encoded string) bool {
+	if !p.Current.Recognizes(encoded) {
+		return true
+	}
+
+	return p.Current.NeedsRehash(encoded)
+}
+
+func (p *Policy) hasherFor(encoded string) (Hasher, bool) {
+	if p.Current.Recognizes(encoded) {
+		return p.Current, true
+	}
+
+	for _, hasher := range p.Legacy {
+		if hasher.Recognizes(encoded) {
+			return hasher, true
+		}
+	}
+
+	return nil, false
+}
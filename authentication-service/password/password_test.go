@@ -0,0 +1,102 @@
+package password
+
+import (
+	"strings"
+	"testing"
+)
+
+// seededAdminHash is the bcrypt hash of "verysecret" the database is seeded with.
+const seededAdminHash = "$2a$12$1zGLuYDDNvATh4RA4avbKuheAMpb1svexSzrQm7up.bnpwQHs0jNe"
+
+func Test_Hashers(t *testing.T) {
+	hashers := map[string]Hasher{
+		"bcrypt":   Bcrypt{Cost: 4},
+		"argon2id": Argon2id{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32},
+	}
+
+	for name, hasher := range hashers {
+		encoded, err := hasher.Hash("verysecret")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		if !hasher.Recognizes(encoded) {
+			t.Errorf("%s: expected the hasher to recognize its own hash %q", name, encoded)
+		}
+
+		if ok, err := hasher.Verify("verysecret", encoded); !ok || err != nil {
+			t.Errorf("%s: expected the password to match but got %v, %v", name, ok, err)
+		}
+
+		if ok, _ := hasher.Verify("wrong", encoded); ok {
+			t.Errorf("%s: expected a wrong password not to match", name)
+		}
+
+		if hasher.NeedsRehash(encoded) {
+			t.Errorf("%s: expected a fresh hash not to need a rehash", name)
+		}
+	}
+}
+
+func Test_Argon2idEncoding(t *testing.T) {
+	encoded, err := DefaultArgon2id.Hash("verysecret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(encoded, "$argon2id$v=19$m=19456,t=2,p=1$") {
+		t.Errorf("unexpected PHC encoding %q", encoded)
+	}
+
+	invalid := []string{
+		"$argon2id$v=19$m=19456,t=2,p=1$c2FsdA",
+		"$argon2id$v=16$m=19456,t=2,p=1$c2FsdA$aGFzaA",
+		"$argon2id$v=19$m=0,t=2,p=1$c2FsdA$aGFzaA",
+		"$argon2id$v=19$m=19456,t=2,p=1$!!!$aGFzaA",
+	}
+
+	for _, encoded := range invalid {
+		if _, err := DefaultArgon2id.Verify("verysecret", encoded); err == nil {
+			t.Errorf("expected %q to be rejected", encoded)
+		}
+	}
+}
+
+func Test_PolicyRehash(t *testing.T) {
+	policy, err := NewPolicy("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ok, err := policy.Verify("verysecret", seededAdminHash)
+	if !ok || err != nil {
+		t.Fatalf("expected the legacy bcrypt hash to verify but got %v, %v", ok, err)
+	}
+
+	if !policy.NeedsRehash(seededAdminHash) {
+		t.Error("expected a bcrypt hash to need a rehash under the argon2id policy")
+	}
+
+	weak := Argon2id{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+	weakHash, _ := weak.Hash("verysecret")
+	if !policy.NeedsRehash(weakHash) {
+		t.Error("expected an argon2id hash with outdated parameters to need a rehash")
+	}
+
+	if ok, err := policy.Verify("verysecret", weakHash); !ok || err != nil {
+		t.Errorf("expected a hash with outdated parameters to still verify but got %v, %v", ok, err)
+	}
+
+	bcryptPolicy, _ := NewPolicy(BcryptID)
+	if bcryptPolicy.NeedsRehash(seededAdminHash) {
+		t.Error("expected a cost 12 bcrypt hash to be current under the bcrypt policy")
+	}
+
+	if _, err := policy.Verify("verysecret", "plain-text"); err != ErrUnknownAlgorithm {
+		t.Errorf("expected ErrUnknownAlgorithm but got %v", err)
+	}
+
+	if _, err := NewPolicy("md5"); err == nil {
+		t.Error("expected an unknown algorithm to be rejected")
+	}
+}
@@ -0,0 +1,60 @@
+package throttle
+
+import (
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps counters in memory. Counters are lost on restart and not shared between
+// replicas, which makes it suitable for tests and single instance deployments.
+type MemoryStore struct {
+	mu       sync.Mutex
+	attempts map[string]Attempts
+	now      func() time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		attempts: make(map[string]Attempts),
+		now:      time.Now,
+	}
+}
+
+func (s *MemoryStore) Get(key string) (Attempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.attempts[key], nil
+}
+
+func (s *MemoryStore) RecordFailure(key string, policy Policy) (Attempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	attempts := s.attempts[key]
+
+	if now.Sub(attempts.LastFailureAt) > policy.Window {
+		attempts.Failures = 0
+	}
+
+	attempts.Failures++
+	attempts.LastFailureAt = now
+
+	if lockout := policy.Lockout(attempts.Failures); lockout > 0 {
+		attempts.LockedUntil = now.Add(lockout)
+	}
+
+	s.attempts[key] = attempts
+
+	return attempts, nil
+}
+
+func (s *MemoryStore) Reset(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.attempts, key)
+
+	return nil
+}
@@ -0,0 +1,101 @@
+package throttle
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+const dbTimeout = time.Second * 3
+
+// PostgresStore keeps counters in the login_attempts table, so they survive restarts and are
+// shared by all replicas of the service.
+type PostgresStore struct {
+	Conn *sql.DB
+}
+
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	return &PostgresStore{
+		Conn: db,
+	}
+}
+
+func (s *PostgresStore) Get(key string) (Attempts, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select failures, locked_until, last_failure_at from login_attempts where key = $1`
+
+	var attempts Attempts
+	var lockedUntil sql.NullTime
+
+	err := s.Conn.QueryRowContext(ctx, query, key).Scan(
+		&attempts.Failures,
+		&lockedUntil,
+		&attempts.LastFailureAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Attempts{}, nil
+		}
+		return Attempts{}, err
+	}
+
+	attempts.LockedUntil = lockedUntil.Time
+
+	return attempts, nil
+}
+
+func (s *PostgresStore) RecordFailure(key string, policy Policy) (Attempts, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	now := time.Now()
+
+	// the counter starts over when the previous failure is older than the policy window
+	stmt := `insert into login_attempts (key, failures, last_failure_at) values ($1, 1, $2)
+		on conflict (key) do update set
+			failures = case when login_attempts.last_failure_at < $3 then 1 else login_attempts.failures + 1 end,
+			last_failure_at = excluded.last_failure_at
+		returning failures, locked_until`
+
+	var attempts Attempts
+	var lockedUntil sql.NullTime
+
+	err := s.Conn.QueryRowContext(ctx, stmt, key, now, now.Add(-policy.Window)).Scan(
+		&attempts.Failures,
+		&lockedUntil,
+	)
+	if err != nil {
+		return Attempts{}, err
+	}
+
+	attempts.LastFailureAt = now
+	attempts.LockedUntil = lockedUntil.Time
+
+	if lockout := policy.Lockout(attempts.Failures); lockout > 0 {
+		attempts.LockedUntil = now.Add(lockout)
+
+		stmt = `update login_attempts set locked_until = $1 where key = $2`
+		if _, err := s.Conn.ExecContext(ctx, stmt, attempts.LockedUntil, key); err != nil {
+			return Attempts{}, err
+		}
+	}
+
+	return attempts, nil
+}
+
+func (s *PostgresStore) Reset(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `delete from login_attempts where key = $1`
+
+	_, err := s.Conn.ExecContext(ctx, stmt, key)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,101 @@
+package throttle
+
+import (
+	"time"
+)
+
+// Policy decides when a key gets locked after repeated failures. Once a key has failed more than
+// MaxFailures times it is locked for BaseLockout, and every further failure doubles the lockout up
+// to MaxLockout. Failures older than Window are forgotten.
+type Policy struct {
+	MaxFailures int
+	BaseLockout time.Duration
+	MaxLockout  time.Duration
+	Window      time.Duration
+}
+
+// Lockout returns how long a key is locked after its n-th consecutive failure.
+func (p Policy) Lockout(failures int) time.Duration {
+	if failures <= p.MaxFailures {
+		return 0
+	}
+
+	lockout := p.BaseLockout
+	for i := p.MaxFailures + 1; i < failures && lockout < p.MaxLockout; i++ {
+		lockout *= 2
+	}
+
+	if lockout > p.MaxLockout {
+		return p.MaxLockout
+	}
+
+	return lockout
+}
+
+// Attempts is the failed attempt counter of one key.
+type Attempts struct {
+	Failures      int
+	LockedUntil   time.Time
+	LastFailureAt time.Time
+}
+
+// Store keeps failed attempt counters. Implementations must apply RecordFailure atomically so
+// concurrent failures are all counted.
+type Store interface {
+	Get(key string) (Attempts, error)
+	RecordFailure(key string, policy Policy) (Attempts, error)
+	Reset(key string) error
+}
+
+// Key is a counter to check, such as one account or one client IP, and the policy applied to it.
+type Key struct {
+	Name   string
+	Policy Policy
+}
+
+// Limiter tracks failures of several keys at once.
+type Limiter struct {
+	store Store
+	now   func() time.Time
+}
+
+func NewLimiter(store Store) *Limiter {
+	return &Limiter{
+		store: store,
+		now:   time.Now,
+	}
+}
+
+// Check returns how long the longest running lock among keys lasts; zero when none is locked.
+func (l *Limiter) Check(keys ...Key) (time.Duration, error) {
+	var longest time.Duration
+
+	for _, key := range keys {
+		attempts, err := l.store.Get(key.Name)
+		if err != nil {
+			return 0, err
+		}
+
+		if remaining := attempts.LockedUntil.Sub(l.now()); remaining > longest {
+			longest = remaining
+		}
+	}
+
+	return longest, nil
+}
+
+// Fail records a failed attempt for every key.
+func (l *Limiter) Fail(keys ...Key) error {
+	for _, key := range keys {
+		if _, err := l.store.RecordFailure(key.Name, key.Policy); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Reset clears the counter of a key, lifting its lock.
+func (l *Limiter) Reset(key Key) error {
+	return l.store.Reset(key.Name)
+}
@@ -0,0 +1,66 @@
+package throttle
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_PolicyLockout(t *testing.T) {
+	policy := Policy{
+		MaxFailures: 3,
+		BaseLockout: time.Minute,
+		MaxLockout:  5 * time.Minute,
+		Window:      time.Hour,
+	}
+
+	expected := map[int]time.Duration{
+		1: 0,
+		3: 0,
+		4: time.Minute,
+		5: 2 * time.Minute,
+		6: 4 * time.Minute,
+		7: 5 * time.Minute,
+		9: 5 * time.Minute,
+	}
+
+	for failures, lockout := range expected {
+		if got := policy.Lockout(failures); got != lockout {
+			t.Errorf("%d failures: expected lockout %s but got %s", failures, lockout, got)
+		}
+	}
+}
+
+func Test_Limiter(t *testing.T) {
+	now := time.Date(2023, 3, 14, 12, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+
+	store := NewMemoryStore()
+	store.now = clock
+	limiter := NewLimiter(store)
+	limiter.now = clock
+
+	key := Key{Name: "account:me@here.com", Policy: Policy{MaxFailures: 1, BaseLockout: time.Minute, MaxLockout: time.Hour, Window: time.Hour}}
+	other := Key{Name: "ip:10.0.0.1", Policy: Policy{MaxFailures: 10, BaseLockout: time.Minute, MaxLockout: time.Hour, Window: time.Hour}}
+
+	_ = limiter.Fail(key, other)
+	if retryAfter, _ := limiter.Check(key, other); retryAfter != 0 {
+		t.Fatalf("expected no lock after the first failure but got %s", retryAfter)
+	}
+
+	_ = limiter.Fail(key, other)
+	if retryAfter, _ := limiter.Check(key, other); retryAfter != time.Minute {
+		t.Fatalf("expected a one minute lock but got %s", retryAfter)
+	}
+
+	// failures outside of the window are forgotten
+	now = now.Add(2 * time.Hour)
+	_ = limiter.Fail(key)
+	if attempts, _ := store.Get(key.Name); attempts.Failures != 1 {
+		t.Errorf("expected the counter to start over but got %d failures", attempts.Failures)
+	}
+
+	_ = limiter.Reset(key)
+	if attempts, _ := store.Get(key.Name); attempts.Failures != 0 {
+		t.Errorf("expected reset to clear the counter but got %d failures", attempts.Failures)
+	}
+}
@@ -0,0 +1,70 @@
+package token
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ChallengeAudience is the "aud" claim of second factor challenge tokens. It differs from
+// Audience, so a challenge can never be used as an access token or the other way round.
+const ChallengeAudience = "microservices-in-go/second-factor"
+
+// IssueChallengeToken returns a short-lived token proving that userID passed the first
+// authentication factor, to be exchanged for a token pair once the second factor is verified.
+func (m *Manager) IssueChallengeToken(userID int, ttl time.Duration) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(ttl)
+
+	jti, err := randomString(16)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	claims := jwt.RegisteredClaims{
+		Issuer:    m.Issuer,
+		Subject:   strconv.Itoa(userID),
+		Audience:  jwt.ClaimStrings{ChallengeAudience},
+		ExpiresAt: jwt.NewNumericDate(expiresAt),
+		IssuedAt:  jwt.NewNumericDate(now),
+		NotBefore: jwt.NewNumericDate(now),
+		ID:        jti,
+	}
+
+	signed, err := m.sign(claims)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return signed, expiresAt, nil
+}
+
+// Challenge is a verified challenge token.
+type Challenge struct {
+	UserID int
+	// ID is the "jti" claim, which identifies the challenge when it is redeemed.
+	ID        string
+	ExpiresAt time.Time
+}
+
+// VerifyChallengeToken checks a challenge token and returns who it was issued to. Redeeming the
+// challenge only once is up to the caller.
+func (m *Manager) VerifyChallengeToken(tokenString string) (*Challenge, error) {
+	var claims jwt.RegisteredClaims
+
+	if err := m.parse(tokenString, &claims, ChallengeAudience); err != nil {
+		return nil, err
+	}
+
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil || claims.ID == "" || claims.ExpiresAt == nil {
+		return nil, ErrInvalidToken
+	}
+
+	return &Challenge{
+		UserID:    userID,
+		ID:        claims.ID,
+		ExpiresAt: claims.ExpiresAt.Time,
+	}, nil
+}
@@ -0,0 +1,321 @@
+package token
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// Audience is the "aud" claim of the access tokens the service issues to its users, which the
+	// services of this project accept.
+	Audience = "microservices-in-go"
+	// ClientAudience is the "aud" claim of the access tokens issued to OAuth clients. They are
+	// only good for the userinfo endpoint, so a client can't act as the user anywhere else.
+	ClientAudience = "microservices-in-go/oauth"
+
+	signingAlgorithm = "RS256"
+	opaqueTokenSize  = 32
+)
+
+var ErrInvalidToken = errors.New("invalid or expired token")
+
+// Claims is the payload of an access token.
+type Claims struct {
+	Email string   `json:"email"`
+	Roles []string `json:"roles,omitempty"`
+	// AMR lists the authentication methods used to log in, such as "pwd" and "otp" (RFC 8176).
+	AMR []string `json:"amr,omitempty"`
+	// Scope and ClientID are set on tokens issued to OAuth clients.
+	Scope    string `json:"scope,omitempty"`
+	ClientID string `json:"client_id,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// Manager signs and verifies access tokens with a single RSA key.
+type Manager struct {
+	key        *rsa.PrivateKey
+	keyID      string
+	Issuer     string
+	AccessTTL  time.Duration
+	RefreshTTL time.Duration
+}
+
+func NewManager(key *rsa.PrivateKey, issuer string, accessTTL, refreshTTL time.Duration) *Manager {
+	return &Manager{
+		key:        key,
+		keyID:      thumbprint(&key.PublicKey),
+		Issuer:     issuer,
+		AccessTTL:  accessTTL,
+		RefreshTTL: refreshTTL,
+	}
+}
+
+// IssueAccessToken returns a signed access token for the given subject along with its expiry time.
+func (m *Manager) IssueAccessToken(userID int, email string, roles, amr []string) (string, time.Time, error) {
+	return m.IssueAccessTokenClaims(Claims{
+		Email: email,
+		Roles: roles,
+		AMR:   amr,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject: strconv.Itoa(userID),
+		},
+	})
+}
+
+// IssueAccessTokenClaims signs an access token with the given custom claims and subject. The
+// other registered claims are filled in by the manager; tokens with a ClientID get the
+// ClientAudience.
+func (m *Manager) IssueAccessTokenClaims(claims Claims) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(m.AccessTTL)
+
+	jti, err := randomString(16)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	audience := Audience
+	if claims.ClientID != "" {
+		audience = ClientAudience
+	}
+
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		Issuer:    m.Issuer,
+		Subject:   claims.Subject,
+		Audience:  jwt.ClaimStrings{audience},
+		ExpiresAt: jwt.NewNumericDate(expiresAt),
+		IssuedAt:  jwt.NewNumericDate(now),
+		NotBefore: jwt.NewNumericDate(now),
+		ID:        jti,
+	}
+
+	signed, err := m.sign(claims)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return signed, expiresAt, nil
+}
+
+// Verify checks the signature and the registered claims of an access token issued to a user and
+// returns its claims. Tokens issued to OAuth clients are rejected.
+func (m *Manager) Verify(tokenString string) (*Claims, error) {
+	var claims Claims
+
+	if err := m.parse(tokenString, &claims, Audience); err != nil {
+		return nil, err
+	}
+	if claims.ClientID != "" {
+		return nil, ErrInvalidToken
+	}
+
+	return &claims, nil
+}
+
+// VerifyClientToken checks an access token issued to an OAuth client and returns its claims.
+func (m *Manager) VerifyClientToken(tokenString string) (*Claims, error) {
+	var claims Claims
+
+	if err := m.parse(tokenString, &claims, ClientAudience); err != nil {
+		return nil, err
+	}
+	if claims.ClientID == "" {
+		return nil, ErrInvalidToken
+	}
+
+	return &claims, nil
+}
+
+// HasScope reports whether the token was granted the given OAuth scope.
+func (c *Claims) HasScope(scope string) bool {
+	for _, s := range strings.Fields(c.Scope) {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// HasMethod reports whether the token was obtained using the given authentication method.
+func (c *Claims) HasMethod(method string) bool {
+	for _, m := range c.AMR {
+		if m == method {
+			return true
+		}
+	}
+
+	return false
+}
+
+// HasRole reports whether the token grants any of the given roles.
+func (c *Claims) HasRole(roles ...string) bool {
+	for _, required := range roles {
+		for _, role := range c.Roles {
+			if role == required {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// parse verifies a token signed by m for the given audience and decodes it into claims.
+func (m *Manager) parse(tokenString string, claims jwt.Claims, audience string) error {
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (any, error) {
+		return &m.key.PublicKey, nil
+	},
+		jwt.WithValidMethods([]string{signingAlgorithm}),
+		jwt.WithIssuer(m.Issuer),
+		jwt.WithAudience(audience),
+	)
+	if err != nil {
+		return ErrInvalidToken
+	}
+
+	return nil
+}
+
+func (m *Manager) sign(claims jwt.Claims) (string, error) {
+	t := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	t.Header["kid"] = m.keyID
+
+	return t.SignedString(m.key)
+}
+
+// NewOpaqueToken generates a random token such as a refresh or password reset token.
+// Only its hash is meant to be persisted.
+func NewOpaqueToken() (plainText string, hash string, err error) {
+	plainText, err = randomString(opaqueTokenSize)
+	if err != nil {
+		return "", "", err
+	}
+
+	return plainText, Hash(plainText), nil
+}
+
+// NewFamilyID generates the identifier shared by all refresh tokens descending from one login.
+func NewFamilyID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// Hash returns the hex encoded SHA-256 digest of an opaque token.
+func Hash(plainText string) string {
+	sum := sha256.Sum256([]byte(plainText))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomString(size int) (string, error) {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// JWK is the public part of a signing key as described in RFC 7517.
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// JWKS is a JSON Web Key Set.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the key set other services use to verify access tokens offline.
+func (m *Manager) JWKS() JWKS {
+	pub := m.key.PublicKey
+
+	return JWKS{
+		Keys: []JWK{
+			{
+				Kty: "RSA",
+				Use: "sig",
+				Kid: m.keyID,
+				Alg: signingAlgorithm,
+				N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			},
+		},
+	}
+}
+
+// thumbprint computes the RFC 7638 thumbprint of a public key, used as its key ID.
+func thumbprint(pub *rsa.PublicKey) string {
+	fields := struct {
+		E   string `json:"e"`
+		Kty string `json:"kty"`
+		N   string `json:"n"`
+	}{
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		Kty: "RSA",
+		N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+	}
+
+	out, _ := json.Marshal(fields)
+	sum := sha256.Sum256(out)
+
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// LoadKey reads a PEM encoded RSA private key (PKCS#1 or PKCS#8) from path. When path is
+// empty a fresh key is generated, which means tokens won't survive a restart.
+func LoadKey(path string) (*rsa.PrivateKey, error) {
+	if path == "" {
+		return rsa.GenerateKey(rand.Reader, 2048)
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in %s", path)
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s does not contain an RSA private key", path)
+	}
+
+	return key, nil
+}
@@ -0,0 +1,132 @@
+package token
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func newTestManager(t *testing.T, accessTTL time.Duration) *Manager {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewManager(key, "test-issuer", accessTTL, time.Hour)
+}
+
+func Test_IssueAndVerify(t *testing.T) {
+	manager := newTestManager(t, time.Minute)
+
+	signed, _, err := manager.IssueAccessToken(7, "me@here.com", []string{"admin"}, []string{"pwd", "otp"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := manager.Verify(signed)
+	if err != nil {
+		t.Fatalf("expected token to verify: %v", err)
+	}
+
+	if claims.Subject != "7" || claims.Email != "me@here.com" || !claims.HasRole("admin") || claims.HasRole("mailer") || !claims.HasMethod("otp") {
+		t.Errorf("unexpected claims: %+v", claims)
+	}
+}
+
+func Test_ClientTokens(t *testing.T) {
+	manager := newTestManager(t, time.Minute)
+
+	clientToken, _, err := manager.IssueAccessTokenClaims(Claims{Email: "me@here.com", Scope: "openid", ClientID: "partner-app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if claims, err := manager.VerifyClientToken(clientToken); err != nil || claims.ClientID != "partner-app" {
+		t.Errorf("expected the client token to verify as one but got %+v, %v", claims, err)
+	}
+	if _, err := manager.Verify(clientToken); err != ErrInvalidToken {
+		t.Errorf("expected a client token not to be accepted as a user's access token but got %v", err)
+	}
+
+	userToken, _, _ := manager.IssueAccessToken(7, "me@here.com", nil, nil)
+	if _, err := manager.VerifyClientToken(userToken); err != ErrInvalidToken {
+		t.Errorf("expected a user's access token not to be accepted as a client token but got %v", err)
+	}
+
+	// client tokens issued before they had an audience of their own
+	claims := Claims{Email: "me@here.com", ClientID: "partner-app"}
+	claims.Issuer = manager.Issuer
+	claims.Audience = []string{Audience}
+	claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(time.Minute))
+	legacy, _ := manager.sign(claims)
+	if _, err := manager.Verify(legacy); err != ErrInvalidToken {
+		t.Errorf("expected a token with a client_id not to be accepted as a user's access token but got %v", err)
+	}
+}
+
+func Test_VerifyRejectsForeignAndExpiredTokens(t *testing.T) {
+	manager := newTestManager(t, time.Minute)
+	other := newTestManager(t, time.Minute)
+	expired := newTestManager(t, -time.Minute)
+	expired.key = manager.key
+
+	foreign, _, _ := other.IssueAccessToken(1, "me@here.com", nil, nil)
+	stale, _, _ := expired.IssueAccessToken(1, "me@here.com", nil, nil)
+
+	tests := map[string]string{
+		"signed by another key": foreign,
+		"expired":               stale,
+		"garbage":               "not-a-token",
+	}
+
+	for name, signed := range tests {
+		if _, err := manager.Verify(signed); err != ErrInvalidToken {
+			t.Errorf("%s: expected ErrInvalidToken but got %v", name, err)
+		}
+	}
+}
+
+func Test_NewOpaqueToken(t *testing.T) {
+	plainText, hash, err := NewOpaqueToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if plainText == hash || Hash(plainText) != hash {
+		t.Errorf("expected hash to be the SHA-256 digest of the token")
+	}
+}
+
+func Test_ChallengeTokens(t *testing.T) {
+	manager := newTestManager(t, time.Minute)
+
+	challenge, _, err := manager.IssueChallengeToken(7, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verified, err := manager.VerifyChallengeToken(challenge)
+	if err != nil || verified.UserID != 7 {
+		t.Fatalf("expected challenge for user 7 but got %+v, %v", verified, err)
+	}
+	if verified.ID == "" || time.Until(verified.ExpiresAt) <= 0 {
+		t.Errorf("expected the challenge to have an ID and an expiry but got %+v", verified)
+	}
+
+	other, _, _ := manager.IssueChallengeToken(7, time.Minute)
+	if again, _ := manager.VerifyChallengeToken(other); again == nil || again.ID == verified.ID {
+		t.Errorf("expected every challenge to have its own ID")
+	}
+
+	if _, err := manager.Verify(challenge); err != ErrInvalidToken {
+		t.Errorf("expected a challenge not to be accepted as an access token but got %v", err)
+	}
+
+	access, _, _ := manager.IssueAccessToken(7, "me@here.com", nil, nil)
+	if _, err := manager.VerifyChallengeToken(access); err != ErrInvalidToken {
+		t.Errorf("expected an access token not to be accepted as a challenge but got %v", err)
+	}
+}
@@ -0,0 +1,31 @@
+package backoff
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_Backoff(t *testing.T) {
+	b := Backoff{Min: 100 * time.Millisecond, Max: time.Second}
+
+	bounds := []time.Duration{
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+		time.Second,
+		time.Second,
+	}
+
+	for i, bound := range bounds {
+		d := b.Next()
+		if d < bound/2 || d > bound {
+			t.Errorf("attempt %d: expected a delay between %v and %v but got %v", i, bound/2, bound, d)
+		}
+	}
+
+	b.Reset()
+	if d := b.Next(); d > 100*time.Millisecond {
+		t.Errorf("expected the delay to start over after a reset but got %v", d)
+	}
+}
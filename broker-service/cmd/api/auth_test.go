@@ -0,0 +1,107 @@
+package main
+
+import (
+	"broker/data"
+	"broker/token"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_authenticateToken(t *testing.T) {
+	foreignKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	tests := []struct {
+		name          string
+		header        string
+		expectedCode  int
+		expectSubject bool
+	}{
+		{"no header", "", http.StatusOK, false},
+		{"valid token", "Bearer " + signTestToken(testSigningKey, []string{"admin"}, time.Minute), http.StatusOK, true},
+		{"expired token", "Bearer " + signTestToken(testSigningKey, nil, -time.Minute), http.StatusUnauthorized, false},
+		{"foreign key", "Bearer " + signTestToken(foreignKey, nil, time.Minute), http.StatusUnauthorized, false},
+		{"wrong scheme", "Basic dXNlcjpwYXNz", http.StatusUnauthorized, false},
+		{"oauth client token", "Bearer " + signTestClaims(testSigningKey, token.Claims{Roles: []string{"admin"}, ClientID: "partner-app"}, time.Minute), http.StatusUnauthorized, false},
+	}
+
+	for _, tt := range tests {
+		var principal *Principal
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, _ = principalFromContext(r.Context())
+		})
+
+		req, _ := http.NewRequest(http.MethodPost, "/handle", nil)
+		if tt.header != "" {
+			req.Header.Set(string(data.HeaderAuthorization), tt.header)
+		}
+		rr := httptest.NewRecorder()
+
+		testApp.authenticateToken(next).ServeHTTP(rr, req)
+
+		if rr.Code != tt.expectedCode {
+			t.Errorf("%s: expected status %d but got %d", tt.name, tt.expectedCode, rr.Code)
+		}
+
+		if tt.expectSubject && (principal == nil || principal.Subject != "1" || principal.Roles[0] != "admin") {
+			t.Errorf("%s: expected principal in context but got %+v", tt.name, principal)
+		}
+	}
+}
+
+func Test_authorize(t *testing.T) {
+	authenticated := context.WithValue(context.Background(), principalContextKey, &Principal{Subject: "1"})
+	mailer := context.WithValue(context.Background(), principalContextKey, &Principal{Subject: "2", Roles: []string{roleMailer}})
+
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		action   data.ActionType
+		expected error
+	}{
+		{"ping is public", context.Background(), data.Ping, nil},
+		{"auth is public", context.Background(), data.Auth, nil},
+		{"mail needs a user", context.Background(), data.Mail, errUnauthenticated},
+		{"mail without a role", authenticated, data.Mail, errForbidden},
+		{"mail with the mailer role", mailer, data.Mail, nil},
+		{"log with a user", authenticated, data.Log, nil},
+		{"unknown action", authenticated, data.ActionType(-1), errForbidden},
+	}
+
+	for _, tt := range tests {
+		if err := authorize(tt.ctx, tt.action); err != tt.expected {
+			t.Errorf("%s: expected %v but got %v", tt.name, tt.expected, err)
+		}
+	}
+}
+
+func Test_HandleSubmission_rejectsAnonymousMail(t *testing.T) {
+	body := []byte(`{"action": "mail", "mail": {"to": "you@there.com"}}`)
+
+	req, _ := http.NewRequest(http.MethodPost, "/handle", bytes.NewReader(body))
+	rr := httptest.NewRecorder()
+
+	testApp.routes().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected http.StatusUnauthorized but got %d", rr.Code)
+	}
+}
+
+func Test_HandleSubmission_allowsAnonymousPing(t *testing.T) {
+	body := []byte(`{"action": "ping"}`)
+
+	req, _ := http.NewRequest(http.MethodPost, "/handle", bytes.NewReader(body))
+	rr := httptest.NewRecorder()
+
+	testApp.routes().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected http.StatusOK but got %d", rr.Code)
+	}
+}
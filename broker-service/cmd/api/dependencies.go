@@ -0,0 +1,93 @@
+package main
+
+import (
+	"broker/data"
+	"broker/logclient"
+	"broker/problem"
+	"broker/resilience"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// dependencies are the services the broker calls. Each one has its own timeout and circuit
+// breaker. None of the calls is retried, as each of them changes state in the service it calls.
+type dependencies struct {
+	Auth       *resilience.Dependency
+	Mail       *resilience.Dependency
+	LoggerRPC  *resilience.Dependency
+	LoggerGRPC *resilience.Dependency
+}
+
+func newDependencies(logger *logclient.Clients) *dependencies {
+	deps := &dependencies{
+		Auth: resilience.New("authentication-service", resilience.Options{
+			Timeout: 5 * time.Second,
+		}),
+		Mail: resilience.New("mail-service", resilience.Options{
+			Timeout: 10 * time.Second,
+		}),
+	}
+
+	rpcOptions := resilience.Options{Timeout: time.Second}
+	grpcOptions := resilience.Options{Timeout: time.Second}
+	if logger != nil {
+		rpcOptions.Healthy = logger.RPC.Healthy
+		grpcOptions.Healthy = logger.GRPC.Healthy
+	}
+
+	deps.LoggerRPC = resilience.New("logger-service-rpc", rpcOptions)
+	deps.LoggerGRPC = resilience.New("logger-service-grpc", grpcOptions)
+
+	return deps
+}
+
+func (d *dependencies) all() []*resilience.Dependency {
+	return []*resilience.Dependency{d.Auth, d.Mail, d.LoggerRPC, d.LoggerGRPC}
+}
+
+// DependencyStatus reports the state of the circuit breakers of the services the broker calls.
+func (app *Config) DependencyStatus(w http.ResponseWriter, r *http.Request) {
+	statuses := make([]resilience.Status, 0, 4)
+	for _, dependency := range app.Dependencies.all() {
+		statuses = append(statuses, dependency.Status())
+	}
+
+	app.writeJSON(w, http.StatusOK, data.ResponsePayload{
+		Error:   false,
+		Message: "Dependency status",
+		Data:    statuses,
+	})
+}
+
+// dependencyProblem classifies the error of a call to a dependency: an open breaker or a service
+// that can't be reached is unavailable, a call that ran out of time is a timeout, and anything
+// else is a failure of the service.
+func dependencyProblem(dependency *resilience.Dependency, err error) *problem.Problem {
+	var p *problem.Problem
+	if errors.As(err, &p) {
+		return p
+	}
+
+	var opErr *net.OpError
+
+	switch {
+	case errors.Is(err, resilience.ErrOpen):
+		return problem.Unavailable(dependency.Name(), "the circuit breaker is open")
+	case errors.Is(err, context.DeadlineExceeded), status.Code(err) == codes.DeadlineExceeded:
+		return problem.Timeout(dependency.Name(), "no answer in time")
+	case errors.Is(err, logclient.ErrUnavailable), status.Code(err) == codes.Unavailable:
+		return problem.Unavailable(dependency.Name(), err.Error())
+	case errors.As(err, &opErr) && opErr.Op == "dial":
+		return problem.Unavailable(dependency.Name(), err.Error())
+	case status.Code(err) == codes.InvalidArgument:
+		return problem.Downstream(dependency.Name(), http.StatusBadRequest, status.Convert(err).Message())
+	default:
+		return problem.Upstream(dependency.Name(), err.Error())
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"broker/data"
+	"broker/resilience"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_sendMail_resilience(t *testing.T) {
+	var calls int32
+	var hang int32
+	release := make(chan struct{})
+
+	mailService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if atomic.LoadInt32(&hang) == 1 {
+			<-release
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error":true,"message":"smtp server unreachable"}`))
+	}))
+	defer mailService.Close()
+	defer close(release)
+
+	app := testApp
+	app.MailServiceURL = mailService.URL
+	app.Dependencies = newDependencies(nil)
+	app.Dependencies.Mail = resilience.New("mail-service", resilience.Options{
+		Timeout: 20 * time.Millisecond,
+		Breaker: resilience.BreakerOptions{FailureThreshold: 3, OpenTimeout: time.Minute},
+	})
+	routes := app.routes()
+
+	mailer := "Bearer " + signTestToken(testSigningKey, []string{roleMailer}, time.Minute)
+	send := func() int {
+		req, _ := http.NewRequest(http.MethodPost, "/v1/mail", strings.NewReader(`{"to":"you@there.com"}`))
+		req.Header.Set(string(data.HeaderAuthorization), mailer)
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	// the failure of the mail service is reported as a bad gateway, and the call is not repeated
+	if code := send(); code != http.StatusBadGateway || atomic.LoadInt32(&calls) != 1 {
+		t.Fatalf("expected one call answered with 502 but got %d after %d calls", code, calls)
+	}
+
+	atomic.StoreInt32(&hang, 1)
+	if code := send(); code != http.StatusGatewayTimeout {
+		t.Fatalf("expected a hung mail service to time out with 504 but got %d", code)
+	}
+
+	send()
+	if code := send(); code != http.StatusServiceUnavailable || atomic.LoadInt32(&calls) != 3 {
+		t.Fatalf("expected the open breaker to answer 503 without calling the service but got %d after %d calls", code, calls)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "/status/dependencies", nil)
+	rr := httptest.NewRecorder()
+	routes.ServeHTTP(rr, req)
+
+	var response struct {
+		Data []struct {
+			Name  string `json:"name"`
+			State string `json:"state"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+
+	states := map[string]string{}
+	for _, dependency := range response.Data {
+		states[dependency.Name] = dependency.State
+	}
+
+	if states["mail-service"] != "open" || states["authentication-service"] != "closed" || len(states) != 4 {
+		t.Errorf("expected only the mail service breaker to be open but got %v", states)
+	}
+}
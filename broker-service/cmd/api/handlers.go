@@ -5,21 +5,32 @@ import (
 	"broker/event"
 	eventData "broker/event/data"
 	"broker/logs"
+	"broker/problem"
+	"broker/resilience"
 	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
-	"net/rpc"
+	"strings"
 	"time"
 
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
-// HandleSubmission is the main point of entry into the broker. It accepts a JSON
-// payload and performs an action based on the value of "action" in that JSON.
+// publishTimeout bounds how long a request waits for RabbitMQ to confirm an event, including
+// the time it spends buffered while RabbitMQ is unavailable.
+const publishTimeout = 5 * time.Second
+
+// HandleSubmission is the original point of entry into the broker. It accepts a JSON payload
+// and performs an action based on the value of "action" in that JSON. New clients should use
+// the /v1 routes instead.
 func (app *Config) HandleSubmission(w http.ResponseWriter, r *http.Request) {
 	var requestPayload data.RequestPayload
 
@@ -29,24 +40,99 @@ func (app *Config) HandleSubmission(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !app.authorizeAction(w, r, requestPayload.Action) {
+		return
+	}
+
 	switch requestPayload.Action {
 	case data.Ping:
 		app.ping(w)
 	case data.Auth:
-		app.authenticate(w, requestPayload.Auth)
+		app.authenticate(w, r, requestPayload.Auth)
 	case data.Log:
-		app.logEvent(w, requestPayload.Log)
+		app.logEvent(w, r, requestPayload.Log)
 	case data.LogRPC:
-		app.logItemViaRPC(w, requestPayload.Log)
+		app.logItemViaRPC(w, r, requestPayload.Log)
 	case data.LogGRPC:
-		app.logItemViaGRPC(w, requestPayload.Log)
+		app.logItemViaGRPC(w, r, requestPayload.Log)
 	case data.Mail:
-		app.sendMail(w, requestPayload.Mail)
+		app.sendMail(w, r, requestPayload.Mail)
+	default:
+		app.errorJSON(w, data.ErrUnknownAction)
+	}
+}
+
+// Authenticate checks the credentials of a user with the authentication service.
+func (app *Config) Authenticate(w http.ResponseWriter, r *http.Request) {
+	if !app.authorizeAction(w, r, data.Auth) {
+		return
+	}
+
+	var requestPayload data.AuthPayload
+	if err := app.readJSON(w, r, &requestPayload); err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.authenticate(w, r, requestPayload)
+}
+
+// logTransports maps the transport query parameter of WriteLog to the action that uses it.
+var logTransports = map[string]data.ActionType{
+	"amqp": data.Log,
+	"rpc":  data.LogRPC,
+	"grpc": data.LogGRPC,
+}
+
+// WriteLog writes a log entry with the logger service. The transport query parameter picks
+// how the entry gets there; RabbitMQ is the default.
+func (app *Config) WriteLog(w http.ResponseWriter, r *http.Request) {
+	transport := r.URL.Query().Get("transport")
+	if transport == "" {
+		transport = "amqp"
+	}
+
+	action, ok := logTransports[transport]
+	if !ok {
+		app.errorJSON(w, fmt.Errorf("unknown transport %q", transport))
+		return
+	}
+
+	if !app.authorizeAction(w, r, action) {
+		return
+	}
+
+	var requestPayload data.LogPayload
+	if err := app.readJSON(w, r, &requestPayload); err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	switch action {
+	case data.LogRPC:
+		app.logItemViaRPC(w, r, requestPayload)
+	case data.LogGRPC:
+		app.logItemViaGRPC(w, r, requestPayload)
 	default:
-		app.errorJSON(w, errors.New("unknown action"))
+		app.logEvent(w, r, requestPayload)
 	}
 }
 
+// SendMail sends an email with the mail service.
+func (app *Config) SendMail(w http.ResponseWriter, r *http.Request) {
+	if !app.authorizeAction(w, r, data.Mail) {
+		return
+	}
+
+	var requestPayload data.MailPayload
+	if err := app.readJSON(w, r, &requestPayload); err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.sendMail(w, r, requestPayload)
+}
+
 func (app *Config) ping(w http.ResponseWriter) {
 	payload := data.ResponsePayload{
 		Error:   false,
@@ -56,45 +142,56 @@ func (app *Config) ping(w http.ResponseWriter) {
 	_ = app.writeJSON(w, http.StatusOK, payload)
 }
 
-// authenticate calls the authentication microservice and sends back the appropriate response
-func (app *Config) authenticate(w http.ResponseWriter, requestPayload data.AuthPayload) {
-	responsePayload, err := callExternalService(app.AuthenticationServiceURL, requestPayload)
+// authenticate calls the authentication microservice and sends back the appropriate response.
+// The address of the client is passed along, so failed logins are throttled per client rather
+// than for the broker as a whole. A login starts a refresh token family or a second factor
+// challenge, so it is not retried.
+func (app *Config) authenticate(w http.ResponseWriter, r *http.Request, requestPayload data.AuthPayload) {
+	headers := make(http.Header)
+	headers.Set("X-Forwarded-For", clientIP(r))
+
+	responsePayload, err := callExternalService(r.Context(), app.Dependencies.Auth, false, app.AuthenticationServiceURL, requestPayload, headers)
 	if err != nil {
 		app.errorJSON(w, err)
 		return
 	}
 
-	if responsePayload.Error {
-		app.errorJSON(w, fmt.Errorf("status code %d: %s", responsePayload.StatusCode, responsePayload.Message), http.StatusUnauthorized)
-		return
-	}
-
 	app.writeJSON(w, http.StatusAccepted, responsePayload)
 }
 
-func (app *Config) sendMail(w http.ResponseWriter, requestPayload data.MailPayload) {
-	responsePayload, err := callExternalService(app.MailServiceURL, requestPayload)
+// sendMail calls the mail microservice. Sending is not retried, so an email is never sent twice.
+func (app *Config) sendMail(w http.ResponseWriter, r *http.Request, requestPayload data.MailPayload) {
+	responsePayload, err := callExternalService(r.Context(), app.Dependencies.Mail, false, app.MailServiceURL, requestPayload)
 	if err != nil {
 		app.errorJSON(w, err)
 		return
 	}
 
-	if responsePayload.Error {
-		app.errorJSON(w, fmt.Errorf("status code %d: %s", responsePayload.StatusCode, responsePayload.Message), http.StatusUnauthorized)
-		return
-	}
-
 	app.writeJSON(w, http.StatusAccepted, responsePayload)
 }
 
-// logEvent logs an event using the logger-service. It makes the call by pushing the data to RabbitMQ.
-func (app *Config) logEvent(w http.ResponseWriter, logPayload data.LogPayload) {
-	err := app.pushToQueue(logPayload.Name, logPayload.Data)
-	if err != nil {
+// logEvent logs an event using the logger-service. It makes the call by pushing the data to
+// RabbitMQ and only reports success once RabbitMQ confirmed the event.
+func (app *Config) logEvent(w http.ResponseWriter, r *http.Request, logPayload data.LogPayload) {
+	if err := completeLog(r.Context(), &logPayload); err != nil {
 		app.errorJSON(w, err)
 		return
 	}
 
+	err := app.pushToQueue(r.Context(), logPayload)
+	if err != nil {
+		switch {
+		case errors.Is(err, context.DeadlineExceeded):
+			app.errorJSON(w, problem.Timeout("rabbitmq", "timed out waiting for RabbitMQ to confirm the event"))
+		case errors.Is(err, event.ErrBufferFull), errors.Is(err, event.ErrUnroutable),
+			errors.Is(err, event.ErrNacked), errors.Is(err, event.ErrPublisherClosed):
+			app.errorJSON(w, problem.Unavailable("rabbitmq", err.Error()))
+		default:
+			app.errorJSON(w, err, http.StatusInternalServerError)
+		}
+		return
+	}
+
 	var responsePayload data.ResponsePayload
 	responsePayload.Error = false
 	responsePayload.Message = "The event info is sent to the queue."
@@ -103,49 +200,89 @@ func (app *Config) logEvent(w http.ResponseWriter, logPayload data.LogPayload) {
 	app.writeJSON(w, http.StatusAccepted, responsePayload)
 }
 
-// pushToQueue pushes a message into RabbitMQ
-func (app *Config) pushToQueue(name, msg string) error {
-	emitter, err := event.NewEventEmitter(app.Rabbit)
-	if err != nil {
-		return err
-	}
+// routingKeys maps the severity of a log entry to the routing key of its event.
+var routingKeys = map[data.Severity]eventData.EventSeverity{
+	data.SeverityDebug:   eventData.SeverityDebug,
+	data.SeverityInfo:    eventData.SeverityInfo,
+	data.SeverityWarning: eventData.SeverityWarning,
+	data.SeverityError:   eventData.SeverityError,
+}
 
-	payload := data.LogPayload{
-		Name: name,
-		Data: msg,
-	}
+// pushToQueue publishes a log entry to RabbitMQ, routed by its severity, and waits for it to be
+// confirmed.
+func (app *Config) pushToQueue(ctx context.Context, payload data.LogPayload) error {
+	ctx, cancel := context.WithTimeout(ctx, publishTimeout)
+	defer cancel()
 
 	eventPayload, _ := json.MarshalIndent(&payload, "", "\t")
-	err = emitter.Push(string(eventPayload), string(eventData.SeverityLog))
+	return app.Publisher.Publish(ctx, string(routingKeys[data.Severity(payload.Severity)]), eventPayload)
+}
+
+// completeLog checks the severity of a log entry, which it replaces by its canonical name, and
+// attributes the entry to the caller when it names no user.
+func completeLog(ctx context.Context, payload *data.LogPayload) error {
+	severity, err := data.ParseSeverity(payload.Severity)
 	if err != nil {
 		return err
 	}
+	payload.Severity = string(severity)
+
+	if payload.UserID == "" {
+		if principal, ok := principalFromContext(ctx); ok {
+			payload.UserID = principal.Subject
+		}
+	}
+
 	return nil
 }
 
-func (app *Config) logItemViaGRPC(w http.ResponseWriter, requestPayload data.LogPayload) {
-	gRPCaddress := fmt.Sprintf("%s:%s", app.LogServiceAddress, app.LogServiceGRPCPort)
-	client, err := grpc.Dial(gRPCaddress, grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithBlock())
-	if err != nil {
-		app.errorJSON(w, err)
-		return
+// rpcPayloadFor converts a log entry to the argument of the RPC and gRPC calls.
+func rpcPayloadFor(payload data.LogPayload) data.RPCPayload {
+	rpcPayload := data.RPCPayload{
+		Name:       payload.Name,
+		Data:       payload.Data,
+		Severity:   payload.Severity,
+		Service:    payload.Service,
+		TraceID:    payload.TraceID,
+		UserID:     payload.UserID,
+		Attributes: payload.Attributes.Typed(),
+	}
+	if payload.Timestamp != nil {
+		rpcPayload.Timestamp = *payload.Timestamp
 	}
-	defer client.Close()
 
-	gRPCPayload := data.RPCPayload(requestPayload)
+	return rpcPayload
+}
 
-	conn := logs.NewLogServiceClient(client)
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
-	defer cancel()
+// logItemViaGRPC writes a log entry over the shared gRPC connection to the logger service.
+func (app *Config) logItemViaGRPC(w http.ResponseWriter, r *http.Request, requestPayload data.LogPayload) {
+	if err := completeLog(r.Context(), &requestPayload); err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+	gRPCPayload := rpcPayloadFor(requestPayload)
+
+	entry := &logs.Log{
+		Name:       gRPCPayload.Name,
+		Data:       gRPCPayload.Data,
+		Severity:   logs.Severity(logs.Severity_value[strings.ToUpper(gRPCPayload.Severity)]),
+		Service:    gRPCPayload.Service,
+		TraceId:    gRPCPayload.TraceID,
+		UserId:     gRPCPayload.UserID,
+		Attributes: toProtoAttributes(gRPCPayload.Attributes),
+	}
+	if !gRPCPayload.Timestamp.IsZero() {
+		entry.Timestamp = timestamppb.New(gRPCPayload.Timestamp)
+	}
 
-	logResponse, err := conn.WriteLog(ctx, &logs.LogRequest{
-		LogEntry: &logs.Log{
-			Name: gRPCPayload.Name,
-			Data: gRPCPayload.Data,
-		},
+	var logResponse *logs.LogResponse
+	err := app.Dependencies.LoggerGRPC.Call(r.Context(), func(ctx context.Context) error {
+		var err error
+		logResponse, err = app.Logger.GRPC.WriteLog(ctx, &logs.LogRequest{LogEntry: entry})
+		return err
 	})
 	if err != nil {
-		app.errorJSON(w, err)
+		app.errorJSON(w, dependencyProblem(app.Dependencies.LoggerGRPC, err))
 		return
 	}
 
@@ -158,20 +295,23 @@ func (app *Config) logItemViaGRPC(w http.ResponseWriter, requestPayload data.Log
 	app.writeJSON(w, http.StatusAccepted, payload)
 }
 
-func (app *Config) logItemViaRPC(w http.ResponseWriter, requestPayload data.LogPayload) {
-	RPCaddress := fmt.Sprintf("%s:%s", app.LogServiceAddress, app.LogServiceRPCPort)
-	client, err := rpc.Dial("tcp", RPCaddress)
-	if err != nil {
+// logItemViaRPC writes a log entry over one of the pooled RPC connections to the logger service.
+func (app *Config) logItemViaRPC(w http.ResponseWriter, r *http.Request, requestPayload data.LogPayload) {
+	if err := completeLog(r.Context(), &requestPayload); err != nil {
 		app.errorJSON(w, err)
 		return
 	}
 
-	rpcPayload := data.RPCPayload(requestPayload)
+	rpcPayload := rpcPayloadFor(requestPayload)
+	rpcPayload.Trace = map[string]string{}
+	otel.GetTextMapPropagator().Inject(r.Context(), propagation.MapCarrier(rpcPayload.Trace))
 
 	var result string
-	err = client.Call("RPCServer.LogInfo", rpcPayload, &result)
+	err := app.Dependencies.LoggerRPC.Call(r.Context(), func(ctx context.Context) error {
+		return app.Logger.RPC.Call(ctx, "RPCServer.LogInfo", rpcPayload, &result)
+	})
 	if err != nil {
-		app.errorJSON(w, err)
+		app.errorJSON(w, dependencyProblem(app.Dependencies.LoggerRPC, err))
 		return
 	}
 
@@ -184,32 +324,133 @@ func (app *Config) logItemViaRPC(w http.ResponseWriter, requestPayload data.LogP
 	app.writeJSON(w, http.StatusAccepted, payload)
 }
 
-// util func to send post request with json payload
-func callExternalService(url string, requestPayload interface{}) (data.ResponsePayload, error) {
-	jsonData, err := json.MarshalIndent(requestPayload, "", "\t")
-	if err != nil {
-		return data.ResponsePayload{}, err
+// toProtoAttributes converts the attributes of a log entry to their protobuf values.
+func toProtoAttributes(attrs map[string]interface{}) map[string]*logs.AttributeValue {
+	if len(attrs) == 0 {
+		return nil
 	}
 
-	request, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return data.ResponsePayload{}, err
+	out := make(map[string]*logs.AttributeValue, len(attrs))
+	for key, value := range attrs {
+		switch v := value.(type) {
+		case string:
+			out[key] = &logs.AttributeValue{Value: &logs.AttributeValue_StringValue{StringValue: v}}
+		case int64:
+			out[key] = &logs.AttributeValue{Value: &logs.AttributeValue_IntValue{IntValue: v}}
+		case float64:
+			out[key] = &logs.AttributeValue{Value: &logs.AttributeValue_DoubleValue{DoubleValue: v}}
+		case bool:
+			out[key] = &logs.AttributeValue{Value: &logs.AttributeValue_BoolValue{BoolValue: v}}
+		}
 	}
 
-	client := &http.Client{}
-	response, err := client.Do(request)
+	return out
+}
+
+// externalClient is shared by all calls to other services; each call is bounded by the timeout
+// of its dependency. Its transport passes the trace context on in the request headers.
+var externalClient = &http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport)}
+
+// maxResponseBytes limits how much of a response from another service is read.
+const maxResponseBytes = 1 << 20
+
+// callExternalService sends a post request with a json payload to a dependency. Calls marked
+// idempotent are retried. Any response outside 2xx is returned as a problem that keeps the
+// status class of the service: its client errors are passed on, its failures and responses
+// that aren't JSON become upstream errors.
+func callExternalService(ctx context.Context, dependency *resilience.Dependency, idempotent bool, url string, requestPayload interface{}, headers ...http.Header) (data.ResponsePayload, error) {
+	jsonData, err := json.MarshalIndent(requestPayload, "", "\t")
 	if err != nil {
 		return data.ResponsePayload{}, err
 	}
-	defer response.Body.Close()
 
 	var responsePayload data.ResponsePayload
-	err = json.NewDecoder(response.Body).Decode(&responsePayload)
-	if err != nil {
-		return data.ResponsePayload{}, err
+	// rejected holds a client error of the service; it is not a failure of the service
+	var rejected *problem.Problem
+
+	attempt := func(ctx context.Context) error {
+		responsePayload = data.ResponsePayload{}
+		rejected = nil
+
+		request, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(jsonData))
+		if err != nil {
+			return err
+		}
+
+		request.Header.Set(string(data.HeaderContentType), string(data.ContentTypeJSON))
+		if len(headers) > 0 {
+			for key, value := range headers[0] {
+				request.Header[key] = value
+			}
+		}
+
+		response, err := externalClient.Do(request)
+		if err != nil {
+			return err
+		}
+		defer response.Body.Close()
+
+		body, err := io.ReadAll(io.LimitReader(response.Body, maxResponseBytes))
+		if err != nil {
+			return err
+		}
+
+		decodeErr := json.Unmarshal(body, &responsePayload)
+		responsePayload.StatusCode = response.StatusCode
+
+		switch {
+		case response.StatusCode >= 400 && response.StatusCode < 500:
+			rejected = problem.Downstream(dependency.Name(), response.StatusCode, downstreamDetail(response, body, responsePayload, decodeErr))
+			return nil
+		case response.StatusCode < 200 || response.StatusCode >= 300:
+			return problem.Downstream(dependency.Name(), response.StatusCode, downstreamDetail(response, body, responsePayload, decodeErr))
+		case decodeErr != nil:
+			return problem.Upstream(dependency.Name(), fmt.Sprintf("invalid response: %v", decodeErr))
+		}
+
+		return nil
+	}
+
+	if idempotent {
+		err = dependency.CallIdempotent(ctx, attempt)
+	} else {
+		err = dependency.Call(ctx, attempt)
 	}
 
-	responsePayload.StatusCode = response.StatusCode
+	switch {
+	case err != nil:
+		return data.ResponsePayload{}, dependencyProblem(dependency, err)
+	case rejected != nil:
+		return data.ResponsePayload{}, rejected
+	}
 
 	return responsePayload, nil
 }
+
+// downstreamDetail describes an error response of another service: its message if it sent the
+// usual JSON envelope, a plain text body as is, and the status text otherwise.
+func downstreamDetail(response *http.Response, body []byte, payload data.ResponsePayload, decodeErr error) string {
+	if decodeErr == nil && payload.Message != "" {
+		return payload.Message
+	}
+
+	text := strings.TrimSpace(string(body))
+	if strings.HasPrefix(response.Header.Get(string(data.HeaderContentType)), string(data.ContentTypeText)) && text != "" {
+		if len(text) > 200 {
+			text = text[:200] + "..."
+		}
+		return text
+	}
+
+	return http.StatusText(response.StatusCode)
+}
+
+// clientIP returns the address of the client that sent r, without the port
+func clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return ip
+}
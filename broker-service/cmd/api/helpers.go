@@ -2,63 +2,26 @@ package main
 
 import (
 	"broker/data"
+	"broker/problem"
 	"encoding/json"
 	"errors"
 	"io"
 	"net/http"
 )
 
-// readJSON tries to read the body of a request and converts it into JSON
-func (app *Config) readJSON(w http.ResponseWriter, r *http.Request, requestPayload any) error {
+// readJSON tries to read the body of a request and converts it from JSON into payload
+func (app *Config) readJSON(w http.ResponseWriter, r *http.Request, payload any) error {
 	maxBytes := 1048576 // one megabyte
 
 	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
 
 	dec := json.NewDecoder(r.Body)
 
-	// Use a temporary struct to decode the request payload
-	var temp struct {
-		Action string           `json:"action"`
-		Auth   data.AuthPayload `json:"auth,omitempty"`
-		Log    data.LogPayload  `json:"log,omitempty"`
-		Mail   data.MailPayload `json:"mail,omitempty"`
-	}
-
-	err := dec.Decode(&temp)
+	err := dec.Decode(payload)
 	if err != nil {
 		return err
 	}
 
-	// Convert the string action to an enum value
-	var action data.ActionType
-	switch temp.Action {
-	case "ping":
-		action = data.Ping
-	case "auth":
-		action = data.Auth
-	case "log":
-		action = data.Log
-	case "logRPC":
-		action = data.LogRPC
-	case "logGRPC":
-		action = data.LogGRPC
-	case "mail":
-		action = data.Mail
-	default:
-		return errors.New("unknown action")
-	}
-
-	// Copy the decoded values to the output parameter
-	switch payload := requestPayload.(type) {
-	case *data.RequestPayload:
-		payload.Action = action
-		payload.Auth = temp.Auth
-		payload.Log = temp.Log
-		payload.Mail = temp.Mail
-	default:
-		return errors.New("unsupported payload type")
-	}
-
 	err = dec.Decode(&struct{}{})
 	if err != io.EOF {
 		return errors.New("body must have only a single JSON value")
@@ -90,18 +53,29 @@ func (app *Config) writeJSON(w http.ResponseWriter, status int, payload any, hea
 	return nil
 }
 
-// errorJSON takes an error, and optionally a response status code, and generates and sends
-// a json error response
+// errorJSON takes an error, and optionally a response status code, and sends it as a problem
+// details response. A *problem.Problem is sent as is; any other error gets the given status,
+// 400 Bad Request by default.
 func (app *Config) errorJSON(w http.ResponseWriter, err error, status ...int) error {
-	statusCode := http.StatusBadRequest
+	var p *problem.Problem
+	if !errors.As(err, &p) {
+		statusCode := http.StatusBadRequest
 
-	if len(status) > 0 {
-		statusCode = status[0]
+		if len(status) > 0 {
+			statusCode = status[0]
+		}
+
+		p = problem.New(statusCode, err.Error())
+	}
+
+	out, err := json.Marshal(p)
+	if err != nil {
+		return err
 	}
 
-	var payload data.ResponsePayload
-	payload.Error = true
-	payload.Message = err.Error()
+	w.Header().Set(string(data.HeaderContentType), problem.ContentType)
+	w.WriteHeader(p.Status)
+	_, err = w.Write(out)
 
-	return app.writeJSON(w, statusCode, payload)
+	return err
 }
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// realIP replaces the remote address of requests that come from one of the trusted proxies with
+// the address of the client the proxy forwarded. The headers of other requests are ignored, so
+// clients can't choose the address the authentication service throttles their logins by.
+func realIP(proxies []string) func(http.Handler) http.Handler {
+	// the settings were validated, so the proxies parse
+	trusted, _ := parseNetworks(proxies)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if ip := forwardedClient(r, trusted); ip != "" {
+				r.RemoteAddr = ip
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// forwardedClient returns the client address forwarded by a trusted proxy, or "" when r didn't
+// come from one. X-Forwarded-For is read from the right, skipping the trusted proxies, as the
+// addresses to their left could have been sent by the client.
+func forwardedClient(r *http.Request, trusted []*net.IPNet) string {
+	if !isTrusted(net.ParseIP(clientIP(r)), trusted) {
+		return ""
+	}
+
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		hops := strings.Split(forwarded, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			ip := net.ParseIP(strings.TrimSpace(hops[i]))
+			if ip == nil {
+				return ""
+			}
+			if i == 0 || !isTrusted(ip, trusted) {
+				return ip.String()
+			}
+		}
+	}
+
+	if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+		return ip.String()
+	}
+
+	return ""
+}
+
+func isTrusted(ip net.IP, trusted []*net.IPNet) bool {
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
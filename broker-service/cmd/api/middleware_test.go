@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_realIP(t *testing.T) {
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		realIP     string
+		expected   string
+	}{
+		{"direct client", "203.0.113.7:4000", "", "", "203.0.113.7"},
+		{"spoofed by a client", "203.0.113.7:4000", "198.51.100.1", "198.51.100.2", "203.0.113.7"},
+		{"forwarded by the ingress", "10.0.0.5:4000", "198.51.100.1", "", "198.51.100.1"},
+		{"spoofed through the ingress", "10.0.0.5:4000", "198.51.100.9, 198.51.100.1", "", "198.51.100.1"},
+		{"real ip from the ingress", "10.0.0.5:4000", "", "198.51.100.2", "198.51.100.2"},
+		{"garbage from the ingress", "10.0.0.5:4000", "not-an-ip", "", "10.0.0.5"},
+	}
+
+	// clientIP is what the authentication service is sent in X-Forwarded-For
+	handler := realIP([]string{"10.0.0.0/8"})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(clientIP(r)))
+	}))
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = tt.remoteAddr
+		if tt.forwarded != "" {
+			req.Header.Set("X-Forwarded-For", tt.forwarded)
+		}
+		if tt.realIP != "" {
+			req.Header.Set("X-Real-IP", tt.realIP)
+		}
+		rr := httptest.NewRecorder()
+
+		handler.ServeHTTP(rr, req)
+
+		if got := rr.Body.String(); got != tt.expected {
+			t.Errorf("%s: expected client %s but got %s", tt.name, tt.expected, got)
+		}
+	}
+}
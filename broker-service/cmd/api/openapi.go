@@ -0,0 +1,244 @@
+package main
+
+import (
+	"broker/data"
+	"broker/problem"
+	"fmt"
+	"net/http"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	actionTypeType = reflect.TypeOf(data.ActionType(0))
+	timeType       = reflect.TypeOf(time.Time{})
+)
+
+// OpenAPI serves an OpenAPI document describing the endpoints of the broker.
+func (app *Config) OpenAPI(w http.ResponseWriter, r *http.Request) {
+	app.writeJSON(w, http.StatusOK, openAPIDocument(app.endpoints()))
+}
+
+// openAPIDocument builds an OpenAPI 3 document from the endpoints. Request schemas are derived
+// from the payload types and operation IDs from the handler names.
+func openAPIDocument(endpoints []endpoint) map[string]any {
+	schemas := map[string]any{}
+	paths := map[string]any{}
+
+	responseSchema := schemaFor(reflect.TypeOf(data.ResponsePayload{}), schemas)
+	problemSchema := schemaFor(reflect.TypeOf(problem.Problem{}), schemas)
+
+	for _, e := range endpoints {
+		operation := map[string]any{
+			"operationId": handlerName(e.Handler),
+			"summary":     e.Summary,
+			"responses":   responses(e.Responses, responseSchema, problemSchema),
+		}
+
+		if e.Deprecated {
+			operation["deprecated"] = true
+		}
+
+		if e.Request != nil {
+			operation["requestBody"] = map[string]any{
+				"required": true,
+				"content": map[string]any{
+					string(data.ContentTypeJSON): map[string]any{
+						"schema": schemaFor(reflect.TypeOf(e.Request), schemas),
+					},
+				},
+			}
+		}
+
+		if len(e.Query) > 0 {
+			operation["parameters"] = queryParameters(e.Query)
+		}
+
+		security, description := securityRequirements(e.Actions)
+		if security != nil {
+			operation["security"] = security
+		}
+		if description != "" {
+			operation["description"] = description
+		}
+
+		item, ok := paths[e.Path].(map[string]any)
+		if !ok {
+			item = map[string]any{}
+			paths[e.Path] = item
+		}
+		item[strings.ToLower(e.Method)] = operation
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "Broker Service",
+			"version": "1.0.0",
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": schemas,
+			"securitySchemes": map[string]any{
+				"bearerAuth": map[string]any{
+					"type":         "http",
+					"scheme":       "bearer",
+					"bearerFormat": "JWT",
+				},
+			},
+		},
+	}
+}
+
+// responses describes the responses of an operation. Errors are problem details.
+func responses(descriptions map[int]string, success, failure map[string]any) map[string]any {
+	out := map[string]any{}
+	for status, description := range descriptions {
+		contentType, schema := string(data.ContentTypeJSON), success
+		if status >= http.StatusBadRequest {
+			contentType, schema = problem.ContentType, failure
+		}
+
+		out[strconv.Itoa(status)] = map[string]any{
+			"description": description,
+			"content": map[string]any{
+				contentType: map[string]any{"schema": schema},
+			},
+		}
+	}
+
+	return out
+}
+
+func queryParameters(params []queryParameter) []any {
+	out := make([]any, 0, len(params))
+	for _, p := range params {
+		schema := map[string]any{"type": "string"}
+		if len(p.Enum) > 0 {
+			schema["enum"] = p.Enum
+		}
+		if p.Default != "" {
+			schema["default"] = p.Default
+		}
+
+		out = append(out, map[string]any{
+			"name":        p.Name,
+			"in":          "query",
+			"description": p.Description,
+			"schema":      schema,
+		})
+	}
+
+	return out
+}
+
+// securityRequirements derives the security of an operation from the policies of its actions,
+// and describes the roles they require.
+func securityRequirements(actions []data.ActionType) ([]any, string) {
+	public, protected := 0, 0
+	var lines []string
+
+	for _, action := range actions {
+		policy := actionPolicies[action]
+		if policy.Public {
+			public++
+			continue
+		}
+
+		protected++
+		if len(policy.Roles) > 0 {
+			lines = append(lines, fmt.Sprintf("The %s action requires one of the roles %s.", action, strings.Join(policy.Roles, ", ")))
+		}
+	}
+
+	bearer := map[string]any{"bearerAuth": []string{}}
+
+	switch {
+	case protected == 0:
+		return nil, strings.Join(lines, " ")
+	case public == 0:
+		return []any{bearer}, strings.Join(lines, " ")
+	default:
+		// the token is only needed for some of the actions
+		return []any{map[string]any{}, bearer}, strings.Join(lines, " ")
+	}
+}
+
+// schemaFor returns the JSON schema of t. Structs are added to schemas and referenced by name.
+func schemaFor(t reflect.Type, schemas map[string]any) map[string]any {
+	if t == actionTypeType {
+		return map[string]any{
+			"type":        "string",
+			"enum":        data.ActionNames(),
+			"description": "The name of the action. Older clients may still send its number.",
+		}
+	}
+	if t == timeType {
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return schemaFor(t.Elem(), schemas)
+	case reflect.Struct:
+		if _, ok := schemas[t.Name()]; !ok {
+			// reserve the name first, so recursive types terminate
+			schemas[t.Name()] = nil
+
+			properties := map[string]any{}
+			for i := 0; i < t.NumField(); i++ {
+				field := t.Field(i)
+				if !field.IsExported() {
+					continue
+				}
+
+				name := field.Name
+				if tag, _, _ := strings.Cut(field.Tag.Get("json"), ","); tag == "-" {
+					continue
+				} else if tag != "" {
+					name = tag
+				}
+
+				properties[name] = schemaFor(field.Type, schemas)
+			}
+
+			schemas[t.Name()] = map[string]any{
+				"type":       "object",
+				"properties": properties,
+			}
+		}
+
+		return map[string]any{"$ref": "#/components/schemas/" + t.Name()}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": schemaFor(t.Elem(), schemas)}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaFor(t.Elem(), schemas)}
+	default:
+		// interface values can hold anything
+		return map[string]any{}
+	}
+}
+
+// handlerName returns the name of the method behind a handler, such as "SendMail".
+func handlerName(handler http.HandlerFunc) string {
+	name := runtime.FuncForPC(reflect.ValueOf(handler).Pointer()).Name()
+	name = strings.TrimSuffix(name, "-fm")
+
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		name = name[i+1:]
+	}
+
+	return name
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"broker/data"
+	"broker/problem"
+	"broker/resilience"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// standIn answers like a downstream service would.
+type standIn struct {
+	status      int
+	contentType string
+	body        string
+	hang        bool
+}
+
+func (s standIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.hang {
+		// longer than the timeout of the dependency, short enough not to hold up Close
+		time.Sleep(200 * time.Millisecond)
+		return
+	}
+
+	w.Header().Set(string(data.HeaderContentType), s.contentType)
+	w.WriteHeader(s.status)
+	w.Write([]byte(s.body))
+}
+
+func Test_downstreamProblems(t *testing.T) {
+	mailer := "Bearer " + signTestToken(testSigningKey, []string{roleMailer}, time.Minute)
+	jsonType := string(data.ContentTypeJSON)
+	textType := string(data.ContentTypeText)
+
+	tests := []struct {
+		name           string
+		url            string
+		header         string
+		downstream     standIn
+		closed         bool
+		expectedStatus int
+		expectedClass  problem.Class
+		expectedDetail string
+		upstreamStatus int
+	}{
+		{"auth accepted", "/v1/auth", "", standIn{status: http.StatusAccepted, contentType: jsonType, body: `{"error":false,"message":"Logged in"}`}, false, http.StatusAccepted, "", "", 0},
+		{"auth invalid credentials", "/v1/auth", "", standIn{status: http.StatusUnauthorized, contentType: jsonType, body: `{"error":true,"message":"invalid credentials"}`}, false, http.StatusUnauthorized, problem.ClassClient, "invalid credentials", http.StatusUnauthorized},
+		{"auth throttled", "/v1/auth", "", standIn{status: http.StatusTooManyRequests, contentType: jsonType, body: `{"error":true,"message":"too many attempts"}`}, false, http.StatusTooManyRequests, problem.ClassClient, "too many attempts", http.StatusTooManyRequests},
+		{"auth failing", "/v1/auth", "", standIn{status: http.StatusInternalServerError, contentType: jsonType, body: `{"error":true,"message":"database is down"}`}, false, http.StatusBadGateway, problem.ClassUpstream, "database is down", http.StatusInternalServerError},
+		{"auth behind a proxy page", "/v1/auth", "", standIn{status: http.StatusServiceUnavailable, contentType: "text/html", body: `<html>maintenance</html>`}, false, http.StatusServiceUnavailable, problem.ClassUnavailable, "Service Unavailable", http.StatusServiceUnavailable},
+		{"auth not running", "/v1/auth", "", standIn{}, true, http.StatusServiceUnavailable, problem.ClassUnavailable, "", 0},
+		{"mail sent", "/v1/mail", mailer, standIn{status: http.StatusAccepted, contentType: jsonType, body: `{"error":false,"message":"sent"}`}, false, http.StatusAccepted, "", "", 0},
+		{"mail invalid address", "/v1/mail", mailer, standIn{status: http.StatusUnprocessableEntity, contentType: jsonType, body: `{"error":true,"message":"invalid recipient"}`}, false, http.StatusUnprocessableEntity, problem.ClassClient, "invalid recipient", http.StatusUnprocessableEntity},
+		{"mail plain text failure", "/v1/mail", mailer, standIn{status: http.StatusInternalServerError, contentType: textType, body: "smtp server unreachable\n"}, false, http.StatusBadGateway, problem.ClassUpstream, "smtp server unreachable", http.StatusInternalServerError},
+		{"mail success without json", "/v1/mail", mailer, standIn{status: http.StatusOK, contentType: textType, body: "ok"}, false, http.StatusBadGateway, problem.ClassUpstream, "", 0},
+		{"mail hangs", "/v1/mail", mailer, standIn{hang: true}, false, http.StatusGatewayTimeout, problem.ClassTimeout, "no answer in time", 0},
+	}
+
+	for _, tt := range tests {
+		server := httptest.NewServer(tt.downstream)
+		if tt.closed {
+			server.Close()
+		}
+
+		app := testApp
+		app.AuthenticationServiceURL = server.URL
+		app.MailServiceURL = server.URL
+		app.Dependencies = &dependencies{
+			Auth: resilience.New("authentication-service", resilience.Options{
+				Timeout:    50 * time.Millisecond,
+				MinBackoff: time.Millisecond,
+				MaxBackoff: time.Millisecond,
+			}),
+			Mail: resilience.New("mail-service", resilience.Options{Timeout: 50 * time.Millisecond}),
+		}
+
+		req, _ := http.NewRequest(http.MethodPost, tt.url, strings.NewReader(`{}`))
+		if tt.header != "" {
+			req.Header.Set(string(data.HeaderAuthorization), tt.header)
+		}
+		rr := httptest.NewRecorder()
+
+		app.routes().ServeHTTP(rr, req)
+		server.Close()
+
+		if rr.Code != tt.expectedStatus {
+			t.Errorf("%s: expected status %d but got %d: %s", tt.name, tt.expectedStatus, rr.Code, rr.Body.String())
+			continue
+		}
+
+		if tt.expectedClass == "" {
+			continue
+		}
+
+		if contentType := rr.Header().Get(string(data.HeaderContentType)); contentType != problem.ContentType {
+			t.Errorf("%s: expected a problem response but got %s", tt.name, contentType)
+		}
+
+		var p struct {
+			problem.Problem
+			Error bool `json:"error"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &p); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+
+		if p.Class != tt.expectedClass || p.Status != tt.expectedStatus || !p.Error {
+			t.Errorf("%s: expected a %s problem with status %d but got %+v", tt.name, tt.expectedClass, tt.expectedStatus, p)
+		}
+
+		if tt.expectedDetail != "" && p.Detail != tt.expectedDetail {
+			t.Errorf("%s: expected detail %q but got %q", tt.name, tt.expectedDetail, p.Detail)
+		}
+
+		if p.UpstreamStatus != tt.upstreamStatus {
+			t.Errorf("%s: expected upstream status %d but got %d", tt.name, tt.upstreamStatus, p.UpstreamStatus)
+		}
+	}
+}
+
+func Test_errorJSON(t *testing.T) {
+	tests := []struct {
+		name           string
+		status         []int
+		expectedStatus int
+		expectedClass  problem.Class
+	}{
+		{"default", nil, http.StatusBadRequest, problem.ClassClient},
+		{"forbidden", []int{http.StatusForbidden}, http.StatusForbidden, problem.ClassClient},
+		{"internal", []int{http.StatusInternalServerError}, http.StatusInternalServerError, problem.ClassServer},
+	}
+
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		testApp.errorJSON(rr, data.ErrUnknownAction, tt.status...)
+
+		var p problem.Problem
+		_ = json.Unmarshal(rr.Body.Bytes(), &p)
+
+		if rr.Code != tt.expectedStatus || p.Status != tt.expectedStatus || p.Class != tt.expectedClass || p.Title != http.StatusText(tt.expectedStatus) {
+			t.Errorf("%s: expected a %s problem with status %d but got %d %+v", tt.name, tt.expectedClass, tt.expectedStatus, rr.Code, p)
+		}
+	}
+}
+
+var jsonUnmarshal = json.Unmarshal
@@ -1,6 +1,9 @@
 package main
 
 import (
+	"broker/data"
+	"broker/metrics"
+	"broker/tracing"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
@@ -8,6 +11,30 @@ import (
 	"github.com/go-chi/cors"
 )
 
+// endpoint describes a route of the broker API. The router and the OpenAPI document are both
+// built from the same list, so the document always matches what is served.
+type endpoint struct {
+	Method     string
+	Path       string
+	Summary    string
+	Deprecated bool
+	// Actions are the actions the endpoint can perform. Their access policies decide the
+	// security requirements in the document; the handler enforces them.
+	Actions []data.ActionType
+	Query   []queryParameter
+	// Request is a value of the type the request body is decoded into.
+	Request   any
+	Responses map[int]string
+	Handler   http.HandlerFunc
+}
+
+type queryParameter struct {
+	Name        string
+	Description string
+	Enum        []string
+	Default     string
+}
+
 func (app *Config) routes() http.Handler {
 	mux := chi.NewRouter()
 
@@ -22,8 +49,109 @@ func (app *Config) routes() http.Handler {
 	}))
 
 	mux.Use(middleware.Heartbeat("/ping"))
+	mux.Use(realIP(app.TrustedProxies))
+	mux.Use(tracing.Middleware)
+	mux.Use(metrics.Middleware)
 
-	mux.Post("/handle", app.HandleSubmission)
+	mux.Get("/v1/openapi.json", app.OpenAPI)
+	mux.Handle("/metrics", metrics.Handler())
+	mux.Get("/livez", app.Health.Livez)
+	mux.Get("/readyz", app.Health.Readyz)
+
+	mux.Group(func(mux chi.Router) {
+		mux.Use(app.authenticateToken)
+
+		for _, e := range app.endpoints() {
+			mux.Method(e.Method, e.Path, e.Handler)
+		}
+	})
 
 	return mux
 }
+
+func (app *Config) endpoints() []endpoint {
+	return []endpoint{
+		{
+			Method:     http.MethodPost,
+			Path:       "/handle",
+			Summary:    "Perform the action named in the payload. Kept for older clients; use the /v1 routes instead.",
+			Deprecated: true,
+			Actions:    []data.ActionType{data.Ping, data.Auth, data.Log, data.LogRPC, data.LogGRPC, data.Mail},
+			Request:    data.RequestPayload{},
+			Responses: map[int]string{
+				http.StatusOK:                 "The broker is reachable (ping)",
+				http.StatusAccepted:           "The action was performed",
+				http.StatusBadRequest:         "The payload is invalid or the action failed",
+				http.StatusUnauthorized:       "The action requires a valid access token",
+				http.StatusForbidden:          "The caller lacks a role the action requires",
+				http.StatusServiceUnavailable: "RabbitMQ did not accept the event, or the circuit breaker of the service is open",
+				http.StatusGatewayTimeout:     "RabbitMQ or the service did not answer in time",
+			},
+			Handler: app.HandleSubmission,
+		},
+		{
+			Method:  http.MethodPost,
+			Path:    "/v1/auth",
+			Summary: "Check the credentials of a user",
+			Actions: []data.ActionType{data.Auth},
+			Request: data.AuthPayload{},
+			Responses: map[int]string{
+				http.StatusAccepted:           "The credentials are valid",
+				http.StatusBadRequest:         "The payload is invalid or the authentication service failed",
+				http.StatusUnauthorized:       "The credentials are invalid",
+				http.StatusServiceUnavailable: "The circuit breaker of the authentication service is open",
+				http.StatusGatewayTimeout:     "The authentication service did not answer in time",
+			},
+			Handler: app.Authenticate,
+		},
+		{
+			Method:  http.MethodPost,
+			Path:    "/v1/logs",
+			Summary: "Write a log entry",
+			Actions: []data.ActionType{data.Log, data.LogRPC, data.LogGRPC},
+			Query: []queryParameter{
+				{
+					Name:        "transport",
+					Description: "How the entry is sent to the logger service",
+					Enum:        []string{"amqp", "rpc", "grpc"},
+					Default:     "amqp",
+				},
+			},
+			Request: data.LogPayload{},
+			Responses: map[int]string{
+				http.StatusAccepted:           "The entry was written or, over amqp, confirmed by RabbitMQ",
+				http.StatusBadRequest:         "The payload or the transport is invalid, or the logger service failed",
+				http.StatusUnauthorized:       "A valid access token is required",
+				http.StatusForbidden:          "The caller lacks a required role",
+				http.StatusServiceUnavailable: "RabbitMQ did not accept the event, or the circuit breaker of the logger service is open",
+				http.StatusGatewayTimeout:     "RabbitMQ or the logger service did not answer in time",
+			},
+			Handler: app.WriteLog,
+		},
+		{
+			Method:  http.MethodPost,
+			Path:    "/v1/mail",
+			Summary: "Send an email",
+			Actions: []data.ActionType{data.Mail},
+			Request: data.MailPayload{},
+			Responses: map[int]string{
+				http.StatusAccepted:           "The email was sent",
+				http.StatusBadRequest:         "The payload is invalid or the mail service failed",
+				http.StatusUnauthorized:       "A valid access token is required",
+				http.StatusForbidden:          "The caller lacks a required role",
+				http.StatusServiceUnavailable: "The circuit breaker of the mail service is open",
+				http.StatusGatewayTimeout:     "The mail service did not answer in time",
+			},
+			Handler: app.SendMail,
+		},
+		{
+			Method:  http.MethodGet,
+			Path:    "/status/dependencies",
+			Summary: "Report the circuit breakers of the services the broker calls",
+			Responses: map[int]string{
+				http.StatusOK: "The state of each dependency",
+			},
+			Handler: app.DependencyStatus,
+		},
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"broker/data"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_routes(t *testing.T) {
+	user := "Bearer " + signTestToken(testSigningKey, nil, time.Minute)
+
+	tests := []struct {
+		name         string
+		url          string
+		body         string
+		header       string
+		expectedCode int
+	}{
+		{"ping by name", "/handle", `{"action":"ping"}`, "", http.StatusOK},
+		{"ping by number", "/handle", `{"action":0}`, "", http.StatusOK},
+		{"unknown action name", "/handle", `{"action":"fax"}`, "", http.StatusBadRequest},
+		{"unknown action number", "/handle", `{"action":42}`, "", http.StatusBadRequest},
+		{"mail by number without token", "/handle", `{"action":5}`, "", http.StatusUnauthorized},
+		{"mail without token", "/v1/mail", `{}`, "", http.StatusUnauthorized},
+		{"mail without role", "/v1/mail", `{}`, user, http.StatusForbidden},
+		{"logs without token", "/v1/logs", `{}`, "", http.StatusUnauthorized},
+		{"logs with unknown transport", "/v1/logs?transport=pigeon", `{}`, user, http.StatusBadRequest},
+		{"logs with two values", "/v1/logs?transport=rpc", `{}{}`, user, http.StatusBadRequest},
+		{"logs with unknown severity", "/v1/logs", `{"name":"a","data":"b","severity":"fatal"}`, user, http.StatusBadRequest},
+		{"logs with nested attribute", "/v1/logs?transport=grpc", `{"name":"a","data":"b","attributes":{"a":{"b":1}}}`, user, http.StatusBadRequest},
+		{"auth with invalid json", "/v1/auth", `{"email":`, "", http.StatusBadRequest},
+	}
+
+	routes := testApp.routes()
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest(http.MethodPost, tt.url, strings.NewReader(tt.body))
+		req.Header.Set(string(data.HeaderContentType), string(data.ContentTypeJSON))
+		if tt.header != "" {
+			req.Header.Set(string(data.HeaderAuthorization), tt.header)
+		}
+		rr := httptest.NewRecorder()
+
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != tt.expectedCode {
+			t.Errorf("%s: expected status %d but got %d: %s", tt.name, tt.expectedCode, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+func Test_ActionType_JSON(t *testing.T) {
+	out, err := json.Marshal(data.RequestPayload{Action: data.LogGRPC})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(out), `"action":"logGRPC"`) {
+		t.Errorf("expected the action to be written by name but got %s", out)
+	}
+
+	var payload data.RequestPayload
+	if err := json.Unmarshal(out, &payload); err != nil || payload.Action != data.LogGRPC {
+		t.Errorf("expected to read back logGRPC but got %v (%v)", payload.Action, err)
+	}
+}
+
+func Test_OpenAPI(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "/v1/openapi.json", nil)
+	rr := httptest.NewRecorder()
+
+	testApp.routes().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200 but got %d", rr.Code)
+	}
+
+	var document struct {
+		Paths map[string]map[string]struct {
+			OperationID string                `json:"operationId"`
+			Deprecated  bool                  `json:"deprecated"`
+			Security    []map[string][]string `json:"security"`
+			RequestBody struct {
+				Content map[string]struct {
+					Schema map[string]string `json:"schema"`
+				} `json:"content"`
+			} `json:"requestBody"`
+		} `json:"paths"`
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]json.RawMessage `json:"properties"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &document); err != nil {
+		t.Fatal(err)
+	}
+
+	operations := map[string]string{
+		"/handle":  "HandleSubmission",
+		"/v1/auth": "Authenticate",
+		"/v1/logs": "WriteLog",
+		"/v1/mail": "SendMail",
+	}
+	for path, operationID := range operations {
+		if got := document.Paths[path]["post"].OperationID; got != operationID {
+			t.Errorf("%s: expected operation %s but got %q", path, operationID, got)
+		}
+	}
+
+	if !document.Paths["/handle"]["post"].Deprecated {
+		t.Error("expected /handle to be deprecated")
+	}
+
+	if security := document.Paths["/v1/mail"]["post"].Security; len(security) != 1 || security[0]["bearerAuth"] == nil {
+		t.Errorf("expected /v1/mail to require a bearer token but got %v", security)
+	}
+
+	if security := document.Paths["/v1/auth"]["post"].Security; len(security) != 0 {
+		t.Errorf("expected /v1/auth to be public but got %v", security)
+	}
+
+	ref := document.Paths["/v1/mail"]["post"].RequestBody.Content[string(data.ContentTypeJSON)].Schema["$ref"]
+	if ref != "#/components/schemas/MailPayload" {
+		t.Errorf("expected the mail payload schema but got %q", ref)
+	}
+
+	if _, ok := document.Components.Schemas["MailPayload"].Properties["subject"]; !ok {
+		t.Error("expected the mail payload schema to describe the subject")
+	}
+
+	if _, ok := document.Components.Schemas["Problem"].Properties["upstream_status"]; !ok {
+		t.Error("expected errors to be described as problem details")
+	}
+}
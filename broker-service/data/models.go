@@ -1,16 +1,102 @@
 package data
 
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrUnknownAction is returned when decoding an action that doesn't exist.
+var ErrUnknownAction = errors.New("unknown action")
+
+// ActionType is an action of the /handle endpoint. In JSON it is written as its name; for
+// older clients, the number is still accepted.
 type ActionType int
 
+// The numbers are part of the /handle wire format; never reorder or reuse them.
 const (
-	Ping ActionType = iota
-	Auth
-	Log
-	LogRPC
-	LogGRPC
-	Mail
+	Ping    ActionType = 0
+	Auth    ActionType = 1
+	Log     ActionType = 2
+	LogRPC  ActionType = 3
+	LogGRPC ActionType = 4
+	Mail    ActionType = 5
 )
 
+var actionNames = map[ActionType]string{
+	Ping:    "ping",
+	Auth:    "auth",
+	Log:     "log",
+	LogRPC:  "logRPC",
+	LogGRPC: "logGRPC",
+	Mail:    "mail",
+}
+
+// ActionNames returns the names of all actions, in the order of their numbers.
+func ActionNames() []string {
+	names := make([]string, 0, len(actionNames))
+	for action := Ping; action <= Mail; action++ {
+		names = append(names, actionNames[action])
+	}
+
+	return names
+}
+
+// ParseActionType returns the action with the given name.
+func ParseActionType(name string) (ActionType, error) {
+	for action, actionName := range actionNames {
+		if actionName == name {
+			return action, nil
+		}
+	}
+
+	return 0, fmt.Errorf("%w %q", ErrUnknownAction, name)
+}
+
+func (a ActionType) String() string {
+	if name, ok := actionNames[a]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("ActionType(%d)", int(a))
+}
+
+func (a ActionType) MarshalJSON() ([]byte, error) {
+	name, ok := actionNames[a]
+	if !ok {
+		return nil, ErrUnknownAction
+	}
+
+	return json.Marshal(name)
+}
+
+func (a *ActionType) UnmarshalJSON(b []byte) error {
+	var name string
+	if err := json.Unmarshal(b, &name); err == nil {
+		action, err := ParseActionType(name)
+		if err != nil {
+			return err
+		}
+		*a = action
+		return nil
+	}
+
+	var number int
+	if err := json.Unmarshal(b, &number); err != nil {
+		return errors.New("action must be a name or a number")
+	}
+
+	if _, ok := actionNames[ActionType(number)]; !ok {
+		return fmt.Errorf("%w %d", ErrUnknownAction, number)
+	}
+	*a = ActionType(number)
+
+	return nil
+}
+
 type HeaderName string
 
 const (
@@ -47,9 +133,100 @@ type AuthPayload struct {
 	Password string `json:"password"`
 }
 
+// LogPayload is a log entry. Only name and data are required; the other fields were added
+// later and are passed on to the logger service as they are.
 type LogPayload struct {
-	Name string `json:"name"`
-	Data string `json:"data"`
+	Name     string `json:"name"`
+	Data     string `json:"data"`
+	Severity string `json:"severity,omitempty"`
+	// Service is the service that produced the entry.
+	Service string `json:"service,omitempty"`
+	// TraceID is the trace the entry belongs to; the logger uses the trace of the call when
+	// it is empty.
+	TraceID string `json:"trace_id,omitempty"`
+	// UserID defaults to the subject of the access token.
+	UserID string `json:"user_id,omitempty"`
+	// Timestamp is when the entry happened; the logger uses the time it stores the entry
+	// when it is empty.
+	Timestamp  *time.Time `json:"timestamp,omitempty"`
+	Attributes Attributes `json:"attributes,omitempty"`
+}
+
+// Severity is the level of a log entry.
+type Severity string
+
+const (
+	SeverityDebug   Severity = "debug"
+	SeverityInfo    Severity = "info"
+	SeverityWarning Severity = "warning"
+	SeverityError   Severity = "error"
+)
+
+// ErrInvalidLog is returned for log entries the logger service would reject.
+var ErrInvalidLog = errors.New("invalid log entry")
+
+// ParseSeverity returns the severity with the given name, ignoring case. "warn" is accepted for
+// warning, and an empty name means info.
+func ParseSeverity(name string) (Severity, error) {
+	switch strings.ToLower(name) {
+	case "", "info":
+		return SeverityInfo, nil
+	case "debug":
+		return SeverityDebug, nil
+	case "warning", "warn":
+		return SeverityWarning, nil
+	case "error":
+		return SeverityError, nil
+	default:
+		return "", fmt.Errorf("%w: unknown severity %q", ErrInvalidLog, name)
+	}
+}
+
+// Attributes are typed values attached to a log entry: strings, numbers or booleans. Numbers
+// are kept as json.Number, so they are passed on exactly as they were sent.
+type Attributes map[string]interface{}
+
+func (a *Attributes) UnmarshalJSON(b []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+
+	var raw map[string]interface{}
+	if err := dec.Decode(&raw); err != nil {
+		return err
+	}
+
+	for key, value := range raw {
+		switch value.(type) {
+		case string, json.Number, bool:
+		default:
+			return fmt.Errorf("%w: attribute %q must be a string, number or boolean", ErrInvalidLog, key)
+		}
+	}
+	*a = raw
+
+	return nil
+}
+
+// Typed returns the attributes with numbers converted to int64, or to float64 when they have a
+// fraction, for transports that don't carry JSON.
+func (a Attributes) Typed() map[string]interface{} {
+	if len(a) == 0 {
+		return nil
+	}
+
+	out := make(map[string]interface{}, len(a))
+	for key, value := range a {
+		if n, ok := value.(json.Number); ok {
+			if i, err := n.Int64(); err == nil {
+				value = i
+			} else if f, err := n.Float64(); err == nil {
+				value = f
+			}
+		}
+		out[key] = value
+	}
+
+	return out
 }
 
 type MailPayload struct {
@@ -59,7 +236,18 @@ type MailPayload struct {
 	Message string `json:"message"`
 }
 
+// RPCPayload is the argument of RPCServer.LogInfo. net/rpc has no metadata, so the trace
+// context of the call travels in Trace. gob matches the fields by name, so they must keep the
+// names the logger service uses.
 type RPCPayload struct {
-	Name string
-	Data string
+	Name  string
+	Data  string
+	Trace map[string]string `json:"-"`
+
+	Severity   string                 `json:",omitempty"`
+	Service    string                 `json:",omitempty"`
+	TraceID    string                 `json:",omitempty"`
+	UserID     string                 `json:",omitempty"`
+	Timestamp  time.Time              `json:"-"`
+	Attributes map[string]interface{} `json:",omitempty"`
 }
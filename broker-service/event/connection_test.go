@@ -0,0 +1,40 @@
+package event
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_Connect_givesUp(t *testing.T) {
+	// a listener that hangs up right away, so every dial fails quickly
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	_, err = Connect("amqp://guest:guest@"+listener.Addr().String(), ConnectionOptions{
+		Attempts:   3,
+		MinBackoff: time.Millisecond,
+		MaxBackoff: 5 * time.Millisecond,
+	})
+	if err == nil {
+		t.Fatal("expected connecting to fail")
+	}
+
+	if !strings.Contains(err.Error(), "after 3 attempts") {
+		t.Errorf("expected the error to mention the attempts but got %v", err)
+	}
+}
@@ -1,36 +1,70 @@
 package event
 
 import (
+	"broker/backoff"
 	"broker/event/data"
+	"broker/tracing"
+	"context"
 	"encoding/json"
 	"fmt"
+	"time"
 
 	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Consumer struct {
-	conn      *amqp.Connection
+	conn      *Connection
 	logger    *Logger
 	queueName string
 }
 
-func NewConsumer(conn *amqp.Connection, logger *Logger) *Consumer {
+func NewConsumer(conn *Connection, logger *Logger) *Consumer {
 	return &Consumer{
 		conn:   conn,
 		logger: logger,
 	}
 }
 
-func (consumer *Consumer) setup() error {
-	channel, err := consumer.conn.Channel()
-	if err != nil {
-		return err
-	}
+// Listen consumes messages for the given topics until the connection is closed. Whenever the
+// connection or the channel is lost, a new queue is declared and bound once RabbitMQ is back.
+func (consumer *Consumer) Listen(topics []string) error {
+	retry := backoff.Backoff{Min: 500 * time.Millisecond, Max: 30 * time.Second}
+
+	for {
+		select {
+		case <-consumer.conn.Done():
+			return ErrConnectionClosed
+		default:
+		}
+
+		select {
+		case <-consumer.conn.Ready():
+		case <-consumer.conn.Done():
+			return ErrConnectionClosed
+		}
+
+		err := consumer.consume(topics)
+		if err == nil {
+			retry.Reset()
+			continue
+		}
+
+		fmt.Printf("Consuming from RabbitMQ failed: %v\n", err)
 
-	return declareExchange(channel)
+		select {
+		case <-consumer.conn.Done():
+			return ErrConnectionClosed
+		case <-time.After(retry.Next()):
+		}
+	}
 }
 
-func (consumer *Consumer) Listen(topics []string) error {
+// consume declares a queue, binds the topics to it and handles messages until the delivery
+// channel is closed.
+func (consumer *Consumer) consume(topics []string) error {
 	channel, err := consumer.conn.Channel()
 	if err != nil {
 		return err
@@ -43,7 +77,7 @@ func (consumer *Consumer) Listen(topics []string) error {
 	}
 
 	for _, topic := range topics {
-		channel.QueueBind(
+		err := channel.QueueBind(
 			queue.Name,
 			topic,
 			"logs_topic",
@@ -61,34 +95,51 @@ func (consumer *Consumer) Listen(topics []string) error {
 		return err
 	}
 
-	forever := make(chan bool)
-	go func() {
-		for d := range messages {
-			var payload data.Payload
-			_ = json.Unmarshal(d.Body, &payload)
+	fmt.Printf("Waiting for message [Exchange, Queue] [logs_topic, %s]\n", queue.Name)
 
-			go handlePayload(payload, consumer.logger)
-		}
-	}()
+	for d := range messages {
+		var payload data.Payload
+		_ = json.Unmarshal(d.Body, &payload)
 
-	fmt.Printf("Waiting for message [Exchange, Queue] [logs_topic, %s]\n", queue.Name)
-	<-forever
+		go handlePayload(deliveryContext(d), payload, consumer.logger)
+	}
 
 	return nil
 }
 
-func handlePayload(payload data.Payload, logger *Logger) {
+// deliveryContext continues the trace of the publisher of a delivery.
+func deliveryContext(d amqp.Delivery) context.Context {
+	headers := AMQPHeaders(d.Headers)
+	if headers == nil {
+		headers = AMQPHeaders{}
+	}
+
+	return otel.GetTextMapPropagator().Extract(context.Background(), headers)
+}
+
+func handlePayload(ctx context.Context, payload data.Payload, logger *Logger) {
+	ctx, span := tracing.Tracer().Start(ctx, "logs_topic process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			semconv.MessagingSystem("rabbitmq"),
+			semconv.MessagingSourceName("logs_topic"),
+		),
+	)
+	defer span.End()
+
 	switch payload.Name {
 	case "log", "event":
-		err := logger.logEvent(payload)
+		err := logger.logEvent(ctx, payload)
 		if err != nil {
+			span.RecordError(err)
 			fmt.Println(err)
 		}
 	case "auth":
 	// you can have as many cases as you want, as long as you write the logic
 	default:
-		err := logger.logEvent(payload)
+		err := logger.logEvent(ctx, payload)
 		if err != nil {
+			span.RecordError(err)
 			fmt.Println(err)
 		}
 	}
@@ -1,11 +1,14 @@
 package data
 
+// EventSeverity is the routing key of a log event. The listener binds its queue to the routing
+// keys of the severities it handles.
 type EventSeverity string
 
 const (
-	SeverityLog     EventSeverity = "log.INFO"
+	SeverityDebug   EventSeverity = "log.DEBUG"
+	SeverityInfo    EventSeverity = "log.INFO"
 	SeverityWarning EventSeverity = "log.WARNING"
-	SeverityInfo    EventSeverity = "log.ERROR"
+	SeverityError   EventSeverity = "log.ERROR"
 )
 
 type Payload struct {
@@ -0,0 +1,29 @@
+package event
+
+import (
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// AMQPHeaders carries trace context in the headers of an AMQP message.
+type AMQPHeaders amqp.Table
+
+var _ propagation.TextMapCarrier = AMQPHeaders{}
+
+func (h AMQPHeaders) Get(key string) string {
+	value, _ := h[key].(string)
+	return value
+}
+
+func (h AMQPHeaders) Set(key, value string) {
+	h[key] = value
+}
+
+func (h AMQPHeaders) Keys() []string {
+	keys := make([]string, 0, len(h))
+	for key := range h {
+		keys = append(keys, key)
+	}
+
+	return keys
+}
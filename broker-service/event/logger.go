@@ -2,11 +2,14 @@ package event
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 
 	"broker/event/data"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
 type Logger struct {
@@ -17,14 +20,14 @@ type Logger struct {
 func NewLogger(logServiceURL string) *Logger {
 	return &Logger{
 		logServiceURL: logServiceURL,
-		client:        &http.Client{},
+		client:        &http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport)},
 	}
 }
 
-func (logger *Logger) logEvent(entry data.Payload) error {
+func (logger *Logger) logEvent(ctx context.Context, entry data.Payload) error {
 	jsonData, _ := json.MarshalIndent(entry, "", "\t")
 
-	request, err := http.NewRequest(http.MethodPost, logger.logServiceURL, bytes.NewBuffer(jsonData))
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, logger.logServiceURL, bytes.NewBuffer(jsonData))
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,407 @@
+package event
+
+import (
+	"broker/backoff"
+	"broker/data"
+	"broker/tracing"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	// ErrBufferFull is returned when RabbitMQ has been unavailable for long enough that the
+	// buffer of waiting messages filled up.
+	ErrBufferFull = errors.New("publish buffer is full")
+	// ErrUnroutable is returned for messages RabbitMQ couldn't route to any queue, because
+	// nothing is bound to their routing key.
+	ErrUnroutable = errors.New("no queue is bound to the routing key")
+	// ErrNacked is returned when RabbitMQ refused to take responsibility for a message.
+	ErrNacked = errors.New("message was rejected by RabbitMQ")
+	// ErrPublisherClosed is returned by Publish after Close.
+	ErrPublisherClosed = errors.New("publisher is closed")
+
+	errNotConfirmed = errors.New("message was not confirmed in time")
+)
+
+// PublisherOptions configure a Publisher. Zero values are replaced by the defaults below.
+type PublisherOptions struct {
+	// Channels is the number of AMQP channels messages are published on concurrently.
+	Channels int
+	// BufferSize is the number of messages held while RabbitMQ is unavailable.
+	BufferSize int
+	// ConfirmTimeout bounds the wait for RabbitMQ to confirm a message.
+	ConfirmTimeout time.Duration
+	// MinBackoff and MaxBackoff bound the delay between attempts to reopen a failed channel.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+func (o PublisherOptions) withDefaults() PublisherOptions {
+	if o.Channels <= 0 {
+		o.Channels = 4
+	}
+	if o.BufferSize <= 0 {
+		o.BufferSize = 1000
+	}
+	if o.ConfirmTimeout <= 0 {
+		o.ConfirmTimeout = 5 * time.Second
+	}
+	if o.MinBackoff <= 0 {
+		o.MinBackoff = 100 * time.Millisecond
+	}
+	if o.MaxBackoff <= 0 {
+		o.MaxBackoff = 10 * time.Second
+	}
+
+	return o
+}
+
+// Publisher publishes messages to the logs_topic exchange on a pool of long-lived channels in
+// confirm mode. Publish returns once RabbitMQ has confirmed the message. Messages are
+// published with the mandatory flag, so messages no queue is bound for are reported instead
+// of silently dropped. While RabbitMQ is unavailable, messages wait in a bounded buffer and
+// are published as soon as a channel can be opened again.
+type Publisher struct {
+	opts  PublisherOptions
+	open  func() (publishChannel, error)
+	queue chan *pendingMessage
+
+	// mu makes enqueueing and closing exclusive, so Close fails every message enqueued before
+	// it and none is enqueued after it
+	mu     sync.RWMutex
+	closed bool
+	stop   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// pendingMessage is a message waiting in the buffer or being published.
+type pendingMessage struct {
+	routingKey string
+	msg        amqp.Publishing
+	state      int32
+	result     chan error
+}
+
+const (
+	stateQueued int32 = iota
+	statePublishing
+	stateWithdrawn
+)
+
+// NewPublisher starts publishing on conn. Channels lost together with the connection are
+// reopened once it has been re-established.
+func NewPublisher(conn *Connection, opts PublisherOptions) *Publisher {
+	return newPublisher(func() (publishChannel, error) {
+		return openConfirmChannel(conn)
+	}, opts)
+}
+
+func newPublisher(open func() (publishChannel, error), opts PublisherOptions) *Publisher {
+	opts = opts.withDefaults()
+
+	p := &Publisher{
+		opts:  opts,
+		open:  open,
+		queue: make(chan *pendingMessage, opts.BufferSize),
+		stop:  make(chan struct{}),
+	}
+
+	for i := 0; i < opts.Channels; i++ {
+		p.wg.Add(1)
+		go p.work()
+	}
+
+	return p
+}
+
+// Publish sends body with the given routing key and waits until RabbitMQ confirmed it. When
+// ctx ends while the message is still buffered, it is withdrawn and never published; once it
+// has been handed to RabbitMQ its fate is unknown to the caller. The trace context of ctx
+// travels with the message in its headers.
+func (p *Publisher) Publish(ctx context.Context, routingKey string, body []byte) (err error) {
+	id, err := newMessageID()
+	if err != nil {
+		return err
+	}
+
+	ctx, span := tracing.Tracer().Start(ctx, "logs_topic send",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			semconv.MessagingSystem("rabbitmq"),
+			semconv.MessagingDestinationName("logs_topic"),
+			semconv.MessagingMessageID(id),
+			semconv.MessagingRabbitmqDestinationRoutingKey(routingKey),
+		),
+	)
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
+	headers := AMQPHeaders{}
+	otel.GetTextMapPropagator().Inject(ctx, headers)
+
+	pending := &pendingMessage{
+		routingKey: routingKey,
+		msg: amqp.Publishing{
+			Headers:     amqp.Table(headers),
+			ContentType: string(data.ContentTypeText),
+			MessageId:   id,
+			Timestamp:   time.Now(),
+			Body:        body,
+		},
+		result: make(chan error, 1),
+	}
+
+	if err := p.enqueue(pending); err != nil {
+		return err
+	}
+
+	select {
+	case err := <-pending.result:
+		return err
+	case <-ctx.Done():
+		atomic.CompareAndSwapInt32(&pending.state, stateQueued, stateWithdrawn)
+		return ctx.Err()
+	}
+}
+
+// enqueue buffers pending unless the buffer is full or the publisher is closed.
+func (p *Publisher) enqueue(pending *pendingMessage) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.closed {
+		return ErrPublisherClosed
+	}
+
+	select {
+	case p.queue <- pending:
+		return nil
+	default:
+		return ErrBufferFull
+	}
+}
+
+// Close stops publishing and closes the channels. Buffered messages fail with
+// ErrPublisherClosed.
+func (p *Publisher) Close() error {
+	p.mu.Lock()
+	closed := p.closed
+	p.closed = true
+	p.mu.Unlock()
+
+	if closed {
+		return nil
+	}
+
+	close(p.stop)
+	p.wg.Wait()
+
+	for {
+		select {
+		case pending := <-p.queue:
+			pending.result <- ErrPublisherClosed
+		default:
+			return nil
+		}
+	}
+}
+
+// work publishes buffered messages one at a time on its own channel. When the channel fails,
+// the message is kept and published again on a new channel, so messages survive short
+// outages of RabbitMQ.
+func (p *Publisher) work() {
+	defer p.wg.Done()
+
+	var channel publishChannel
+	defer func() {
+		if channel != nil {
+			_ = channel.Close()
+		}
+	}()
+
+	retry := backoff.Backoff{Min: p.opts.MinBackoff, Max: p.opts.MaxBackoff}
+
+	for {
+		var pending *pendingMessage
+
+		select {
+		case <-p.stop:
+			return
+		case pending = <-p.queue:
+		}
+
+		for atomic.CompareAndSwapInt32(&pending.state, stateQueued, statePublishing) {
+			var err error
+
+			if channel == nil {
+				channel, err = p.open()
+			}
+			if err == nil {
+				err = p.publish(channel, pending)
+			}
+
+			if err == nil || !transient(err) {
+				retry.Reset()
+				pending.result <- err
+				break
+			}
+
+			log.Printf("Publishing to RabbitMQ failed, retrying: %v", err)
+			if channel != nil {
+				_ = channel.Close()
+				channel = nil
+			}
+
+			// give the caller a chance to withdraw the message while we wait
+			atomic.StoreInt32(&pending.state, stateQueued)
+
+			select {
+			case <-p.stop:
+				pending.result <- ErrPublisherClosed
+				return
+			case <-time.After(retry.Next()):
+			}
+		}
+	}
+}
+
+// publish sends one message and waits for its confirmation.
+func (p *Publisher) publish(channel publishChannel, pending *pendingMessage) error {
+	ctx, cancel := context.WithTimeout(context.Background(), p.opts.ConfirmTimeout)
+	defer cancel()
+
+	confirmation, err := channel.Publish(ctx, "logs_topic", pending.routingKey, pending.msg)
+	if err != nil {
+		return &channelError{err}
+	}
+
+	acked, err := confirmation.WaitContext(ctx)
+	if err != nil {
+		return &channelError{errNotConfirmed}
+	}
+
+	if !acked {
+		// a closing channel nacks everything that is still outstanding
+		if channel.IsClosed() {
+			return &channelError{amqp.ErrClosed}
+		}
+		return ErrNacked
+	}
+
+	// RabbitMQ returns an unroutable message before confirming it
+	if channel.Returned(pending.msg.MessageId) {
+		return ErrUnroutable
+	}
+
+	return nil
+}
+
+// channelError marks failures of the channel or the connection rather than of the message.
+type channelError struct {
+	err error
+}
+
+func (e *channelError) Error() string {
+	return e.err.Error()
+}
+
+func (e *channelError) Unwrap() error {
+	return e.err
+}
+
+func transient(err error) bool {
+	var chErr *channelError
+	return errors.As(err, &chErr)
+}
+
+func newMessageID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// publishChannel is the part of an AMQP channel in confirm mode the publisher uses.
+type publishChannel interface {
+	Publish(ctx context.Context, exchange, routingKey string, msg amqp.Publishing) (confirmation, error)
+	// Returned reports whether the message with the given ID came back as unroutable.
+	Returned(messageID string) bool
+	IsClosed() bool
+	Close() error
+}
+
+type confirmation interface {
+	WaitContext(ctx context.Context) (bool, error)
+}
+
+// confirmChannel is a publishChannel on a real AMQP channel.
+type confirmChannel struct {
+	channel *amqp.Channel
+	returns chan amqp.Return
+}
+
+func openConfirmChannel(conn *Connection) (publishChannel, error) {
+	channel, err := conn.Channel()
+	if err != nil {
+		return nil, &channelError{err}
+	}
+
+	if err := channel.Confirm(false); err != nil {
+		channel.Close()
+		return nil, &channelError{err}
+	}
+
+	return &confirmChannel{
+		channel: channel,
+		returns: channel.NotifyReturn(make(chan amqp.Return, 8)),
+	}, nil
+}
+
+func (c *confirmChannel) Publish(ctx context.Context, exchange, routingKey string, msg amqp.Publishing) (confirmation, error) {
+	return c.channel.PublishWithDeferredConfirmWithContext(ctx, exchange, routingKey, true, false, msg)
+}
+
+func (c *confirmChannel) Returned(messageID string) bool {
+	returned := false
+
+	for {
+		select {
+		case ret, ok := <-c.returns:
+			if !ok {
+				return returned
+			}
+			if ret.MessageId == messageID {
+				returned = true
+			}
+		default:
+			return returned
+		}
+	}
+}
+
+func (c *confirmChannel) IsClosed() bool {
+	return c.channel.IsClosed()
+}
+
+func (c *confirmChannel) Close() error {
+	return c.channel.Close()
+}
@@ -0,0 +1,288 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// fakeBroker hands out fake channels and decides what happens to published messages.
+type fakeBroker struct {
+	mu        sync.Mutex
+	down      bool
+	nack      bool
+	unbound   map[string]bool
+	published []string
+	headers   []amqp.Table
+}
+
+func (b *fakeBroker) setDown(down bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.down = down
+}
+
+func (b *fakeBroker) messages() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return append([]string(nil), b.published...)
+}
+
+func (b *fakeBroker) open() (publishChannel, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.down {
+		return nil, &channelError{amqp.ErrClosed}
+	}
+
+	return &fakeChannel{broker: b, returned: map[string]bool{}}, nil
+}
+
+type fakeChannel struct {
+	broker   *fakeBroker
+	closed   bool
+	returned map[string]bool
+}
+
+type fakeConfirmation bool
+
+func (c fakeConfirmation) WaitContext(ctx context.Context) (bool, error) {
+	return bool(c), nil
+}
+
+func (c *fakeChannel) Publish(ctx context.Context, exchange, routingKey string, msg amqp.Publishing) (confirmation, error) {
+	b := c.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.down || c.closed {
+		c.closed = true
+		return nil, amqp.ErrClosed
+	}
+
+	if b.unbound[routingKey] {
+		c.returned[msg.MessageId] = true
+		return fakeConfirmation(true), nil
+	}
+
+	if b.nack {
+		return fakeConfirmation(false), nil
+	}
+
+	b.published = append(b.published, string(msg.Body))
+	b.headers = append(b.headers, msg.Headers)
+	return fakeConfirmation(true), nil
+}
+
+func (c *fakeChannel) Returned(messageID string) bool {
+	return c.returned[messageID]
+}
+
+func (c *fakeChannel) IsClosed() bool {
+	return c.closed
+}
+
+func (c *fakeChannel) Close() error {
+	c.closed = true
+	return nil
+}
+
+func testPublisher(broker *fakeBroker, opts PublisherOptions) *Publisher {
+	opts.MinBackoff = time.Millisecond
+	opts.MaxBackoff = 5 * time.Millisecond
+
+	return newPublisher(broker.open, opts)
+}
+
+func Test_Publisher_confirms(t *testing.T) {
+	broker := &fakeBroker{unbound: map[string]bool{"log.UNBOUND": true}}
+	p := testPublisher(broker, PublisherOptions{})
+	defer p.Close()
+
+	ctx := context.Background()
+
+	if err := p.Publish(ctx, "log.INFO", []byte("first")); err != nil {
+		t.Errorf("expected the message to be confirmed but got %v", err)
+	}
+
+	if err := p.Publish(ctx, "log.UNBOUND", []byte("unroutable")); err != ErrUnroutable {
+		t.Errorf("expected ErrUnroutable but got %v", err)
+	}
+
+	broker.mu.Lock()
+	broker.nack = true
+	broker.mu.Unlock()
+
+	if err := p.Publish(ctx, "log.INFO", []byte("nacked")); err != ErrNacked {
+		t.Errorf("expected ErrNacked but got %v", err)
+	}
+
+	if messages := broker.messages(); len(messages) != 1 || messages[0] != "first" {
+		t.Errorf("expected only the first message to be published but got %v", messages)
+	}
+}
+
+func Test_Publisher_buffersWhileUnavailable(t *testing.T) {
+	broker := &fakeBroker{down: true}
+	p := testPublisher(broker, PublisherOptions{Channels: 1, BufferSize: 2})
+	defer p.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- p.Publish(context.Background(), "log.INFO", []byte("delayed"))
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	broker.setDown(false)
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("expected the buffered message to be confirmed once RabbitMQ is back but got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the buffered message was never published")
+	}
+
+	if messages := broker.messages(); len(messages) != 1 {
+		t.Errorf("expected the message to be published once but got %v", messages)
+	}
+}
+
+func Test_Publisher_bufferFull(t *testing.T) {
+	broker := &fakeBroker{down: true}
+	p := testPublisher(broker, PublisherOptions{Channels: 1, BufferSize: 1})
+	defer p.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	// the worker holds one message and the buffer another; the third doesn't fit
+	results := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			results <- p.Publish(ctx, "log.INFO", []byte("message"))
+		}()
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	full := 0
+	for i := 0; i < 3; i++ {
+		if errors.Is(<-results, ErrBufferFull) {
+			full++
+		}
+	}
+
+	if full != 1 {
+		t.Errorf("expected one message to be rejected with ErrBufferFull but got %d", full)
+	}
+}
+
+func Test_Publisher_withdrawsExpiredMessages(t *testing.T) {
+	broker := &fakeBroker{down: true}
+	p := testPublisher(broker, PublisherOptions{Channels: 1})
+	defer p.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if err := p.Publish(ctx, "log.INFO", []byte("expired")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the deadline to be exceeded but got %v", err)
+	}
+
+	broker.setDown(false)
+
+	if err := p.Publish(context.Background(), "log.INFO", []byte("fresh")); err != nil {
+		t.Fatal(err)
+	}
+
+	if messages := broker.messages(); len(messages) != 1 || messages[0] != "fresh" {
+		t.Errorf("expected the expired message to be withdrawn but got %v", messages)
+	}
+}
+
+func Test_Publisher_close(t *testing.T) {
+	p := testPublisher(&fakeBroker{}, PublisherOptions{})
+	p.Close()
+
+	if err := p.Publish(context.Background(), "log.INFO", []byte("late")); err != ErrPublisherClosed {
+		t.Errorf("expected ErrPublisherClosed but got %v", err)
+	}
+}
+
+func Test_Publisher_closeWhilePublishing(t *testing.T) {
+	for i := 0; i < 50; i++ {
+		// while RabbitMQ is down, messages stay in the buffer until Close fails them
+		broker := &fakeBroker{down: true}
+		p := testPublisher(broker, PublisherOptions{Channels: 1})
+
+		var wg sync.WaitGroup
+		errs := make(chan error, 20)
+		for j := 0; j < cap(errs); j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errs <- p.Publish(context.Background(), "log.INFO", []byte("racing"))
+			}()
+		}
+
+		p.Close()
+
+		done := make(chan struct{})
+		go func() {
+			wg.Wait()
+			close(done)
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(2 * time.Second):
+			t.Fatal("expected every Publish to return once the publisher is closed")
+		}
+
+		close(errs)
+		for err := range errs {
+			if err != ErrPublisherClosed {
+				t.Errorf("expected ErrPublisherClosed but got %v", err)
+			}
+		}
+	}
+}
+
+func Test_Publisher_propagatesTrace(t *testing.T) {
+	otel.SetTracerProvider(sdktrace.NewTracerProvider())
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	broker := &fakeBroker{}
+	p := testPublisher(broker, PublisherOptions{})
+	defer p.Close()
+
+	ctx, span := otel.Tracer("test").Start(context.Background(), "request")
+	defer span.End()
+
+	if err := p.Publish(ctx, "log.INFO", []byte("traced")); err != nil {
+		t.Fatal(err)
+	}
+
+	broker.mu.Lock()
+	headers := broker.headers[0]
+	broker.mu.Unlock()
+
+	received := trace.SpanContextFromContext(
+		otel.GetTextMapPropagator().Extract(context.Background(), AMQPHeaders(headers)),
+	)
+	if received.TraceID() != span.SpanContext().TraceID() {
+		t.Errorf("expected the message to carry trace %s but got %s", span.SpanContext().TraceID(), received.TraceID())
+	}
+}
@@ -0,0 +1,115 @@
+// Package health serves the /livez and /readyz probes of a service.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Check reports whether a dependency is usable.
+type Check func(ctx context.Context) error
+
+// Checker runs the readiness checks of the service.
+type Checker struct {
+	timeout  time.Duration
+	names    []string
+	checks   map[string]Check
+	draining int32
+}
+
+// Report is the body of a probe response. Checks maps the name of every check to "ok" or to
+// the reason it failed.
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+const (
+	statusOK       = "ok"
+	statusNotReady = "not ready"
+	statusDraining = "draining"
+)
+
+// New returns a Checker that gives every check up to timeout.
+func New(timeout time.Duration) *Checker {
+	return &Checker{
+		timeout: timeout,
+		checks:  map[string]Check{},
+	}
+}
+
+// Add registers a readiness check under name.
+func (c *Checker) Add(name string, check Check) {
+	c.names = append(c.names, name)
+	c.checks[name] = check
+}
+
+// Drain makes the service report that it isn't ready, so no new traffic is routed to it while
+// it shuts down.
+func (c *Checker) Drain() {
+	atomic.StoreInt32(&c.draining, 1)
+}
+
+// Livez answers as long as the process can serve requests at all.
+func (c *Checker) Livez(w http.ResponseWriter, r *http.Request) {
+	writeReport(w, http.StatusOK, Report{Status: statusOK})
+}
+
+// Readyz runs all checks concurrently and answers 503 if any of them fails or the service is
+// shutting down.
+func (c *Checker) Readyz(w http.ResponseWriter, r *http.Request) {
+	if atomic.LoadInt32(&c.draining) == 1 {
+		writeReport(w, http.StatusServiceUnavailable, Report{Status: statusDraining})
+		return
+	}
+
+	report := c.Check(r.Context())
+
+	status := http.StatusOK
+	if report.Status != statusOK {
+		status = http.StatusServiceUnavailable
+	}
+
+	writeReport(w, status, report)
+}
+
+// Check runs all checks and reports their results.
+func (c *Checker) Check(ctx context.Context) Report {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	results := make([]error, len(c.names))
+
+	var wg sync.WaitGroup
+	for i, name := range c.names {
+		wg.Add(1)
+		go func(i int, check Check) {
+			defer wg.Done()
+			results[i] = check(ctx)
+		}(i, c.checks[name])
+	}
+	wg.Wait()
+
+	report := Report{Status: statusOK, Checks: map[string]string{}}
+	for i, name := range c.names {
+		if results[i] != nil {
+			report.Status = statusNotReady
+			report.Checks[name] = results[i].Error()
+			continue
+		}
+		report.Checks[name] = statusOK
+	}
+
+	return report
+}
+
+func writeReport(w http.ResponseWriter, status int, report Report) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,73 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func probe(t *testing.T, handler http.HandlerFunc) (int, Report) {
+	t.Helper()
+
+	rr := httptest.NewRecorder()
+	handler(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	var report Report
+	if err := json.NewDecoder(rr.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+
+	return rr.Code, report
+}
+
+func Test_Checker(t *testing.T) {
+	var rabbitErr error
+
+	checker := New(50 * time.Millisecond)
+	checker.Add("rabbitmq", func(ctx context.Context) error {
+		return rabbitErr
+	})
+	checker.Add("slow", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	if code, _ := probe(t, checker.Livez); code != http.StatusOK {
+		t.Errorf("expected /livez to answer 200 but got %d", code)
+	}
+
+	code, report := probe(t, checker.Readyz)
+	if code != http.StatusServiceUnavailable {
+		t.Errorf("expected a check that times out to make the service unready but got %d", code)
+	}
+	if report.Checks["rabbitmq"] != "ok" || report.Checks["slow"] != context.DeadlineExceeded.Error() {
+		t.Errorf("expected the result of every check but got %v", report.Checks)
+	}
+
+	checker = New(time.Second)
+	checker.Add("rabbitmq", func(ctx context.Context) error {
+		return rabbitErr
+	})
+
+	if code, _ := probe(t, checker.Readyz); code != http.StatusOK {
+		t.Errorf("expected passing checks to make the service ready but got %d", code)
+	}
+
+	rabbitErr = errors.New("not connected to RabbitMQ")
+	if code, report := probe(t, checker.Readyz); code != http.StatusServiceUnavailable || report.Checks["rabbitmq"] != rabbitErr.Error() {
+		t.Errorf("expected the failing check to be reported with 503 but got %d: %v", code, report.Checks)
+	}
+
+	rabbitErr = nil
+	checker.Drain()
+	if code, report := probe(t, checker.Readyz); code != http.StatusServiceUnavailable || report.Status != "draining" {
+		t.Errorf("expected a draining service to be unready but got %d: %v", code, report)
+	}
+	if code, _ := probe(t, checker.Livez); code != http.StatusOK {
+		t.Errorf("expected a draining service to stay live but got %d", code)
+	}
+}
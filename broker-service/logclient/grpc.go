@@ -0,0 +1,136 @@
+package logclient
+
+import (
+	"broker/logs"
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc/filters"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// GRPCClient is a LogServiceClient on a single long-lived connection. gRPC multiplexes
+// concurrent calls over it and redials on its own, with the backoff from the options.
+type GRPCClient struct {
+	logs.LogServiceClient
+
+	conn    *grpc.ClientConn
+	health  healthpb.HealthClient
+	timeout time.Duration
+	healthy int32
+	closed  int32
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewGRPCClient starts connecting to the gRPC address in the options without waiting for the
+// connection to be established.
+func NewGRPCClient(opts Options) (*GRPCClient, error) {
+	opts = opts.withDefaults()
+
+	conn, err := grpc.Dial(opts.GRPCAddress,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		// health checks run in the background and would only add noise to the traces
+		grpc.WithUnaryInterceptor(otelgrpc.UnaryClientInterceptor(
+			otelgrpc.WithInterceptorFilter(filters.Not(filters.HealthCheck())),
+		)),
+		grpc.WithConnectParams(grpc.ConnectParams{
+			Backoff: backoff.Config{
+				BaseDelay:  opts.MinBackoff,
+				Multiplier: 1.6,
+				Jitter:     0.2,
+				MaxDelay:   opts.MaxBackoff,
+			},
+			MinConnectTimeout: opts.DialTimeout,
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	conn.Connect()
+
+	c := &GRPCClient{
+		LogServiceClient: logs.NewLogServiceClient(conn),
+		conn:             conn,
+		health:           healthpb.NewHealthClient(conn),
+		timeout:          opts.DialTimeout,
+		stop:             make(chan struct{}),
+	}
+
+	c.wg.Add(1)
+	go c.watch(opts.HealthInterval)
+
+	return c, nil
+}
+
+// Healthy reports whether the last health check succeeded.
+func (c *GRPCClient) Healthy() bool {
+	return atomic.LoadInt32(&c.healthy) == 1
+}
+
+// Close stops the health checks and closes the connection.
+func (c *GRPCClient) Close() error {
+	if !atomic.CompareAndSwapInt32(&c.closed, 0, 1) {
+		return nil
+	}
+
+	close(c.stop)
+	c.wg.Wait()
+
+	return c.conn.Close()
+}
+
+func (c *GRPCClient) watch(interval time.Duration) {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		c.check()
+
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// check asks the logger's health service whether it is serving. Loggers without a health
+// service count as healthy while the connection is ready.
+func (c *GRPCClient) check() {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	healthy := false
+
+	resp, err := c.health.Check(ctx, &healthpb.HealthCheckRequest{})
+	switch {
+	case err == nil:
+		healthy = resp.GetStatus() == healthpb.HealthCheckResponse_SERVING
+	case status.Code(err) == codes.Unimplemented:
+		healthy = c.conn.GetState() == connectivity.Ready
+	}
+
+	if !healthy {
+		// a connection that went idle only reconnects when asked to
+		c.conn.Connect()
+	}
+
+	var flag int32
+	if healthy {
+		flag = 1
+	}
+	atomic.StoreInt32(&c.healthy, flag)
+}
@@ -0,0 +1,85 @@
+// Package logclient holds the broker's connections to the logger service. The gRPC and RPC
+// clients are created once in main and shared by all requests. They reconnect with backoff
+// when the logger goes away, are health-checked in the background and are closed on shutdown.
+package logclient
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	// ErrClosed is returned by calls made after Close.
+	ErrClosed = errors.New("logger client is closed")
+	// ErrUnavailable is returned while a connection is waiting out its backoff before redialing.
+	ErrUnavailable = errors.New("logger service is unavailable")
+)
+
+// Options configure the clients. Zero values are replaced by the defaults below.
+type Options struct {
+	// GRPCAddress and RPCAddress are the host:port addresses of the logger service.
+	GRPCAddress string
+	RPCAddress  string
+	// RPCConnections is the number of RPC connections calls are spread over. Each one is
+	// multiplexed, but its requests are written one at a time.
+	RPCConnections int
+	// DialTimeout bounds establishing a connection and each health check.
+	DialTimeout time.Duration
+	// HealthInterval is the time between health checks of idle connections.
+	HealthInterval time.Duration
+	// MinBackoff and MaxBackoff bound the delay between reconnection attempts.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+func (o Options) withDefaults() Options {
+	if o.RPCConnections <= 0 {
+		o.RPCConnections = 4
+	}
+	if o.DialTimeout <= 0 {
+		o.DialTimeout = time.Second
+	}
+	if o.HealthInterval <= 0 {
+		o.HealthInterval = 10 * time.Second
+	}
+	if o.MinBackoff <= 0 {
+		o.MinBackoff = 100 * time.Millisecond
+	}
+	if o.MaxBackoff <= 0 {
+		o.MaxBackoff = 30 * time.Second
+	}
+
+	return o
+}
+
+// Clients bundles the gRPC and RPC clients of the logger service.
+type Clients struct {
+	GRPC *GRPCClient
+	RPC  *RPCClient
+}
+
+// New creates both clients. Connections are established in the background, so New succeeds
+// even while the logger service is down.
+func New(opts Options) (*Clients, error) {
+	opts = opts.withDefaults()
+
+	grpcClient, err := NewGRPCClient(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Clients{
+		GRPC: grpcClient,
+		RPC:  NewRPCClient(opts),
+	}, nil
+}
+
+// Close stops the health checks and closes all connections.
+func (c *Clients) Close() error {
+	rpcErr := c.RPC.Close()
+	if err := c.GRPC.Close(); err != nil {
+		return err
+	}
+
+	return rpcErr
+}
@@ -0,0 +1,370 @@
+package logclient
+
+import (
+	"broker/logs"
+	"context"
+	"errors"
+	"net"
+	"net/rpc"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// RPCServer and grpcServer stand in for the servers of the logger service.
+type RPCServer struct{}
+
+type RPCPayload struct {
+	Name string
+	Data string
+}
+
+func (s *RPCServer) LogInfo(payload RPCPayload, resp *string) error {
+	*resp = "Processed payload via RPC"
+	return nil
+}
+
+// Slow answers after the given delay, like a logger under load.
+func (s *RPCServer) Slow(delay time.Duration, resp *string) error {
+	time.Sleep(delay)
+	*resp = "slow"
+	return nil
+}
+
+func (s *RPCServer) Ping(_ string, resp *string) error {
+	*resp = "pong"
+	return nil
+}
+
+type grpcServer struct {
+	logs.UnimplementedLogServiceServer
+}
+
+func (s *grpcServer) WriteLog(ctx context.Context, req *logs.LogRequest) (*logs.LogResponse, error) {
+	return &logs.LogResponse{Result: "Processed payload via gRPC"}, nil
+}
+
+// testRPCServer serves the logger's RPC methods on a loopback address until closed. Closing
+// it also cuts the connections it accepted, like a restarting logger.
+type testRPCServer struct {
+	listener net.Listener
+
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+func startRPCServer(tb testing.TB, address string) *testRPCServer {
+	tb.Helper()
+
+	server := rpc.NewServer()
+	if err := server.Register(&RPCServer{}); err != nil {
+		tb.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	s := &testRPCServer{listener: listener}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			s.mu.Lock()
+			s.conns = append(s.conns, conn)
+			s.mu.Unlock()
+
+			go server.ServeConn(conn)
+		}
+	}()
+
+	return s
+}
+
+func (s *testRPCServer) Address() string {
+	return s.listener.Addr().String()
+}
+
+func (s *testRPCServer) Close() {
+	s.listener.Close()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+	s.conns = nil
+}
+
+func startGRPCServer(tb testing.TB) (string, func()) {
+	tb.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	server := grpc.NewServer()
+	logs.RegisterLogServiceServer(server, &grpcServer{})
+	healthpb.RegisterHealthServer(server, health.NewServer())
+
+	go server.Serve(listener)
+
+	return listener.Addr().String(), server.Stop
+}
+
+func Test_RPCClient_reconnects(t *testing.T) {
+	server := startRPCServer(t, "127.0.0.1:0")
+	address := server.Address()
+
+	client := NewRPCClient(Options{
+		RPCAddress:     address,
+		RPCConnections: 2,
+		MinBackoff:     10 * time.Millisecond,
+		MaxBackoff:     20 * time.Millisecond,
+		HealthInterval: time.Hour,
+	})
+	defer client.Close()
+
+	call := func() error {
+		var reply string
+		return client.Call(context.Background(), "RPCServer.LogInfo", RPCPayload{Name: "test", Data: "data"}, &reply)
+	}
+
+	for i := 0; i < 4; i++ {
+		if err := call(); err != nil {
+			t.Fatalf("call %d: %v", i, err)
+		}
+	}
+
+	if !client.Healthy() {
+		t.Error("expected the client to be healthy")
+	}
+
+	// the logger restarts on the same address
+	server.Close()
+
+	for i := 0; i < 4; i++ {
+		_ = call()
+	}
+
+	server = startRPCServer(t, address)
+	defer server.Close()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for call() != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the client to reconnect to the restarted server")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func Test_RPCClient_backoff(t *testing.T) {
+	server := startRPCServer(t, "127.0.0.1:0")
+	address := server.Address()
+	server.Close()
+
+	client := NewRPCClient(Options{
+		RPCAddress:     address,
+		RPCConnections: 1,
+		MinBackoff:     time.Hour,
+		HealthInterval: time.Hour,
+	})
+	defer client.Close()
+
+	var reply string
+
+	// after a failed dial, by this call or by the first health check, calls fail fast until
+	// the backoff is over
+	_ = client.Call(context.Background(), "RPCServer.LogInfo", RPCPayload{}, &reply)
+
+	err := client.Call(context.Background(), "RPCServer.LogInfo", RPCPayload{}, &reply)
+	if !errors.Is(err, ErrUnavailable) {
+		t.Errorf("expected ErrUnavailable while backing off but got %v", err)
+	}
+}
+
+func Test_RPCClient_close(t *testing.T) {
+	server := startRPCServer(t, "127.0.0.1:0")
+	defer server.Close()
+
+	client := NewRPCClient(Options{RPCAddress: server.Address()})
+	client.Close()
+
+	var reply string
+	if err := client.Call(context.Background(), "RPCServer.LogInfo", RPCPayload{}, &reply); err != ErrClosed {
+		t.Errorf("expected ErrClosed but got %v", err)
+	}
+}
+
+func Test_RPCClient_abandonedReply(t *testing.T) {
+	server := startRPCServer(t, "127.0.0.1:0")
+	defer server.Close()
+
+	client := NewRPCClient(Options{RPCAddress: server.Address(), RPCConnections: 1, HealthInterval: time.Hour})
+	defer client.Close()
+
+	reply := "untouched"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if err := client.Call(ctx, "RPCServer.Slow", 100*time.Millisecond, &reply); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the call to time out but got %v", err)
+	}
+	if reply != "untouched" {
+		t.Errorf("expected a failed call to leave the reply alone but got %q", reply)
+	}
+
+	// a retry with the same reply, while the abandoned call is still running
+	if err := client.Call(context.Background(), "RPCServer.LogInfo", RPCPayload{}, &reply); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+
+	if reply != "Processed payload via RPC" {
+		t.Errorf("expected the abandoned call not to write the reply but got %q", reply)
+	}
+}
+
+func Test_RPCClient_concurrentDial(t *testing.T) {
+	server := startRPCServer(t, "127.0.0.1:0")
+	defer server.Close()
+
+	client := NewRPCClient(Options{RPCAddress: server.Address(), RPCConnections: 1, HealthInterval: time.Hour})
+	defer client.Close()
+
+	// all calls find the connection missing at once; one dials while the others wait for it
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			var reply string
+			errs <- client.Call(context.Background(), "RPCServer.LogInfo", RPCPayload{}, &reply)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("expected every call to succeed but got %v", err)
+		}
+	}
+}
+
+func Test_GRPCClient(t *testing.T) {
+	address, stop := startGRPCServer(t)
+	defer stop()
+
+	client, err := NewGRPCClient(Options{GRPCAddress: address, HealthInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	resp, err := client.WriteLog(context.Background(), &logs.LogRequest{LogEntry: &logs.Log{Name: "test", Data: "data"}})
+	if err != nil || resp.Result != "Processed payload via gRPC" {
+		t.Fatalf("unexpected response %v, %v", resp, err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !client.Healthy() {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the health check to succeed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// The benchmarks compare the shared clients with what the handlers used to do: dial the logger
+// for every request.
+
+func BenchmarkRPC(b *testing.B) {
+	server := startRPCServer(b, "127.0.0.1:0")
+	defer server.Close()
+
+	payload := RPCPayload{Name: "bench", Data: "data"}
+
+	b.Run("dial-per-call", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			client, err := rpc.Dial("tcp", server.Address())
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			var reply string
+			if err := client.Call("RPCServer.LogInfo", payload, &reply); err != nil {
+				b.Fatal(err)
+			}
+			client.Close()
+		}
+	})
+
+	b.Run("pooled", func(b *testing.B) {
+		client := NewRPCClient(Options{RPCAddress: server.Address()})
+		defer client.Close()
+
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				var reply string
+				if err := client.Call(context.Background(), "RPCServer.LogInfo", payload, &reply); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	})
+}
+
+func BenchmarkGRPC(b *testing.B) {
+	address, stop := startGRPCServer(b)
+	defer stop()
+
+	req := &logs.LogRequest{LogEntry: &logs.Log{Name: "bench", Data: "data"}}
+
+	b.Run("dial-per-call", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			conn, err := grpc.Dial(address, grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithBlock())
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			if _, err := logs.NewLogServiceClient(conn).WriteLog(context.Background(), req); err != nil {
+				b.Fatal(err)
+			}
+			conn.Close()
+		}
+	})
+
+	b.Run("pooled", func(b *testing.B) {
+		client, err := NewGRPCClient(Options{GRPCAddress: address})
+		if err != nil {
+			b.Fatal(err)
+		}
+		defer client.Close()
+
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				if _, err := client.WriteLog(context.Background(), req); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	})
+}
@@ -0,0 +1,301 @@
+package logclient
+
+import (
+	"broker/backoff"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/rpc"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// pingMethod is answered by the logger's RPC server without touching its database.
+const pingMethod = "RPCServer.Ping"
+
+// RPCClient spreads net/rpc calls over a fixed number of long-lived connections. A connection
+// that breaks is dropped and redialed on demand, waiting out a growing backoff between
+// failed dials.
+type RPCClient struct {
+	address string
+	timeout time.Duration
+	conns   []*rpcConn
+	next    uint32
+	closed  int32
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// rpcConn is one slot of the pool.
+type rpcConn struct {
+	mu     sync.Mutex
+	client *rpc.Client
+	// dialing is closed once the dial in progress is over; it is nil while there is none
+	dialing chan struct{}
+	backoff backoff.Backoff
+	retryAt time.Time
+	lastErr error
+}
+
+// NewRPCClient returns a client for the RPC address in the options. Connections are dialed
+// by the first health check and on demand.
+func NewRPCClient(opts Options) *RPCClient {
+	opts = opts.withDefaults()
+
+	c := &RPCClient{
+		address: opts.RPCAddress,
+		timeout: opts.DialTimeout,
+		stop:    make(chan struct{}),
+	}
+
+	for i := 0; i < opts.RPCConnections; i++ {
+		c.conns = append(c.conns, &rpcConn{
+			backoff: backoff.Backoff{Min: opts.MinBackoff, Max: opts.MaxBackoff},
+		})
+	}
+
+	c.wg.Add(1)
+	go c.watch(opts.HealthInterval)
+
+	return c
+}
+
+// Call invokes serviceMethod on the logger and waits for the reply or for ctx to be done.
+// A call that finds its connection already shut down is retried once on another connection,
+// as it can't have reached the logger. reply must be a pointer; it is only written when the
+// call succeeds, never by a reply that arrives after Call returned.
+func (c *RPCClient) Call(ctx context.Context, serviceMethod string, args any, reply any) error {
+	err := c.call(ctx, serviceMethod, args, reply)
+	if errors.Is(err, rpc.ErrShutdown) {
+		err = c.call(ctx, serviceMethod, args, reply)
+	}
+
+	return err
+}
+
+// Healthy reports whether at least one connection is established.
+func (c *RPCClient) Healthy() bool {
+	for _, conn := range c.conns {
+		conn.mu.Lock()
+		connected := conn.client != nil
+		conn.mu.Unlock()
+
+		if connected {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Close stops the health checks and closes all connections. Calls in flight fail with
+// rpc.ErrShutdown.
+func (c *RPCClient) Close() error {
+	if !atomic.CompareAndSwapInt32(&c.closed, 0, 1) {
+		return nil
+	}
+
+	close(c.stop)
+	c.wg.Wait()
+
+	for _, conn := range c.conns {
+		conn.mu.Lock()
+		if conn.client != nil {
+			_ = conn.client.Close()
+			conn.client = nil
+		}
+		conn.mu.Unlock()
+	}
+
+	return nil
+}
+
+func (c *RPCClient) call(ctx context.Context, serviceMethod string, args any, reply any) error {
+	if atomic.LoadInt32(&c.closed) == 1 {
+		return ErrClosed
+	}
+
+	target := reflect.ValueOf(reply)
+	if target.Kind() != reflect.Pointer || target.IsNil() {
+		return fmt.Errorf("reply must be a non-nil pointer, not %T", reply)
+	}
+
+	conn := c.conns[atomic.AddUint32(&c.next, 1)%uint32(len(c.conns))]
+
+	client, err := c.connect(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	// the reply is decoded into a value of its own, as net/rpc still writes it after ctx is
+	// done; a retry must not share it with the abandoned call
+	fresh := reflect.New(target.Type().Elem())
+	call := client.Go(serviceMethod, args, fresh.Interface(), make(chan *rpc.Call, 1))
+
+	select {
+	case <-call.Done:
+		err = call.Error
+	case <-ctx.Done():
+		// the reply is discarded when it arrives, the connection stays usable
+		return ctx.Err()
+	}
+
+	if broken(err) {
+		conn.drop(client)
+	}
+	if err == nil {
+		target.Elem().Set(fresh.Elem())
+	}
+
+	return err
+}
+
+// connect returns the client of conn, dialing it unless the last dial failed too recently.
+// The lock of conn is not held while dialing; callers that find a dial in progress wait for
+// it or for ctx to be done.
+func (c *RPCClient) connect(ctx context.Context, conn *rpcConn) (*rpc.Client, error) {
+	for {
+		conn.mu.Lock()
+
+		if conn.client != nil {
+			client := conn.client
+			conn.mu.Unlock()
+			return client, nil
+		}
+
+		if time.Now().Before(conn.retryAt) {
+			err := fmt.Errorf("%w: %v", ErrUnavailable, conn.lastErr)
+			conn.mu.Unlock()
+			return nil, err
+		}
+
+		if dialing := conn.dialing; dialing != nil {
+			conn.mu.Unlock()
+
+			select {
+			case <-dialing:
+				continue
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+
+		dialing := make(chan struct{})
+		conn.dialing = dialing
+		conn.mu.Unlock()
+
+		netConn, err := net.DialTimeout("tcp", c.address, c.timeout)
+
+		return c.dialed(conn, dialing, netConn, err)
+	}
+}
+
+// dialed puts the outcome of a dial into conn and wakes up the callers waiting for it.
+func (c *RPCClient) dialed(conn *rpcConn, dialing chan struct{}, netConn net.Conn, err error) (*rpc.Client, error) {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+
+	conn.dialing = nil
+	close(dialing)
+
+	if err != nil {
+		conn.lastErr = err
+		conn.retryAt = time.Now().Add(conn.backoff.Next())
+		return nil, err
+	}
+
+	// Close sets closed before it takes the lock of each slot, so either it already went past
+	// this one or it will close the new client
+	if atomic.LoadInt32(&c.closed) == 1 {
+		_ = netConn.Close()
+		return nil, ErrClosed
+	}
+
+	conn.client = rpc.NewClient(netConn)
+	conn.backoff.Reset()
+	conn.lastErr = nil
+
+	return conn.client, nil
+}
+
+// drop discards client if it is still the one in the slot, so the next call redials.
+func (conn *rpcConn) drop(client *rpc.Client) {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+
+	if conn.client == client {
+		_ = client.Close()
+		conn.client = nil
+	}
+}
+
+func (c *RPCClient) watch(interval time.Duration) {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for _, conn := range c.conns {
+			c.check(conn)
+		}
+
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// check pings the logger over conn, dialing it first when needed, and drops the connection
+// when the ping doesn't come back. Idle connections that died are noticed here instead of
+// failing the next request.
+func (c *RPCClient) check(conn *rpcConn) {
+	// a dial in progress ends within the dial timeout
+	client, err := c.connect(context.Background(), conn)
+	if err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	var reply string
+	call := client.Go(pingMethod, "", &reply, make(chan *rpc.Call, 1))
+
+	select {
+	case <-call.Done:
+		err = call.Error
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	// an error returned by the server, such as a logger without the ping method, means the
+	// connection works
+	var serverErr rpc.ServerError
+	if err != nil && !errors.As(err, &serverErr) {
+		conn.drop(client)
+	}
+}
+
+// broken reports whether err means the connection can't be used any more.
+func broken(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var serverErr rpc.ServerError
+	if errors.As(err, &serverErr) {
+		return false
+	}
+
+	var netErr net.Error
+	return errors.Is(err, rpc.ErrShutdown) || errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) || errors.As(err, &netErr)
+}
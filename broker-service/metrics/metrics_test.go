@@ -0,0 +1,38 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func Test_Middleware(t *testing.T) {
+	mux := chi.NewRouter()
+	mux.Use(Middleware)
+	mux.Get("/metrics", Handler().ServeHTTP)
+	mux.Post("/v1/logs/{name}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	for _, path := range []string{"/v1/logs/first", "/v1/logs/second", "/nowhere"} {
+		mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, path, nil))
+	}
+
+	if count := testutil.ToFloat64(requests.WithLabelValues(http.MethodPost, "/v1/logs/{name}", "202")); count != 2 {
+		t.Errorf("expected both requests to be counted under the route but got %v", count)
+	}
+	if count := testutil.ToFloat64(requests.WithLabelValues(http.MethodPost, unmatchedRoute, "404")); count != 1 {
+		t.Errorf("expected the unknown path to be counted as unmatched but got %v", count)
+	}
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if !strings.Contains(rr.Body.String(), `http_request_duration_seconds_count{method="POST",route="/v1/logs/{name}",status="202"} 2`) {
+		t.Errorf("expected the latency of the route to be exposed but got:\n%s", rr.Body.String())
+	}
+}
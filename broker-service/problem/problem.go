@@ -0,0 +1,144 @@
+// Package problem describes failed requests as RFC 7807 problem details. A problem records
+// whether the client, a downstream service or the broker itself is to blame, so the status the
+// broker answers with says what went wrong and where.
+package problem
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// ContentType is the media type of a problem response.
+const ContentType = "application/problem+json"
+
+// Class tells who is to blame for a problem.
+type Class string
+
+const (
+	// ClassClient problems were caused by the request, whether the broker or a downstream
+	// service rejected it. They keep their 4xx status.
+	ClassClient Class = "client"
+	// ClassUpstream problems are failures of a downstream service, including responses the
+	// broker can't make sense of. They are answered with 502.
+	ClassUpstream Class = "upstream"
+	// ClassTimeout problems happen when a downstream service doesn't answer in time. They are
+	// answered with 504.
+	ClassTimeout Class = "timeout"
+	// ClassUnavailable problems happen when a downstream service can't be reached or its
+	// circuit breaker is open. They are answered with 503.
+	ClassUnavailable Class = "unavailable"
+	// ClassServer problems are failures of the broker itself.
+	ClassServer Class = "server"
+)
+
+// Problem is a problem details object. It is also an error, so it can be returned through the
+// layers of the broker and written as is.
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	Class  Class  `json:"class"`
+	// Service is the downstream service that caused the problem.
+	Service string `json:"service,omitempty"`
+	// UpstreamStatus is the status the downstream service answered with.
+	UpstreamStatus int `json:"upstream_status,omitempty"`
+}
+
+// New returns a problem raised by the broker itself. The class follows from status.
+func New(status int, detail string) *Problem {
+	return build(classOf(status), status, "", detail)
+}
+
+// Downstream returns the problem for a downstream service that answered with status. Client
+// errors keep their status; a 503 stays unavailable; other failures become 502 Bad Gateway.
+func Downstream(service string, status int, detail string) *Problem {
+	var p *Problem
+
+	switch {
+	case status >= 400 && status < 500:
+		p = build(ClassClient, status, service, detail)
+	case status == http.StatusServiceUnavailable:
+		p = build(ClassUnavailable, http.StatusServiceUnavailable, service, detail)
+	default:
+		p = build(ClassUpstream, http.StatusBadGateway, service, detail)
+	}
+	p.UpstreamStatus = status
+
+	return p
+}
+
+// Upstream returns the problem for a downstream service that failed without a usable status,
+// for example by sending a response the broker couldn't read.
+func Upstream(service, detail string) *Problem {
+	return build(ClassUpstream, http.StatusBadGateway, service, detail)
+}
+
+// Timeout returns the problem for a downstream service that didn't answer in time.
+func Timeout(service, detail string) *Problem {
+	return build(ClassTimeout, http.StatusGatewayTimeout, service, detail)
+}
+
+// Unavailable returns the problem for a downstream service that can't be reached.
+func Unavailable(service, detail string) *Problem {
+	return build(ClassUnavailable, http.StatusServiceUnavailable, service, detail)
+}
+
+func build(class Class, status int, service, detail string) *Problem {
+	return &Problem{
+		Type:    "urn:broker:problem:" + string(class),
+		Title:   http.StatusText(status),
+		Status:  status,
+		Detail:  detail,
+		Class:   class,
+		Service: service,
+	}
+}
+
+func classOf(status int) Class {
+	switch {
+	case status < 500:
+		return ClassClient
+	case status == http.StatusBadGateway:
+		return ClassUpstream
+	case status == http.StatusServiceUnavailable:
+		return ClassUnavailable
+	case status == http.StatusGatewayTimeout:
+		return ClassTimeout
+	default:
+		return ClassServer
+	}
+}
+
+func (p *Problem) Error() string {
+	if p.Service != "" {
+		return fmt.Sprintf("%s: %s", p.Service, p.message())
+	}
+
+	return p.message()
+}
+
+func (p *Problem) message() string {
+	if p.Detail != "" {
+		return p.Detail
+	}
+
+	return p.Title
+}
+
+// MarshalJSON adds the error and message members of the broker's older JSON envelope, so
+// clients that only know that envelope keep working.
+func (p *Problem) MarshalJSON() ([]byte, error) {
+	type problem Problem
+
+	return json.Marshal(struct {
+		*problem
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}{
+		problem: (*problem)(p),
+		Error:   true,
+		Message: p.Error(),
+	})
+}
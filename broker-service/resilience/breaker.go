@@ -0,0 +1,181 @@
+package resilience
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrOpen is returned instead of calling a dependency whose circuit breaker is open.
+var ErrOpen = errors.New("circuit breaker is open")
+
+// State is the state of a circuit breaker.
+type State int
+
+const (
+	// Closed lets all calls through and counts consecutive failures.
+	Closed State = iota
+	// Open rejects all calls until OpenTimeout has passed.
+	Open
+	// HalfOpen lets a limited number of probe calls through; their outcome decides whether the
+	// breaker closes or opens again.
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+func (s State) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Outcome is the result of a call let through by a breaker.
+type Outcome int
+
+const (
+	Success Outcome = iota
+	Failure
+	// Abandoned calls were given up by the caller and say nothing about the dependency.
+	Abandoned
+)
+
+// BreakerOptions configure a Breaker. Zero values are replaced by the defaults below.
+type BreakerOptions struct {
+	// FailureThreshold is the number of consecutive failures that opens the breaker.
+	FailureThreshold int
+	// OpenTimeout is how long the breaker stays open before it lets probes through.
+	OpenTimeout time.Duration
+	// HalfOpenProbes is the number of calls let through at the same time while half-open.
+	HalfOpenProbes int
+}
+
+func (o BreakerOptions) withDefaults() BreakerOptions {
+	if o.FailureThreshold <= 0 {
+		o.FailureThreshold = 5
+	}
+	if o.OpenTimeout <= 0 {
+		o.OpenTimeout = 30 * time.Second
+	}
+	if o.HalfOpenProbes <= 0 {
+		o.HalfOpenProbes = 1
+	}
+
+	return o
+}
+
+// Breaker is a circuit breaker. It opens after FailureThreshold consecutive failures, so calls
+// to a dependency that is down fail fast instead of piling up. After OpenTimeout it turns
+// half-open and lets probes through: a successful probe closes it, a failed one opens it again.
+type Breaker struct {
+	opts BreakerOptions
+	now  func() time.Time
+
+	mu         sync.Mutex
+	state      State
+	failures   int
+	probes     int
+	generation uint64
+	changedAt  time.Time
+}
+
+// BreakerStatus is a snapshot of a Breaker.
+type BreakerStatus struct {
+	State    State     `json:"state"`
+	Failures int       `json:"consecutive_failures"`
+	Since    time.Time `json:"since"`
+}
+
+func NewBreaker(opts BreakerOptions) *Breaker {
+	return &Breaker{
+		opts:      opts.withDefaults(),
+		now:       time.Now,
+		changedAt: time.Now(),
+	}
+}
+
+// Allow asks to make a call. If the call may go ahead, the returned function must be called
+// with its outcome; otherwise ErrOpen is returned.
+func (b *Breaker) Allow() (func(Outcome), error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == Open {
+		if b.now().Sub(b.changedAt) < b.opts.OpenTimeout {
+			return nil, ErrOpen
+		}
+		b.setState(HalfOpen)
+	}
+
+	if b.state == HalfOpen {
+		if b.probes >= b.opts.HalfOpenProbes {
+			return nil, ErrOpen
+		}
+		b.probes++
+	}
+
+	generation := b.generation
+	return func(outcome Outcome) {
+		b.record(generation, outcome)
+	}, nil
+}
+
+// Status returns the current state of the breaker.
+func (b *Breaker) Status() BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return BreakerStatus{
+		State:    b.state,
+		Failures: b.failures,
+		Since:    b.changedAt,
+	}
+}
+
+func (b *Breaker) record(generation uint64, outcome Outcome) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// the state changed while the call was running, so its outcome no longer matters
+	if generation != b.generation {
+		return
+	}
+
+	switch b.state {
+	case Closed:
+		switch outcome {
+		case Success:
+			b.failures = 0
+		case Failure:
+			b.failures++
+			if b.failures >= b.opts.FailureThreshold {
+				b.setState(Open)
+			}
+		}
+	case HalfOpen:
+		b.probes--
+		switch outcome {
+		case Success:
+			b.setState(Closed)
+		case Failure:
+			b.setState(Open)
+		}
+	}
+}
+
+func (b *Breaker) setState(state State) {
+	b.state = state
+	b.failures = 0
+	b.probes = 0
+	b.generation++
+	b.changedAt = b.now()
+}
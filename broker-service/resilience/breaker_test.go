@@ -0,0 +1,101 @@
+package resilience
+
+import (
+	"testing"
+	"time"
+)
+
+// testBreaker returns a breaker with a clock the test moves by hand.
+func testBreaker(opts BreakerOptions) (*Breaker, *time.Time) {
+	now := time.Now()
+	b := NewBreaker(opts)
+	b.now = func() time.Time { return now }
+	b.changedAt = now
+
+	return b, &now
+}
+
+func call(t *testing.T, b *Breaker, outcome Outcome) {
+	t.Helper()
+
+	done, err := b.Allow()
+	if err != nil {
+		t.Fatalf("expected the call to be allowed but got %v", err)
+	}
+	done(outcome)
+}
+
+func Test_Breaker(t *testing.T) {
+	b, now := testBreaker(BreakerOptions{FailureThreshold: 3, OpenTimeout: time.Minute})
+
+	call(t, b, Failure)
+	call(t, b, Failure)
+	call(t, b, Success)
+	if status := b.Status(); status.State != Closed || status.Failures != 0 {
+		t.Fatalf("expected a success to reset the failures but got %+v", status)
+	}
+
+	for i := 0; i < 3; i++ {
+		call(t, b, Failure)
+	}
+	if state := b.Status().State; state != Open {
+		t.Fatalf("expected the breaker to open after 3 failures but it is %s", state)
+	}
+
+	if _, err := b.Allow(); err != ErrOpen {
+		t.Fatalf("expected ErrOpen but got %v", err)
+	}
+
+	// after the timeout a single probe is let through
+	*now = now.Add(time.Minute)
+
+	probe, err := b.Allow()
+	if err != nil {
+		t.Fatalf("expected a probe to be allowed but got %v", err)
+	}
+	if _, err := b.Allow(); err != ErrOpen {
+		t.Fatalf("expected a second probe to be rejected but got %v", err)
+	}
+
+	probe(Failure)
+	if state := b.Status().State; state != Open {
+		t.Fatalf("expected a failed probe to open the breaker again but it is %s", state)
+	}
+
+	*now = now.Add(time.Minute)
+	call(t, b, Success)
+	if state := b.Status().State; state != Closed {
+		t.Fatalf("expected a successful probe to close the breaker but it is %s", state)
+	}
+}
+
+func Test_Breaker_abandonedProbe(t *testing.T) {
+	b, now := testBreaker(BreakerOptions{FailureThreshold: 1, OpenTimeout: time.Minute})
+
+	call(t, b, Failure)
+	*now = now.Add(time.Minute)
+
+	call(t, b, Abandoned)
+	if state := b.Status().State; state != HalfOpen {
+		t.Fatalf("expected an abandoned probe to keep the breaker half-open but it is %s", state)
+	}
+
+	// the probe slot was freed
+	call(t, b, Success)
+	if state := b.Status().State; state != Closed {
+		t.Fatalf("expected the breaker to close but it is %s", state)
+	}
+}
+
+func Test_Breaker_staleOutcome(t *testing.T) {
+	b, _ := testBreaker(BreakerOptions{FailureThreshold: 1})
+
+	slow, _ := b.Allow()
+	call(t, b, Failure)
+
+	// a call that started before the breaker opened doesn't close it
+	slow(Success)
+	if state := b.Status().State; state != Open {
+		t.Fatalf("expected the breaker to stay open but it is %s", state)
+	}
+}
@@ -0,0 +1,137 @@
+// Package resilience guards the broker's calls to other services. Every dependency gets a
+// timeout per call and a circuit breaker; calls that are safe to repeat are retried with
+// jittered backoff.
+package resilience
+
+import (
+	"broker/backoff"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Options configure a Dependency. Zero values are replaced by the defaults below.
+type Options struct {
+	// Timeout bounds a single attempt.
+	Timeout time.Duration
+	// Attempts is the number of times an idempotent call is tried.
+	Attempts int
+	// MinBackoff and MaxBackoff bound the delay between attempts.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	Breaker    BreakerOptions
+	// Healthy optionally reports the result of a background health check.
+	Healthy func() bool
+}
+
+func (o Options) withDefaults() Options {
+	if o.Timeout <= 0 {
+		o.Timeout = 5 * time.Second
+	}
+	if o.Attempts <= 0 {
+		o.Attempts = 3
+	}
+	if o.MinBackoff <= 0 {
+		o.MinBackoff = 100 * time.Millisecond
+	}
+	if o.MaxBackoff <= 0 {
+		o.MaxBackoff = time.Second
+	}
+
+	return o
+}
+
+// Dependency is a service the broker calls.
+type Dependency struct {
+	name    string
+	opts    Options
+	breaker *Breaker
+}
+
+// Status describes a dependency and the state of its breaker.
+type Status struct {
+	Name    string `json:"name"`
+	Timeout string `json:"timeout"`
+	Healthy *bool  `json:"healthy,omitempty"`
+	BreakerStatus
+}
+
+func New(name string, opts Options) *Dependency {
+	opts = opts.withDefaults()
+
+	return &Dependency{
+		name:    name,
+		opts:    opts,
+		breaker: NewBreaker(opts.Breaker),
+	}
+}
+
+// Name returns the name of the dependency.
+func (d *Dependency) Name() string {
+	return d.name
+}
+
+// Call makes a single attempt of fn. Use it for calls that must not be repeated, such as
+// sending an email.
+func (d *Dependency) Call(ctx context.Context, fn func(ctx context.Context) error) error {
+	return d.attempt(ctx, fn)
+}
+
+// CallIdempotent tries fn up to Attempts times, waiting a jittered backoff between attempts.
+// It stops early when the breaker opens or ctx ends.
+func (d *Dependency) CallIdempotent(ctx context.Context, fn func(ctx context.Context) error) error {
+	retry := backoff.Backoff{Min: d.opts.MinBackoff, Max: d.opts.MaxBackoff}
+
+	for attempt := 1; ; attempt++ {
+		err := d.attempt(ctx, fn)
+		if err == nil || errors.Is(err, ErrOpen) || ctx.Err() != nil || attempt >= d.opts.Attempts {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(retry.Next()):
+		}
+	}
+}
+
+// Status returns the current state of the dependency.
+func (d *Dependency) Status() Status {
+	status := Status{
+		Name:          d.name,
+		Timeout:       d.opts.Timeout.String(),
+		BreakerStatus: d.breaker.Status(),
+	}
+
+	if d.opts.Healthy != nil {
+		healthy := d.opts.Healthy()
+		status.Healthy = &healthy
+	}
+
+	return status
+}
+
+func (d *Dependency) attempt(ctx context.Context, fn func(ctx context.Context) error) error {
+	done, err := d.breaker.Allow()
+	if err != nil {
+		return fmt.Errorf("%s: %w", d.name, err)
+	}
+
+	callCtx, cancel := context.WithTimeout(ctx, d.opts.Timeout)
+	defer cancel()
+
+	err = fn(callCtx)
+	switch {
+	case err == nil:
+		done(Success)
+	case ctx.Err() != nil:
+		// the caller gave up, which says nothing about the dependency
+		done(Abandoned)
+	default:
+		done(Failure)
+	}
+
+	return err
+}
@@ -0,0 +1,103 @@
+package resilience
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+var errBroken = errors.New("broken")
+
+func testDependency(opts Options) *Dependency {
+	opts.MinBackoff = time.Millisecond
+	opts.MaxBackoff = time.Millisecond
+
+	return New("test", opts)
+}
+
+func Test_CallIdempotent_retries(t *testing.T) {
+	d := testDependency(Options{Attempts: 3})
+
+	calls := 0
+	err := d.CallIdempotent(context.Background(), func(ctx context.Context) error {
+		calls++
+		if calls < 3 {
+			return errBroken
+		}
+		return nil
+	})
+
+	if err != nil || calls != 3 {
+		t.Errorf("expected success on the third attempt but got %v after %d calls", err, calls)
+	}
+}
+
+func Test_Call_doesNotRetry(t *testing.T) {
+	d := testDependency(Options{Attempts: 3})
+
+	calls := 0
+	err := d.Call(context.Background(), func(ctx context.Context) error {
+		calls++
+		return errBroken
+	})
+
+	if err != errBroken || calls != 1 {
+		t.Errorf("expected a single failed attempt but got %v after %d calls", err, calls)
+	}
+}
+
+func Test_Call_timeout(t *testing.T) {
+	d := testDependency(Options{Timeout: 10 * time.Millisecond, Attempts: 2})
+
+	calls := 0
+	err := d.CallIdempotent(context.Background(), func(ctx context.Context) error {
+		calls++
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	if !errors.Is(err, context.DeadlineExceeded) || calls != 2 {
+		t.Errorf("expected both attempts to time out but got %v after %d calls", err, calls)
+	}
+
+	if failures := d.Status().Failures; failures != 2 {
+		t.Errorf("expected timeouts to count as failures but got %d", failures)
+	}
+}
+
+func Test_Call_breakerOpens(t *testing.T) {
+	d := testDependency(Options{Attempts: 5, Breaker: BreakerOptions{FailureThreshold: 2}})
+
+	calls := 0
+	err := d.CallIdempotent(context.Background(), func(ctx context.Context) error {
+		calls++
+		return errBroken
+	})
+
+	if !errors.Is(err, ErrOpen) || calls != 2 {
+		t.Errorf("expected the breaker to stop the retries after 2 calls but got %v after %d calls", err, calls)
+	}
+
+	if state := d.Status().State; state != Open {
+		t.Errorf("expected the breaker to be open but it is %s", state)
+	}
+}
+
+func Test_Call_canceledByCaller(t *testing.T) {
+	d := testDependency(Options{Breaker: BreakerOptions{FailureThreshold: 1}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err := d.CallIdempotent(ctx, func(ctx context.Context) error {
+		cancel()
+		return ctx.Err()
+	})
+
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the call to be canceled but got %v", err)
+	}
+
+	if state := d.Status().State; state != Closed {
+		t.Errorf("expected a canceled call not to open the breaker but it is %s", state)
+	}
+}
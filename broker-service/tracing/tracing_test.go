@@ -0,0 +1,48 @@
+package tracing
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func Test_Middleware(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	mux := chi.NewRouter()
+	mux.Use(Middleware)
+	mux.Post("/v1/logs/{name}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	})
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/logs/event", nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	mux.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("expected one span but got %d", len(spans))
+	}
+	span := spans[0]
+
+	if span.Name() != "POST /v1/logs/{name}" {
+		t.Errorf("expected the span to be named after the route but got %q", span.Name())
+	}
+	if span.SpanContext().TraceID().String() != traceID {
+		t.Errorf("expected the span to continue trace %s but got %s", traceID, span.SpanContext().TraceID())
+	}
+	if span.Status().Code != codes.Error {
+		t.Errorf("expected a 502 to mark the span as failed but got %v", span.Status().Code)
+	}
+}
@@ -0,0 +1,51 @@
+// Package backoff computes the delays between reconnection attempts: exponential growth with
+// random jitter, so replicas that lost the same dependency don't all retry in lockstep.
+package backoff
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+var (
+	randMu sync.Mutex
+	random = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// Backoff hands out growing delays between Min and Max. It is not safe for concurrent use.
+type Backoff struct {
+	Min time.Duration
+	Max time.Duration
+
+	attempt int
+}
+
+// Next returns the delay before the next attempt. The upper bound doubles with every call until
+// it reaches Max; the delay itself is picked at random between half the bound and the bound.
+func (b *Backoff) Next() time.Duration {
+	bound := b.Min
+	for i := 0; i < b.attempt && bound < b.Max; i++ {
+		bound *= 2
+	}
+	if bound > b.Max {
+		bound = b.Max
+	}
+	b.attempt++
+
+	if bound <= 0 {
+		return 0
+	}
+
+	half := bound / 2
+
+	randMu.Lock()
+	defer randMu.Unlock()
+
+	return half + time.Duration(random.Int63n(int64(bound-half)+1))
+}
+
+// Reset starts over at Min after a successful attempt.
+func (b *Backoff) Reset() {
+	b.attempt = 0
+}
@@ -0,0 +1,329 @@
+// Package config loads typed settings from defaults, a YAML file, the environment and flags.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FileEnv names the environment variable with the path of the YAML file; the --config flag
+// takes precedence over it.
+const FileEnv = "CONFIG_FILE"
+
+// redacted replaces secrets when the settings are printed.
+const redacted = "xxxxx"
+
+// ErrPrintConfig is returned by Load when --print-config was given. The settings have been
+// loaded, but not validated.
+var ErrPrintConfig = errors.New("print config requested")
+
+// Validator is implemented by settings that check more than the presence of required fields.
+type Validator interface {
+	Validate() error
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+type field struct {
+	key      string
+	env      string
+	flag     string
+	usage    string
+	def      string
+	secret   bool
+	required bool
+	value    reflect.Value
+}
+
+// Parse loads the settings from the command line of the process, like flag.Parse. It prints the
+// settings and exits for --print-config, and exits with a message when the settings are invalid.
+// It returns the arguments left after the flags.
+func Parse(settings interface{}) []string {
+	args, err := Load(settings, os.Args[1:])
+	switch {
+	case err == nil:
+		return args
+	case errors.Is(err, flag.ErrHelp):
+		os.Exit(0)
+	case errors.Is(err, ErrPrintConfig):
+		if err := Print(os.Stdout, settings); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	fmt.Fprintf(os.Stderr, "invalid configuration: %v\n", err)
+	os.Exit(2)
+	return nil
+}
+
+// Load fills settings, a pointer to a struct, and validates them. Each field is read from its
+// default tag, the YAML file, its env variable and its flag, the last one set winning; the flag
+// is the yaml key with dashes. It returns the arguments left after the flags.
+func Load(settings interface{}, args []string) ([]string, error) {
+	fields, err := fieldsOf(settings)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range fields {
+		if f.def == "" {
+			continue
+		}
+		if err := f.set(f.def); err != nil {
+			return nil, fmt.Errorf("default of %s: %w", f.key, err)
+		}
+	}
+
+	flags := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	file := flags.String("config", os.Getenv(FileEnv), "YAML file with settings (env "+FileEnv+")")
+	printConfig := flags.Bool("print-config", false, "print the settings with secrets redacted and exit")
+
+	values := make([]*flagValue, len(fields))
+	for i, f := range fields {
+		values[i] = &flagValue{field: f}
+		usage := f.usage
+		if f.env != "" {
+			usage = strings.TrimSpace(fmt.Sprintf("%s (env %s)", usage, f.env))
+		}
+		flags.Var(values[i], f.flag, usage)
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *file != "" {
+		if err := readFile(*file, settings); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, f := range fields {
+		if f.env == "" {
+			continue
+		}
+		if value, ok := os.LookupEnv(f.env); ok {
+			if err := f.set(value); err != nil {
+				return nil, fmt.Errorf("%s: %w", f.env, err)
+			}
+		}
+	}
+
+	for _, v := range values {
+		if v.set {
+			if err := v.field.set(v.raw); err != nil {
+				return nil, fmt.Errorf("--%s: %w", v.field.flag, err)
+			}
+		}
+	}
+
+	if *printConfig {
+		return flags.Args(), ErrPrintConfig
+	}
+
+	if err := validate(settings, fields); err != nil {
+		return nil, err
+	}
+
+	return flags.Args(), nil
+}
+
+// Print writes settings as YAML, with the secrets redacted. Only the passwords of URLs are
+// replaced; other secrets are replaced as a whole.
+func Print(w io.Writer, settings interface{}) error {
+	fields, err := fieldsOf(settings)
+	if err != nil {
+		return err
+	}
+
+	doc := &yaml.Node{Kind: yaml.MappingNode}
+	for _, f := range fields {
+		var value interface{}
+		switch {
+		case f.secret:
+			value = redact(f.value.String())
+		case f.value.Type() == durationType:
+			value = time.Duration(f.value.Int()).String()
+		default:
+			value = f.value.Interface()
+		}
+
+		valueNode := &yaml.Node{}
+		if err := valueNode.Encode(value); err != nil {
+			return fmt.Errorf("%s: %w", f.key, err)
+		}
+		doc.Content = append(doc.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: f.key}, valueNode)
+	}
+
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(doc); err != nil {
+		return err
+	}
+
+	return encoder.Close()
+}
+
+func fieldsOf(settings interface{}) ([]field, error) {
+	v := reflect.ValueOf(settings)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("config: settings must be a pointer to a struct, not %T", settings)
+	}
+	v = v.Elem()
+
+	var fields []field
+	for i := 0; i < v.NumField(); i++ {
+		structField := v.Type().Field(i)
+		key := strings.Split(structField.Tag.Get("yaml"), ",")[0]
+		if key == "" || key == "-" {
+			continue
+		}
+
+		fields = append(fields, field{
+			key:      key,
+			env:      structField.Tag.Get("env"),
+			flag:     strings.ReplaceAll(key, "_", "-"),
+			usage:    structField.Tag.Get("usage"),
+			def:      structField.Tag.Get("default"),
+			secret:   structField.Tag.Get("secret") == "true",
+			required: structField.Tag.Get("required") == "true",
+			value:    v.Field(i),
+		})
+	}
+
+	return fields, nil
+}
+
+func (f field) set(raw string) error {
+	switch {
+	case f.value.Type() == durationType:
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		f.value.SetInt(int64(d))
+	case f.value.Kind() == reflect.String:
+		f.value.SetString(raw)
+	case f.value.Kind() == reflect.Int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", raw)
+		}
+		f.value.SetInt(int64(n))
+	case f.value.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", raw)
+		}
+		f.value.SetBool(b)
+	case f.value.Kind() == reflect.Slice && f.value.Type().Elem().Kind() == reflect.String:
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		f.value.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported type %s", f.value.Type())
+	}
+
+	return nil
+}
+
+func readFile(path string, settings interface{}) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// unknown keys are most likely typos, which would silently leave a setting at its default
+	decoder := yaml.NewDecoder(file)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(settings); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	return nil
+}
+
+func validate(settings interface{}, fields []field) error {
+	var missing []string
+	for _, f := range fields {
+		if f.required && f.value.IsZero() {
+			name := "--" + f.flag
+			if f.env != "" {
+				name = f.env
+			}
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing required settings: %s", strings.Join(missing, ", "))
+	}
+
+	if validator, ok := settings.(Validator); ok {
+		return validator.Validate()
+	}
+
+	return nil
+}
+
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+
+	// a URL is only secret for its password
+	if u, err := url.Parse(secret); err == nil && u.Scheme != "" && u.Host != "" {
+		return u.Redacted()
+	}
+
+	return redacted
+}
+
+// flagValue records a flag, so it can be applied after the file and the environment.
+type flagValue struct {
+	field field
+	raw   string
+	set   bool
+}
+
+func (v *flagValue) String() string {
+	if v == nil || v.field.secret {
+		return ""
+	}
+
+	return v.field.def
+}
+
+func (v *flagValue) Set(raw string) error {
+	// parse into a scratch value, so mistakes are reported with the flag usage
+	scratch := v.field
+	scratch.value = reflect.New(v.field.value.Type()).Elem()
+	if err := scratch.set(raw); err != nil {
+		return err
+	}
+
+	v.raw = raw
+	v.set = true
+
+	return nil
+}
+
+func (v *flagValue) IsBoolFlag() bool {
+	return v.field.value.Kind() == reflect.Bool
+}
@@ -0,0 +1,259 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"listener/backoff"
+	"log"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+var (
+	// ErrNotConnected is returned by Channel while the connection to RabbitMQ is being
+	// re-established.
+	ErrNotConnected = errors.New("not connected to RabbitMQ")
+	// ErrConnectionClosed is returned once the connection has been closed for good.
+	ErrConnectionClosed = errors.New("connection to RabbitMQ is closed")
+)
+
+// ConnectionOptions configure a Connection. Zero values are replaced by the defaults below.
+type ConnectionOptions struct {
+	// Attempts is the number of times Connect tries to reach RabbitMQ before giving up.
+	Attempts int
+	// MinBackoff and MaxBackoff bound the delay between connection attempts.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+func (o ConnectionOptions) withDefaults() ConnectionOptions {
+	if o.Attempts <= 0 {
+		o.Attempts = 6
+	}
+	if o.MinBackoff <= 0 {
+		o.MinBackoff = 500 * time.Millisecond
+	}
+	if o.MaxBackoff <= 0 {
+		o.MaxBackoff = 30 * time.Second
+	}
+
+	return o
+}
+
+// Connection is a connection to RabbitMQ that is re-established whenever it is lost. After
+// every connect the logs_topic exchange is declared again, so the topology is in place even
+// if RabbitMQ came back empty. Channels opened on a lost connection are not recovered; callers
+// open new ones once Ready is closed again.
+type Connection struct {
+	dial func() (amqpConnection, <-chan *amqp.Error, error)
+	opts ConnectionOptions
+
+	mu     sync.Mutex
+	conn   amqpConnection
+	ready  chan struct{}
+	closed bool
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// Connect connects to RabbitMQ, retrying with backoff if it isn't reachable yet, and keeps
+// the connection up until Close is called.
+func Connect(url string, opts ConnectionOptions) (*Connection, error) {
+	return connect(func() (amqpConnection, <-chan *amqp.Error, error) {
+		return dial(url)
+	}, opts)
+}
+
+func connect(dial func() (amqpConnection, <-chan *amqp.Error, error), opts ConnectionOptions) (*Connection, error) {
+	opts = opts.withDefaults()
+
+	c := &Connection{
+		dial:  dial,
+		opts:  opts,
+		ready: make(chan struct{}),
+		stop:  make(chan struct{}),
+	}
+
+	retry := backoff.Backoff{Min: opts.MinBackoff, Max: opts.MaxBackoff}
+
+	for attempt := 1; ; attempt++ {
+		conn, closes, err := c.dial()
+		if err == nil {
+			log.Println("Connected to RabbitMQ!")
+
+			c.conn = conn
+			close(c.ready)
+
+			c.wg.Add(1)
+			go c.supervise(closes)
+
+			return c, nil
+		}
+
+		if attempt >= opts.Attempts {
+			return nil, fmt.Errorf("failed to connect to RabbitMQ after %d attempts: %w", attempt, err)
+		}
+
+		delay := retry.Next()
+		log.Printf("Failed to connect to RabbitMQ. Retrying in %v...", delay)
+		time.Sleep(delay)
+	}
+}
+
+// Channel opens a channel on the current connection. It returns ErrNotConnected while the
+// connection is down.
+func (c *Connection) Channel() (*amqp.Channel, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil, ErrConnectionClosed
+	}
+	if c.conn == nil {
+		return nil, ErrNotConnected
+	}
+
+	return c.conn.Channel()
+}
+
+// Ready returns a channel that is closed while the connection is up.
+func (c *Connection) Ready() <-chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.ready
+}
+
+// Check reports whether the connection is up. It is the readiness check of RabbitMQ.
+func (c *Connection) Check(ctx context.Context) error {
+	// Ready may still be closed after Close, so Done is looked at on its own first
+	select {
+	case <-c.Done():
+		return ErrConnectionClosed
+	default:
+	}
+
+	select {
+	case <-c.Ready():
+		return nil
+	default:
+		return ErrNotConnected
+	}
+}
+
+// Done returns a channel that is closed when the connection is closed for good.
+func (c *Connection) Done() <-chan struct{} {
+	return c.stop
+}
+
+// Close stops reconnecting and closes the connection.
+func (c *Connection) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	conn := c.conn
+	c.conn = nil
+	c.mu.Unlock()
+
+	close(c.stop)
+	c.wg.Wait()
+
+	if conn == nil {
+		return nil
+	}
+
+	return conn.Close()
+}
+
+// supervise waits for the connection to be lost and reconnects with backoff until it succeeds
+// or the connection is closed.
+func (c *Connection) supervise(closes <-chan *amqp.Error) {
+	defer c.wg.Done()
+
+	retry := backoff.Backoff{Min: c.opts.MinBackoff, Max: c.opts.MaxBackoff}
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case err := <-closes:
+			log.Printf("Lost connection to RabbitMQ: %v", err)
+		}
+
+		c.mu.Lock()
+		if c.closed {
+			c.mu.Unlock()
+			return
+		}
+		c.conn = nil
+		c.ready = make(chan struct{})
+		c.mu.Unlock()
+
+		retry.Reset()
+
+		for {
+			select {
+			case <-c.stop:
+				return
+			case <-time.After(retry.Next()):
+			}
+
+			conn, reconnectedCloses, err := c.dial()
+			if err != nil {
+				log.Printf("Failed to reconnect to RabbitMQ: %v", err)
+				continue
+			}
+
+			c.mu.Lock()
+			if c.closed {
+				c.mu.Unlock()
+				_ = conn.Close()
+				return
+			}
+			c.conn = conn
+			close(c.ready)
+			c.mu.Unlock()
+
+			log.Println("Reconnected to RabbitMQ!")
+			closes = reconnectedCloses
+			break
+		}
+	}
+}
+
+// amqpConnection is the part of an AMQP connection a Connection uses.
+type amqpConnection interface {
+	Channel() (*amqp.Channel, error)
+	Close() error
+}
+
+// dial opens a connection and declares the exchange on it.
+func dial(url string) (amqpConnection, <-chan *amqp.Error, error) {
+	conn, err := amqp.Dial(url)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	closes := conn.NotifyClose(make(chan *amqp.Error, 1))
+
+	channel, err := conn.Channel()
+	if err != nil {
+		_ = conn.Close()
+		return nil, nil, err
+	}
+	defer channel.Close()
+
+	if err := declareExchange(channel); err != nil {
+		_ = conn.Close()
+		return nil, nil, err
+	}
+
+	return conn, closes, nil
+}
@@ -0,0 +1,239 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// fakeAMQP stands in for RabbitMQ: dials fail while it is down, and every connection it hands
+// out can be dropped through its close notifications.
+type fakeAMQP struct {
+	mu     sync.Mutex
+	down   bool
+	dials  int
+	conns  []*fakeAMQPConnection
+	closes []chan *amqp.Error
+}
+
+func (f *fakeAMQP) setDown(down bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.down = down
+}
+
+func (f *fakeAMQP) dial() (amqpConnection, <-chan *amqp.Error, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.dials++
+	if f.down {
+		return nil, nil, errors.New("connection refused")
+	}
+
+	conn := &fakeAMQPConnection{}
+	closes := make(chan *amqp.Error, 1)
+	f.conns = append(f.conns, conn)
+	f.closes = append(f.closes, closes)
+
+	return conn, closes, nil
+}
+
+// drop loses the latest connection.
+func (f *fakeAMQP) drop() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.closes[len(f.closes)-1] <- amqp.ErrClosed
+}
+
+func (f *fakeAMQP) connections() []*fakeAMQPConnection {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]*fakeAMQPConnection(nil), f.conns...)
+}
+
+type fakeAMQPConnection struct {
+	mu     sync.Mutex
+	closed bool
+}
+
+func (c *fakeAMQPConnection) Channel() (*amqp.Channel, error) {
+	return nil, errors.New("fake connections have no channels")
+}
+
+func (c *fakeAMQPConnection) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closed = true
+	return nil
+}
+
+func (c *fakeAMQPConnection) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.closed
+}
+
+var testConnectionOptions = ConnectionOptions{
+	Attempts:   3,
+	MinBackoff: time.Millisecond,
+	MaxBackoff: 5 * time.Millisecond,
+}
+
+// waitFor polls check until it returns nil or two seconds have passed.
+func waitFor(t *testing.T, what string, check func() error) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		err := check()
+		if err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s: %v", what, err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func Test_Connect_givesUp(t *testing.T) {
+	rabbit := &fakeAMQP{down: true}
+
+	_, err := connect(rabbit.dial, testConnectionOptions)
+	if err == nil {
+		t.Fatal("expected connecting to fail")
+	}
+
+	if !strings.Contains(err.Error(), "after 3 attempts") {
+		t.Errorf("expected the error to mention the attempts but got %v", err)
+	}
+}
+
+func Test_Connection_reconnects(t *testing.T) {
+	rabbit := &fakeAMQP{}
+
+	c, err := connect(rabbit.dial, testConnectionOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	ctx := context.Background()
+	if err := c.Check(ctx); err != nil {
+		t.Fatalf("expected the connection to be up but got %v", err)
+	}
+
+	// RabbitMQ goes away: the connection is down until it can be dialled again
+	rabbit.setDown(true)
+	rabbit.drop()
+
+	waitFor(t, "expected the connection to go down", func() error {
+		if err := c.Check(ctx); err != ErrNotConnected {
+			return errors.New("still up")
+		}
+		return nil
+	})
+
+	if _, err := c.Channel(); err != ErrNotConnected {
+		t.Errorf("expected ErrNotConnected while down but got %v", err)
+	}
+
+	waitFor(t, "expected redials while RabbitMQ is down", func() error {
+		rabbit.mu.Lock()
+		defer rabbit.mu.Unlock()
+
+		if rabbit.dials < 3 {
+			return errors.New("too few dials")
+		}
+		return nil
+	})
+
+	rabbit.setDown(false)
+
+	select {
+	case <-c.Ready():
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the connection to come back")
+	}
+
+	conns := rabbit.connections()
+	if len(conns) != 2 {
+		t.Fatalf("expected 2 connections but got %d", len(conns))
+	}
+
+	// the new connection is watched as well
+	rabbit.drop()
+	rabbit.setDown(false)
+
+	waitFor(t, "expected a third connection", func() error {
+		if len(rabbit.connections()) != 3 {
+			return errors.New("not reconnected")
+		}
+		return c.Check(ctx)
+	})
+
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	conns = rabbit.connections()
+	if !conns[2].isClosed() {
+		t.Error("expected Close to close the current connection")
+	}
+	if err := c.Check(ctx); err != ErrConnectionClosed {
+		t.Errorf("expected ErrConnectionClosed after Close but got %v", err)
+	}
+	if _, err := c.Channel(); err != ErrConnectionClosed {
+		t.Errorf("expected Channel to return ErrConnectionClosed after Close but got %v", err)
+	}
+}
+
+func Test_Connection_closeWhileReconnecting(t *testing.T) {
+	rabbit := &fakeAMQP{}
+
+	c, err := connect(rabbit.dial, testConnectionOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rabbit.setDown(true)
+	rabbit.drop()
+
+	waitFor(t, "expected the connection to go down", func() error {
+		if err := c.Check(context.Background()); err != ErrNotConnected {
+			return errors.New("still up")
+		}
+		return nil
+	})
+
+	closed := make(chan error, 1)
+	go func() {
+		closed <- c.Close()
+	}()
+
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Errorf("expected Close to succeed but got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Close did not stop the reconnect loop")
+	}
+
+	select {
+	case <-c.Done():
+	default:
+		t.Error("expected Done to be closed")
+	}
+}
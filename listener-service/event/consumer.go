@@ -1,47 +1,130 @@
 package event
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	amqp "github.com/rabbitmq/amqp091-go"
+	"listener/backoff"
+	"listener/metrics"
+	"listener/tracing"
 	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Consumer struct {
-	conn          *amqp.Connection
-	queueName     string
+	conn          connectionState
+	open          func() (consumeChannel, error)
 	logServiceURL string
+	// handlers tracks the messages being handled, so Listen can wait for them before returning
+	handlers sync.WaitGroup
 }
 
+// Payload is a log entry. The broker checks the fields after name and data, so they are passed
+// on to the logger service as they are.
 type Payload struct {
-	Name string `json:"name"`
-	Data string `json:"data"`
+	Name       string          `json:"name"`
+	Data       string          `json:"data"`
+	Severity   string          `json:"severity,omitempty"`
+	Service    string          `json:"service,omitempty"`
+	TraceID    string          `json:"trace_id,omitempty"`
+	UserID     string          `json:"user_id,omitempty"`
+	Timestamp  *time.Time      `json:"timestamp,omitempty"`
+	Attributes json.RawMessage `json:"attributes,omitempty"`
 }
 
-func NewConsumer(conn *amqp.Connection, logServiceURL string) (Consumer, error) {
-	consumer := Consumer{
+func NewConsumer(conn *Connection, logServiceURL string) *Consumer {
+	return newConsumer(conn, func() (consumeChannel, error) {
+		channel, err := conn.Channel()
+		if err != nil {
+			return nil, err
+		}
+
+		return channel, nil
+	}, logServiceURL)
+}
+
+func newConsumer(conn connectionState, open func() (consumeChannel, error), logServiceURL string) *Consumer {
+	return &Consumer{
 		conn:          conn,
+		open:          open,
 		logServiceURL: logServiceURL,
 	}
+}
 
-	err := consumer.setup()
-	if err != nil {
-		return Consumer{}, err
-	}
+// connectionState is the part of a Connection a Consumer waits on.
+type connectionState interface {
+	Ready() <-chan struct{}
+	Done() <-chan struct{}
+}
 
-	return consumer, nil
+// consumeChannel is the part of an AMQP channel the consumer uses.
+type consumeChannel interface {
+	QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error)
+	QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error
+	Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error)
+	Cancel(consumer string, noWait bool) error
+	Close() error
 }
 
-func (consumer *Consumer) setup() error {
-	channel, err := consumer.conn.Channel()
-	if err != nil {
-		return err
-	}
+// Listen consumes messages for the given topics until ctx ends or the connection is closed.
+// Whenever the connection or the channel is lost, a new queue is declared and bound once
+// RabbitMQ is back. When ctx ends, the consumer is cancelled so RabbitMQ stops delivering, and
+// Listen returns once the messages already received have been handled.
+func (consumer *Consumer) Listen(ctx context.Context, topics []string) error {
+	defer consumer.handlers.Wait()
+
+	retry := backoff.Backoff{Min: 500 * time.Millisecond, Max: 30 * time.Second}
 
-	return declareExchange(channel)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-consumer.conn.Done():
+			return ErrConnectionClosed
+		default:
+		}
+
+		select {
+		case <-consumer.conn.Ready():
+		case <-consumer.conn.Done():
+			return ErrConnectionClosed
+		case <-ctx.Done():
+			return nil
+		}
+
+		err := consumer.consume(ctx, topics)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err == nil {
+			retry.Reset()
+			continue
+		}
+
+		log.Printf("Consuming from RabbitMQ failed: %v", err)
+
+		select {
+		case <-consumer.conn.Done():
+			return ErrConnectionClosed
+		case <-ctx.Done():
+			return nil
+		case <-time.After(retry.Next()):
+		}
+	}
 }
 
-func (consumer *Consumer) Listen(topics []string) error {
-	channel, err := consumer.conn.Channel()
+// consume declares a queue, binds the topics to it and handles messages until the delivery
+// channel is closed.
+func (consumer *Consumer) consume(ctx context.Context, topics []string) error {
+	channel, err := consumer.open()
 	if err != nil {
 		return err
 	}
@@ -57,10 +140,10 @@ func (consumer *Consumer) Listen(topics []string) error {
 		return err
 	}
 
-	return consumeMessages(channel, queue, consumer.logServiceURL)
+	return consumer.consumeMessages(ctx, channel, queue)
 }
 
-func bindTopicsToQueue(channel *amqp.Channel, queue amqp.Queue, topics []string) error {
+func bindTopicsToQueue(channel consumeChannel, queue amqp.Queue, topics []string) error {
 	for _, topic := range topics {
 		err := channel.QueueBind(
 			queue.Name,
@@ -78,41 +161,106 @@ func bindTopicsToQueue(channel *amqp.Channel, queue amqp.Queue, topics []string)
 	return nil
 }
 
-func consumeMessages(channel *amqp.Channel, queue amqp.Queue, logServiceURL string) error {
-	messages, err := channel.Consume(queue.Name, "", true, false, false, false, nil)
+// consumeMessages handles deliveries until the channel or the connection is closed, or ctx
+// ends. Each delivery continues the trace carried in its headers.
+func (consumer *Consumer) consumeMessages(ctx context.Context, channel consumeChannel, queue amqp.Queue) error {
+	tag, err := newConsumerTag()
 	if err != nil {
 		return err
 	}
 
-	forever := make(chan bool)
-	go func() {
-		for d := range messages {
-			var payload Payload
-			_ = json.Unmarshal(d.Body, &payload)
+	messages, err := channel.Consume(queue.Name, tag, true, false, false, false, nil)
+	if err != nil {
+		return err
+	}
 
-			go handlePayload(payload, logServiceURL)
+	log.Printf("Waiting for message [Exchange, Queue] [logs_topic, %s]\n", queue.Name)
+
+	stopped := make(chan struct{})
+	defer close(stopped)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			// RabbitMQ stops delivering; the deliveries already received are still handled
+			// before the delivery channel is closed
+			log.Println("Cancelling the consumer, handling the messages already received...")
+			if err := channel.Cancel(tag, false); err != nil {
+				log.Printf("Failed to cancel the consumer: %v", err)
+			}
+		case <-stopped:
 		}
 	}()
 
-	log.Printf("Waiting for message [Exchange, Queue] [logs_topic, %s]\n", queue.Name)
-	<-forever
+	for d := range messages {
+		var payload Payload
+		if err := json.Unmarshal(d.Body, &payload); err != nil {
+			log.Printf("Dropping message that isn't a valid payload: %v", err)
+			metrics.Messages.WithLabelValues(d.RoutingKey, metrics.Failed).Inc()
+			continue
+		}
+
+		consumer.handlers.Add(1)
+		go func(d amqp.Delivery) {
+			defer consumer.handlers.Done()
+			handlePayload(deliveryContext(d), d.RoutingKey, payload, consumer.logServiceURL)
+		}(d)
+	}
 
 	return nil
 }
 
-func handlePayload(payload Payload, logServiceURL string) {
+func newConsumerTag() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return "listener-" + hex.EncodeToString(b), nil
+}
+
+// deliveryContext continues the trace of the publisher of a delivery.
+func deliveryContext(d amqp.Delivery) context.Context {
+	headers := AMQPHeaders(d.Headers)
+	if headers == nil {
+		headers = AMQPHeaders{}
+	}
+
+	return otel.GetTextMapPropagator().Extract(context.Background(), headers)
+}
+
+func handlePayload(ctx context.Context, routingKey string, payload Payload, logServiceURL string) {
+	ctx, span := tracing.Tracer().Start(ctx, "logs_topic process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			semconv.MessagingSystem("rabbitmq"),
+			semconv.MessagingSourceName("logs_topic"),
+		),
+	)
+	defer span.End()
+
+	// events published before payloads had a severity only carry it in their routing key
+	if payload.Severity == "" && strings.HasPrefix(routingKey, "log.") {
+		payload.Severity = strings.ToLower(strings.TrimPrefix(routingKey, "log."))
+	}
+
+	var err error
+
 	switch payload.Name {
 	case "log", "event":
-		err := logEvent(payload, logServiceURL)
-		if err != nil {
-			log.Println(err)
-		}
+		err = logEvent(ctx, payload, logServiceURL)
 	case "auth":
 		// you can have as many cases as you want, as long as you write the logic
 	default:
-		err := logEvent(payload, logServiceURL)
-		if err != nil {
-			log.Println(err)
-		}
+		err = logEvent(ctx, payload, logServiceURL)
 	}
+
+	if err != nil {
+		span.RecordError(err)
+		log.Println(err)
+		metrics.Messages.WithLabelValues(routingKey, metrics.Failed).Inc()
+		return
+	}
+
+	metrics.Messages.WithLabelValues(routingKey, metrics.Consumed).Inc()
 }
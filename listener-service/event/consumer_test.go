@@ -0,0 +1,360 @@
+package event
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// fakeConnection hands out fake channels while it is up, and every channel it opened on
+// channels so tests can drive them.
+type fakeConnection struct {
+	mu       sync.Mutex
+	ready    chan struct{}
+	done     chan struct{}
+	opened   int
+	channels chan *fakeChannel
+}
+
+func newFakeConnection() *fakeConnection {
+	ready := make(chan struct{})
+	close(ready)
+
+	return &fakeConnection{
+		ready:    ready,
+		done:     make(chan struct{}),
+		channels: make(chan *fakeChannel, 16),
+	}
+}
+
+func (c *fakeConnection) Ready() <-chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.ready
+}
+
+func (c *fakeConnection) Done() <-chan struct{} {
+	return c.done
+}
+
+// lose takes the connection down; the channels opened on it have to be closed by the test.
+func (c *fakeConnection) lose() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.ready = make(chan struct{})
+}
+
+func (c *fakeConnection) restore() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	close(c.ready)
+}
+
+func (c *fakeConnection) open() (consumeChannel, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	select {
+	case <-c.ready:
+	default:
+		return nil, ErrNotConnected
+	}
+
+	c.opened++
+	channel := &fakeChannel{
+		queue:      fmt.Sprintf("amq.gen-%d", c.opened),
+		deliveries: make(chan amqp.Delivery, 16),
+		consuming:  make(chan struct{}),
+	}
+	c.channels <- channel
+
+	return channel, nil
+}
+
+// next returns the next channel the consumer opens, once it consumes from it.
+func (c *fakeConnection) next(t *testing.T) *fakeChannel {
+	t.Helper()
+
+	select {
+	case channel := <-c.channels:
+		select {
+		case <-channel.consuming:
+			return channel
+		case <-time.After(2 * time.Second):
+			t.Fatal("the consumer never started consuming")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the consumer never opened a channel")
+	}
+
+	return nil
+}
+
+type fakeChannel struct {
+	queue      string
+	deliveries chan amqp.Delivery
+	consuming  chan struct{}
+	// inFlight are delivered when the consumer is cancelled, like the messages RabbitMQ
+	// already sent when the cancel reaches it
+	inFlight []amqp.Delivery
+
+	mu        sync.Mutex
+	bindings  []string
+	tag       string
+	cancelled string
+	closeOnce sync.Once
+}
+
+func (c *fakeChannel) QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error) {
+	return amqp.Queue{Name: c.queue}, nil
+}
+
+func (c *fakeChannel) QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if name != c.queue || exchange != "logs_topic" {
+		return fmt.Errorf("unexpected binding of %s to %s", name, exchange)
+	}
+	c.bindings = append(c.bindings, key)
+
+	return nil
+}
+
+func (c *fakeChannel) Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error) {
+	c.mu.Lock()
+	c.tag = consumer
+	c.mu.Unlock()
+
+	close(c.consuming)
+
+	return c.deliveries, nil
+}
+
+func (c *fakeChannel) Cancel(consumer string, noWait bool) error {
+	c.mu.Lock()
+	c.cancelled = consumer
+	inFlight := c.inFlight
+	c.mu.Unlock()
+
+	c.closeOnce.Do(func() {
+		for _, d := range inFlight {
+			c.deliveries <- d
+		}
+		close(c.deliveries)
+	})
+
+	return nil
+}
+
+func (c *fakeChannel) Close() error {
+	c.lose()
+	return nil
+}
+
+// lose closes the delivery channel, as RabbitMQ does when the channel or connection is lost.
+func (c *fakeChannel) lose() {
+	c.closeOnce.Do(func() {
+		close(c.deliveries)
+	})
+}
+
+func (c *fakeChannel) boundTopics() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]string(nil), c.bindings...)
+}
+
+// fakeLogger is a logger service that records the payloads posted to it.
+type fakeLogger struct {
+	*httptest.Server
+
+	delay time.Duration
+
+	mu       sync.Mutex
+	payloads []Payload
+	headers  []http.Header
+}
+
+func newFakeLogger(delay time.Duration) *fakeLogger {
+	logger := &fakeLogger{delay: delay}
+	logger.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload Payload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		time.Sleep(logger.delay)
+
+		logger.mu.Lock()
+		logger.payloads = append(logger.payloads, payload)
+		logger.headers = append(logger.headers, r.Header.Clone())
+		logger.mu.Unlock()
+
+		w.WriteHeader(http.StatusAccepted)
+	}))
+
+	return logger
+}
+
+func (l *fakeLogger) received() []Payload {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return append([]Payload(nil), l.payloads...)
+}
+
+func delivery(routingKey, data string) amqp.Delivery {
+	body, _ := json.Marshal(Payload{Name: "event", Data: data})
+	return amqp.Delivery{RoutingKey: routingKey, Body: body}
+}
+
+func sameTopics(got, want []string) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func Test_Consumer_rebindsAfterReconnect(t *testing.T) {
+	logger := newFakeLogger(0)
+	defer logger.Close()
+
+	conn := newFakeConnection()
+	consumer := newConsumer(conn, conn.open, logger.URL)
+	topics := []string{"log.INFO", "log.ERROR"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- consumer.Listen(ctx, topics)
+	}()
+
+	first := conn.next(t)
+	if bound := first.boundTopics(); !sameTopics(bound, topics) {
+		t.Errorf("expected %v to be bound but got %v", topics, bound)
+	}
+
+	// the connection drops: the consumer waits until it is back
+	conn.lose()
+	first.lose()
+
+	select {
+	case channel := <-conn.channels:
+		t.Fatalf("expected no channel while the connection is down but %s was opened", channel.queue)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	conn.restore()
+
+	second := conn.next(t)
+	if second.queue == first.queue {
+		t.Errorf("expected a new queue after reconnecting")
+	}
+	if bound := second.boundTopics(); !sameTopics(bound, topics) {
+		t.Errorf("expected %v to be bound again but got %v", topics, bound)
+	}
+
+	second.deliveries <- delivery("log.INFO", "after reconnect")
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(logger.received()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if received := logger.received(); len(received) != 1 || received[0].Data != "after reconnect" {
+		t.Errorf("expected the message sent after reconnecting to be logged but got %v", received)
+	}
+
+	cancel()
+	if err := <-errs; err != nil {
+		t.Errorf("expected Listen to return nil but got %v", err)
+	}
+}
+
+func Test_Consumer_drainsOnCancel(t *testing.T) {
+	logger := newFakeLogger(20 * time.Millisecond)
+	defer logger.Close()
+
+	conn := newFakeConnection()
+	consumer := newConsumer(conn, conn.open, logger.URL)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- consumer.Listen(ctx, []string{"log.INFO"})
+	}()
+
+	channel := conn.next(t)
+
+	channel.mu.Lock()
+	for i := 0; i < 5; i++ {
+		channel.inFlight = append(channel.inFlight, delivery("log.INFO", fmt.Sprintf("in flight %d", i)))
+	}
+	channel.mu.Unlock()
+
+	cancel()
+
+	select {
+	case err := <-errs:
+		if err != nil {
+			t.Errorf("expected Listen to return nil but got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Listen did not return after ctx was cancelled")
+	}
+
+	channel.mu.Lock()
+	tag, cancelled := channel.tag, channel.cancelled
+	channel.mu.Unlock()
+
+	if cancelled == "" || cancelled != tag {
+		t.Errorf("expected consumer %q to be cancelled but got %q", tag, cancelled)
+	}
+
+	if received := logger.received(); len(received) != 5 {
+		t.Errorf("expected the 5 messages in flight to be logged before Listen returned but got %d", len(received))
+	}
+}
+
+func Test_Consumer_stopsWhenConnectionCloses(t *testing.T) {
+	conn := newFakeConnection()
+	conn.lose()
+	consumer := newConsumer(conn, conn.open, "http://logger-service.invalid/log")
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- consumer.Listen(context.Background(), []string{"log.INFO"})
+	}()
+
+	close(conn.done)
+
+	select {
+	case err := <-errs:
+		if err != ErrConnectionClosed {
+			t.Errorf("expected ErrConnectionClosed but got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Listen did not return after the connection was closed")
+	}
+}
@@ -16,7 +16,7 @@ func declareExchange(ch *amqp.Channel) error {
 	)
 }
 
-func declareRandomQueue(ch *amqp.Channel) (amqp.Queue, error) {
+func declareRandomQueue(ch consumeChannel) (amqp.Queue, error) {
 	return ch.QueueDeclare(
 		"",    // name (given a random name by the server)
 		false, // durable (will not survive a broker restart)
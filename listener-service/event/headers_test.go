@@ -0,0 +1,66 @@
+package event
+
+import (
+	"context"
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func Test_AMQPHeaders(t *testing.T) {
+	headers := AMQPHeaders{"number": 1}
+	headers.Set("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+
+	if got := headers.Get("traceparent"); got != "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01" {
+		t.Errorf("expected the header to be read back but got %q", got)
+	}
+	if got := headers.Get("number"); got != "" {
+		t.Errorf("expected headers that aren't strings to be ignored but got %q", got)
+	}
+	if got := headers.Get("missing"); got != "" {
+		t.Errorf("expected a missing header to be empty but got %q", got)
+	}
+	if keys := headers.Keys(); len(keys) != 2 {
+		t.Errorf("expected 2 keys but got %v", keys)
+	}
+}
+
+func Test_deliveryContext_continuesTrace(t *testing.T) {
+	otel.SetTracerProvider(sdktrace.NewTracerProvider())
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	logger := newFakeLogger(0)
+	defer logger.Close()
+
+	ctx, span := otel.Tracer("test").Start(context.Background(), "publish")
+	defer span.End()
+
+	headers := AMQPHeaders{}
+	otel.GetTextMapPropagator().Inject(ctx, headers)
+
+	d := delivery("log.INFO", "traced")
+	d.Headers = amqp.Table(headers)
+
+	handlePayload(deliveryContext(d), d.RoutingKey, Payload{Name: "event", Data: "traced"}, logger.URL)
+
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+
+	if len(logger.headers) != 1 {
+		t.Fatalf("expected one request to the logger service but got %d", len(logger.headers))
+	}
+
+	received := trace.SpanContextFromContext(
+		otel.GetTextMapPropagator().Extract(context.Background(), propagation.HeaderCarrier(logger.headers[0])),
+	)
+	if received.TraceID() != span.SpanContext().TraceID() {
+		t.Errorf("expected the request to carry trace %s but got %s", span.SpanContext().TraceID(), received.TraceID())
+	}
+	if logger.payloads[0].Severity != "info" {
+		t.Errorf("expected the severity to come from the routing key but got %q", logger.payloads[0].Severity)
+	}
+}
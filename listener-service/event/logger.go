@@ -2,21 +2,31 @@ package event
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
-func logEvent(entry Payload, logServiceURL string) error {
+// client passes the trace context on to the logger service in the request headers. The timeout
+// keeps a slow logger service from holding up shutdown.
+var client = &http.Client{
+	Transport: otelhttp.NewTransport(http.DefaultTransport),
+	Timeout:   10 * time.Second,
+}
+
+func logEvent(ctx context.Context, entry Payload, logServiceURL string) error {
 	jsonData, _ := json.MarshalIndent(entry, "", "\t")
-	request, err := http.NewRequest(http.MethodPost, logServiceURL, bytes.NewBuffer(jsonData))
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, logServiceURL, bytes.NewBuffer(jsonData))
 	if err != nil {
 		return err
 	}
 
 	request.Header.Set("Content-Type", "application/json")
 
-	client := &http.Client{}
-
 	response, err := client.Do(request)
 	if err != nil {
 		return err
@@ -24,7 +34,7 @@ func logEvent(entry Payload, logServiceURL string) error {
 	defer response.Body.Close()
 
 	if response.StatusCode != http.StatusAccepted {
-		return err
+		return fmt.Errorf("unexpected status code from logger service: %d", response.StatusCode)
 	}
 
 	return nil
@@ -0,0 +1,28 @@
+// Package metrics exposes the Prometheus metrics of the listener on /metrics.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Results of listener_messages_total.
+const (
+	Consumed = "consumed"
+	Failed   = "failed"
+)
+
+// Messages counts messages taken from RabbitMQ by routing key and result. A message fails when
+// it can't be decoded or the logger service didn't accept it.
+var Messages = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "listener_messages_total",
+	Help: "Messages consumed from RabbitMQ, by routing key and result.",
+}, []string{"routing_key", "result"})
+
+// Handler serves the metrics in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
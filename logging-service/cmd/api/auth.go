@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log-service/token"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Role names match the roles managed by the authentication service.
+const (
+	roleAdmin     = "admin"
+	roleLogReader = "log-reader"
+)
+
+// readerRoles may read log entries. Writing them stays open to the services, which reach the
+// logger service from inside their network.
+var readerRoles = []string{roleLogReader, roleAdmin}
+
+// callRoles are the roles the gRPC calls that need an access token require; calls that aren't
+// listed need none.
+var callRoles = map[string][]string{
+	"/logs.LogService/ListLogs": readerRoles,
+	"/logs.LogService/GetLog":   readerRoles,
+	"/logs.LogService/TailLogs": readerRoles,
+}
+
+var (
+	errUnauthenticated = errors.New("authentication required")
+	errMalformedHeader = errors.New("malformed authorization header")
+	errForbidden       = errors.New("not allowed to perform this action")
+)
+
+// authorize verifies the bearer token in an Authorization header and checks that its caller has
+// at least one of roles. It returns errUnauthenticated without a token, errForbidden when the
+// caller lacks the roles, and another error for a token that can't be verified.
+func (app *Config) authorize(header string, roles ...string) (*token.Claims, error) {
+	if header == "" {
+		return nil, errUnauthenticated
+	}
+
+	scheme, tokenString, found := strings.Cut(header, " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") || tokenString == "" {
+		return nil, errMalformedHeader
+	}
+
+	claims, err := app.Keys.Verify(tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, required := range roles {
+		for _, role := range claims.Roles {
+			if role == required {
+				return claims, nil
+			}
+		}
+	}
+
+	return nil, errForbidden
+}
+
+// requireRole only lets requests through whose access token has at least one of roles.
+func (app *Config) requireRole(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, err := app.authorize(r.Header.Get("Authorization"), roles...)
+			switch {
+			case err == nil:
+				next.ServeHTTP(w, r)
+			case errors.Is(err, errForbidden):
+				app.errorJSON(w, err, http.StatusForbidden)
+			case errors.Is(err, errUnauthenticated):
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				app.errorJSON(w, err, http.StatusUnauthorized)
+			default:
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				app.errorJSON(w, err, http.StatusUnauthorized)
+			}
+		})
+	}
+}
+
+// authorizeCall checks the access token in the "authorization" metadata of a gRPC call against
+// the roles callRoles lists for method.
+func (app *Config) authorizeCall(ctx context.Context, method string) error {
+	roles, ok := callRoles[method]
+	if !ok {
+		return nil
+	}
+
+	var header string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("authorization"); len(values) > 0 {
+			header = values[0]
+		}
+	}
+
+	_, err := app.authorize(header, roles...)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, errForbidden):
+		return status.Error(codes.PermissionDenied, err.Error())
+	default:
+		return status.Error(codes.Unauthenticated, err.Error())
+	}
+}
+
+// authorizeUnary is the gRPC interceptor that applies authorizeCall to unary calls.
+func (app *Config) authorizeUnary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if err := app.authorizeCall(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+
+	return handler(ctx, req)
+}
+
+// authorizeStream is the gRPC interceptor that applies authorizeCall to streaming calls.
+func (app *Config) authorizeStream(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := app.authorizeCall(stream.Context(), info.FullMethod); err != nil {
+		return err
+	}
+
+	return handler(srv, stream)
+}
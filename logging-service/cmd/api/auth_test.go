@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"log-service/token"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func Test_requireRole(t *testing.T) {
+	foreignKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	tests := []struct {
+		name         string
+		header       string
+		expectedCode int
+		challenge    string
+	}{
+		{"no header", "", http.StatusUnauthorized, "Bearer"},
+		{"wrong scheme", "Basic dXNlcjpwYXNz", http.StatusUnauthorized, `Bearer error="invalid_token"`},
+		{"expired token", "Bearer " + signTestToken(testSigningKey, []string{"admin"}, -time.Minute), http.StatusUnauthorized, `Bearer error="invalid_token"`},
+		{"foreign key", "Bearer " + signTestToken(foreignKey, []string{"admin"}, time.Minute), http.StatusUnauthorized, `Bearer error="invalid_token"`},
+		{"oauth client token", "Bearer " + signTestClaims(testSigningKey, token.Claims{Roles: []string{"admin"}, ClientID: "partner-app"}, time.Minute), http.StatusUnauthorized, `Bearer error="invalid_token"`},
+		{"without a role", "Bearer " + signTestToken(testSigningKey, nil, time.Minute), http.StatusForbidden, ""},
+		{"with another role", "Bearer " + signTestToken(testSigningKey, []string{"mailer"}, time.Minute), http.StatusForbidden, ""},
+		{"with the role", "Bearer " + signTestToken(testSigningKey, []string{"mailer", "admin"}, time.Minute), http.StatusOK, ""},
+	}
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest(http.MethodGet, "/admin/retention", nil)
+		if tt.header != "" {
+			req.Header.Set("Authorization", tt.header)
+		}
+		rr := httptest.NewRecorder()
+
+		testApp.requireRole(roleAdmin)(next).ServeHTTP(rr, req)
+
+		if rr.Code != tt.expectedCode {
+			t.Errorf("%s: expected status %d but got %d", tt.name, tt.expectedCode, rr.Code)
+		}
+		if challenge := rr.Header().Get("WWW-Authenticate"); challenge != tt.challenge {
+			t.Errorf("%s: expected the challenge %q but got %q", tt.name, tt.challenge, challenge)
+		}
+	}
+}
+
+func Test_routes_retentionNeedsAdmin(t *testing.T) {
+	routes := testApp.routes()
+	user := "Bearer " + signTestToken(testSigningKey, []string{"log-reader"}, time.Minute)
+
+	tests := []struct {
+		name         string
+		method       string
+		header       string
+		expectedCode int
+	}{
+		{"get without token", http.MethodGet, "", http.StatusUnauthorized},
+		{"put without token", http.MethodPut, "", http.StatusUnauthorized},
+		{"get without the admin role", http.MethodGet, user, http.StatusForbidden},
+		{"put without the admin role", http.MethodPut, user, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest(tt.method, "/admin/retention", strings.NewReader(`{"default_max_age":"1d"}`))
+		if tt.header != "" {
+			req.Header.Set("Authorization", tt.header)
+		}
+		rr := httptest.NewRecorder()
+
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != tt.expectedCode {
+			t.Errorf("%s: expected status %d but got %d", tt.name, tt.expectedCode, rr.Code)
+		}
+	}
+}
+
+func Test_routes_readingNeedsReader(t *testing.T) {
+	routes := testApp.routes()
+	user := "Bearer " + signTestToken(testSigningKey, []string{"mailer"}, time.Minute)
+
+	for _, path := range []string{"/logs", "/logs?severity=error", "/logs/642f0a5be2d1c3a6b1f0c9d2", "/logs/tail", "/logs/tail?severity=error"} {
+		tests := []struct {
+			name         string
+			header       string
+			expectedCode int
+		}{
+			{"without token", "", http.StatusUnauthorized},
+			{"with an invalid token", "Bearer nope", http.StatusUnauthorized},
+			{"without the log-reader role", user, http.StatusForbidden},
+		}
+
+		for _, tt := range tests {
+			req, _ := http.NewRequest(http.MethodGet, path, nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rr := httptest.NewRecorder()
+
+			routes.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedCode {
+				t.Errorf("%s %s: expected status %d but got %d", path, tt.name, tt.expectedCode, rr.Code)
+			}
+		}
+	}
+}
+
+func Test_authorizeUnary(t *testing.T) {
+	withToken := func(tokenString string) context.Context {
+		return metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+tokenString))
+	}
+
+	reader := withToken(signTestToken(testSigningKey, []string{"log-reader"}, time.Minute))
+	admin := withToken(signTestToken(testSigningKey, []string{"admin"}, time.Minute))
+	mailer := withToken(signTestToken(testSigningKey, []string{"mailer"}, time.Minute))
+	expired := withToken(signTestToken(testSigningKey, []string{"log-reader"}, -time.Minute))
+
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		method   string
+		expected codes.Code
+	}{
+		{"list without token", context.Background(), "/logs.LogService/ListLogs", codes.Unauthenticated},
+		{"list with an expired token", expired, "/logs.LogService/ListLogs", codes.Unauthenticated},
+		{"list without the role", mailer, "/logs.LogService/ListLogs", codes.PermissionDenied},
+		{"list as a reader", reader, "/logs.LogService/ListLogs", codes.OK},
+		{"get without token", context.Background(), "/logs.LogService/GetLog", codes.Unauthenticated},
+		{"get as an admin", admin, "/logs.LogService/GetLog", codes.OK},
+		{"write without token", context.Background(), "/logs.LogService/WriteLog", codes.OK},
+		{"health check without token", context.Background(), "/grpc.health.v1.Health/Check", codes.OK},
+	}
+
+	for _, tt := range tests {
+		called := false
+		handler := func(ctx context.Context, req any) (any, error) {
+			called = true
+			return nil, nil
+		}
+
+		_, err := testApp.authorizeUnary(tt.ctx, nil, &grpc.UnaryServerInfo{FullMethod: tt.method}, handler)
+
+		if code := status.Code(err); code != tt.expected {
+			t.Errorf("%s: expected %v but got %v", tt.name, tt.expected, code)
+		}
+		if called != (tt.expected == codes.OK) {
+			t.Errorf("%s: expected the handler to be called only when the call is allowed", tt.name)
+		}
+	}
+}
+
+// fakeServerStream is a server stream of a call with the given context.
+type fakeServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s fakeServerStream) Context() context.Context {
+	return s.ctx
+}
+
+func Test_authorizeStream(t *testing.T) {
+	reader := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+signTestToken(testSigningKey, []string{"log-reader"}, time.Minute)))
+	mailer := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+signTestToken(testSigningKey, []string{"mailer"}, time.Minute)))
+
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		method   string
+		expected codes.Code
+	}{
+		{"tail without token", context.Background(), "/logs.LogService/TailLogs", codes.Unauthenticated},
+		{"tail without the role", mailer, "/logs.LogService/TailLogs", codes.PermissionDenied},
+		{"tail as a reader", reader, "/logs.LogService/TailLogs", codes.OK},
+		{"write stream without token", context.Background(), "/logs.LogService/WriteLogs", codes.OK},
+	}
+
+	for _, tt := range tests {
+		called := false
+		handler := func(srv any, stream grpc.ServerStream) error {
+			called = true
+			return nil
+		}
+
+		err := testApp.authorizeStream(nil, fakeServerStream{ctx: tt.ctx}, &grpc.StreamServerInfo{FullMethod: tt.method}, handler)
+
+		if code := status.Code(err); code != tt.expected {
+			t.Errorf("%s: expected %v but got %v", tt.name, tt.expected, code)
+		}
+		if called != (tt.expected == codes.OK) {
+			t.Errorf("%s: expected the handler to be called only when the call is allowed", tt.name)
+		}
+	}
+}
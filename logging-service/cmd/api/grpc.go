@@ -2,26 +2,41 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log-service/data"
+	"log-service/ingest"
 	"log-service/logs"
+	"log-service/metrics"
+	"log-service/tail"
+	"sort"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 type LogServer struct {
 	logs.UnimplementedLogServiceServer
 	Models data.Models
+	// Ingest stores the entries of WriteLogs, BatchSize at a time
+	Ingest    *ingest.Buffer
+	BatchSize int
+	// Tail hands TailLogs the entries as they are stored
+	Tail *tail.Hub
 }
 
 func (logServer *LogServer) WriteLog(ctx context.Context, req *logs.LogRequest) (*logs.LogResponse, error) {
-	input := req.GetLogEntry()
-
 	//write a log
-	logEntry := data.LogEntry{
-		Name: input.Name,
-		Data: input.Data,
+	logEntry, err := fromProtoLog(ctx, req.GetLogEntry())
+	if err == nil {
+		_, err = logServer.Models.LogEntry.Insert(ctx, logEntry)
+	}
+	metrics.RecordInsert(metrics.GRPC, err)
+	if errors.Is(err, data.ErrInvalidEntry) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
-
-	err := logServer.Models.LogEntry.Insert(logEntry)
 	if err != nil {
 		res := &logs.LogResponse{
 			Result: fmt.Sprintf("Failed. Details: %s", err),
@@ -35,3 +50,289 @@ func (logServer *LogServer) WriteLog(ctx context.Context, req *logs.LogRequest)
 
 	return res, nil
 }
+
+// WriteLogs stores a stream of entries through the ingest buffer, BatchSize at a time, and
+// answers with the entries it couldn't store once the client closed the stream. The stream
+// isn't read while a batch is stored, which slows down clients that send faster than that.
+func (logServer *LogServer) WriteLogs(stream logs.LogService_WriteLogsServer) error {
+	ctx := stream.Context()
+	res := &logs.WriteLogsResponse{}
+
+	var (
+		batch []data.LogEntry
+		// indexes are the positions of the entries of batch in the stream
+		indexes []int
+	)
+
+	fail := func(index int, err error) {
+		metrics.RecordInsert(metrics.GRPC, err)
+		res.Errors = append(res.Errors, &logs.EntryError{
+			Index:   int32(index),
+			Error:   err.Error(),
+			Invalid: errors.Is(err, data.ErrInvalidEntry),
+		})
+	}
+
+	write := func() {
+		writeCtx, cancel := context.WithTimeout(ctx, ingestTimeout)
+		errs, err := logServer.Ingest.Write(writeCtx, batch)
+		cancel()
+
+		for i, index := range indexes {
+			switch {
+			case err != nil:
+				// the buffer stayed full; the client may send these entries again
+				fail(index, fmt.Errorf("the logger service is busy: %w", err))
+			case errs[i] != nil:
+				fail(index, errs[i])
+			default:
+				metrics.RecordInsert(metrics.GRPC, nil)
+				res.Stored++
+			}
+		}
+
+		batch, indexes = nil, nil
+	}
+
+	for index := 0; ; index++ {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		entry, err := fromProtoLog(ctx, req.GetLogEntry())
+		if err != nil {
+			fail(index, err)
+			continue
+		}
+
+		batch = append(batch, entry)
+		indexes = append(indexes, index)
+		if len(batch) >= logServer.BatchSize {
+			write()
+		}
+	}
+	if len(batch) > 0 {
+		write()
+	}
+
+	// entries that failed when their batch was stored are reported after those that failed
+	// when they arrived
+	sort.Slice(res.Errors, func(i, j int) bool { return res.Errors[i].Index < res.Errors[j].Index })
+
+	return stream.SendAndClose(res)
+}
+
+// ListLogs returns a page of log entries, like GET /logs.
+func (logServer *LogServer) ListLogs(ctx context.Context, req *logs.ListLogsRequest) (*logs.ListLogsResponse, error) {
+	query := data.Query{
+		Name:     req.GetName(),
+		Severity: fromProtoSeverity(req.GetSeverity()),
+		Service:  req.GetService(),
+		Search:   req.GetSearch(),
+		Limit:    int(req.GetPageSize()),
+		Cursor:   req.GetPageToken(),
+	}
+	if req.GetFrom() != nil {
+		query.From = req.GetFrom().AsTime()
+	}
+	if req.GetTo() != nil {
+		query.To = req.GetTo().AsTime()
+	}
+
+	switch req.GetSort() {
+	case logs.Sort_NEWEST_FIRST:
+		query.Sort = data.NewestFirst
+	case logs.Sort_OLDEST_FIRST:
+		query.Sort = data.OldestFirst
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "unknown sort %v", req.GetSort())
+	}
+
+	page, err := logServer.Models.LogEntry.Find(query)
+	if errors.Is(err, data.ErrInvalidQuery) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	res := &logs.ListLogsResponse{
+		Entries:       make([]*logs.LogEntry, 0, len(page.Entries)),
+		NextPageToken: page.NextCursor,
+	}
+	for _, entry := range page.Entries {
+		res.Entries = append(res.Entries, toProto(entry))
+	}
+
+	return res, nil
+}
+
+// GetLog returns a single log entry, like GET /logs/{id}.
+func (logServer *LogServer) GetLog(ctx context.Context, req *logs.GetLogRequest) (*logs.LogEntry, error) {
+	entry, err := logServer.Models.LogEntry.GetOne(req.GetId())
+	if errors.Is(err, data.ErrNotFound) {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return toProto(entry), nil
+}
+
+// TailLogs streams the entries stored from now on that match the request, like GET /logs/tail,
+// until the client cancels the call. The call ends with UNAVAILABLE when the service shuts down,
+// so clients know to follow another replica.
+func (logServer *LogServer) TailLogs(req *logs.TailLogsRequest, stream logs.LogService_TailLogsServer) error {
+	filter, err := tail.NewFilter(req.GetName(), string(fromProtoSeverity(req.GetSeverity())), req.GetService(), req.GetSearch())
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	subscription, err := logServer.Tail.Subscribe(filter)
+	if errors.Is(err, tail.ErrTooManySubscribers) {
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+	if err != nil {
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	defer subscription.Close()
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return status.FromContextError(stream.Context().Err()).Err()
+		case entry, ok := <-subscription.C:
+			if !ok {
+				return status.Error(codes.Unavailable, "the logger service is shutting down")
+			}
+
+			res := &logs.TailLogsResponse{Entry: toProto(entry), Dropped: subscription.Dropped()}
+			if err := stream.Send(res); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// fromProtoLog returns the entry of a log sent over gRPC. Entries without a trace ID belong to
+// the trace of ctx.
+func fromProtoLog(ctx context.Context, input *logs.Log) (data.LogEntry, error) {
+	entry := data.LogEntry{
+		Name:     input.GetName(),
+		Data:     input.GetData(),
+		Severity: fromProtoSeverity(input.GetSeverity()),
+		Service:  input.GetService(),
+		TraceID:  input.GetTraceId(),
+		UserID:   input.GetUserId(),
+	}
+	if input.GetTimestamp() != nil {
+		entry.Timestamp = input.GetTimestamp().AsTime()
+	}
+	if entry.TraceID == "" {
+		entry.TraceID = traceID(ctx)
+	}
+
+	attributes, err := fromProtoAttributes(input.GetAttributes())
+	if err != nil {
+		return entry, err
+	}
+	entry.Attributes = attributes
+
+	return entry, nil
+}
+
+func toProto(entry *data.LogEntry) *logs.LogEntry {
+	return &logs.LogEntry{
+		Id:         entry.ID,
+		Name:       entry.Name,
+		Data:       entry.Data,
+		CreatedAt:  timestamppb.New(entry.CreatedAt),
+		UpdatedAt:  timestamppb.New(entry.UpdatedAt),
+		Severity:   toProtoSeverity(entry.Severity),
+		Service:    entry.Service,
+		TraceId:    entry.TraceID,
+		UserId:     entry.UserID,
+		Timestamp:  timestamppb.New(entry.Timestamp),
+		Attributes: toProtoAttributes(entry.Attributes),
+	}
+}
+
+var protoSeverities = map[logs.Severity]data.Severity{
+	logs.Severity_DEBUG:   data.SeverityDebug,
+	logs.Severity_INFO:    data.SeverityInfo,
+	logs.Severity_WARNING: data.SeverityWarning,
+	logs.Severity_ERROR:   data.SeverityError,
+}
+
+// fromProtoSeverity returns "" for an unspecified severity, which means info for entries and
+// any severity for queries. Unknown numbers keep their name, so they are rejected as such.
+func fromProtoSeverity(severity logs.Severity) data.Severity {
+	if severity == logs.Severity_SEVERITY_UNSPECIFIED {
+		return ""
+	}
+	if s, ok := protoSeverities[severity]; ok {
+		return s
+	}
+
+	return data.Severity(severity.String())
+}
+
+func toProtoSeverity(severity data.Severity) logs.Severity {
+	for p, s := range protoSeverities {
+		if s == severity {
+			return p
+		}
+	}
+
+	return logs.Severity_SEVERITY_UNSPECIFIED
+}
+
+func fromProtoAttributes(attrs map[string]*logs.AttributeValue) (data.Attributes, error) {
+	raw := make(map[string]interface{}, len(attrs))
+	for key, value := range attrs {
+		switch v := value.GetValue().(type) {
+		case *logs.AttributeValue_StringValue:
+			raw[key] = v.StringValue
+		case *logs.AttributeValue_IntValue:
+			raw[key] = v.IntValue
+		case *logs.AttributeValue_DoubleValue:
+			raw[key] = v.DoubleValue
+		case *logs.AttributeValue_BoolValue:
+			raw[key] = v.BoolValue
+		default:
+			return nil, fmt.Errorf("%w: attribute %q has no value", data.ErrInvalidEntry, key)
+		}
+	}
+
+	return data.NewAttributes(raw)
+}
+
+func toProtoAttributes(attrs data.Attributes) map[string]*logs.AttributeValue {
+	if len(attrs) == 0 {
+		return nil
+	}
+
+	out := make(map[string]*logs.AttributeValue, len(attrs))
+	for key, value := range attrs {
+		switch v := value.(type) {
+		case string:
+			out[key] = &logs.AttributeValue{Value: &logs.AttributeValue_StringValue{StringValue: v}}
+		case int64:
+			out[key] = &logs.AttributeValue{Value: &logs.AttributeValue_IntValue{IntValue: v}}
+		case int32:
+			// documents written by other clients may hold 32-bit integers
+			out[key] = &logs.AttributeValue{Value: &logs.AttributeValue_IntValue{IntValue: int64(v)}}
+		case float64:
+			out[key] = &logs.AttributeValue{Value: &logs.AttributeValue_DoubleValue{DoubleValue: v}}
+		case bool:
+			out[key] = &logs.AttributeValue{Value: &logs.AttributeValue_BoolValue{BoolValue: v}}
+		}
+	}
+
+	return out
+}
@@ -1,13 +1,51 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log-service/data"
+	"log-service/metrics"
 	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// JSONPayload is the body of POST /log. Only name and data are required, as they were the
+// whole payload before entries were structured.
 type JSONPayload struct {
-	Name string `json:"name"`
-	Data string `json:"data"`
+	Name       string          `json:"name"`
+	Data       string          `json:"data"`
+	Severity   string          `json:"severity,omitempty"`
+	Service    string          `json:"service,omitempty"`
+	TraceID    string          `json:"trace_id,omitempty"`
+	UserID     string          `json:"user_id,omitempty"`
+	Timestamp  time.Time       `json:"timestamp"`
+	Attributes data.Attributes `json:"attributes,omitempty"`
+}
+
+// entry returns the log entry of the payload. Entries without a trace ID belong to the trace
+// of ctx.
+func (p JSONPayload) entry(ctx context.Context) data.LogEntry {
+	entry := data.LogEntry{
+		Name:       p.Name,
+		Data:       p.Data,
+		Severity:   data.Severity(p.Severity),
+		Service:    p.Service,
+		TraceID:    p.TraceID,
+		UserID:     p.UserID,
+		Timestamp:  p.Timestamp,
+		Attributes: p.Attributes,
+	}
+	if entry.TraceID == "" {
+		entry.TraceID = traceID(ctx)
+	}
+
+	return entry
 }
 
 func (app *Config) WriteLog(w http.ResponseWriter, r *http.Request) {
@@ -17,21 +55,207 @@ func (app *Config) WriteLog(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	event := data.LogEntry{
-		Name: requestPayload.Name,
-		Data: requestPayload.Data,
-	}
-
-	if err := app.Models.LogEntry.Insert(event); err != nil {
+	entry, err := app.Models.LogEntry.Insert(r.Context(), requestPayload.entry(r.Context()))
+	metrics.RecordInsert(metrics.HTTP, err)
+	if errors.Is(err, data.ErrInvalidEntry) {
 		app.errorJSON(w, err)
 		return
 	}
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
 
 	resp := jsonResponse{
 		Error:   false,
 		Message: "The event is logged.",
-		Data:    event,
+		Data:    entry,
 	}
 
 	app.writeJSON(w, http.StatusAccepted, resp)
 }
+
+const (
+	// maxBatchEntries is the most entries POST /logs/batch takes at once.
+	maxBatchEntries = 1000
+	// maxBatchBytes limits the body of POST /logs/batch.
+	maxBatchBytes = 16 << 20
+	// ingestTimeout bounds how long a batch waits for room in a full ingest buffer.
+	ingestTimeout = 10 * time.Second
+)
+
+// batchPayload is the body of POST /logs/batch.
+type batchPayload struct {
+	Entries []JSONPayload `json:"entries"`
+}
+
+// entryError reports an entry of a batch that wasn't stored, by its position in the batch.
+// Invalid entries can't be stored; the others may be sent again.
+type entryError struct {
+	Index   int    `json:"index"`
+	Error   string `json:"error"`
+	Invalid bool   `json:"invalid"`
+}
+
+type batchResult struct {
+	Stored int          `json:"stored"`
+	Errors []entryError `json:"errors"`
+}
+
+// WriteLogs answers POST /logs/batch by storing the entries of the batch through the ingest
+// buffer. Each entry is stored or rejected on its own; the response lists the ones that weren't
+// stored. It answers 503 when the buffer stays full, so nothing of the batch was stored.
+func (app *Config) WriteLogs(w http.ResponseWriter, r *http.Request) {
+	var requestPayload batchPayload
+	if err := app.readJSON(w, r, &requestPayload, maxBatchBytes); err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if len(requestPayload.Entries) == 0 {
+		app.errorJSON(w, errors.New("the batch has no entries"))
+		return
+	}
+	if len(requestPayload.Entries) > maxBatchEntries {
+		app.errorJSON(w, fmt.Errorf("a batch has at most %d entries", maxBatchEntries), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	entries := make([]data.LogEntry, len(requestPayload.Entries))
+	for i, payload := range requestPayload.Entries {
+		entries[i] = payload.entry(r.Context())
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), ingestTimeout)
+	errs, err := app.Ingest.Write(ctx, entries)
+	cancel()
+	if err != nil {
+		w.Header().Set("Retry-After", "1")
+		app.errorJSON(w, fmt.Errorf("the logger service is busy: %w", err), http.StatusServiceUnavailable)
+		return
+	}
+
+	result := batchResult{Errors: []entryError{}}
+	for i, err := range errs {
+		metrics.RecordInsert(metrics.HTTP, err)
+		if err != nil {
+			result.Errors = append(result.Errors, entryError{Index: i, Error: err.Error(), Invalid: errors.Is(err, data.ErrInvalidEntry)})
+			continue
+		}
+		result.Stored++
+	}
+
+	resp := jsonResponse{
+		Error:   len(result.Errors) > 0,
+		Message: fmt.Sprintf("Stored %d of %d log entries.", result.Stored, len(entries)),
+		Data:    result,
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// ListLogs answers GET /logs with a page of log entries. The query parameters filter them by
+// name, severity and service, by creation time (from and to, RFC 3339) and by the words in
+// their data (q); sort is newest (the default) or oldest. The next page is fetched by passing
+// the returned next_cursor as cursor along with the same parameters.
+func (app *Config) ListLogs(w http.ResponseWriter, r *http.Request) {
+	query, err := parseQuery(r.URL.Query())
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	page, err := app.Models.LogEntry.Find(query)
+	if errors.Is(err, data.ErrInvalidQuery) {
+		app.errorJSON(w, err)
+		return
+	}
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Found %d log entries.", len(page.Entries)),
+		Data:    page,
+	}
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+// GetLog answers GET /logs/{id} with a single log entry.
+func (app *Config) GetLog(w http.ResponseWriter, r *http.Request) {
+	entry, err := app.Models.LogEntry.GetOne(chi.URLParam(r, "id"))
+	if errors.Is(err, data.ErrNotFound) {
+		app.errorJSON(w, err, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := jsonResponse{
+		Error:   false,
+		Message: "Found the log entry.",
+		Data:    entry,
+	}
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+func parseQuery(params url.Values) (data.Query, error) {
+	query := data.Query{
+		Name:     params.Get("name"),
+		Severity: data.Severity(params.Get("severity")),
+		Service:  params.Get("service"),
+		Search:   params.Get("q"),
+		Cursor:   params.Get("cursor"),
+	}
+
+	for _, bound := range []struct {
+		param string
+		value *time.Time
+	}{
+		{"from", &query.From},
+		{"to", &query.To},
+	} {
+		if raw := params.Get(bound.param); raw != "" {
+			t, err := time.Parse(time.RFC3339, raw)
+			if err != nil {
+				return query, fmt.Errorf("%s must be an RFC 3339 time", bound.param)
+			}
+			*bound.value = t
+		}
+	}
+
+	switch params.Get("sort") {
+	case "", "newest":
+		query.Sort = data.NewestFirst
+	case "oldest":
+		query.Sort = data.OldestFirst
+	default:
+		return query, errors.New("sort must be newest or oldest")
+	}
+
+	if raw := params.Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 {
+			return query, errors.New("limit must be a positive number")
+		}
+		query.Limit = limit
+	}
+
+	return query, nil
+}
+
+// traceID returns the ID of the trace ctx belongs to, so entries written without one can still
+// be found from a trace.
+func traceID(ctx context.Context) string {
+	if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+		return sc.TraceID().String()
+	}
+
+	return ""
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"log-service/data"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func Test_parseQuery(t *testing.T) {
+	params := url.Values{
+		"name":     {"auth"},
+		"severity": {"error"},
+		"service":  {"broker"},
+		"q":        {"logged"},
+		"from":     {"2023-04-01T00:00:00Z"},
+		"to":       {"2023-04-02T00:00:00+02:00"},
+		"sort":     {"oldest"},
+		"limit":    {"20"},
+		"cursor":   {"abc"},
+	}
+
+	query, err := parseQuery(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := data.Query{
+		Name:     "auth",
+		Severity: data.SeverityError,
+		Service:  "broker",
+		Search:   "logged",
+		From:     time.Date(2023, 4, 1, 0, 0, 0, 0, time.UTC),
+		To:       time.Date(2023, 4, 1, 22, 0, 0, 0, time.UTC),
+		Sort:     data.OldestFirst,
+		Limit:    20,
+		Cursor:   "abc",
+	}
+	if query.Name != expected.Name || query.Severity != expected.Severity || query.Service != expected.Service ||
+		query.Search != expected.Search || !query.From.Equal(expected.From) || !query.To.Equal(expected.To) ||
+		query.Sort != expected.Sort || query.Limit != expected.Limit || query.Cursor != expected.Cursor {
+		t.Errorf("expected %+v but got %+v", expected, query)
+	}
+
+	query, err = parseQuery(url.Values{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if query.Sort != data.NewestFirst || query.Limit != 0 || !query.From.IsZero() {
+		t.Errorf("expected the defaults but got %+v", query)
+	}
+
+	invalid := []url.Values{
+		{"from": {"yesterday"}},
+		{"to": {"2023-04-01"}},
+		{"sort": {"random"}},
+		{"limit": {"0"}},
+		{"limit": {"many"}},
+	}
+	for _, params := range invalid {
+		if _, err := parseQuery(params); err == nil {
+			t.Errorf("%v: expected an error", params)
+		}
+	}
+}
@@ -13,11 +13,15 @@ type jsonResponse struct {
 	Data    any    `json:"data,omitempty"`
 }
 
-// readJSON tries to read the body of a request and converts it into JSON
-func (app *Config) readJSON(w http.ResponseWriter, r *http.Request, data any) error {
-	maxBytes := 1048576 // one megabyte
+// readJSON tries to read the body of a request and converts it into JSON. The body may be one
+// megabyte, unless a larger limit is passed.
+func (app *Config) readJSON(w http.ResponseWriter, r *http.Request, data any, limit ...int64) error {
+	maxBytes := int64(1048576) // one megabyte
+	if len(limit) > 0 {
+		maxBytes = limit[0]
+	}
 
-	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
 
 	dec := json.NewDecoder(r.Body)
 	err := dec.Decode(data)
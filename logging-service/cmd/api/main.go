@@ -2,59 +2,151 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"log-service/config"
 	"log-service/data"
+	"log-service/health"
+	"log-service/ingest"
 	"log-service/logs"
+	"log-service/metrics"
+	"log-service/tail"
+	"log-service/token"
+	"log-service/tracing"
 	"net"
 	"net/http"
 	"net/rpc"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc/filters"
 	"google.golang.org/grpc"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const (
+	// shutdownTimeout bounds how long in-flight requests and calls may take to finish after
+	// SIGTERM
+	shutdownTimeout = 20 * time.Second
+	// readinessTimeout bounds the checks behind /readyz
+	readinessTimeout = 2 * time.Second
 )
 
 type Config struct {
-	Models   data.Models
-	WebPort  string
-	RPCPort  string
-	GRPCPort string
-	MongoURL string
+	Settings
+	Models data.Models
+	Health *health.Checker
+	// Ingest batches the entries written to the batch endpoints
+	Ingest *ingest.Buffer
+	// Tail hands the tail streams the entries as they are stored
+	Tail *tail.Hub
+	// Keys verify the access tokens of the endpoints that need one
+	Keys *token.KeySet
 }
 
 func main() {
+	// load the settings from flags, environment variables and an optional YAML file
+	var settings Settings
+	config.Parse(&settings)
+
 	app := Config{
-		WebPort:  "80",
-		RPCPort:  "5001",
-		GRPCPort: "50001",
-		MongoURL: "mongodb://mongo:27017",
+		Settings: settings,
+		Health:   health.New(readinessTimeout),
+	}
+
+	// export traces to the exporter named in OTEL_TRACES_EXPORTER
+	shutdownTracing, err := tracing.Setup("logger-service")
+	if err != nil {
+		log.Panic(err)
+	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			log.Printf("Error flushing traces: %v", err)
+		}
+	}()
+
+	// load the keys that verify access tokens
+	app.Keys, err = loadKeySet(app.Settings)
+	if err != nil {
+		log.Panic(err)
 	}
 
 	// connect to mongo
-	mongoClient, err := connectToMongo(app.MongoURL)
+	mongoClient, err := connectToMongo(app.Settings)
 	if err != nil {
-		log.Panicf("Failed to establish connection to mongoDB. URL: %s. Error: %v", app.MongoURL, err)
+		log.Panicf("Failed to establish connection to mongoDB. Error: %v", err)
 	}
 	app.Models = data.New(mongoClient)
 
-	// create a context in order to disconnect
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
-	defer cancel()
+	// queries filter and sort on indexed fields; a failure here only makes them slower, except
+	// for full-text searches, which need the text index
+	indexCtx, cancelIndexes := context.WithTimeout(context.Background(), 15*time.Second)
+	if err := app.Models.LogEntry.EnsureIndexes(indexCtx); err != nil {
+		log.Printf("Failed to create the log indexes. Error: %v", err)
+	}
+	cancelIndexes()
 
-	// close connection
+	// close connection once all servers have stopped
 	defer func() {
-		if err = mongoClient.Disconnect(ctx); err != nil {
-			log.Panicf("Failed to close mongoDB connection. Error: %v", err)
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+
+		if err := mongoClient.Disconnect(ctx); err != nil {
+			log.Printf("Failed to close mongoDB connection. Error: %v", err)
 		}
 	}()
 
+	// the batch endpoints insert their entries together, with the entries of other callers
+	app.Ingest = ingest.New(&app.Models.LogEntry, ingest.Options{
+		BatchSize:     app.IngestBatchSize,
+		FlushInterval: app.IngestFlushInterval,
+		Capacity:      app.IngestCapacity,
+		OnFlush:       metrics.RecordFlush,
+	})
+
+	// every entry stored, whichever way it came, is handed to the tail streams
+	app.Tail = tail.NewHub(app.TailMaxSubscribers)
+	data.Observe(app.Tail.Publish)
+
+	// the service is ready while it can reach mongo
+	app.Health.Add("mongo", func(ctx context.Context) error {
+		return mongoClient.Ping(ctx, readpref.Primary())
+	})
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	// Register RPC & gPRC Server
-	rpcServer := &RPCServer{Client: mongoClient}
-	err = rpc.Register(rpcServer)
-	go app.RPCListen()
-	go app.gRPCListen()
+	if err := rpc.Register(&RPCServer{Models: app.Models}); err != nil {
+		log.Panic(err)
+	}
+	rpcListener, err := app.RPCListen()
+	if err != nil {
+		log.Panic(err)
+	}
+	gRPCServer, gRPCHealth, err := app.gRPCListen()
+	if err != nil {
+		log.Panic(err)
+	}
+
+	// delete the entries the retention policies expire until the service stops
+	sweeperDone := make(chan struct{})
+	if app.RetentionInterval > 0 {
+		go func() {
+			app.sweepRetention(ctx)
+			close(sweeperDone)
+		}()
+	} else {
+		close(sweeperDone)
+	}
 
 	// start web server
 	log.Println("Starting service on port", app.WebPort)
@@ -63,55 +155,128 @@ func main() {
 		Handler: app.routes(),
 	}
 
-	err = srv.ListenAndServe()
-	if err != nil {
-		log.Panic()
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Panic(err)
+		}
+	}()
+
+	<-ctx.Done()
+	log.Println("Shutting down service...")
+
+	// report not ready on every transport, then let requests and calls in flight finish
+	app.Health.Drain()
+	gRPCHealth.Shutdown()
+
+	// tail streams never finish on their own, so end them before waiting for the requests
+	app.Tail.Close()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Error shutting down the HTTP server: %v", err)
+	}
+
+	if err := rpcListener.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Error shutting down the RPC server: %v", err)
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		gRPCServer.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-shutdownCtx.Done():
+		log.Println("gRPC calls didn't finish in time, stopping the gRPC server")
+		gRPCServer.Stop()
 	}
+
+	// store the entries still waiting in the ingest buffer
+	if err := app.Ingest.Close(shutdownCtx); err != nil {
+		log.Printf("Error flushing the ingest buffer: %v", err)
+	}
+
+	// the sweep stops with ctx; mongo is disconnected once it has
+	<-sweeperDone
 }
 
-func (app *Config) RPCListen() error {
+// RPCListen starts serving the registered RPC server.
+func (app *Config) RPCListen() (*RPCListener, error) {
 	log.Println("Starting RPC server on port ", app.RPCPort)
 	listener, err := net.Listen("tcp", fmt.Sprintf("0.0.0.0:%s", app.RPCPort))
 	if err != nil {
-		log.Printf("Failed to establish TCP connection for RPC on port: %s. Error: %v", app.RPCPort, err)
-		return err
+		return nil, fmt.Errorf("failed to listen for RPC on port %s: %w", app.RPCPort, err)
 	}
-	defer listener.Close()
 
-	for {
-		rpcConn, err := listener.Accept()
-		if err != nil {
-			continue
+	rpcListener := NewRPCListener(listener)
+	go func() {
+		if err := rpcListener.Serve(); err != nil {
+			log.Panicf("Failed to serve RPC: %v", err)
 		}
+	}()
 
-		go rpc.ServeConn(rpcConn)
-	}
+	return rpcListener, nil
 }
 
-func (app *Config) gRPCListen() error {
+// gRPCListen starts serving the log service and the standard health service over gRPC.
+func (app *Config) gRPCListen() (*grpc.Server, *grpchealth.Server, error) {
 	listener, err := net.Listen("tcp", fmt.Sprintf("0.0.0.0:%s", app.GRPCPort))
 	if err != nil {
-		log.Panicf("Failed to establish TCP connection for gRPC on port: %s. Error: %v", app.GRPCPort, err)
+		return nil, nil, fmt.Errorf("failed to listen for gRPC on port %s: %w", app.GRPCPort, err)
 	}
-	defer listener.Close()
 
-	gRPCServer := grpc.NewServer()
-	logs.RegisterLogServiceServer(gRPCServer, &LogServer{Models: app.Models})
+	// calls continue the trace of the caller; health checks are left out of the traces. The calls
+	// that read entries need an access token, which is checked within the trace.
+	notHealth := otelgrpc.WithInterceptorFilter(filters.Not(filters.HealthCheck()))
+	gRPCServer := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(otelgrpc.UnaryServerInterceptor(notHealth), app.authorizeUnary),
+		grpc.ChainStreamInterceptor(otelgrpc.StreamServerInterceptor(notHealth), app.authorizeStream),
+	)
+	logs.RegisterLogServiceServer(gRPCServer, &LogServer{
+		Models:    app.Models,
+		Ingest:    app.Ingest,
+		BatchSize: app.IngestBatchSize,
+		Tail:      app.Tail,
+	})
+
+	// the standard health service lets clients check their connection without writing a log
+	healthServer := grpchealth.NewServer()
+	healthpb.RegisterHealthServer(gRPCServer, healthServer)
 
-	if err := gRPCServer.Serve(listener); err != nil {
-		log.Panicf("Failed to listen gRPC: %v", err)
+	go func() {
+		log.Printf("gRPCServer starting on port: %s", app.GRPCPort)
+		if err := gRPCServer.Serve(listener); err != nil {
+			log.Panicf("Failed to listen gRPC: %v", err)
+		}
+	}()
+
+	return gRPCServer, healthServer, nil
+}
+
+// loadKeySet prefers a local JWKS file, which allows verifying tokens completely offline, and falls
+// back to fetching the keys from the authentication service.
+func loadKeySet(settings Settings) (*token.KeySet, error) {
+	if settings.JWKSFile != "" {
+		log.Printf("Verifying access tokens with keys from %s", settings.JWKSFile)
+		return token.LoadFile(settings.JWKSFile, settings.TokenIssuer)
 	}
-	log.Printf("gRPCServer starting on port: %s", app.GRPCPort)
 
-	return nil
+	log.Printf("Verifying access tokens with keys from %s", settings.JWKSURL)
+	return token.NewRemoteKeySet(settings.JWKSURL, settings.TokenIssuer), nil
 }
 
-func connectToMongo(mongoURL string) (*mongo.Client, error) {
-	clientOptions := options.Client().ApplyURI(mongoURL)
-	clientOptions.SetAuth(options.Credential{
-		Username: "admin",
-		Password: "password",
-	})
+func connectToMongo(settings Settings) (*mongo.Client, error) {
+	clientOptions := options.Client().ApplyURI(settings.MongoURL)
+	if settings.MongoUsername != "" {
+		clientOptions.SetAuth(options.Credential{
+			Username: settings.MongoUsername,
+			Password: settings.MongoPassword,
+		})
+	}
 
 	conn, err := mongo.Connect(context.TODO(), clientOptions)
 	if err != nil {
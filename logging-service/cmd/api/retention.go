@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"log-service/data"
+	"log-service/metrics"
+	"net/http"
+	"time"
+)
+
+// GetRetention answers GET /admin/retention with the retention policies.
+func (app *Config) GetRetention(w http.ResponseWriter, r *http.Request) {
+	policies, err := app.Models.Retention.Policies(r.Context())
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, jsonResponse{
+		Error:   false,
+		Message: "The retention policies.",
+		Data:    policies,
+	})
+}
+
+// SetRetention answers PUT /admin/retention by replacing the retention policies. They apply from
+// the next sweep on.
+func (app *Config) SetRetention(w http.ResponseWriter, r *http.Request) {
+	var policies data.RetentionPolicies
+	if err := app.readJSON(w, r, &policies); err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err := app.Models.Retention.SetPolicies(r.Context(), &policies)
+	if errors.Is(err, data.ErrInvalidPolicy) {
+		app.errorJSON(w, err)
+		return
+	}
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, jsonResponse{
+		Error:   false,
+		Message: "The retention policies are updated.",
+		Data:    policies,
+	})
+}
+
+// sweepRetention deletes expired entries every RetentionInterval until ctx is done. A sweep
+// that is cut short by ctx leaves the entries it didn't get to for the next start.
+func (app *Config) sweepRetention(ctx context.Context) {
+	var archiver data.Archiver
+	if app.ArchiveDir != "" {
+		archiver = data.FileArchiver{Dir: app.ArchiveDir}
+	}
+
+	ticker := time.NewTicker(app.RetentionInterval)
+	defer ticker.Stop()
+
+	for {
+		deleted, err := app.Models.Retention.Sweep(ctx, archiver)
+		metrics.Expired.Add(float64(deleted))
+		switch {
+		case err != nil && ctx.Err() == nil:
+			metrics.Sweeps.WithLabelValues(metrics.Failed).Inc()
+			log.Printf("Retention sweep failed after deleting %d entries: %v", deleted, err)
+		case err == nil:
+			metrics.Sweeps.WithLabelValues(metrics.Completed).Inc()
+			if deleted > 0 {
+				log.Printf("Retention sweep deleted %d expired entries", deleted)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
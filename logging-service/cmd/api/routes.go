@@ -1,6 +1,8 @@
 package main
 
 import (
+	"log-service/metrics"
+	"log-service/tracing"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
@@ -22,8 +24,29 @@ func (app *Config) routes() http.Handler {
 	}))
 
 	mux.Use(middleware.Heartbeat("/ping"))
+	mux.Use(tracing.Middleware)
+	mux.Use(metrics.Middleware)
+
+	mux.Handle("/metrics", metrics.Handler())
+	mux.Get("/livez", app.Health.Livez)
+	mux.Get("/readyz", app.Health.Readyz)
 
 	mux.Post("/log", app.WriteLog)
+	mux.Post("/logs/batch", app.WriteLogs)
+	mux.Group(func(mux chi.Router) {
+		mux.Use(app.requireRole(readerRoles...))
+
+		mux.Get("/logs", app.ListLogs)
+		mux.Get("/logs/tail", app.TailLogs)
+		mux.Get("/logs/{id}", app.GetLog)
+	})
+
+	mux.Group(func(mux chi.Router) {
+		mux.Use(app.requireRole(roleAdmin))
+
+		mux.Get("/admin/retention", app.GetRetention)
+		mux.Put("/admin/retention", app.SetRetention)
+	})
 
 	return mux
 }
@@ -2,34 +2,179 @@ package main
 
 import (
 	"context"
-	"go.mongodb.org/mongo-driver/mongo"
 	"log"
 	"log-service/data"
+	"log-service/metrics"
+	"log-service/tracing"
+	"net"
+	"net/rpc"
+	"sync"
 	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type RPCServer struct {
-	Client *mongo.Client
+	Models data.Models
 }
 
+// RPCPayload is the argument of LogInfo. net/rpc has no metadata, so callers send the trace
+// context of the call in Trace. Only Name and Data are required; gob leaves the fields older
+// callers don't send at their zero values.
 type RPCPayload struct {
-	Name string
-	Data string
+	Name  string
+	Data  string
+	Trace map[string]string
+
+	Severity   string
+	Service    string
+	TraceID    string
+	UserID     string
+	Timestamp  time.Time
+	Attributes map[string]interface{}
 }
 
 func (r *RPCServer) LogInfo(payload RPCPayload, resp *string) error {
-	collection := r.Client.Database("logs").Collection("logs")
-	_, err := collection.InsertOne(context.TODO(), data.LogEntry{
-		Name:      payload.Name,
-		Data:      payload.Data,
-		CreatedAt: time.Now(),
-	})
+	ctx := otel.GetTextMapPropagator().Extract(context.Background(), propagation.MapCarrier(payload.Trace))
+	ctx, span := tracing.Tracer().Start(ctx, "RPCServer.LogInfo",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			semconv.RPCSystemKey.String("net_rpc"),
+			semconv.RPCService("RPCServer"),
+			semconv.RPCMethod("LogInfo"),
+		),
+	)
+	defer span.End()
+
+	entry := data.LogEntry{
+		Name:       payload.Name,
+		Data:       payload.Data,
+		Severity:   data.Severity(payload.Severity),
+		Service:    payload.Service,
+		TraceID:    payload.TraceID,
+		UserID:     payload.UserID,
+		Timestamp:  payload.Timestamp,
+		Attributes: payload.Attributes,
+	}
+	if entry.TraceID == "" {
+		entry.TraceID = traceID(ctx)
+	}
+
+	_, err := r.Models.LogEntry.Insert(ctx, entry)
+	metrics.RecordInsert(metrics.RPC, err)
 
 	if err != nil {
-		log.Println("error writing to mongo", err)
+		log.Println("error writing log entry", err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
 		return err
 	}
 
 	*resp = "Processed payload via RPC"
 	return nil
 }
+
+// Ping lets clients check that their connection works. It doesn't touch the database.
+func (r *RPCServer) Ping(_ string, resp *string) error {
+	*resp = "pong"
+	return nil
+}
+
+// RPCListener serves net/rpc on every connection it accepts and keeps track of the
+// connections, so they can be drained on shutdown.
+type RPCListener struct {
+	listener net.Listener
+
+	mu     sync.Mutex
+	conns  map[net.Conn]struct{}
+	closed bool
+	wg     sync.WaitGroup
+}
+
+func NewRPCListener(listener net.Listener) *RPCListener {
+	return &RPCListener{
+		listener: listener,
+		conns:    map[net.Conn]struct{}{},
+	}
+}
+
+// Serve accepts connections until Shutdown is called.
+func (l *RPCListener) Serve() error {
+	for {
+		conn, err := l.listener.Accept()
+		if err != nil {
+			l.mu.Lock()
+			closed := l.closed
+			l.mu.Unlock()
+
+			if closed {
+				return nil
+			}
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			return err
+		}
+
+		l.mu.Lock()
+		if l.closed {
+			l.mu.Unlock()
+			_ = conn.Close()
+			return nil
+		}
+		l.conns[conn] = struct{}{}
+		l.wg.Add(1)
+		l.mu.Unlock()
+
+		go l.serve(conn)
+	}
+}
+
+func (l *RPCListener) serve(conn net.Conn) {
+	defer l.wg.Done()
+
+	rpc.ServeConn(conn)
+
+	l.mu.Lock()
+	delete(l.conns, conn)
+	l.mu.Unlock()
+}
+
+// Shutdown stops accepting connections and stops reading requests from the open ones. The
+// server answers the calls in flight and then closes each connection; connections still open
+// when ctx ends are closed right away.
+func (l *RPCListener) Shutdown(ctx context.Context) error {
+	l.mu.Lock()
+	l.closed = true
+	err := l.listener.Close()
+	for conn := range l.conns {
+		if tcp, ok := conn.(interface{ CloseRead() error }); ok {
+			_ = tcp.CloseRead()
+		} else {
+			_ = conn.Close()
+		}
+	}
+	l.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		l.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return err
+	case <-ctx.Done():
+		l.mu.Lock()
+		for conn := range l.conns {
+			_ = conn.Close()
+		}
+		l.mu.Unlock()
+		return ctx.Err()
+	}
+}
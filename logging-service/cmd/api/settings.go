@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Settings configure the logger service; see package config for where they are read from.
+type Settings struct {
+	WebPort             string        `yaml:"web_port" env:"WEB_PORT" default:"80" usage:"port of the HTTP server"`
+	RPCPort             string        `yaml:"rpc_port" env:"RPC_PORT" default:"5001" usage:"port of the RPC server"`
+	GRPCPort            string        `yaml:"grpc_port" env:"GRPC_PORT" default:"50001" usage:"port of the gRPC server"`
+	MongoURL            string        `yaml:"mongo_url" env:"MONGO_URL" default:"mongodb://mongo:27017" secret:"true" usage:"URL of MongoDB"`
+	MongoUsername       string        `yaml:"mongo_username" env:"MONGO_USERNAME" usage:"user the service authenticates to MongoDB as"`
+	MongoPassword       string        `yaml:"mongo_password" env:"MONGO_PASSWORD" secret:"true" usage:"password of mongo_username"`
+	IngestBatchSize     int           `yaml:"ingest_batch_size" env:"INGEST_BATCH_SIZE" default:"500" usage:"entries of the batch endpoints inserted together"`
+	IngestFlushInterval time.Duration `yaml:"ingest_flush_interval" env:"INGEST_FLUSH_INTERVAL" default:"100ms" usage:"how long entries of the batch endpoints wait at most before they are inserted"`
+	IngestCapacity      int           `yaml:"ingest_capacity" env:"INGEST_CAPACITY" default:"10000" usage:"entries waiting to be inserted before the batch endpoints block"`
+	TailMaxSubscribers  int           `yaml:"tail_max_subscribers" env:"TAIL_MAX_SUBSCRIBERS" default:"100" usage:"tail streams served at once"`
+	// RetentionInterval is zero on replicas that leave the sweeping to another one.
+	RetentionInterval time.Duration `yaml:"retention_interval" env:"RETENTION_INTERVAL" default:"1h" usage:"how often entries expired by the retention policies are deleted; 0 turns the sweeper off"`
+	ArchiveDir        string        `yaml:"archive_dir" env:"ARCHIVE_DIR" usage:"directory expiring entries are exported to as gzipped NDJSON before they are deleted; not exported if empty"`
+	JWKSFile          string        `yaml:"jwks_file" env:"JWKS_FILE" usage:"file with the keys that verify access tokens; the keys are fetched from jwks_url if empty"`
+	JWKSURL           string        `yaml:"jwks_url" env:"JWKS_URL" default:"http://authentication-service/.well-known/jwks.json" usage:"URL the keys that verify access tokens are fetched from"`
+	TokenIssuer       string        `yaml:"jwt_issuer" env:"JWT_ISSUER" default:"http://authentication-service" usage:"issuer access tokens must have"`
+}
+
+func (s *Settings) Validate() error {
+	ports := []struct{ key, value string }{
+		{"web_port", s.WebPort},
+		{"rpc_port", s.RPCPort},
+		{"grpc_port", s.GRPCPort},
+	}
+	for _, p := range ports {
+		if n, err := strconv.Atoi(p.value); err != nil || n < 1 || n > 65535 {
+			return fmt.Errorf("%s: %q is not a port", p.key, p.value)
+		}
+	}
+
+	if u, err := url.Parse(s.MongoURL); err != nil || (u.Scheme != "mongodb" && u.Scheme != "mongodb+srv") {
+		return errors.New("mongo_url is not a mongodb:// URL")
+	}
+
+	if s.MongoPassword != "" && s.MongoUsername == "" {
+		return errors.New("mongo_password is set without mongo_username")
+	}
+
+	if s.IngestBatchSize < 1 || s.IngestFlushInterval <= 0 {
+		return errors.New("ingest_batch_size and ingest_flush_interval must be positive")
+	}
+	// a request to POST /logs/batch must fit in the buffer
+	if s.IngestCapacity < s.IngestBatchSize || s.IngestCapacity < maxBatchEntries {
+		return fmt.Errorf("ingest_capacity must be at least ingest_batch_size and %d", maxBatchEntries)
+	}
+
+	if s.TailMaxSubscribers < 1 {
+		return errors.New("tail_max_subscribers must be positive")
+	}
+
+	if s.RetentionInterval < 0 {
+		return errors.New("retention_interval must not be negative")
+	}
+
+	if s.ArchiveDir != "" {
+		if info, err := os.Stat(s.ArchiveDir); err != nil || !info.IsDir() {
+			return fmt.Errorf("archive_dir: %q is not a directory", s.ArchiveDir)
+		}
+	}
+
+	if u, err := url.Parse(s.JWKSURL); err != nil || u.Scheme == "" || u.Host == "" {
+		return errors.New("jwks_url is not an absolute URL")
+	}
+	if s.TokenIssuer == "" {
+		return errors.New("jwt_issuer must not be empty")
+	}
+
+	return nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"log-service/health"
+	"log-service/token"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const testIssuer = "http://authentication-service"
+
+var (
+	testApp        Config
+	testSigningKey *rsa.PrivateKey
+)
+
+func TestMain(m *testing.M) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	testSigningKey = key
+
+	dir, err := os.MkdirTemp("", "logger-test")
+	if err != nil {
+		panic(err)
+	}
+
+	jwksFile := filepath.Join(dir, "jwks.json")
+	if err := writeJWKS(jwksFile, &key.PublicKey); err != nil {
+		panic(err)
+	}
+
+	keys, err := token.LoadFile(jwksFile, testIssuer)
+	if err != nil {
+		panic(err)
+	}
+	testApp.Keys = keys
+	testApp.Health = health.New(time.Second)
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func writeJWKS(path string, pub *rsa.PublicKey) error {
+	set := map[string]any{
+		"keys": []map[string]string{
+			{
+				"kty": "RSA",
+				"kid": "test-key",
+				"alg": "RS256",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			},
+		},
+	}
+
+	out, err := json.Marshal(set)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, out, 0600)
+}
+
+// signTestToken returns an access token for user id 1 shaped like the ones issued by the
+// authentication service.
+func signTestToken(key *rsa.PrivateKey, roles []string, ttl time.Duration) string {
+	return signTestClaims(key, token.Claims{Email: "me@here.com", Roles: roles}, ttl)
+}
+
+// signTestClaims signs claims for user id 1 with the registered claims of an access token.
+func signTestClaims(key *rsa.PrivateKey, claims token.Claims, ttl time.Duration) string {
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		Issuer:    testIssuer,
+		Subject:   strconv.Itoa(1),
+		Audience:  jwt.ClaimStrings{token.Audience},
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl)),
+		IssuedAt:  jwt.NewNumericDate(time.Now()),
+	}
+
+	t := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	t.Header["kid"] = "test-key"
+
+	signed, _ := t.SignedString(key)
+	return signed
+}
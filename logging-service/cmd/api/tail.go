@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log-service/tail"
+	"net/http"
+	"time"
+)
+
+// tailHeartbeat is how often an idle tail stream sends a comment, so proxies keep it open.
+const tailHeartbeat = 15 * time.Second
+
+// TailLogs answers GET /logs/tail with a stream of Server-Sent Events of the entries stored from
+// now on that match the query parameters name, severity, service and q. Each entry is a log
+// event with the entry as JSON. When the client read too slowly, a dropped event with the number
+// of entries left out comes before the next entry. The stream ends when the client goes away or
+// the service shuts down.
+func (app *Config) TailLogs(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	filter, err := tail.NewFilter(params.Get("name"), params.Get("severity"), params.Get("service"), params.Get("q"))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		app.errorJSON(w, errors.New("streaming is not supported"), http.StatusInternalServerError)
+		return
+	}
+
+	subscription, err := app.Tail.Subscribe(filter)
+	if err != nil {
+		w.Header().Set("Retry-After", "5")
+		app.errorJSON(w, err, http.StatusServiceUnavailable)
+		return
+	}
+	defer subscription.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// keep nginx and the like from buffering the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(tailHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case entry, ok := <-subscription.C:
+			if !ok {
+				return
+			}
+
+			if dropped := subscription.Dropped(); dropped > 0 {
+				fmt.Fprintf(w, "event: dropped\ndata: {\"dropped\":%d}\n\n", dropped)
+			}
+
+			out, err := json.Marshal(entry)
+			if err != nil {
+				return
+			}
+			fmt.Fprintf(w, "id: %s\nevent: log\ndata: %s\n\n", entry.ID, out)
+		}
+
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"log-service/data"
+	"log-service/tail"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readEvent reads the next Server-Sent Event and returns its fields.
+func readEvent(t *testing.T, r *bufio.Reader) map[string]string {
+	t.Helper()
+
+	event := map[string]string{}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading the stream: %v", err)
+		}
+
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			if len(event) > 0 {
+				return event
+			}
+			continue
+		}
+
+		field, value, _ := strings.Cut(line, ": ")
+		event[field] = value
+	}
+}
+
+func Test_TailLogs(t *testing.T) {
+	app := testApp
+	app.Tail = tail.NewHub(1)
+
+	server := httptest.NewServer(app.routes())
+	defer server.Close()
+
+	get := func(query string) *http.Response {
+		req, _ := http.NewRequest(http.MethodGet, server.URL+"/logs/tail"+query, nil)
+		req.Header.Set("Authorization", "Bearer "+signTestToken(testSigningKey, []string{"log-reader"}, time.Minute))
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return res
+	}
+
+	if res := get("?severity=fatal"); res.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400 for an unknown severity but got %d", res.StatusCode)
+	}
+
+	res := get("?severity=error")
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 but got %d", res.StatusCode)
+	}
+	if contentType := res.Header.Get("Content-Type"); contentType != "text/event-stream" {
+		t.Errorf("expected an event stream but got %q", contentType)
+	}
+
+	// the hub takes one stream, and this one is subscribed once its headers are sent
+	busy := get("")
+	if busy.StatusCode != http.StatusServiceUnavailable || busy.Header.Get("Retry-After") == "" {
+		t.Errorf("expected 503 with Retry-After for too many streams but got %d", busy.StatusCode)
+	}
+	busy.Body.Close()
+
+	app.Tail.Publish([]*data.LogEntry{
+		{ID: "642f0a5be2d1c3a6b1f0c9d1", Name: "checkout", Data: "Paid", Severity: data.SeverityInfo},
+		{ID: "642f0a5be2d1c3a6b1f0c9d2", Name: "checkout", Data: "Payment declined", Severity: data.SeverityError},
+	})
+
+	stream := bufio.NewReader(res.Body)
+	event := readEvent(t, stream)
+
+	if event["event"] != "log" || event["id"] != "642f0a5be2d1c3a6b1f0c9d2" {
+		t.Errorf("expected the error entry as a log event but got %v", event)
+	}
+
+	var entry data.LogEntry
+	if err := json.Unmarshal([]byte(event["data"]), &entry); err != nil || entry.Data != "Payment declined" {
+		t.Errorf("expected the entry as JSON but got %q, %v", event["data"], err)
+	}
+
+	// the stream ends when the service shuts down
+	app.Tail.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := io.ReadAll(stream)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("expected the stream to end cleanly but got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the stream to end when the hub closes")
+	}
+}
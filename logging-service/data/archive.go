@@ -0,0 +1,99 @@
+package data
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// maxPolicyNameLength bounds the part of an archive file name taken from the policy, so long log
+// names keep the file name within what file systems take.
+const maxPolicyNameLength = 64
+
+// FileArchiver archives expiring entries to gzip-compressed NDJSON files in Dir, one file per
+// batch, with one entry per line as GET /logs returns it.
+type FileArchiver struct {
+	Dir string
+}
+
+// Archive writes entries to a new file named after the policy that expired them, the time and
+// the first entry, such as logs-severity=info-20230406T180443Z-642f0a5be2d1c3a6b1f0c9d2.ndjson.gz.
+// Log names may hold any character, so the policy part of the name is made safe by archiveName.
+// The file is written under a temporary name and renamed once it is complete, so a file with
+// the final name always holds its whole batch.
+func (a FileArchiver) Archive(ctx context.Context, policy RetentionPolicy, entries []*LogEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	name := fmt.Sprintf("logs-%s-%s-%s.ndjson.gz", archiveName(policy), time.Now().UTC().Format("20060102T150405Z"), entries[0].ID)
+	path := filepath.Join(a.Dir, name)
+
+	file, err := os.CreateTemp(a.Dir, name+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	compressed := gzip.NewWriter(file)
+	encoder := json.NewEncoder(compressed)
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := encoder.Encode(entry); err != nil {
+			return err
+		}
+	}
+
+	if err := compressed.Close(); err != nil {
+		return err
+	}
+	// the entries are deleted once this returns, so they must be on disk by then
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(file.Name(), path)
+}
+
+// archiveName returns the policy as it appears in archive file names. Characters other than
+// letters, digits and ".=,_-" are replaced by underscores; when any are, or the name is too
+// long, a hash of the policy is appended so policies whose names only differ in those
+// characters still get names of their own.
+func archiveName(policy RetentionPolicy) string {
+	original := policy.String()
+
+	safe := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		case strings.ContainsRune(".=,_-", r):
+			return r
+		default:
+			return '_'
+		}
+	}, original)
+
+	if safe == original && len(safe) <= maxPolicyNameLength {
+		return safe
+	}
+
+	sum := sha256.Sum256([]byte(original))
+	if len(safe) > maxPolicyNameLength-9 {
+		safe = safe[:maxPolicyNameLength-9]
+	}
+
+	return safe + "_" + hex.EncodeToString(sum[:4])
+}
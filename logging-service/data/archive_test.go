@@ -0,0 +1,107 @@
+package data
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_archiveName(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy RetentionPolicy
+		want   string
+	}{
+		{"default", RetentionPolicy{}, "default"},
+		{"severity", RetentionPolicy{Severity: SeverityInfo}, "severity=info"},
+		{"name and severity", RetentionPolicy{Name: "auth-events", Severity: SeverityError}, "name=auth-events,severity=error"},
+		{"slash", RetentionPolicy{Name: "billing/invoices"}, "name=billing_invoices_"},
+		{"parent directory", RetentionPolicy{Name: "../../etc"}, "name=.._.._etc_"},
+		{"long", RetentionPolicy{Name: strings.Repeat("a", 300)}, "name=aaaa"},
+	}
+
+	for _, e := range tests {
+		got := archiveName(e.policy)
+
+		if !strings.HasPrefix(got, e.want) {
+			t.Errorf("%s: expected a name starting with %q but got %q", e.name, e.want, got)
+		}
+		if strings.ContainsAny(got, `/\`) || len(got) > maxPolicyNameLength {
+			t.Errorf("%s: %q is not a safe file name part", e.name, got)
+		}
+	}
+
+	// names that only differ in replaced characters still get their own file names
+	if archiveName(RetentionPolicy{Name: "a/b"}) == archiveName(RetentionPolicy{Name: "a b"}) {
+		t.Error("expected different policies to get different names")
+	}
+}
+
+func Test_FileArchiver_Archive(t *testing.T) {
+	dir := t.TempDir()
+	archiver := FileArchiver{Dir: dir}
+
+	entries := []*LogEntry{
+		{ID: "642f0a5be2d1c3a6b1f0c9d2", Name: "billing/invoices", Data: "first"},
+		{ID: "642f0a5be2d1c3a6b1f0c9d3", Name: "billing/invoices", Data: "second"},
+	}
+
+	if err := archiver.Archive(context.Background(), RetentionPolicy{Name: "billing/invoices"}, entries); err != nil {
+		t.Fatalf("expected the entries to be archived but got %v", err)
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("expected one archive without temporary files but got %v", files)
+	}
+
+	name := files[0].Name()
+	if !strings.HasPrefix(name, "logs-name=billing_invoices_") || !strings.HasSuffix(name, "-642f0a5be2d1c3a6b1f0c9d2.ndjson.gz") {
+		t.Errorf("unexpected archive name %q", name)
+	}
+
+	file, err := os.Open(filepath.Join(dir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	compressed, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var archived []string
+	scanner := bufio.NewScanner(compressed)
+	for scanner.Scan() {
+		var entry LogEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("expected one entry per line but got %q: %v", scanner.Text(), err)
+		}
+		archived = append(archived, entry.Data)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(archived) != 2 || archived[0] != "first" || archived[1] != "second" {
+		t.Errorf("expected both entries in order but got %v", archived)
+	}
+}
+
+func Test_FileArchiver_Archive_failure(t *testing.T) {
+	archiver := FileArchiver{Dir: filepath.Join(t.TempDir(), "missing")}
+
+	err := archiver.Archive(context.Background(), RetentionPolicy{}, []*LogEntry{{ID: "642f0a5be2d1c3a6b1f0c9d2"}})
+	if err == nil {
+		t.Error("expected archiving to a missing directory to fail")
+	}
+}
@@ -0,0 +1,154 @@
+package data
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// MaxAttributes is the largest number of attributes an entry may have.
+const MaxAttributes = 64
+
+// ErrInvalidEntry is returned for entries that can't be stored, such as those with an unknown
+// severity.
+var ErrInvalidEntry = errors.New("invalid log entry")
+
+// Severity is the level of a log entry.
+type Severity string
+
+const (
+	SeverityDebug   Severity = "debug"
+	SeverityInfo    Severity = "info"
+	SeverityWarning Severity = "warning"
+	SeverityError   Severity = "error"
+)
+
+// ParseSeverity returns the severity with the given name, ignoring case. "warn" is accepted for
+// warning, and an empty name means info, the severity of entries written before severities
+// existed.
+func ParseSeverity(name string) (Severity, error) {
+	switch strings.ToLower(name) {
+	case "":
+		return SeverityInfo, nil
+	case "debug":
+		return SeverityDebug, nil
+	case "info":
+		return SeverityInfo, nil
+	case "warning", "warn":
+		return SeverityWarning, nil
+	case "error":
+		return SeverityError, nil
+	default:
+		return "", fmt.Errorf("%w: unknown severity %q", ErrInvalidEntry, name)
+	}
+}
+
+// Attributes are typed values attached to a log entry. Values are strings, int64s, float64s or
+// bools.
+type Attributes map[string]interface{}
+
+// NewAttributes checks the values of attrs and converts them to the types Attributes hold.
+// Numbers decoded with json.Decoder.UseNumber stay integers when they have no fraction.
+func NewAttributes(attrs map[string]interface{}) (Attributes, error) {
+	if len(attrs) == 0 {
+		return nil, nil
+	}
+	if len(attrs) > MaxAttributes {
+		return nil, fmt.Errorf("%w: more than %d attributes", ErrInvalidEntry, MaxAttributes)
+	}
+
+	out := make(Attributes, len(attrs))
+	for key, value := range attrs {
+		// MongoDB reads dots as paths and dollars as operators
+		if key == "" || strings.ContainsAny(key, ".$") {
+			return nil, fmt.Errorf("%w: attribute name %q must be non-empty without dots or dollars", ErrInvalidEntry, key)
+		}
+
+		switch v := value.(type) {
+		case string, int64, float64, bool:
+			out[key] = v
+		case int:
+			out[key] = int64(v)
+		case int32:
+			out[key] = int64(v)
+		case float32:
+			out[key] = float64(v)
+		case json.Number:
+			if n, err := v.Int64(); err == nil {
+				out[key] = n
+			} else if f, err := v.Float64(); err == nil {
+				out[key] = f
+			} else {
+				return nil, fmt.Errorf("%w: attribute %q is not a valid number", ErrInvalidEntry, key)
+			}
+		default:
+			return nil, fmt.Errorf("%w: attribute %q must be a string, number or boolean", ErrInvalidEntry, key)
+		}
+	}
+
+	return out, nil
+}
+
+func (a *Attributes) UnmarshalJSON(b []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+
+	var raw map[string]interface{}
+	if err := dec.Decode(&raw); err != nil {
+		return err
+	}
+
+	attrs, err := NewAttributes(raw)
+	if err != nil {
+		return err
+	}
+	*a = attrs
+
+	return nil
+}
+
+// normalize checks an entry before it is stored and fills in the defaults: info for the
+// severity and now for the timestamp.
+func (l *LogEntry) normalize(now time.Time) error {
+	severity, err := ParseSeverity(string(l.Severity))
+	if err != nil {
+		return err
+	}
+	l.Severity = severity
+
+	if l.TraceID != "" {
+		if id, err := hex.DecodeString(l.TraceID); err != nil || len(id) != 16 {
+			return fmt.Errorf("%w: trace ID must be 32 hex digits", ErrInvalidEntry)
+		}
+		l.TraceID = strings.ToLower(l.TraceID)
+	}
+
+	attrs, err := NewAttributes(l.Attributes)
+	if err != nil {
+		return err
+	}
+	l.Attributes = attrs
+
+	if l.Timestamp.IsZero() {
+		l.Timestamp = now
+	}
+	l.CreatedAt = now
+	l.UpdatedAt = now
+
+	return nil
+}
+
+// fillLegacy gives entries written before they had a severity and a timestamp the values
+// they would have had.
+func (l *LogEntry) fillLegacy() {
+	if l.Severity == "" {
+		l.Severity = SeverityInfo
+	}
+	if l.Timestamp.IsZero() {
+		l.Timestamp = l.CreatedAt
+	}
+}
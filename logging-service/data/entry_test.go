@@ -0,0 +1,114 @@
+package data
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func Test_ParseSeverity(t *testing.T) {
+	tests := map[string]Severity{
+		"":        SeverityInfo,
+		"debug":   SeverityDebug,
+		"INFO":    SeverityInfo,
+		"warn":    SeverityWarning,
+		"Warning": SeverityWarning,
+		"error":   SeverityError,
+	}
+
+	for name, expected := range tests {
+		severity, err := ParseSeverity(name)
+		if err != nil || severity != expected {
+			t.Errorf("%q: expected %q but got %q, %v", name, expected, severity, err)
+		}
+	}
+
+	if _, err := ParseSeverity("fatal"); !errors.Is(err, ErrInvalidEntry) {
+		t.Errorf("expected ErrInvalidEntry for an unknown severity but got %v", err)
+	}
+}
+
+func Test_Attributes_UnmarshalJSON(t *testing.T) {
+	var attrs Attributes
+	err := json.Unmarshal([]byte(`{"status": 404, "duration": 1.5, "path": "/users", "cached": false}`), &attrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := Attributes{"status": int64(404), "duration": 1.5, "path": "/users", "cached": false}
+	if !reflect.DeepEqual(attrs, expected) {
+		t.Errorf("expected %v but got %v", expected, attrs)
+	}
+
+	invalid := []string{
+		`{"nested": {"a": 1}}`,
+		`{"list": [1, 2]}`,
+		`{"null": null}`,
+		`{"a.b": 1}`,
+		`{"$where": 1}`,
+		`{"": 1}`,
+	}
+	for _, raw := range invalid {
+		var attrs Attributes
+		if err := json.Unmarshal([]byte(raw), &attrs); !errors.Is(err, ErrInvalidEntry) {
+			t.Errorf("%s: expected ErrInvalidEntry but got %v", raw, err)
+		}
+	}
+
+	many := make(map[string]interface{}, MaxAttributes+1)
+	for i := 0; i <= MaxAttributes; i++ {
+		many["a"+strconv.Itoa(i)] = i
+	}
+	if _, err := NewAttributes(many); !errors.Is(err, ErrInvalidEntry) {
+		t.Errorf("expected ErrInvalidEntry for too many attributes but got %v", err)
+	}
+}
+
+func Test_LogEntry_normalize(t *testing.T) {
+	now := time.Date(2023, 4, 6, 18, 4, 43, 0, time.UTC)
+	happened := now.Add(-time.Minute)
+
+	entry := LogEntry{Name: "auth", Data: "logged in", Severity: "WARN", TraceID: "0AF7651916CD43DD8448EB211C80319C", Timestamp: happened}
+	if err := entry.normalize(now); err != nil {
+		t.Fatal(err)
+	}
+	if entry.Severity != SeverityWarning || entry.TraceID != "0af7651916cd43dd8448eb211c80319c" {
+		t.Errorf("expected the severity and trace ID in canonical form but got %q and %q", entry.Severity, entry.TraceID)
+	}
+	if !entry.Timestamp.Equal(happened) || !entry.CreatedAt.Equal(now) || !entry.UpdatedAt.Equal(now) {
+		t.Errorf("expected the timestamp to be kept and the entry created now but got %+v", entry)
+	}
+
+	entry = LogEntry{Name: "auth", Data: "logged in"}
+	if err := entry.normalize(now); err != nil {
+		t.Fatal(err)
+	}
+	if entry.Severity != SeverityInfo || !entry.Timestamp.Equal(now) {
+		t.Errorf("expected info and now as the defaults but got %q and %v", entry.Severity, entry.Timestamp)
+	}
+
+	for _, invalid := range []LogEntry{
+		{Severity: "fatal"},
+		{TraceID: "not-hex"},
+		{TraceID: "0af7651916cd43dd"},
+		{Attributes: Attributes{"a.b": 1}},
+	} {
+		if err := invalid.normalize(now); !errors.Is(err, ErrInvalidEntry) {
+			t.Errorf("%+v: expected ErrInvalidEntry but got %v", invalid, err)
+		}
+	}
+}
+
+func Test_LogEntry_fillLegacy(t *testing.T) {
+	created := time.Date(2022, 3, 14, 0, 0, 0, 0, time.UTC)
+
+	entry := LogEntry{CreatedAt: created}
+	entry.fillLegacy()
+
+	if entry.Severity != SeverityInfo || !entry.Timestamp.Equal(created) {
+		t.Errorf("expected a legacy entry to be info at its creation time but got %q and %v", entry.Severity, entry.Timestamp)
+	}
+}
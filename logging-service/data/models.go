@@ -2,6 +2,7 @@ package data
 
 import (
 	"context"
+	"errors"
 	"log"
 	"time"
 
@@ -13,41 +14,144 @@ import (
 
 var client *mongo.Client
 
+// observer is called with the entries Insert and InsertMany stored; see Observe.
+var observer func(entries []*LogEntry)
+
+// Observe has f called with the entries Insert and InsertMany stored, with their IDs, right
+// after they were stored. f is called by the inserting goroutine, so it must not block. Observe
+// must be called before entries are inserted.
+func Observe(f func(entries []*LogEntry)) {
+	observer = f
+}
+
 func New(mongo *mongo.Client) Models {
 	client = mongo
 
 	return Models{
-		LogEntry: LogEntry{},
+		LogEntry:  LogEntry{},
+		Retention: Retention{},
 	}
 }
 
 type Models struct {
-	LogEntry LogEntry
+	LogEntry  LogEntry
+	Retention Retention
 }
 
 type LogEntry struct {
-	ID        string    `bson:"_id,omitempty" json:"id,omitempty"`
-	Name      string    `bson:"name" json:"name"`
-	Data      string    `bson:"data" json:"data"`
-	CreatedAt time.Time `bson:"created_at" json:"created_at"`
-	UpdatedAt time.Time `bson:"updated_at" json:"updated_at"`
+	ID       string   `bson:"_id,omitempty" json:"id,omitempty"`
+	Name     string   `bson:"name" json:"name"`
+	Data     string   `bson:"data" json:"data"`
+	Severity Severity `bson:"severity" json:"severity"`
+	// Service is the service that produced the entry.
+	Service string `bson:"service,omitempty" json:"service,omitempty"`
+	// TraceID is the OpenTelemetry trace the entry was written in.
+	TraceID string `bson:"trace_id,omitempty" json:"trace_id,omitempty"`
+	UserID  string `bson:"user_id,omitempty" json:"user_id,omitempty"`
+	// Timestamp is when the producer says the entry happened; CreatedAt is when it was stored.
+	Timestamp  time.Time  `bson:"timestamp" json:"timestamp"`
+	Attributes Attributes `bson:"attributes,omitempty" json:"attributes,omitempty"`
+	CreatedAt  time.Time  `bson:"created_at" json:"created_at"`
+	UpdatedAt  time.Time  `bson:"updated_at" json:"updated_at"`
 }
 
-func (l *LogEntry) Insert(entry LogEntry) error {
+// Insert stores entry and returns it as stored, with its ID, its severity and its timestamps.
+// It returns an error wrapping ErrInvalidEntry for entries that can't be stored.
+func (l *LogEntry) Insert(ctx context.Context, entry LogEntry) (*LogEntry, error) {
+	entry.ID = ""
+	if err := entry.normalize(time.Now()); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
 	collection := client.Database("logs").Collection("logs")
 
-	_, err := collection.InsertOne(context.TODO(), LogEntry{
-		Name:      entry.Name,
-		Data:      entry.Data,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
-	})
+	result, err := collection.InsertOne(ctx, entry)
 	if err != nil {
 		log.Println("Error inserting into logs:", err)
-		return err
+		return nil, err
 	}
 
-	return nil
+	if id, ok := result.InsertedID.(primitive.ObjectID); ok {
+		entry.ID = id.Hex()
+	}
+	if observer != nil {
+		observer([]*LogEntry{&entry})
+	}
+
+	return &entry, nil
+}
+
+// InsertMany stores entries with a single round trip to MongoDB. It returns an error for each
+// entry, nil for those that were stored; an entry that fails doesn't keep the others from being
+// stored. Errors of entries that can't be stored wrap ErrInvalidEntry.
+func (l *LogEntry) InsertMany(ctx context.Context, entries []LogEntry) []error {
+	errs := make([]error, len(entries))
+
+	now := time.Now()
+	docs := make([]interface{}, 0, len(entries))
+	// indexes maps the documents sent to MongoDB back to the entries
+	indexes := make([]int, 0, len(entries))
+	for i, entry := range entries {
+		entry.ID = ""
+		if err := entry.normalize(now); err != nil {
+			errs[i] = err
+			continue
+		}
+		docs = append(docs, entry)
+		indexes = append(indexes, i)
+	}
+	if len(docs) == 0 {
+		return errs
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	collection := client.Database("logs").Collection("logs")
+
+	result, err := collection.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	if err != nil {
+		log.Println("Error inserting into logs:", err)
+
+		var bulkErr mongo.BulkWriteException
+		if !errors.As(err, &bulkErr) || bulkErr.WriteConcernError != nil {
+			// nothing tells which of the entries were stored, if any
+			for _, i := range indexes {
+				errs[i] = err
+			}
+			return errs
+		}
+
+		for _, writeErr := range bulkErr.WriteErrors {
+			if writeErr.Index >= 0 && writeErr.Index < len(indexes) {
+				errs[indexes[writeErr.Index]] = writeErr
+			}
+		}
+	}
+
+	if observer != nil && result != nil {
+		stored := make([]*LogEntry, 0, len(docs))
+		for k, i := range indexes {
+			if errs[i] != nil {
+				continue
+			}
+			entry := docs[k].(LogEntry)
+			if k < len(result.InsertedIDs) {
+				if id, ok := result.InsertedIDs[k].(primitive.ObjectID); ok {
+					entry.ID = id.Hex()
+				}
+			}
+			stored = append(stored, &entry)
+		}
+		if len(stored) > 0 {
+			observer(stored)
+		}
+	}
+
+	return errs
 }
 
 func (l *LogEntry) All() ([]*LogEntry, error) {
@@ -76,6 +180,7 @@ func (l *LogEntry) All() ([]*LogEntry, error) {
 			log.Print("Error decoding log into slice:", err)
 			return nil, err
 		} else {
+			item.fillLegacy()
 			logs = append(logs, &item)
 		}
 	}
@@ -89,16 +194,21 @@ func (l *LogEntry) GetOne(id string) (*LogEntry, error) {
 
 	collection := client.Database("logs").Collection("logs")
 
+	// an ID that isn't an ObjectID can't belong to an entry
 	docID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		return nil, err
+		return nil, ErrNotFound
 	}
 
 	var entry LogEntry
 	err = collection.FindOne(ctx, bson.M{"_id": docID}).Decode(&entry)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	}
 	if err != nil {
 		return nil, err
 	}
+	entry.fillLegacy()
 
 	return &entry, nil
 }
@@ -0,0 +1,251 @@
+package data
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// DefaultLimit is the page size of queries that don't set one.
+	DefaultLimit = 50
+	// MaxLimit is the largest page size a query may ask for.
+	MaxLimit = 500
+)
+
+var (
+	// ErrNotFound is returned for log entries that don't exist.
+	ErrNotFound = errors.New("log entry not found")
+	// ErrInvalidQuery is returned for queries that can't be run, such as those with a
+	// malformed cursor.
+	ErrInvalidQuery = errors.New("invalid query")
+)
+
+// Sort is the order of the entries of a query.
+type Sort int
+
+const (
+	NewestFirst Sort = iota
+	OldestFirst
+)
+
+// Query selects log entries. The zero value selects all entries, newest first.
+type Query struct {
+	// Name, Severity and Service match the entries exactly.
+	Name     string
+	Severity Severity
+	Service  string
+	// From and To select entries created at or after From and before To.
+	From time.Time
+	To   time.Time
+	// Search selects entries whose data contains any of its words or its quoted phrases, using
+	// the text index.
+	Search string
+	Sort   Sort
+	// Limit is the page size; DefaultLimit when zero.
+	Limit int
+	// Cursor continues the query after the page that returned it.
+	Cursor string
+}
+
+// Page is a page of entries. NextCursor is empty on the last page.
+type Page struct {
+	Entries    []*LogEntry `json:"entries"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+}
+
+// cursor points after the last entry of a page. Entries are ordered by creation time and then
+// by ID, so entries created in the same millisecond are neither skipped nor repeated.
+type cursor struct {
+	CreatedAt time.Time          `json:"t"`
+	ID        primitive.ObjectID `json:"id"`
+	Sort      Sort               `json:"s"`
+}
+
+func (c cursor) encode() string {
+	out, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(out)
+}
+
+func decodeCursor(s string) (cursor, error) {
+	var c cursor
+
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, fmt.Errorf("%w: malformed cursor", ErrInvalidQuery)
+	}
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return c, fmt.Errorf("%w: malformed cursor", ErrInvalidQuery)
+	}
+
+	return c, nil
+}
+
+// EnsureIndexes creates the indexes queries rely on. It is safe to call on every start.
+func (l *LogEntry) EnsureIndexes(ctx context.Context) error {
+	collection := client.Database("logs").Collection("logs")
+
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "data", Value: "text"}},
+			Options: options.Index().SetName("data_text"),
+		},
+		{
+			Keys:    bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}},
+			Options: options.Index().SetName("created_at_id"),
+		},
+		{
+			Keys:    bson.D{{Key: "name", Value: 1}, {Key: "created_at", Value: -1}, {Key: "_id", Value: -1}},
+			Options: options.Index().SetName("name_created_at_id"),
+		},
+	})
+
+	return err
+}
+
+// Find returns a page of the entries selected by q.
+func (l *LogEntry) Find(q Query) (*Page, error) {
+	if q.Limit == 0 {
+		q.Limit = DefaultLimit
+	}
+
+	filter, err := q.filter()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	collection := client.Database("logs").Collection("logs")
+
+	// one entry more than asked for tells whether there is a next page
+	opts := options.Find().
+		SetSort(q.order()).
+		SetLimit(int64(q.Limit + 1))
+
+	result, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close(ctx)
+
+	var entries []*LogEntry
+	if err := result.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		entry.fillLegacy()
+	}
+
+	return q.page(entries)
+}
+
+// filter checks q and returns the filter that selects its entries, after its cursor if it has
+// one. The limit must already be set.
+func (q Query) filter() (bson.D, error) {
+	if q.Limit < 1 || q.Limit > MaxLimit {
+		return nil, fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalidQuery, MaxLimit)
+	}
+	if q.Sort != NewestFirst && q.Sort != OldestFirst {
+		return nil, fmt.Errorf("%w: unknown sort", ErrInvalidQuery)
+	}
+
+	filter := bson.D{}
+	if q.Name != "" {
+		filter = append(filter, bson.E{Key: "name", Value: q.Name})
+	}
+	if q.Severity != "" {
+		severity, err := ParseSeverity(string(q.Severity))
+		if err != nil {
+			return nil, fmt.Errorf("%w: unknown severity %q", ErrInvalidQuery, q.Severity)
+		}
+
+		value := interface{}(severity)
+		if severity == SeverityInfo {
+			// entries written before severities existed are info
+			value = bson.D{{Key: "$in", Value: bson.A{SeverityInfo, nil}}}
+		}
+		filter = append(filter, bson.E{Key: "severity", Value: value})
+	}
+	if q.Service != "" {
+		filter = append(filter, bson.E{Key: "service", Value: q.Service})
+	}
+
+	created := bson.D{}
+	if !q.From.IsZero() {
+		created = append(created, bson.E{Key: "$gte", Value: q.From})
+	}
+	if !q.To.IsZero() {
+		created = append(created, bson.E{Key: "$lt", Value: q.To})
+	}
+	if len(created) > 0 {
+		filter = append(filter, bson.E{Key: "created_at", Value: created})
+	}
+
+	if q.Search != "" {
+		filter = append(filter, bson.E{Key: "$text", Value: bson.D{{Key: "$search", Value: q.Search}}})
+	}
+
+	if q.Cursor != "" {
+		c, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		if c.Sort != q.Sort {
+			return nil, fmt.Errorf("%w: the cursor belongs to a query with another sort", ErrInvalidQuery)
+		}
+
+		after := "$lt"
+		if q.Sort == OldestFirst {
+			after = "$gt"
+		}
+
+		filter = append(filter, bson.E{Key: "$or", Value: bson.A{
+			bson.D{{Key: "created_at", Value: bson.D{{Key: after, Value: c.CreatedAt}}}},
+			bson.D{{Key: "created_at", Value: c.CreatedAt}, {Key: "_id", Value: bson.D{{Key: after, Value: c.ID}}}},
+		}})
+	}
+
+	return filter, nil
+}
+
+// order sorts the entries of q by creation time and then by ID, the order of its cursors.
+func (q Query) order() bson.D {
+	direction := -1
+	if q.Sort == OldestFirst {
+		direction = 1
+	}
+
+	return bson.D{{Key: "created_at", Value: direction}, {Key: "_id", Value: direction}}
+}
+
+// page returns the first Limit entries as a page. Entries beyond the limit only tell that there
+// is a next page, whose cursor points after the last entry of this one.
+func (q Query) page(entries []*LogEntry) (*Page, error) {
+	page := &Page{Entries: entries}
+	if page.Entries == nil {
+		page.Entries = []*LogEntry{}
+	}
+
+	if len(page.Entries) > q.Limit {
+		page.Entries = page.Entries[:q.Limit]
+
+		last := page.Entries[q.Limit-1]
+		id, err := primitive.ObjectIDFromHex(last.ID)
+		if err != nil {
+			return nil, err
+		}
+		page.NextCursor = cursor{CreatedAt: last.CreatedAt, ID: id, Sort: q.Sort}.encode()
+	}
+
+	return page, nil
+}
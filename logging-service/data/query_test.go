@@ -0,0 +1,166 @@
+package data
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func Test_cursor(t *testing.T) {
+	c := cursor{
+		CreatedAt: time.Date(2023, 4, 6, 18, 4, 43, 123000000, time.UTC),
+		ID:        primitive.NewObjectID(),
+		Sort:      OldestFirst,
+	}
+
+	decoded, err := decodeCursor(c.encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !decoded.CreatedAt.Equal(c.CreatedAt) || decoded.ID != c.ID || decoded.Sort != c.Sort {
+		t.Errorf("expected %+v back but got %+v", c, decoded)
+	}
+
+	for _, malformed := range []string{"not base64!", "bm90IGpzb24"} {
+		if _, err := decodeCursor(malformed); !errors.Is(err, ErrInvalidQuery) {
+			t.Errorf("%q: expected ErrInvalidQuery but got %v", malformed, err)
+		}
+	}
+}
+
+func Test_Query_filter(t *testing.T) {
+	from := time.Date(2023, 4, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(24 * time.Hour)
+
+	tests := []struct {
+		name     string
+		query    Query
+		expected bson.D
+	}{
+		{"everything", Query{}, bson.D{}},
+		{"name and service", Query{Name: "auth", Service: "broker"}, bson.D{
+			{Key: "name", Value: "auth"},
+			{Key: "service", Value: "broker"},
+		}},
+		{"severity", Query{Severity: "ERROR"}, bson.D{
+			{Key: "severity", Value: SeverityError},
+		}},
+		{"info includes entries without a severity", Query{Severity: SeverityInfo}, bson.D{
+			{Key: "severity", Value: bson.D{{Key: "$in", Value: bson.A{SeverityInfo, nil}}}},
+		}},
+		{"time range", Query{From: from, To: to}, bson.D{
+			{Key: "created_at", Value: bson.D{{Key: "$gte", Value: from}, {Key: "$lt", Value: to}}},
+		}},
+		{"search", Query{Search: `logged "in again"`}, bson.D{
+			{Key: "$text", Value: bson.D{{Key: "$search", Value: `logged "in again"`}}},
+		}},
+	}
+
+	for _, tt := range tests {
+		tt.query.Limit = DefaultLimit
+
+		filter, err := tt.query.filter()
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(filter, tt.expected) {
+			t.Errorf("%s: expected %v but got %v", tt.name, tt.expected, filter)
+		}
+	}
+}
+
+func Test_Query_filter_invalid(t *testing.T) {
+	newest := cursor{CreatedAt: time.Now(), ID: primitive.NewObjectID(), Sort: NewestFirst}.encode()
+
+	tests := []struct {
+		name  string
+		query Query
+	}{
+		{"limit too small", Query{Limit: -1}},
+		{"limit too large", Query{Limit: MaxLimit + 1}},
+		{"unknown sort", Query{Limit: 1, Sort: Sort(7)}},
+		{"unknown severity", Query{Limit: 1, Severity: "fatal"}},
+		{"malformed cursor", Query{Limit: 1, Cursor: "nope"}},
+		{"cursor of another sort", Query{Limit: 1, Cursor: newest, Sort: OldestFirst}},
+	}
+
+	for _, tt := range tests {
+		if _, err := tt.query.filter(); !errors.Is(err, ErrInvalidQuery) {
+			t.Errorf("%s: expected ErrInvalidQuery but got %v", tt.name, err)
+		}
+	}
+}
+
+func Test_Query_paging(t *testing.T) {
+	base := time.Date(2023, 4, 6, 18, 0, 0, 0, time.UTC)
+
+	// three entries, the last two created in the same millisecond
+	entries := []*LogEntry{
+		{ID: primitive.NewObjectIDFromTimestamp(base).Hex(), CreatedAt: base.Add(2 * time.Millisecond)},
+		{ID: "642f0a5be2d1c3a6b1f0c9d3", CreatedAt: base.Add(time.Millisecond)},
+		{ID: "642f0a5be2d1c3a6b1f0c9d2", CreatedAt: base.Add(time.Millisecond)},
+	}
+
+	for _, sort := range []Sort{NewestFirst, OldestFirst} {
+		q := Query{Limit: 2, Sort: sort}
+
+		if order := q.order(); order[0].Key != "created_at" || order[1].Key != "_id" || order[0].Value != order[1].Value {
+			t.Errorf("sort %d: expected to sort by creation time and ID in one direction but got %v", sort, order)
+		}
+
+		page, err := q.page(entries)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Entries) != 2 || page.NextCursor == "" {
+			t.Fatalf("sort %d: expected 2 entries and a cursor but got %d and %q", sort, len(page.Entries), page.NextCursor)
+		}
+
+		c, err := decodeCursor(page.NextCursor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.ID.Hex() != entries[1].ID || !c.CreatedAt.Equal(entries[1].CreatedAt) || c.Sort != sort {
+			t.Errorf("sort %d: expected the cursor to point at the last entry of the page but got %+v", sort, c)
+		}
+
+		// the next page continues after the cursor in the direction of the sort, so entries
+		// created in the same millisecond are told apart by their ID
+		after := "$lt"
+		if sort == OldestFirst {
+			after = "$gt"
+		}
+
+		q.Cursor = page.NextCursor
+		filter, err := q.filter()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := bson.D{{Key: "$or", Value: bson.A{
+			bson.D{{Key: "created_at", Value: bson.D{{Key: after, Value: c.CreatedAt}}}},
+			bson.D{{Key: "created_at", Value: c.CreatedAt}, {Key: "_id", Value: bson.D{{Key: after, Value: c.ID}}}},
+		}}}
+		if !reflect.DeepEqual(filter, expected) {
+			t.Errorf("sort %d: expected %v but got %v", sort, expected, filter)
+		}
+	}
+
+	// the last page has no cursor
+	page, err := Query{Limit: 3}.page(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Entries) != 3 || page.NextCursor != "" {
+		t.Errorf("expected the last page to have all entries and no cursor but got %d and %q", len(page.Entries), page.NextCursor)
+	}
+
+	if page, _ := (Query{Limit: 3}).page(nil); page.Entries == nil {
+		t.Error("expected an empty page to have an empty list of entries")
+	}
+}
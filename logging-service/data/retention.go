@@ -0,0 +1,321 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// sweepBatch is how many expired entries are archived and deleted at a time.
+const sweepBatch = 1000
+
+// ErrInvalidPolicy is returned for retention policies that can't be stored, such as those
+// without a maximum age.
+var ErrInvalidPolicy = errors.New("invalid retention policy")
+
+// Duration is a time.Duration written as a string in JSON, such as "36h". Whole days may be
+// written with a d, such as "90d".
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	day := 24 * time.Hour
+	if d > 0 && time.Duration(d)%day == 0 {
+		return json.Marshal(fmt.Sprintf("%dd", time.Duration(d)/day))
+	}
+
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("%w: durations are strings such as \"90d\" or \"12h\"", ErrInvalidPolicy)
+	}
+
+	if strings.HasSuffix(s, "d") {
+		n, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil {
+			return fmt.Errorf("%w: %q is not a duration", ErrInvalidPolicy, s)
+		}
+		*d = Duration(time.Duration(n) * 24 * time.Hour)
+		return nil
+	}
+
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("%w: %q is not a duration", ErrInvalidPolicy, s)
+	}
+	*d = Duration(parsed)
+
+	return nil
+}
+
+// RetentionPolicy keeps the entries with a name, a severity or both for MaxAge after they were
+// created.
+type RetentionPolicy struct {
+	Name     string   `bson:"name,omitempty" json:"name,omitempty"`
+	Severity Severity `bson:"severity,omitempty" json:"severity,omitempty"`
+	MaxAge   Duration `bson:"max_age" json:"max_age"`
+}
+
+func (p RetentionPolicy) String() string {
+	var parts []string
+	if p.Name != "" {
+		parts = append(parts, "name="+p.Name)
+	}
+	if p.Severity != "" {
+		parts = append(parts, "severity="+string(p.Severity))
+	}
+	if len(parts) == 0 {
+		return "default"
+	}
+
+	return strings.Join(parts, ",")
+}
+
+// rank orders policies by how specific they are: name and severity, then name, then severity.
+func (p RetentionPolicy) rank() int {
+	rank := 0
+	if p.Name != "" {
+		rank += 2
+	}
+	if p.Severity != "" {
+		rank++
+	}
+
+	return rank
+}
+
+// overlaps tells whether an entry can match both p and other.
+func (p RetentionPolicy) overlaps(other RetentionPolicy) bool {
+	return (p.Name == "" || other.Name == "" || p.Name == other.Name) &&
+		(p.Severity == "" || other.Severity == "" || p.Severity == other.Severity)
+}
+
+// selector matches the entries the policy applies to, if no more specific policy does.
+func (p RetentionPolicy) selector() bson.D {
+	selector := bson.D{}
+	if p.Name != "" {
+		selector = append(selector, bson.E{Key: "name", Value: p.Name})
+	}
+	if p.Severity == SeverityInfo {
+		// entries written before severities existed are info
+		selector = append(selector, bson.E{Key: "severity", Value: bson.D{{Key: "$in", Value: bson.A{SeverityInfo, nil}}}})
+	} else if p.Severity != "" {
+		selector = append(selector, bson.E{Key: "severity", Value: p.Severity})
+	}
+
+	return selector
+}
+
+// RetentionPolicies decide how long entries are kept. Each entry is kept as long as the most
+// specific policy that matches it says: one for its name and severity, then one for its name,
+// then one for its severity. Entries no policy matches are kept for DefaultMaxAge, or forever
+// when it is zero.
+type RetentionPolicies struct {
+	DefaultMaxAge Duration          `bson:"default_max_age" json:"default_max_age"`
+	Policies      []RetentionPolicy `bson:"policies" json:"policies"`
+	UpdatedAt     time.Time         `bson:"updated_at" json:"updated_at"`
+}
+
+// Validate checks the policies and replaces their severities by their canonical names.
+func (r *RetentionPolicies) Validate() error {
+	if r.DefaultMaxAge < 0 {
+		return fmt.Errorf("%w: default_max_age must not be negative", ErrInvalidPolicy)
+	}
+
+	seen := make(map[string]bool, len(r.Policies))
+	for i := range r.Policies {
+		p := &r.Policies[i]
+
+		if p.Severity != "" {
+			severity, err := ParseSeverity(string(p.Severity))
+			if err != nil {
+				return fmt.Errorf("%w: unknown severity %q", ErrInvalidPolicy, p.Severity)
+			}
+			p.Severity = severity
+		}
+		if p.Name == "" && p.Severity == "" {
+			return fmt.Errorf("%w: a policy needs a name, a severity or both; default_max_age applies to the other entries", ErrInvalidPolicy)
+		}
+		if p.MaxAge <= 0 {
+			return fmt.Errorf("%w: max_age of %s must be positive", ErrInvalidPolicy, p)
+		}
+		if seen[p.String()] {
+			return fmt.Errorf("%w: more than one policy for %s", ErrInvalidPolicy, p)
+		}
+		seen[p.String()] = true
+	}
+
+	return nil
+}
+
+// sweep selects the expired entries of one policy.
+type sweep struct {
+	policy RetentionPolicy
+	filter bson.D
+}
+
+// sweeps returns a filter for the expired entries of every policy. Each filter leaves out the
+// entries a more specific policy applies to, so every entry is only matched by its own policy.
+func (r *RetentionPolicies) sweeps(now time.Time) []sweep {
+	policies := append([]RetentionPolicy{{MaxAge: r.DefaultMaxAge}}, r.Policies...)
+
+	var sweeps []sweep
+	for _, p := range policies {
+		if p.MaxAge <= 0 {
+			continue
+		}
+
+		filter := append(p.selector(), bson.E{Key: "created_at", Value: bson.D{{Key: "$lt", Value: now.Add(-time.Duration(p.MaxAge))}}})
+
+		var overrides bson.A
+		for _, other := range r.Policies {
+			if other.rank() > p.rank() && other.overlaps(p) {
+				overrides = append(overrides, other.selector())
+			}
+		}
+		if len(overrides) > 0 {
+			filter = append(filter, bson.E{Key: "$nor", Value: overrides})
+		}
+
+		sweeps = append(sweeps, sweep{policy: p, filter: filter})
+	}
+
+	return sweeps
+}
+
+// Archiver exports entries before they expire. Entries are only deleted once Archive returned
+// without an error.
+type Archiver interface {
+	Archive(ctx context.Context, policy RetentionPolicy, entries []*LogEntry) error
+}
+
+// Retention stores the retention policies and deletes the entries they expire.
+type Retention struct{}
+
+// Policies returns the stored retention policies; entries are kept forever until some are set.
+func (r *Retention) Policies(ctx context.Context) (*RetentionPolicies, error) {
+	collection := client.Database("logs").Collection("retention")
+
+	policies := &RetentionPolicies{Policies: []RetentionPolicy{}}
+	err := collection.FindOne(ctx, bson.M{"_id": "policies"}).Decode(policies)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+
+	return policies, nil
+}
+
+// SetPolicies validates policies and replaces the stored ones with them. It returns an error
+// wrapping ErrInvalidPolicy for policies that can't be stored.
+func (r *Retention) SetPolicies(ctx context.Context, policies *RetentionPolicies) error {
+	if err := policies.Validate(); err != nil {
+		return err
+	}
+	if policies.Policies == nil {
+		policies.Policies = []RetentionPolicy{}
+	}
+	policies.UpdatedAt = time.Now()
+
+	collection := client.Database("logs").Collection("retention")
+
+	_, err := collection.ReplaceOne(ctx, bson.M{"_id": "policies"}, policies, options.Replace().SetUpsert(true))
+
+	return err
+}
+
+// Sweep deletes the entries the stored policies expire, oldest first, and returns how many it
+// deleted. With an archiver, each batch of entries is archived before it is deleted. A policy
+// whose entries can't be archived or deleted is logged and left for the next sweep, and the
+// other policies are still swept; Sweep then returns the first such error.
+func (r *Retention) Sweep(ctx context.Context, archiver Archiver) (int, error) {
+	policies, err := r.Policies(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	var failed error
+	for _, s := range policies.sweeps(time.Now()) {
+		n, err := s.run(ctx, archiver)
+		deleted += n
+		if err == nil {
+			continue
+		}
+		if ctx.Err() != nil {
+			return deleted, err
+		}
+
+		log.Printf("Retention sweep of %s failed after deleting %d entries: %v", s.policy, n, err)
+		if failed == nil {
+			failed = fmt.Errorf("sweeping entries of %s: %w", s.policy, err)
+		}
+	}
+
+	return deleted, failed
+}
+
+// run deletes the entries expired by one policy, a batch at a time, and returns how many it
+// deleted.
+func (s sweep) run(ctx context.Context, archiver Archiver) (int, error) {
+	collection := client.Database("logs").Collection("logs")
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}).
+		SetLimit(sweepBatch)
+
+	deleted := 0
+	for {
+		result, err := collection.Find(ctx, s.filter, opts)
+		if err != nil {
+			return deleted, err
+		}
+
+		var entries []*LogEntry
+		if err := result.All(ctx, &entries); err != nil {
+			return deleted, err
+		}
+		if len(entries) == 0 {
+			return deleted, nil
+		}
+
+		for _, entry := range entries {
+			entry.fillLegacy()
+		}
+
+		if archiver != nil {
+			if err := archiver.Archive(ctx, s.policy, entries); err != nil {
+				return deleted, fmt.Errorf("archiving: %w", err)
+			}
+		}
+
+		ids := make(bson.A, len(entries))
+		for i, entry := range entries {
+			id, err := primitive.ObjectIDFromHex(entry.ID)
+			if err != nil {
+				return deleted, err
+			}
+			ids[i] = id
+		}
+
+		removed, err := collection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}})
+		if err != nil {
+			return deleted, err
+		}
+		deleted += int(removed.DeletedCount)
+
+		if len(entries) < sweepBatch {
+			return deleted, nil
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package data
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func Test_Duration(t *testing.T) {
+	tests := []struct {
+		json     string
+		duration time.Duration
+	}{
+		{`"90d"`, 90 * 24 * time.Hour},
+		{`"36h0m0s"`, 36 * time.Hour},
+		{`"1h30m0s"`, 90 * time.Minute},
+		{`"0s"`, 0},
+	}
+
+	for _, tt := range tests {
+		var d Duration
+		if err := json.Unmarshal([]byte(tt.json), &d); err != nil {
+			t.Errorf("%s: unexpected error %v", tt.json, err)
+			continue
+		}
+		if time.Duration(d) != tt.duration {
+			t.Errorf("%s: expected %v but got %v", tt.json, tt.duration, time.Duration(d))
+		}
+
+		out, err := json.Marshal(d)
+		if err != nil || string(out) != tt.json {
+			t.Errorf("%v: expected %s but got %s, %v", tt.duration, tt.json, out, err)
+		}
+	}
+
+	if out, _ := json.Marshal(Duration(48 * time.Hour)); string(out) != `"2d"` {
+		t.Errorf("expected whole days to be written in days but got %s", out)
+	}
+
+	for _, invalid := range []string{`90`, `"ninety days"`, `"xd"`} {
+		var d Duration
+		if err := json.Unmarshal([]byte(invalid), &d); !errors.Is(err, ErrInvalidPolicy) {
+			t.Errorf("%s: expected ErrInvalidPolicy but got %v", invalid, err)
+		}
+	}
+}
+
+func Test_RetentionPolicies_Validate(t *testing.T) {
+	day := Duration(24 * time.Hour)
+
+	valid := RetentionPolicies{
+		DefaultMaxAge: 30 * day,
+		Policies: []RetentionPolicy{
+			{Severity: "ERROR", MaxAge: 90 * day},
+			{Name: "auth", Severity: "info", MaxAge: day},
+			{Name: "auth", MaxAge: 7 * day},
+		},
+	}
+	if err := valid.Validate(); err != nil {
+		t.Fatalf("expected the policies to be valid but got %v", err)
+	}
+	if valid.Policies[0].Severity != SeverityError {
+		t.Errorf("expected the severity in canonical form but got %q", valid.Policies[0].Severity)
+	}
+
+	tests := []struct {
+		name     string
+		policies RetentionPolicies
+	}{
+		{"negative default", RetentionPolicies{DefaultMaxAge: -day}},
+		{"unknown severity", RetentionPolicies{Policies: []RetentionPolicy{{Severity: "fatal", MaxAge: day}}}},
+		{"no selector", RetentionPolicies{Policies: []RetentionPolicy{{MaxAge: day}}}},
+		{"no max age", RetentionPolicies{Policies: []RetentionPolicy{{Name: "auth"}}}},
+		{"duplicate", RetentionPolicies{Policies: []RetentionPolicy{{Severity: "warn", MaxAge: day}, {Severity: "warning", MaxAge: 2 * day}}}},
+	}
+
+	for _, tt := range tests {
+		if err := tt.policies.Validate(); !errors.Is(err, ErrInvalidPolicy) {
+			t.Errorf("%s: expected ErrInvalidPolicy but got %v", tt.name, err)
+		}
+	}
+}
+
+func Test_RetentionPolicies_sweeps(t *testing.T) {
+	now := time.Date(2023, 4, 6, 0, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+
+	policies := RetentionPolicies{
+		DefaultMaxAge: Duration(30 * day),
+		Policies: []RetentionPolicy{
+			{Severity: SeverityInfo, MaxAge: Duration(7 * day)},
+			{Name: "auth", MaxAge: Duration(3 * day)},
+			{Name: "auth", Severity: SeverityInfo, MaxAge: Duration(day)},
+			{Name: "billing", MaxAge: Duration(365 * day)},
+		},
+	}
+
+	sweeps := policies.sweeps(now)
+	if len(sweeps) != 5 {
+		t.Fatalf("expected a sweep for the default and every policy but got %d", len(sweeps))
+	}
+
+	info := bson.D{{Key: "$in", Value: bson.A{SeverityInfo, nil}}}
+	expected := []bson.D{
+		// the default leaves out the entries of every other policy
+		{
+			{Key: "created_at", Value: bson.D{{Key: "$lt", Value: now.Add(-30 * day)}}},
+			{Key: "$nor", Value: bson.A{
+				bson.D{{Key: "severity", Value: info}},
+				bson.D{{Key: "name", Value: "auth"}},
+				bson.D{{Key: "name", Value: "auth"}, {Key: "severity", Value: info}},
+				bson.D{{Key: "name", Value: "billing"}},
+			}},
+		},
+		// info leaves out the names with a policy of their own
+		{
+			{Key: "severity", Value: info},
+			{Key: "created_at", Value: bson.D{{Key: "$lt", Value: now.Add(-7 * day)}}},
+			{Key: "$nor", Value: bson.A{
+				bson.D{{Key: "name", Value: "auth"}},
+				bson.D{{Key: "name", Value: "auth"}, {Key: "severity", Value: info}},
+				bson.D{{Key: "name", Value: "billing"}},
+			}},
+		},
+		// auth leaves out its info entries
+		{
+			{Key: "name", Value: "auth"},
+			{Key: "created_at", Value: bson.D{{Key: "$lt", Value: now.Add(-3 * day)}}},
+			{Key: "$nor", Value: bson.A{
+				bson.D{{Key: "name", Value: "auth"}, {Key: "severity", Value: info}},
+			}},
+		},
+		{
+			{Key: "name", Value: "auth"},
+			{Key: "severity", Value: info},
+			{Key: "created_at", Value: bson.D{{Key: "$lt", Value: now.Add(-day)}}},
+		},
+		{
+			{Key: "name", Value: "billing"},
+			{Key: "created_at", Value: bson.D{{Key: "$lt", Value: now.Add(-365 * day)}}},
+		},
+	}
+
+	for i, s := range sweeps {
+		if !reflect.DeepEqual(s.filter, expected[i]) {
+			t.Errorf("sweep of %s: expected %v but got %v", s.policy, expected[i], s.filter)
+		}
+	}
+
+	// without a default, entries no policy matches are kept
+	policies.DefaultMaxAge = 0
+	if sweeps := policies.sweeps(now); len(sweeps) != 4 || sweeps[0].policy.String() != "severity=info" {
+		t.Errorf("expected no sweep for the default but got %d sweeps", len(sweeps))
+	}
+}
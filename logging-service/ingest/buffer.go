@@ -0,0 +1,204 @@
+// Package ingest batches the log entries written by many callers into few inserts. Entries are
+// flushed to the store once enough of them are waiting or the oldest has waited long enough,
+// and each caller gets back the result of every entry it wrote.
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log-service/data"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/semaphore"
+)
+
+// flushTimeout bounds a flush, so a store that hangs can't hold up shutdown forever.
+const flushTimeout = 15 * time.Second
+
+var (
+	// ErrClosed is returned for entries written after Close.
+	ErrClosed = errors.New("ingest buffer closed")
+	// ErrTooLarge is returned for writes of more entries than the buffer can hold.
+	ErrTooLarge = errors.New("too many entries for the ingest buffer")
+)
+
+// Store is where the buffer flushes entries to; data.LogEntry implements it.
+type Store interface {
+	InsertMany(ctx context.Context, entries []data.LogEntry) []error
+}
+
+// Options configure a Buffer.
+type Options struct {
+	// BatchSize is the number of waiting entries that triggers a flush.
+	BatchSize int
+	// FlushInterval is how long an entry waits at most before it is flushed.
+	FlushInterval time.Duration
+	// Capacity is the number of entries the buffer holds before writes block. It can't be
+	// smaller than BatchSize.
+	Capacity int
+	// OnFlush, if set, is called with the size and duration of every flush.
+	OnFlush func(entries int, took time.Duration)
+}
+
+// write is a set of entries from one caller, waiting to be flushed.
+type write struct {
+	entries []data.LogEntry
+	errs    []error
+	done    chan struct{}
+}
+
+// Buffer collects entries and flushes them to a Store in batches.
+type Buffer struct {
+	store Store
+	opts  Options
+	// space counts the entries the buffer can still take; writers block while it is used up
+	space  *semaphore.Weighted
+	writes chan *write
+	done   chan struct{}
+
+	// mu keeps Close from closing writes while a write is being sent on it
+	mu     sync.RWMutex
+	closed bool
+}
+
+// New returns a buffer that flushes to store. It runs until Close is called.
+func New(store Store, opts Options) *Buffer {
+	if opts.Capacity < opts.BatchSize {
+		opts.Capacity = opts.BatchSize
+	}
+
+	b := &Buffer{
+		store: store,
+		opts:  opts,
+		space: semaphore.NewWeighted(int64(opts.Capacity)),
+		// every write holds at least one entry of the capacity, so sending never blocks
+		writes: make(chan *write, opts.Capacity),
+		done:   make(chan struct{}),
+	}
+	go b.run()
+
+	return b
+}
+
+// Write adds entries to the buffer and waits until they are flushed. It blocks while the buffer
+// is full, until ctx is done. It returns the result of every entry, nil for those stored, or an
+// error if the entries weren't accepted at all.
+func (b *Buffer) Write(ctx context.Context, entries []data.LogEntry) ([]error, error) {
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	if len(entries) > b.opts.Capacity {
+		return nil, fmt.Errorf("%w: %d entries, at most %d", ErrTooLarge, len(entries), b.opts.Capacity)
+	}
+
+	if err := b.space.Acquire(ctx, int64(len(entries))); err != nil {
+		return nil, err
+	}
+
+	w := &write{entries: entries, done: make(chan struct{})}
+
+	b.mu.RLock()
+	if b.closed {
+		b.mu.RUnlock()
+		b.space.Release(int64(len(entries)))
+		return nil, ErrClosed
+	}
+	b.writes <- w
+	b.mu.RUnlock()
+
+	// the entries are stored whether or not the caller still waits, so there's no point
+	// in giving up on them
+	<-w.done
+
+	return w.errs, nil
+}
+
+// Close flushes the entries in the buffer and stops it. It waits for the last flush until ctx
+// is done.
+func (b *Buffer) Close(ctx context.Context) error {
+	b.mu.Lock()
+	if !b.closed {
+		b.closed = true
+		close(b.writes)
+	}
+	b.mu.Unlock()
+
+	select {
+	case <-b.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (b *Buffer) run() {
+	defer close(b.done)
+
+	var (
+		pending []*write
+		size    int
+		timer   *time.Timer
+		// flushAt fires once the oldest pending entry has waited FlushInterval
+		flushAt <-chan time.Time
+	)
+
+	flush := func() {
+		if timer != nil {
+			timer.Stop()
+		}
+		flushAt = nil
+		b.flush(pending, size)
+		pending, size = nil, 0
+	}
+
+	for {
+		select {
+		case w, ok := <-b.writes:
+			if !ok {
+				if size > 0 {
+					flush()
+				}
+				return
+			}
+
+			if size == 0 {
+				timer = time.NewTimer(b.opts.FlushInterval)
+				flushAt = timer.C
+			}
+			pending = append(pending, w)
+			size += len(w.entries)
+
+			if size >= b.opts.BatchSize {
+				flush()
+			}
+		case <-flushAt:
+			flush()
+		}
+	}
+}
+
+// flush stores the entries of writes with one insert and hands every write its results.
+func (b *Buffer) flush(writes []*write, size int) {
+	entries := make([]data.LogEntry, 0, size)
+	for _, w := range writes {
+		entries = append(entries, w.entries...)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+	defer cancel()
+
+	start := time.Now()
+	errs := b.store.InsertMany(ctx, entries)
+	if b.opts.OnFlush != nil {
+		b.opts.OnFlush(len(entries), time.Since(start))
+	}
+
+	for _, w := range writes {
+		w.errs, errs = errs[:len(w.entries)], errs[len(w.entries):]
+		close(w.done)
+	}
+
+	b.space.Release(int64(size))
+}
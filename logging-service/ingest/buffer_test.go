@@ -0,0 +1,246 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"log-service/data"
+	"sync"
+	"testing"
+	"time"
+)
+
+var errRejected = errors.New("rejected")
+
+// fakeStore records the batches it is given and rejects the entries whose data is "bad". While
+// hold is set, inserts wait until it is closed.
+type fakeStore struct {
+	mu      sync.Mutex
+	batches [][]data.LogEntry
+	hold    chan struct{}
+}
+
+func (s *fakeStore) InsertMany(ctx context.Context, entries []data.LogEntry) []error {
+	s.mu.Lock()
+	hold := s.hold
+	s.batches = append(s.batches, entries)
+	s.mu.Unlock()
+
+	if hold != nil {
+		<-hold
+	}
+
+	errs := make([]error, len(entries))
+	for i, entry := range entries {
+		if entry.Data == "bad" {
+			errs[i] = errRejected
+		}
+	}
+
+	return errs
+}
+
+func (s *fakeStore) sizes() []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sizes := make([]int, len(s.batches))
+	for i, batch := range s.batches {
+		sizes[i] = len(batch)
+	}
+
+	return sizes
+}
+
+func entries(values ...string) []data.LogEntry {
+	out := make([]data.LogEntry, len(values))
+	for i, d := range values {
+		out[i].Name = "test"
+		out[i].Data = d
+	}
+
+	return out
+}
+
+func Test_Buffer_batchSize(t *testing.T) {
+	store := &fakeStore{}
+	b := New(store, Options{BatchSize: 3, FlushInterval: time.Hour, Capacity: 10})
+	defer b.Close(context.Background())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := b.Write(context.Background(), entries("one")); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if sizes := store.sizes(); len(sizes) != 1 || sizes[0] != 3 {
+		t.Errorf("expected the three writes to be flushed together but got batches of %v", sizes)
+	}
+}
+
+func Test_Buffer_flushInterval(t *testing.T) {
+	store := &fakeStore{}
+	var flushed []int
+	b := New(store, Options{
+		BatchSize:     100,
+		FlushInterval: 20 * time.Millisecond,
+		OnFlush:       func(entries int, took time.Duration) { flushed = append(flushed, entries) },
+	})
+	defer b.Close(context.Background())
+
+	start := time.Now()
+	errs, err := b.Write(context.Background(), entries("one", "two"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if took := time.Since(start); took < 20*time.Millisecond {
+		t.Errorf("expected the entries to wait for the flush interval but they were flushed after %v", took)
+	}
+	if len(errs) != 2 || errs[0] != nil || errs[1] != nil {
+		t.Errorf("expected both entries to be stored but got %v", errs)
+	}
+	if sizes := store.sizes(); len(sizes) != 1 || sizes[0] != 2 {
+		t.Errorf("expected one batch of 2 but got %v", sizes)
+	}
+	if len(flushed) != 1 || flushed[0] != 2 {
+		t.Errorf("expected OnFlush to be called with 2 entries but got %v", flushed)
+	}
+}
+
+func Test_Buffer_resultsPerWrite(t *testing.T) {
+	store := &fakeStore{}
+	b := New(store, Options{BatchSize: 5, FlushInterval: time.Hour})
+	defer b.Close(context.Background())
+
+	type result struct {
+		errs []error
+		err  error
+	}
+	first, second := make(chan result, 1), make(chan result, 1)
+
+	go func() {
+		errs, err := b.Write(context.Background(), entries("good", "bad"))
+		first <- result{errs, err}
+	}()
+	go func() {
+		errs, err := b.Write(context.Background(), entries("bad", "good", "good"))
+		second <- result{errs, err}
+	}()
+
+	r1, r2 := <-first, <-second
+	if r1.err != nil || r2.err != nil {
+		t.Fatal(r1.err, r2.err)
+	}
+
+	if len(r1.errs) != 2 || r1.errs[0] != nil || r1.errs[1] != errRejected {
+		t.Errorf("expected the first write to get its own results but got %v", r1.errs)
+	}
+	if len(r2.errs) != 3 || r2.errs[0] != errRejected || r2.errs[1] != nil || r2.errs[2] != nil {
+		t.Errorf("expected the second write to get its own results but got %v", r2.errs)
+	}
+}
+
+func Test_Buffer_full(t *testing.T) {
+	hold := make(chan struct{})
+	store := &fakeStore{hold: hold}
+	b := New(store, Options{BatchSize: 2, FlushInterval: time.Hour, Capacity: 2})
+
+	if _, err := b.Write(context.Background(), entries("one", "two", "three")); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("expected ErrTooLarge for more entries than the capacity but got %v", err)
+	}
+
+	// the first two entries fill the buffer until their flush finishes
+	written := make(chan error, 1)
+	go func() {
+		_, err := b.Write(context.Background(), entries("one", "two"))
+		written <- err
+	}()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(store.sizes()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if _, err := b.Write(ctx, entries("three")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected a write to a full buffer to wait until ctx is done but got %v", err)
+	}
+
+	close(hold)
+	if err := <-written; err != nil {
+		t.Errorf("expected the first write to be stored but got %v", err)
+	}
+
+	// once flushed, there is room again
+	store.mu.Lock()
+	store.hold = nil
+	store.mu.Unlock()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := b.Write(context.Background(), entries("three", "four"))
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the buffer to take entries again after the flush")
+	}
+
+	if err := b.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_Buffer_Close(t *testing.T) {
+	store := &fakeStore{}
+	b := New(store, Options{BatchSize: 100, FlushInterval: time.Hour, Capacity: 100})
+
+	written := make(chan error, 1)
+	go func() {
+		_, err := b.Write(context.Background(), entries("pending"))
+		written <- err
+	}()
+
+	// the write has taken its space in the buffer, and is handed to it right after
+	deadline := time.Now().Add(2 * time.Second)
+	for b.space.TryAcquire(100) {
+		b.space.Release(100)
+		if time.Now().After(deadline) {
+			t.Fatal("the write never reached the buffer")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	if err := b.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-written:
+		if err != nil {
+			t.Errorf("expected the pending write to be flushed by Close but got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected Close to flush the pending write")
+	}
+	if sizes := store.sizes(); len(sizes) != 1 || sizes[0] != 1 {
+		t.Errorf("expected the pending entry to be flushed but got batches of %v", sizes)
+	}
+
+	if _, err := b.Write(context.Background(), entries("late")); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed after Close but got %v", err)
+	}
+}
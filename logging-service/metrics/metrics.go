@@ -0,0 +1,129 @@
+// Package metrics exposes the Prometheus metrics of the logger service on /metrics.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// unmatchedRoute labels requests that matched no route, so unknown paths don't each get their
+// own series.
+const unmatchedRoute = "unmatched"
+
+var (
+	requests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "HTTP requests handled, by method, route and status.",
+	}, []string{"method", "route", "status"})
+
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "Time spent handling HTTP requests, by method, route and status.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+)
+
+// Transports of log_entries_inserted_total.
+const (
+	HTTP = "http"
+	RPC  = "rpc"
+	GRPC = "grpc"
+)
+
+// Results of log_entries_inserted_total.
+const (
+	Inserted = "inserted"
+	Failed   = "failed"
+)
+
+// Inserts counts attempts to insert a log entry by the transport it arrived on and result.
+var Inserts = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "log_entries_inserted_total",
+	Help: "Attempts to insert a log entry, by transport and result.",
+}, []string{"transport", "result"})
+
+// RecordInsert counts an attempt to insert a log entry that arrived on transport and ended with
+// err.
+func RecordInsert(transport string, err error) {
+	result := Inserted
+	if err != nil {
+		result = Failed
+	}
+
+	Inserts.WithLabelValues(transport, result).Inc()
+}
+
+// FlushSize and FlushDuration record the flushes of the ingest buffer behind the batch endpoints.
+var (
+	FlushSize = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "log_ingest_flush_entries",
+		Help:    "Log entries inserted by each flush of the ingest buffer.",
+		Buckets: prometheus.ExponentialBuckets(1, 4, 7),
+	})
+
+	FlushDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "log_ingest_flush_duration_seconds",
+		Help:    "Time spent inserting the log entries of each flush of the ingest buffer.",
+		Buckets: prometheus.DefBuckets,
+	})
+)
+
+// RecordFlush records a flush of entries that took took.
+func RecordFlush(entries int, took time.Duration) {
+	FlushSize.Observe(float64(entries))
+	FlushDuration.Observe(took.Seconds())
+}
+
+// Expired counts the log entries deleted by the retention policies.
+var Expired = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "log_entries_expired_total",
+	Help: "Log entries deleted by the retention policies.",
+})
+
+// Completed is the result of retention_sweeps_total for sweeps without errors; the others are
+// Failed.
+const Completed = "completed"
+
+// Sweeps counts the runs of the retention sweeper by result.
+var Sweeps = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "retention_sweeps_total",
+	Help: "Runs of the retention sweeper, by result.",
+}, []string{"result"})
+
+// Handler serves the metrics in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// Middleware counts requests and records how long they took. Requests are labelled with the chi
+// route pattern rather than the path, so requests to the same route share a series.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		// the pattern is known once the router has matched the request
+		route := unmatchedRoute
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		labels := prometheus.Labels{"method": r.Method, "route": route, "status": strconv.Itoa(status)}
+		requests.With(labels).Inc()
+		requestDuration.With(labels).Observe(time.Since(start).Seconds())
+	})
+}
@@ -0,0 +1,182 @@
+// Package tail follows log entries as they are stored. A Hub hands every stored entry to the
+// subscribers whose filter it matches, for GET /logs/tail and the gRPC TailLogs call.
+//
+// The hub only sees the entries stored by its own process, so with more than one replica of the
+// logger service a subscriber only gets the entries written to its replica.
+package tail
+
+import (
+	"errors"
+	"fmt"
+	"log-service/data"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// bufferSize is how many entries a subscriber may fall behind before entries are dropped.
+const bufferSize = 256
+
+var (
+	// ErrTooManySubscribers is returned by Subscribe when the hub has all the subscribers it
+	// takes.
+	ErrTooManySubscribers = errors.New("too many subscribers")
+	// ErrClosed is returned by Subscribe once the hub is closed.
+	ErrClosed = errors.New("tail hub closed")
+)
+
+// Filter selects the entries a subscriber gets. The zero value selects all entries.
+type Filter struct {
+	// Name, Severity and Service match the entries exactly.
+	Name     string
+	Severity data.Severity
+	Service  string
+	// Search selects entries whose data contains any of its words, ignoring case. Unlike the
+	// search of GET /logs, words are matched as they are, without stemming.
+	Search string
+}
+
+// NewFilter returns a filter with its severity checked. It returns an error wrapping
+// data.ErrInvalidQuery for an unknown severity.
+func NewFilter(name, severity, service, search string) (Filter, error) {
+	f := Filter{Name: name, Service: service, Search: search}
+
+	if severity != "" {
+		s, err := data.ParseSeverity(severity)
+		if err != nil {
+			return f, fmt.Errorf("%w: unknown severity %q", data.ErrInvalidQuery, severity)
+		}
+		f.Severity = s
+	}
+
+	return f, nil
+}
+
+// Matches tells whether entry is selected by f.
+func (f Filter) Matches(entry *data.LogEntry) bool {
+	if f.Name != "" && entry.Name != f.Name {
+		return false
+	}
+	if f.Severity != "" && entry.Severity != f.Severity {
+		return false
+	}
+	if f.Service != "" && entry.Service != f.Service {
+		return false
+	}
+	if f.Search == "" {
+		return true
+	}
+
+	text := strings.ToLower(entry.Data)
+	for _, word := range strings.Fields(strings.ToLower(f.Search)) {
+		if strings.Contains(text, strings.Trim(word, `"`)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Subscription receives the entries that match its filter on C. C is closed when the
+// subscription or the hub is closed.
+type Subscription struct {
+	// dropped comes first, so it is 64-bit aligned for the atomic operations on 32-bit platforms
+	dropped uint64
+
+	C <-chan *data.LogEntry
+
+	c      chan *data.LogEntry
+	filter Filter
+	hub    *Hub
+}
+
+// Dropped returns how many entries were dropped since the last call, because the subscriber
+// fell more than a buffer behind.
+func (s *Subscription) Dropped() uint64 {
+	return atomic.SwapUint64(&s.dropped, 0)
+}
+
+// Close stops the subscription.
+func (s *Subscription) Close() {
+	s.hub.unsubscribe(s)
+}
+
+// Hub fans stored entries out to subscribers. Publishing never blocks: subscribers that fall
+// behind miss entries, which they learn from Dropped.
+type Hub struct {
+	max int
+
+	mu          sync.RWMutex
+	subscribers map[*Subscription]struct{}
+	closed      bool
+}
+
+// NewHub returns a hub that takes up to max subscribers.
+func NewHub(max int) *Hub {
+	return &Hub{
+		max:         max,
+		subscribers: make(map[*Subscription]struct{}),
+	}
+}
+
+// Subscribe returns a subscription to the entries stored from now on that match filter.
+func (h *Hub) Subscribe(filter Filter) (*Subscription, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return nil, ErrClosed
+	}
+	if len(h.subscribers) >= h.max {
+		return nil, fmt.Errorf("%w: at most %d", ErrTooManySubscribers, h.max)
+	}
+
+	c := make(chan *data.LogEntry, bufferSize)
+	s := &Subscription{C: c, c: c, filter: filter, hub: h}
+	h.subscribers[s] = struct{}{}
+
+	return s, nil
+}
+
+func (h *Hub) unsubscribe(s *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.subscribers[s]; ok {
+		delete(h.subscribers, s)
+		close(s.c)
+	}
+}
+
+// Publish hands entries to the subscribers whose filter they match. It is meant for
+// data.Observe.
+func (h *Hub) Publish(entries []*data.LogEntry) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for s := range h.subscribers {
+		for _, entry := range entries {
+			if !s.filter.Matches(entry) {
+				continue
+			}
+
+			select {
+			case s.c <- entry:
+			default:
+				atomic.AddUint64(&s.dropped, 1)
+			}
+		}
+	}
+}
+
+// Close ends all subscriptions, so the streams that follow them end, and refuses new ones.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for s := range h.subscribers {
+		delete(h.subscribers, s)
+		close(s.c)
+	}
+}
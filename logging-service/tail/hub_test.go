@@ -0,0 +1,149 @@
+package tail
+
+import (
+	"errors"
+	"log-service/data"
+	"testing"
+)
+
+func Test_NewFilter(t *testing.T) {
+	f, err := NewFilter("auth", "WARN", "broker", "denied")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Severity != data.SeverityWarning {
+		t.Errorf("expected the severity in canonical form but got %q", f.Severity)
+	}
+
+	if _, err := NewFilter("", "fatal", "", ""); !errors.Is(err, data.ErrInvalidQuery) {
+		t.Errorf("expected ErrInvalidQuery for an unknown severity but got %v", err)
+	}
+}
+
+func Test_Filter_Matches(t *testing.T) {
+	entry := &data.LogEntry{Name: "auth", Severity: data.SeverityError, Service: "broker", Data: "Login DENIED for me@here.com"}
+
+	tests := []struct {
+		name     string
+		filter   Filter
+		expected bool
+	}{
+		{"zero value", Filter{}, true},
+		{"name", Filter{Name: "auth"}, true},
+		{"other name", Filter{Name: "mail"}, false},
+		{"severity", Filter{Severity: data.SeverityError}, true},
+		{"other severity", Filter{Severity: data.SeverityInfo}, false},
+		{"other service", Filter{Service: "listener"}, false},
+		{"search ignores case", Filter{Search: "denied"}, true},
+		{"search matches any word", Filter{Search: "nothing login"}, true},
+		{"search without a match", Filter{Search: "granted"}, false},
+		{"quoted word", Filter{Search: `"denied"`}, true},
+	}
+
+	for _, tt := range tests {
+		if got := tt.filter.Matches(entry); got != tt.expected {
+			t.Errorf("%s: expected %v but got %v", tt.name, tt.expected, got)
+		}
+	}
+}
+
+func Test_Hub_subscribe(t *testing.T) {
+	h := NewHub(2)
+
+	errors1, err := h.Subscribe(Filter{Severity: data.SeverityError})
+	if err != nil {
+		t.Fatal(err)
+	}
+	all, err := h.Subscribe(Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := h.Subscribe(Filter{}); !errors.Is(err, ErrTooManySubscribers) {
+		t.Errorf("expected ErrTooManySubscribers beyond the maximum but got %v", err)
+	}
+
+	info := &data.LogEntry{ID: "1", Severity: data.SeverityInfo}
+	failure := &data.LogEntry{ID: "2", Severity: data.SeverityError}
+	h.Publish([]*data.LogEntry{info, failure})
+
+	if got := <-errors1.C; got != failure {
+		t.Errorf("expected the error entry but got %+v", got)
+	}
+	if len(errors1.C) != 0 {
+		t.Errorf("expected the info entry to be filtered out")
+	}
+	if first, second := <-all.C, <-all.C; first != info || second != failure {
+		t.Errorf("expected both entries in order but got %+v and %+v", first, second)
+	}
+
+	// unsubscribing closes the channel and makes room for another subscriber
+	errors1.Close()
+	if _, ok := <-errors1.C; ok {
+		t.Error("expected the channel to be closed after Close")
+	}
+	errors1.Close()
+
+	h.Publish([]*data.LogEntry{failure})
+	if got := <-all.C; got != failure {
+		t.Errorf("expected the other subscriber to still get entries but got %+v", got)
+	}
+
+	if _, err := h.Subscribe(Filter{}); err != nil {
+		t.Errorf("expected room for a subscriber after one left but got %v", err)
+	}
+}
+
+func Test_Hub_slowConsumer(t *testing.T) {
+	h := NewHub(1)
+
+	s, err := h.Subscribe(Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries := make([]*data.LogEntry, bufferSize+10)
+	for i := range entries {
+		entries[i] = &data.LogEntry{}
+	}
+
+	// publishing never blocks, even when nobody reads
+	h.Publish(entries)
+
+	if len(s.C) != bufferSize {
+		t.Errorf("expected a full buffer of %d entries but got %d", bufferSize, len(s.C))
+	}
+	if dropped := s.Dropped(); dropped != 10 {
+		t.Errorf("expected 10 dropped entries but got %d", dropped)
+	}
+	if dropped := s.Dropped(); dropped != 0 {
+		t.Errorf("expected Dropped to start over after being read but got %d", dropped)
+	}
+
+	if got := <-s.C; got != entries[0] {
+		t.Error("expected the oldest entries to be kept")
+	}
+}
+
+func Test_Hub_Close(t *testing.T) {
+	h := NewHub(1)
+
+	s, err := h.Subscribe(Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h.Close()
+
+	if _, ok := <-s.C; ok {
+		t.Error("expected the subscription to end when the hub closes")
+	}
+	s.Close()
+
+	if _, err := h.Subscribe(Filter{}); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed after Close but got %v", err)
+	}
+
+	// publishing to a closed hub is a no-op
+	h.Publish([]*data.LogEntry{{}})
+}
@@ -0,0 +1,211 @@
+package token
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Audience is the "aud" claim the authentication service puts into every access token.
+const Audience = "microservices-in-go"
+
+// minRefreshInterval limits how often a remote key set is re-fetched when an unknown key ID shows up.
+const minRefreshInterval = time.Minute
+
+var (
+	ErrInvalidToken = errors.New("invalid or expired token")
+	ErrUnknownKey   = errors.New("token is signed with an unknown key")
+)
+
+// Claims is the payload of an access token issued by the authentication service.
+type Claims struct {
+	Email string   `json:"email"`
+	Roles []string `json:"roles,omitempty"`
+	// ClientID is set on tokens issued to OAuth clients, which the logger service doesn't accept.
+	ClientID string `json:"client_id,omitempty"`
+	jwt.RegisteredClaims
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+type jwks struct {
+	Keys []jwk `json:"keys"`
+}
+
+// KeySet verifies access tokens against the public keys published by the authentication service.
+// Keys are either read once from a local JWKS file or fetched from a URL and refreshed when a
+// token signed with an unknown key shows up.
+type KeySet struct {
+	Issuer string
+
+	mu          sync.RWMutex
+	keys        map[string]*rsa.PublicKey
+	url         string
+	client      *http.Client
+	lastRefresh time.Time
+}
+
+// LoadFile reads a JWKS document from path. The key set never changes afterwards.
+func LoadFile(path, issuer string) (*KeySet, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := parseJWKS(raw)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	return &KeySet{
+		Issuer: issuer,
+		keys:   keys,
+	}, nil
+}
+
+// NewRemoteKeySet returns a key set that fetches its keys from url on first use.
+func NewRemoteKeySet(url, issuer string) *KeySet {
+	return &KeySet{
+		Issuer: issuer,
+		keys:   map[string]*rsa.PublicKey{},
+		url:    url,
+		client: &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// Verify checks the signature and the registered claims of an access token and returns its claims.
+// Tokens issued to OAuth clients are only meant for the userinfo endpoint of the authentication
+// service and are rejected, even those issued before they got an audience of their own.
+func (ks *KeySet) Verify(tokenString string) (*Claims, error) {
+	var claims Claims
+
+	_, err := jwt.ParseWithClaims(tokenString, &claims, ks.keyFunc,
+		jwt.WithValidMethods([]string{"RS256"}),
+		jwt.WithIssuer(ks.Issuer),
+		jwt.WithAudience(Audience),
+	)
+	if err != nil {
+		if errors.Is(err, ErrUnknownKey) {
+			return nil, ErrUnknownKey
+		}
+		return nil, ErrInvalidToken
+	}
+	if claims.ClientID != "" {
+		return nil, ErrInvalidToken
+	}
+
+	return &claims, nil
+}
+
+func (ks *KeySet) keyFunc(t *jwt.Token) (any, error) {
+	kid, _ := t.Header["kid"].(string)
+
+	if key, ok := ks.lookup(kid); ok {
+		return key, nil
+	}
+
+	if err := ks.refresh(); err != nil {
+		return nil, err
+	}
+
+	if key, ok := ks.lookup(kid); ok {
+		return key, nil
+	}
+
+	return nil, ErrUnknownKey
+}
+
+func (ks *KeySet) lookup(kid string) (*rsa.PublicKey, bool) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	key, ok := ks.keys[kid]
+	return key, ok
+}
+
+// refresh re-fetches a remote key set, at most once per minRefreshInterval.
+func (ks *KeySet) refresh() error {
+	if ks.url == "" {
+		return nil
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if time.Since(ks.lastRefresh) < minRefreshInterval {
+		return nil
+	}
+	ks.lastRefresh = time.Now()
+
+	response, err := ks.client.Get(ks.url)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code fetching %s: %d", ks.url, response.StatusCode)
+	}
+
+	var raw json.RawMessage
+	if err := json.NewDecoder(response.Body).Decode(&raw); err != nil {
+		return err
+	}
+
+	keys, err := parseJWKS(raw)
+	if err != nil {
+		return err
+	}
+
+	ks.keys = keys
+	return nil
+}
+
+func parseJWKS(raw []byte) (map[string]*rsa.PublicKey, error) {
+	var set jwks
+	if err := json.Unmarshal(raw, &set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(set.Keys))
+	for _, key := range set.Keys {
+		if key.Kty != "RSA" {
+			continue
+		}
+
+		n, err := base64.RawURLEncoding.DecodeString(key.N)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: invalid modulus: %w", key.Kid, err)
+		}
+
+		e, err := base64.RawURLEncoding.DecodeString(key.E)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: invalid exponent: %w", key.Kid, err)
+		}
+
+		keys[key.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("no RSA keys in key set")
+	}
+
+	return keys, nil
+}
@@ -1,13 +1,20 @@
 package main
 
-import "net/http"
+import (
+	"mail-service/metrics"
+	"net/http"
+)
 
+// SendMail sends an email. The body is rendered from the "mail" templates unless the payload names
+// another template, in which case "data" provides the values the template refers to.
 func (app *Config) SendMail(w http.ResponseWriter, r *http.Request) {
 	type mailMessage struct {
-		From    string `json:"from"`
-		To      string `json:"to"`
-		Subject string `json:"subject"`
-		Message string `json:"message"`
+		From     string         `json:"from"`
+		To       string         `json:"to"`
+		Subject  string         `json:"subject"`
+		Message  string         `json:"message"`
+		Template string         `json:"template,omitempty"`
+		Data     map[string]any `json:"data,omitempty"`
 	}
 
 	var requestPayload mailMessage
@@ -19,17 +26,26 @@ func (app *Config) SendMail(w http.ResponseWriter, r *http.Request) {
 	}
 
 	msg := Message{
-		From:    requestPayload.From,
-		To:      requestPayload.To,
-		Subject: requestPayload.Subject,
-		Data:    requestPayload.Message,
+		From:     requestPayload.From,
+		To:       requestPayload.To,
+		Subject:  requestPayload.Subject,
+		Template: requestPayload.Template,
+		Data:     requestPayload.Message,
+		DataMap:  requestPayload.Data,
 	}
 
-	err = app.Mailer.SendSMTPMessage(msg)
+	if err := app.Mailer.validateTemplate(msg.Template); err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.Mailer.SendSMTPMessage(r.Context(), msg)
 	if err != nil {
+		metrics.Messages.WithLabelValues(templateName(msg.Template), metrics.Failed).Inc()
 		app.errorJSON(w, err)
 		return
 	}
+	metrics.Messages.WithLabelValues(templateName(msg.Template), metrics.Sent).Inc()
 
 	payload := jsonResponse{
 		Error:   false,
@@ -2,13 +2,28 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"fmt"
 	"html/template"
+	"mail-service/tracing"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
 	"time"
 
 	"github.com/vanng822/go-premailer/premailer"
 	mail "github.com/xhit/go-simple-mail/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// defaultTemplate is used for messages that don't name a template
+const defaultTemplate = "mail"
+
+var templateNameRX = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
 type Mail struct {
 	Domain      string
 	Host        string
@@ -20,17 +35,49 @@ type Mail struct {
 	FromName    string
 }
 
+// Check reports whether the SMTP server accepts connections. It is the readiness check of the
+// service.
+func (m *Mail) Check(ctx context.Context) error {
+	var dialer net.Dialer
+
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(m.Host, strconv.Itoa(m.Port)))
+	if err != nil {
+		return err
+	}
+
+	return conn.Close()
+}
+
 type Message struct {
 	From        string
 	FromName    string
 	To          string
 	Subject     string
+	Template    string         // The name of the templates to render, "mail" when empty.
 	Attachments []string       // A list of file paths to attach to the email.
 	Data        any            // The data to be used to populate the email templates.
 	DataMap     map[string]any // A map of keys to values to be used to populate the email templates.
 }
 
-func (m *Mail) SendSMTPMessage(msg Message) error {
+// SendSMTPMessage renders msg and sends it through the SMTP server, recording the send as a
+// span of the trace in ctx.
+func (m *Mail) SendSMTPMessage(ctx context.Context, msg Message) (err error) {
+	_, span := tracing.Tracer().Start(ctx, "smtp send",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("mail.template", templateName(msg.Template)),
+			attribute.String("net.peer.name", m.Host),
+			attribute.Int("net.peer.port", m.Port),
+		),
+	)
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
 	if msg.From == "" {
 		msg.From = m.FromAddress
 	}
@@ -43,6 +90,10 @@ func (m *Mail) SendSMTPMessage(msg Message) error {
 		"message": msg.Data,
 	}
 
+	for key, value := range msg.DataMap {
+		data[key] = value
+	}
+
 	msg.DataMap = data
 
 	formattedMessage, err := m.buildHTMLMessage(msg)
@@ -93,7 +144,7 @@ func (m *Mail) SendSMTPMessage(msg Message) error {
 }
 
 func (m *Mail) buildHTMLMessage(msg Message) (string, error) {
-	templateToRender := "./templates/mail.html.gohtml"
+	templateToRender := templatePath(msg.Template, "html")
 
 	t, err := template.New("email-html").ParseFiles(templateToRender)
 	if err != nil {
@@ -115,7 +166,7 @@ func (m *Mail) buildHTMLMessage(msg Message) (string, error) {
 }
 
 func (m *Mail) buildPlainTextMessage(msg Message) (string, error) {
-	templateToRender := "./templates/mail.plain.gohtml"
+	templateToRender := templatePath(msg.Template, "plain")
 
 	t, err := template.New("email-plain").ParseFiles(templateToRender)
 	if err != nil {
@@ -132,6 +183,37 @@ func (m *Mail) buildPlainTextMessage(msg Message) (string, error) {
 	return plainMessage, nil
 }
 
+// validateTemplate makes sure name refers to an existing pair of html and plain text templates.
+func (m *Mail) validateTemplate(name string) error {
+	if name == "" {
+		return nil
+	}
+
+	if !templateNameRX.MatchString(name) {
+		return fmt.Errorf("invalid template name %q", name)
+	}
+
+	for _, format := range []string{"html", "plain"} {
+		if _, err := os.Stat(templatePath(name, format)); err != nil {
+			return fmt.Errorf("unknown template %q", name)
+		}
+	}
+
+	return nil
+}
+
+func templatePath(name, format string) string {
+	return fmt.Sprintf("./templates/%s.%s.gohtml", templateName(name), format)
+}
+
+func templateName(name string) string {
+	if name == "" {
+		return defaultTemplate
+	}
+
+	return name
+}
+
 func (m *Mail) inlineCSS(s string) (string, error) {
 	options := premailer.Options{
 		RemoveClasses:     false,
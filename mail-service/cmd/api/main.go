@@ -1,48 +1,95 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"mail-service/config"
+	"mail-service/health"
+	"mail-service/tracing"
 	"net/http"
 	"os"
-	"strconv"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 type Config struct {
 	Mailer Mail
+	Health *health.Checker
 }
 
-const webPort = "80"
+const (
+	// shutdownTimeout bounds how long emails being sent may take to finish after SIGTERM
+	shutdownTimeout = 20 * time.Second
+	// readinessTimeout bounds the checks behind /readyz
+	readinessTimeout = 2 * time.Second
+)
 
 func main() {
+	// load the settings from flags, environment variables and an optional YAML file
+	var settings Settings
+	config.Parse(&settings)
+
+	// export traces to the exporter named in OTEL_TRACES_EXPORTER
+	shutdownTracing, err := tracing.Setup("mail-service")
+	if err != nil {
+		log.Panic(err)
+	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			log.Printf("Error flushing traces: %v", err)
+		}
+	}()
+
 	app := Config{
-		Mailer: createMail(),
+		Mailer: createMail(settings),
+		Health: health.New(readinessTimeout),
 	}
 
-	log.Println("Starting mail service on port", webPort)
+	// the service is ready while the SMTP server accepts connections
+	app.Health.Add("smtp", app.Mailer.Check)
 
 	srv := &http.Server{
-		Addr:    fmt.Sprintf(":%s", webPort),
+		Addr:    fmt.Sprintf(":%s", settings.WebPort),
 		Handler: app.routes(),
 	}
 
-	err := srv.ListenAndServe()
-	if err != nil {
-		log.Panic(err)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		log.Println("Starting mail service on port", settings.WebPort)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Panic(err)
+		}
+	}()
+
+	<-ctx.Done()
+	log.Println("Shutting down mail service...")
+
+	// stop taking new requests and let the emails being sent go out
+	app.Health.Drain()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Error shutting down the HTTP server: %v", err)
 	}
 }
 
-func createMail() Mail {
-	port, _ := strconv.Atoi(os.Getenv("MAIL_PORT"))
+func createMail(settings Settings) Mail {
 	m := Mail{
-		Domain:      os.Getenv("MAIL_DOMAIN"),
-		Host:        os.Getenv("MAIL_HOST"),
-		Port:        port,
-		Username:    os.Getenv("MAIL_USERNAME"),
-		Password:    os.Getenv("MAIL_PASSWORD"),
-		Encryption:  os.Getenv("MAIL_ENCRYPTION"),
-		FromName:    os.Getenv("FROM_NAME"),
-		FromAddress: os.Getenv("FROM_ADDRESS"),
+		Domain:      settings.Domain,
+		Host:        settings.Host,
+		Port:        settings.Port,
+		Username:    settings.Username,
+		Password:    settings.Password,
+		Encryption:  settings.Encryption,
+		FromName:    settings.FromName,
+		FromAddress: settings.FromAddress,
 	}
 
 	return m
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"mail-service/metrics"
+	"mail-service/tracing"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
@@ -22,6 +24,12 @@ func (app *Config) routes() http.Handler {
 	}))
 
 	mux.Use(middleware.Heartbeat("/ping"))
+	mux.Use(tracing.Middleware)
+	mux.Use(metrics.Middleware)
+
+	mux.Handle("/metrics", metrics.Handler())
+	mux.Get("/livez", app.Health.Livez)
+	mux.Get("/readyz", app.Health.Readyz)
 	mux.Post("/send", app.SendMail)
 
 	return mux
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// Settings configure the mail service; see package config for where they are read from.
+type Settings struct {
+	WebPort     string `yaml:"web_port" env:"WEB_PORT" default:"80" usage:"port of the HTTP server"`
+	Domain      string `yaml:"mail_domain" env:"MAIL_DOMAIN" default:"localhost" usage:"domain the service sends mail for"`
+	Host        string `yaml:"mail_host" env:"MAIL_HOST" required:"true" usage:"host of the SMTP server"`
+	Port        int    `yaml:"mail_port" env:"MAIL_PORT" default:"1025" usage:"port of the SMTP server"`
+	Username    string `yaml:"mail_username" env:"MAIL_USERNAME" usage:"user of the SMTP server"`
+	Password    string `yaml:"mail_password" env:"MAIL_PASSWORD" secret:"true" usage:"password of mail_username"`
+	Encryption  string `yaml:"mail_encryption" env:"MAIL_ENCRYPTION" default:"none" usage:"encryption of the SMTP connection: none, tls or ssl"`
+	FromName    string `yaml:"from_name" env:"FROM_NAME" usage:"sender name of emails without one"`
+	FromAddress string `yaml:"from_address" env:"FROM_ADDRESS" required:"true" usage:"sender address of emails without one"`
+}
+
+func (s *Settings) Validate() error {
+	if n, err := strconv.Atoi(s.WebPort); err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("web_port: %q is not a port", s.WebPort)
+	}
+
+	if s.Port < 1 || s.Port > 65535 {
+		return fmt.Errorf("mail_port: %d is not a port", s.Port)
+	}
+
+	switch s.Encryption {
+	case "none", "tls", "ssl":
+	default:
+		return fmt.Errorf("mail_encryption: %q is not one of none, tls or ssl", s.Encryption)
+	}
+
+	if s.Password != "" && s.Username == "" {
+		return errors.New("mail_password is set without mail_username")
+	}
+
+	return nil
+}